@@ -0,0 +1,92 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+)
+
+// callerKey returns the API key the request was authenticated with
+func callerKey(r *http.Request) *daos.APIKey {
+	key, _ := r.Context().Value("apiKey").(*daos.APIKey)
+	return key
+}
+
+// callerActor names the caller of a request for the audit log
+func callerActor(r *http.Request) daos.Actor {
+	actor := daos.Actor{Source: daos.SourceAPI, SourceIP: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		actor.SourceIP = host
+	}
+	if key := callerKey(r); key != nil {
+		actor.Name = key.Name
+	}
+	return actor
+}
+
+// authorizeZone checks the caller has at least the role in a zone. It
+// replies not found when the zone does not exist or the caller has no role
+// in it, and forbidden when its role is too weak.
+func authorizeZone(w http.ResponseWriter, r *http.Request, zoneId string, role string) (service.ZoneAccess, bool) {
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return service.ZoneAccess{}, false
+	}
+	access, err := accessService.ZoneAccess(callerKey(r), zoneId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			jsonError(w, "Zone not found", http.StatusNotFound)
+			return access, false
+		}
+		serviceError(w, err)
+		return access, false
+	}
+	if !access.Known() {
+		jsonError(w, "Zone not found", http.StatusNotFound)
+		return access, false
+	}
+	if !access.Allows(role) {
+		jsonError(w, fmt.Sprintf("The %s role is needed in zone %s", role, access.Zone.Name), http.StatusForbidden)
+		return access, false
+	}
+	return access, true
+}
+
+// authorizeRecordEdit checks the caller may edit records of the type at the name
+func authorizeRecordEdit(w http.ResponseWriter, access service.ZoneAccess, recordType string, name string) bool {
+	if !access.AllowsRecord(recordType, name) {
+		jsonError(w, fmt.Sprintf("Editing %s records at %q is not allowed in zone %s", recordType, name, access.Zone.Name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeRecord checks the caller has at least the role in the zone of a record
+func authorizeRecord(w http.ResponseWriter, r *http.Request, recordId string, role string) (*daos.DNSRecord, service.ZoneAccess, bool) {
+	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return nil, service.ZoneAccess{}, false
+	}
+	record, err := recordService.GetRecord(recordId)
+	if err != nil {
+		serviceError(w, err)
+		return nil, service.ZoneAccess{}, false
+	}
+	access, ok := authorizeZone(w, r, record.DNSZoneID, role)
+	return record, access, ok
+}
+
+// visibleZones keeps the zones the caller has a role in
+func visibleZones(r *http.Request, zones []daos.DNSZone) ([]daos.DNSZone, error) {
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		return nil, fmt.Errorf("could not get database connection")
+	}
+	return accessService.VisibleZones(callerKey(r), zones)
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func createUser(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.UserCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	user, err := accessService.CreateUser(data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func getUsers(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	users, err := accessService.GetUsers()
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	user, err := accessService.GetUser(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func deleteUser(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := accessService.DeleteUser(id); err != nil {
+		serviceError(w, err)
+	}
+}
+
+func createTeam(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.TeamCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	team, err := accessService.CreateTeam(data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func getTeams(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	teams, err := accessService.GetTeams()
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+func getTeam(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	team, err := accessService.GetTeam(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func deleteTeam(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := accessService.DeleteTeam(id); err != nil {
+		serviceError(w, err)
+	}
+}
+
+func addTeamMember(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := accessService.AddTeamMember(vars["id"], vars["user_id"]); err != nil {
+		serviceError(w, err)
+	}
+}
+
+func removeTeamMember(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := accessService.RemoveTeamMember(vars["id"], vars["user_id"]); err != nil {
+		serviceError(w, err)
+	}
+}
+
+func createRoleBinding(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	var data daos.RoleBindingCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleOwner); !ok {
+		return
+	}
+
+	fmt.Printf("Received data: %+v\n", data)
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	binding, err := accessService.CreateRoleBinding(zoneId, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(binding)
+}
+
+func getRoleBindings(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	bindings, err := accessService.GetRoleBindings(zoneId)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bindings)
+}
+
+func deleteRoleBinding(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	binding, err := accessService.GetRoleBinding(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if _, ok := authorizeZone(w, r, binding.ZoneID, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	if err := accessService.DeleteRoleBinding(id); err != nil {
+		serviceError(w, err)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func createACLRule(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.ACLRuleCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	aclService, ok := r.Context().Value("aclService").(*service.ACLService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	rule, err := aclService.CreateRule(data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// getACLRules lists all rules, or only those of a zone with the zone_id
+// parameter, where "global" selects the rules applying to all zones
+func getACLRules(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	aclService, ok := r.Context().Value("aclService").(*service.ACLService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	var rules []daos.ACLRule
+	var err error
+	if zoneId, filtered := r.URL.Query()["zone_id"]; filtered {
+		if zoneId[0] == "global" {
+			zoneId[0] = ""
+		}
+		rules, err = aclService.GetZoneRules(zoneId[0])
+	} else {
+		rules, err = aclService.GetRules()
+	}
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func getACLRule(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	aclService, ok := r.Context().Value("aclService").(*service.ACLService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	rule, err := aclService.GetRule(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func deleteACLRule(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	aclService, ok := r.Context().Value("aclService").(*service.ACLService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := aclService.DeleteRule(id); err != nil {
+		serviceError(w, err)
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.APIKeyCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	apiKeyService, ok := r.Context().Value("apiKeyService").(*service.APIKeyService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	key, err := apiKeyService.CreateKey(data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	apiKeyService, ok := r.Context().Value("apiKeyService").(*service.APIKeyService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	keys, err := apiKeyService.GetKeys()
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func getAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	apiKeyService, ok := r.Context().Value("apiKeyService").(*service.APIKeyService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	key, err := apiKeyService.GetKey(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	apiKeyService, ok := r.Context().Value("apiKeyService").(*service.APIKeyService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := apiKeyService.DeleteKey(id); err != nil {
+		serviceError(w, err)
+	}
+}
@@ -3,29 +3,92 @@ package api
 import (
 	"context"
 	"dnsServer/daos"
-	"dnsServer/data"
+	"dnsServer/server"
 	"dnsServer/service"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 	"net/http"
+	"os"
+	"strconv"
 )
 
-func StartApiServer(addr string) *http.Server {
+// bootstrapKeyEnv names the environment variable holding the token of the
+// first global admin API key
+const bootstrapKeyEnv = "DNS_API_BOOTSTRAP_KEY"
+
+// oidcConfigEnv names the environment variable holding the path of a JSON
+// daos.OIDCConfig, to accept JWTs of an identity provider
+const oidcConfigEnv = "DNS_API_OIDC_CONFIG"
+
+// minTTLEnv and maxTTLEnv name the environment variables bounding the TTLs
+// of records, in seconds
+const (
+	minTTLEnv = "DNS_RECORD_MIN_TTL"
+	maxTTLEnv = "DNS_RECORD_MAX_TTL"
+)
+
+// StartApiServer serves the management API on addr from the database db,
+// shared with the DNS server, along with DNS over HTTPS on /dns-query when a
+// DNS server is given
+func StartApiServer(addr string, db *gorm.DB, dnsServer *server.DNSServer) *http.Server {
 	r := mux.NewRouter()
-	db := data.InitDB()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, "Not found", http.StatusNotFound)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
 	// Create service instances
 	zoneService := service.NewZoneService(db)
 	recordService := service.NewRecordService(db) //
+	tsigService := service.NewTSIGService(db)
+	dnssecService := service.NewDNSSECService(db)
+	aclService := service.NewACLService(db)
+	viewService := service.NewViewService(db)
+	apiKeyService := service.NewAPIKeyService(db)
+	accessService := service.NewAccessService(db)
+	auditService := service.NewAuditService(db)
+	historyService := service.NewHistoryService(db)
+	zoneFileService := service.NewZoneFileService(db)
+	changeSetService := service.NewChangeSetService(db, recordService)
+	if err := loadTTLBounds(); err != nil {
+		fmt.Println("Error: default TTL bounds kept:", err)
+	}
+	oidcService, err := loadOIDC(db)
+	if err != nil {
+		fmt.Println("Error: OIDC login disabled:", err)
+	}
+	if token := os.Getenv(bootstrapKeyEnv); token != "" {
+		if err := apiKeyService.BootstrapKey("bootstrap", token); err != nil {
+			fmt.Println("Error:", err)
+		}
+	} else {
+		fmt.Printf("No API key bootstrapped, set %s to create a global admin key\n", bootstrapKeyEnv)
+	}
 	r.Use(
 		injectService("zoneService", zoneService),
 		injectService("recordService", recordService),
+		injectService("tsigService", tsigService),
+		injectService("dnssecService", dnssecService),
+		injectService("aclService", aclService),
+		injectService("viewService", viewService),
+		injectService("apiKeyService", apiKeyService),
+		injectService("accessService", accessService),
+		injectService("auditService", auditService),
+		injectService("historyService", historyService),
+		injectService("zoneFileService", zoneFileService),
+		injectService("changeSetService", changeSetService),
 	)
+	if dnsServer != nil {
+		r.Use(injectService("dnsServer", dnsServer))
+		r.HandleFunc("/dns-query", dnsQuery).Methods(http.MethodGet, http.MethodPost)
+	}
 
 	// Set up routes
 	api := r.PathPrefix("/api").Subrouter()
+	api.Use(authenticate(apiKeyService, oidcService))
 	api.HandleFunc("/zone", createZone).Methods(http.MethodPost)
 	api.HandleFunc("/zone", getZones).Methods(http.MethodGet)
 	api.HandleFunc("/zone/{id}", getZone).Methods(http.MethodGet)
@@ -36,21 +99,115 @@ func StartApiServer(addr string) *http.Server {
 	api.HandleFunc("/record/{id}", getRecord).Methods(http.MethodGet)
 	api.HandleFunc("/record", updateRecord).Methods(http.MethodPut)
 	api.HandleFunc("/record/{id}", deleteRecord).Methods(http.MethodDelete)
+	api.HandleFunc("/zone/{zone_id}/changes", createChangeSet).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{zone_id}/changes", getChangeSets).Methods(http.MethodGet)
+	api.HandleFunc("/change/{id}", getChangeSet).Methods(http.MethodGet)
+	api.HandleFunc("/tsig", createTSIGKey).Methods(http.MethodPost)
+	api.HandleFunc("/tsig", getTSIGKeys).Methods(http.MethodGet)
+	api.HandleFunc("/tsig/{id}", getTSIGKey).Methods(http.MethodGet)
+	api.HandleFunc("/tsig/{id}", deleteTSIGKey).Methods(http.MethodDelete)
+	api.HandleFunc("/zone/{zone_id}/tsig-policy", createTSIGPolicy).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{zone_id}/tsig-policy", getTSIGPolicies).Methods(http.MethodGet)
+	api.HandleFunc("/tsig-policy/{id}", deleteTSIGPolicy).Methods(http.MethodDelete)
+	api.HandleFunc("/zone/{id}/dnssec", enableDNSSEC).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{id}/dnssec", getDNSSEC).Methods(http.MethodGet)
+	api.HandleFunc("/zone/{id}/dnssec", disableDNSSEC).Methods(http.MethodDelete)
+	api.HandleFunc("/zone/{id}/dnssec/rollover", startDNSSECRollover).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{id}/dnssec/ds-seen", confirmDS).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{id}/history", getZoneHistory).Methods(http.MethodGet)
+	api.HandleFunc("/zone/{id}/snapshot", getZoneSnapshot).Methods(http.MethodGet)
+	api.HandleFunc("/zone/{id}/diff", getZoneDiff).Methods(http.MethodGet)
+	api.HandleFunc("/zone/{id}/rollback", rollbackZone).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{id}/import", importZone).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{id}/export", exportZone).Methods(http.MethodGet)
+	api.HandleFunc("/acl", createACLRule).Methods(http.MethodPost)
+	api.HandleFunc("/acl", getACLRules).Methods(http.MethodGet)
+	api.HandleFunc("/acl/{id}", getACLRule).Methods(http.MethodGet)
+	api.HandleFunc("/acl/{id}", deleteACLRule).Methods(http.MethodDelete)
+	api.HandleFunc("/view", createView).Methods(http.MethodPost)
+	api.HandleFunc("/view", getViews).Methods(http.MethodGet)
+	api.HandleFunc("/view/{id}", getView).Methods(http.MethodGet)
+	api.HandleFunc("/view/{id}", deleteView).Methods(http.MethodDelete)
+	api.HandleFunc("/view/{id}/zone", getViewZones).Methods(http.MethodGet)
+	api.HandleFunc("/key", createAPIKey).Methods(http.MethodPost)
+	api.HandleFunc("/key", getAPIKeys).Methods(http.MethodGet)
+	api.HandleFunc("/key/{id}", getAPIKey).Methods(http.MethodGet)
+	api.HandleFunc("/key/{id}", deleteAPIKey).Methods(http.MethodDelete)
+	api.HandleFunc("/user", createUser).Methods(http.MethodPost)
+	api.HandleFunc("/user", getUsers).Methods(http.MethodGet)
+	api.HandleFunc("/user/{id}", getUser).Methods(http.MethodGet)
+	api.HandleFunc("/user/{id}", deleteUser).Methods(http.MethodDelete)
+	api.HandleFunc("/team", createTeam).Methods(http.MethodPost)
+	api.HandleFunc("/team", getTeams).Methods(http.MethodGet)
+	api.HandleFunc("/team/{id}", getTeam).Methods(http.MethodGet)
+	api.HandleFunc("/team/{id}", deleteTeam).Methods(http.MethodDelete)
+	api.HandleFunc("/team/{id}/member/{user_id}", addTeamMember).Methods(http.MethodPut)
+	api.HandleFunc("/team/{id}/member/{user_id}", removeTeamMember).Methods(http.MethodDelete)
+	api.HandleFunc("/zone/{zone_id}/role", createRoleBinding).Methods(http.MethodPost)
+	api.HandleFunc("/zone/{zone_id}/role", getRoleBindings).Methods(http.MethodGet)
+	api.HandleFunc("/role/{id}", deleteRoleBinding).Methods(http.MethodDelete)
+	api.HandleFunc("/audit", getAuditEntries).Methods(http.MethodGet)
+	if dnsServer != nil {
+		api.HandleFunc("/rrl", getRRL).Methods(http.MethodGet)
+		api.HandleFunc("/rrl", updateRRL).Methods(http.MethodPut)
+		api.HandleFunc("/record/{id}/health", getRecordHealth).Methods(http.MethodGet)
+		api.HandleFunc("/cookies", getCookieConfig).Methods(http.MethodGet)
+		api.HandleFunc("/cookies", updateCookieConfig).Methods(http.MethodPut)
+		api.HandleFunc("/listener", getListenerOptions).Methods(http.MethodGet)
+		api.HandleFunc("/listener", updateListenerOptions).Methods(http.MethodPut)
+		api.HandleFunc("/listener/{name}", updateListenerOptions).Methods(http.MethodPut)
+	}
 
 	// Start the HTTP server
-	server := &http.Server{
+	httpServer := &http.Server{
 		Addr:    addr,
 		Handler: r,
 	}
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("ListenAndServe error: %v\n", err)
 		}
 	}()
 
 	println("Starting API server on", addr)
-	return server
+	return httpServer
+}
+
+// loadOIDC sets up JWT logins from the configuration named by the
+// environment, returning nil when there is none
+func loadOIDC(db *gorm.DB) (*service.OIDCService, error) {
+	path := os.Getenv(oidcConfigEnv)
+	if path == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config daos.OIDCConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	return service.NewOIDCService(db, config)
+}
+
+// loadTTLBounds bounds the TTLs of records with those of the environment,
+// when set
+func loadTTLBounds() error {
+	bounds := []int{0, service.MaxTTL}
+	for i, env := range []string{minTTLEnv, maxTTLEnv} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", env, value)
+		}
+		bounds[i] = n
+	}
+	return service.SetTTLBounds(bounds[0], bounds[1])
 }
 
 func injectService(key string, service any) func(http.Handler) http.Handler {
@@ -70,17 +227,26 @@ func createZone(w http.ResponseWriter, r *http.Request) {
 
 	var data daos.DNSZoneCreate
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
 		return
 	}
 	fmt.Printf("Received data: %+v\n", data)
 
 	zoneService, ok := r.Context().Value("zoneService").(*service.ZoneService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
 		return
 	}
-	zone := zoneService.CreateZone(data)
+	zone, err := zoneService.CreateZone(callerActor(r), data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if accessService, ok := r.Context().Value("accessService").(*service.AccessService); ok {
+		if err := accessService.GrantCreator(callerKey(r), zone.ID); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(zone)
 }
@@ -91,18 +257,35 @@ func updateZone(w http.ResponseWriter, r *http.Request) {
 
 	var data daos.DNSZoneUpdate
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
 		return
 	}
 	zoneService, ok := r.Context().Value("zoneService").(*service.ZoneService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
 		return
 	}
 
+	access, ok := authorizeZone(w, r, data.ID, daos.ZoneRoleOwner)
+	if !ok {
+		return
+	}
+	version, ok := checkIfMatch(w, r, access.Zone.Version)
+	if !ok {
+		return
+	}
+	if data.Version == 0 {
+		data.Version = version
+	}
+
 	fmt.Printf("Received data: %+v\n", data)
-	zone := zoneService.UpdateZone(data)
+	zone, err := zoneService.UpdateZone(callerActor(r), data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(zone.Version))
 	json.NewEncoder(w).Encode(zone)
 }
 
@@ -113,20 +296,19 @@ func getZone(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	zoneService, ok := r.Context().Value("zoneService").(*service.ZoneService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer); !ok {
 		return
 	}
 	zone, err := zoneService.GetZone(id)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.NotFound(w, r)
-			return
-		}
-		// For other types of errors, return a 500 Internal Server Error status
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		serviceError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(zone.Version))
 	json.NewEncoder(w).Encode(zone)
 }
 
@@ -137,10 +319,20 @@ func deleteZone(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	zoneService, ok := r.Context().Value("zoneService").(*service.ZoneService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
 		return
 	}
-	zoneService.DeleteZone(id)
+	access, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner)
+	if !ok {
+		return
+	}
+	version, ok := checkIfMatch(w, r, access.Zone.Version)
+	if !ok {
+		return
+	}
+	if err := zoneService.DeleteZone(callerActor(r), id, version); err != nil {
+		serviceError(w, err)
+	}
 
 }
 
@@ -149,12 +341,19 @@ func getZones(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	zoneService, ok := r.Context().Value("zoneService").(*service.ZoneService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	zones, err := zoneService.GetZones("%%")
+	if err == nil {
+		zones, err = visibleZones(r, zones)
+	}
+	if err != nil {
+		serviceError(w, err)
 		return
 	}
-	zone := zoneService.GetZones("%%")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(zone)
+	json.NewEncoder(w).Encode(zones)
 }
 
 func createRecord(w http.ResponseWriter, r *http.Request) {
@@ -164,17 +363,26 @@ func createRecord(w http.ResponseWriter, r *http.Request) {
 	zoneId := vars["zone_id"]
 	var data daos.DNSRecordCreate
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+
+	access, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleEditor)
+	if !ok || !authorizeRecordEdit(w, access, data.Type, data.Name) {
 		return
 	}
 
 	fmt.Printf("Received data: %+v\n", data)
 	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	record, err := recordService.CreateRecord(callerActor(r), zoneId, data)
+	if err != nil {
+		serviceError(w, err)
 		return
 	}
-	record := recordService.CreateRecord(zoneId, data)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(record)
 }
@@ -185,22 +393,44 @@ func updateRecord(w http.ResponseWriter, r *http.Request) {
 
 	var data daos.DNSRecordUpdate
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "Error parsing JSON body", http.StatusBadRequest)
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
 		return
 	}
 
 	fmt.Printf("Received data: %+v\n", data)
 	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	existing, access, ok := authorizeRecord(w, r, data.ID, daos.ZoneRoleEditor)
+	if !ok || !authorizeRecordEdit(w, access, existing.Type, existing.Name) {
+		return
+	}
+	recordType, recordName := existing.Type, existing.Name
+	if data.Type != "" {
+		recordType = data.Type
+	}
+	if data.Name != "" {
+		recordName = data.Name
+	}
+	if !authorizeRecordEdit(w, access, recordType, recordName) {
+		return
+	}
+	version, ok := checkIfMatch(w, r, existing.Version)
+	if !ok {
 		return
 	}
-	record, err := recordService.UpdateRecord(data)
+	if data.Version == 0 {
+		data.Version = version
+	}
+	record, err := recordService.UpdateRecord(callerActor(r), data)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		serviceError(w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(record.Version))
 	json.NewEncoder(w).Encode(record)
 }
 
@@ -209,22 +439,12 @@ func getRecord(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	vars := mux.Vars(r)
 	id := vars["id"]
-	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
+	record, _, ok := authorizeRecord(w, r, id, daos.ZoneRoleViewer)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
-		return
-	}
-	record, err := recordService.GetRecord(id)
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.NotFound(w, r)
-			return
-		}
-		// For other types of errors, return a 500 Internal Server Error status
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(record.Version))
 	json.NewEncoder(w).Encode(record)
 
 }
@@ -236,10 +456,20 @@ func deleteRecord(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
 		return
 	}
-	recordService.DeleteRecord(id)
+	record, access, ok := authorizeRecord(w, r, id, daos.ZoneRoleEditor)
+	if !ok || !authorizeRecordEdit(w, access, record.Type, record.Name) {
+		return
+	}
+	version, ok := checkIfMatch(w, r, record.Version)
+	if !ok {
+		return
+	}
+	if err := recordService.DeleteRecord(callerActor(r), id, version); err != nil {
+		serviceError(w, err)
+	}
 
 }
 
@@ -250,10 +480,17 @@ func getRecords(w http.ResponseWriter, r *http.Request) {
 	zoneId := vars["zone_id"]
 	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
 	if !ok {
-		http.Error(w, "Could not get database connection", http.StatusInternalServerError)
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	record, err := recordService.GetRecords(zoneId, "%%")
+	if err != nil {
+		serviceError(w, err)
 		return
 	}
-	record := recordService.GetRecords(zoneId, "%%")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(record)
 }
@@ -3,20 +3,88 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"dnsServer/client"
 	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/server"
+	"dnsServer/utils"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
+	"io"
+	"math/big"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
+// testAPIToken is the bootstrap global admin key the tests authenticate with
+const testAPIToken = "dnsk_test-bootstrap-token"
+
+// unauthenticated sends requests without the test API key
+var unauthenticated = &http.Client{Transport: http.DefaultTransport}
+
+// bearerTransport authenticates the requests that have no credentials yet
+type bearerTransport struct {
+	base http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" && req.Header.Get("X-API-Key") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Identity provider of the tests, whose keys are in a local JWKS file
+var (
+	testIssuer   = "https://idp.example.test"
+	testAudience = "dns-api"
+	testJWKSFile string
+	testJWTKey   *rsa.PrivateKey
+)
+
 func TestMain(m *testing.M) {
+	os.Setenv(bootstrapKeyEnv, testAPIToken)
+	dir, err := os.MkdirTemp("", "oidc")
+	if err != nil {
+		fmt.Println("Failed to create the OIDC directory:", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	testJWTKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	testJWKSFile = filepath.Join(dir, "jwks.json")
+	writeJWKS(testJWKSFile, map[string]*rsa.PrivateKey{"test-1": testJWTKey})
+	config, _ := json.Marshal(daos.OIDCConfig{
+		Issuer:      testIssuer,
+		Audience:    testAudience,
+		JWKSFile:    testJWKSFile,
+		GroupScopes: map[string]string{"dns-admins": daos.APIScopeGlobalAdmin, "dns-operators": daos.APIScopeZoneAdmin},
+	})
+	os.WriteFile(filepath.Join(dir, "oidc.json"), config, 0600)
+	os.Setenv(oidcConfigEnv, filepath.Join(dir, "oidc.json"))
+	http.DefaultTransport = bearerTransport{base: http.DefaultTransport}
+
 	// Start the DNS server and get the stop channel
-	server := StartApiServer(":8080")
-	defer server.Shutdown(context.Background())
+	dnsServer, err := server.NewDNSServer("127.0.0.1:8153")
+	if err != nil {
+		fmt.Println("Failed to start DNS server:", err)
+		os.Exit(1)
+	}
+	dnsServer.Start()
+	defer dnsServer.Stop()
+	apiServer := StartApiServer(":8080", data.InitDB(), dnsServer)
+	defer apiServer.Shutdown(context.Background())
 
 	// Wait a bit to ensure the server is ready
 	time.Sleep(time.Second)
@@ -76,6 +144,7 @@ func TestZone(t *testing.T) {
 			t.Fatalf("Failed to create request: %v", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
 		resp, err := client.Do(req)
 
 		if err != nil || resp.StatusCode != http.StatusOK {
@@ -161,6 +230,7 @@ func TestZone(t *testing.T) {
 			t.Fatalf("Failed to create request: %v", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
 		resp, err := client.Do(req)
 
 		if err != nil || resp.StatusCode != http.StatusOK {
@@ -192,6 +262,7 @@ func TestZone(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create request: %v", err)
 		}
+		req.Header.Set("If-Match", "*")
 		resp, err := client.Do(req)
 		if err != nil || resp.StatusCode != http.StatusOK {
 			t.Fatalf("Failed to delete record, err: %v, status code: %v", err, resp.StatusCode)
@@ -213,6 +284,7 @@ func TestZone(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create request: %v", err)
 		}
+		req.Header.Set("If-Match", "*")
 		resp, err := client.Do(req)
 		if err != nil || resp.StatusCode != http.StatusOK {
 			t.Fatalf("Failed to delete zone, err: %v, status code: %v", err, resp.StatusCode)
@@ -265,3 +337,1649 @@ func validateRecord(t *testing.T, createdRecord daos.DNSRecord, newRecord daos.D
 		t.Errorf("Expected record ZoneId %v, got %v", newRecord.DNSZoneID, createdRecord.DNSZoneID)
 	}
 }
+
+func TestTSIG(t *testing.T) {
+	var createdKey daos.TSIGKey
+	t.Run("CreateKey", func(t *testing.T) {
+		newKey := daos.TSIGKeyCreate{Name: uuid.NewString(), Algorithm: "hmac-sha512"}
+		body, _ := json.Marshal(newKey)
+		resp, err := http.Post("http://localhost:8080/api/tsig", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create TSIG key, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&createdKey)
+		if createdKey.Name != newKey.Name || createdKey.Secret == "" {
+			t.Errorf("Expected key %v with a generated secret, got %+v", newKey.Name, createdKey)
+		}
+	})
+
+	t.Run("CreateKeyUnsupportedAlgorithm", func(t *testing.T) {
+		body, _ := json.Marshal(daos.TSIGKeyCreate{Name: uuid.NewString(), Algorithm: "hmac-md5"})
+		resp, err := http.Post("http://localhost:8080/api/tsig", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("GetKeyHidesSecret", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/tsig/" + createdKey.ID)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get TSIG key, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var fetched daos.TSIGKey
+		json.NewDecoder(resp.Body).Decode(&fetched)
+		if fetched.Name != createdKey.Name || fetched.Secret != "" {
+			t.Errorf("Expected key %v without secret, got %+v", createdKey.Name, fetched)
+		}
+	})
+
+	t.Run("CreatePolicy", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+		resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		var zone daos.DNSZone
+		json.NewDecoder(resp.Body).Decode(&zone)
+		resp.Body.Close()
+
+		body, _ = json.Marshal(daos.TSIGPolicyCreate{KeyName: createdKey.Name, AllowTransfer: true})
+		resp, err = http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/tsig-policy", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create policy, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp.Body.Close()
+
+		resp, err = http.Get(fmt.Sprintf("http://localhost:8080/api/zone/%s/tsig-policy", zone.ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get policies, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var policies []daos.TSIGPolicy
+		json.NewDecoder(resp.Body).Decode(&policies)
+		if len(policies) != 1 || policies[0].KeyName != createdKey.Name || !policies[0].AllowTransfer {
+			t.Errorf("Expected transfer policy for %v, got %+v", createdKey.Name, policies)
+		}
+	})
+
+	t.Run("DeleteKey", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/tsig/"+createdKey.ID, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to delete TSIG key, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestDNSSEC(t *testing.T) {
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	t.Run("EnableDNSSEC", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSSECEnable{Algorithm: "ED25519"})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/dnssec", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to enable DNSSEC, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var status daos.DNSSECStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		if !status.Enabled || len(status.Keys) != 2 || len(status.DS) != 1 {
+			t.Errorf("Expected a KSK, a ZSK and a DS record, got %+v", status)
+		}
+	})
+
+	t.Run("SwitchToNSEC3", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSSECEnable{Algorithm: "ED25519", NSEC3: &daos.NSEC3Params{Salt: "aabb", Iterations: 5}})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/dnssec", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to enable NSEC3, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var status daos.DNSSECStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		if status.NSEC3 == nil || status.NSEC3.Salt != "aabb" || status.NSEC3.Iterations != 5 || len(status.Keys) != 2 {
+			t.Errorf("Expected NSEC3 with the same keys, got %+v", status)
+		}
+	})
+
+	t.Run("ZSKRollover", func(t *testing.T) {
+		url := fmt.Sprintf("http://localhost:8080/api/zone/%s/dnssec/rollover", zone.ID)
+		body, _ := json.Marshal(daos.DNSSECRollover{KeyType: "ZSK"})
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to start rollover, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var status daos.DNSSECStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		var published *daos.DNSSECKey
+		for i, key := range status.Keys {
+			if key.State == "published" {
+				published = &status.Keys[i]
+			}
+		}
+		if len(status.Keys) != 3 || published == nil || published.KeyType != "ZSK" || len(status.DS) != 1 {
+			t.Fatalf("Expected a published ZSK next to the active keys, got %+v", status)
+		}
+		var planned bool
+		for _, event := range status.Timeline {
+			planned = planned || (event.Planned && event.KeyTag == published.KeyTag && event.State == "active")
+		}
+		if !planned {
+			t.Errorf("Expected the activation of the new ZSK in the timeline, got %+v", status.Timeline)
+		}
+
+		resp, err = http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusConflict {
+			t.Errorf("Expected a second rollover to be rejected, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("DisableDNSSEC", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:8080/api/zone/%s/dnssec", zone.ID), nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to disable DNSSEC, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp, err = http.Get(fmt.Sprintf("http://localhost:8080/api/zone/%s/dnssec", zone.ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get DNSSEC status, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var status daos.DNSSECStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		if status.Enabled || len(status.Keys) != 0 {
+			t.Errorf("Expected DNSSEC to be disabled, got %+v", status)
+		}
+	})
+}
+
+func TestDNSOverHTTPS(t *testing.T) {
+	packet := utils.DNSPacket{
+		Header:    utils.DNSHeader{Flags: utils.FlagRD},
+		Questions: []utils.DNSQuestion{{Name: "google.com", Type: utils.TypeA, Class: utils.ClassIN}},
+	}
+	query := packet.Serialize()
+	checkResponse := func(t *testing.T, resp *http.Response) {
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/dns-message" {
+			t.Fatalf("Unexpected status %v and content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != "max-age=300" {
+			t.Errorf("Expected max-age from the answer TTL, got %q", cacheControl)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		response, err := utils.ParseDNSResponse(body)
+		if err != nil || len(response.Answers) != 1 || response.Answers[0].Value() != "1.2.3.4" {
+			t.Errorf("Unexpected response %v (%v)", response, err)
+		}
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(query))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		checkResponse(t, resp)
+	})
+
+	t.Run("Post", func(t *testing.T) {
+		resp, err := http.Post("http://localhost:8080/dns-query", "application/dns-message", bytes.NewReader(query))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		checkResponse(t, resp)
+	})
+
+	t.Run("PostWrongContentType", func(t *testing.T) {
+		resp, err := http.Post("http://localhost:8080/dns-query", "application/json", bytes.NewReader(query))
+		if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("GetInvalidMessage", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/dns-query?dns=not*base64")
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/dns-query?name=google.com&type=AAAA", nil)
+		req.Header.Set("Accept", "application/dns-json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request failed, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Status   int
+			Question []struct {
+				Name string `json:"name"`
+				Type int    `json:"type"`
+			}
+			Answer []struct {
+				Name string `json:"name"`
+				Type int    `json:"type"`
+				TTL  int
+				Data string `json:"data"`
+			}
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		if response.Status != 0 || len(response.Answer) != 1 || response.Answer[0].Type != 28 ||
+			response.Answer[0].Data != "::1" || response.Answer[0].Name != "google.com." {
+			t.Errorf("Unexpected JSON response %+v", response)
+		}
+	})
+}
+
+func TestACL(t *testing.T) {
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	var createdRule daos.ACLRule
+	t.Run("CreateRule", func(t *testing.T) {
+		newRule := daos.ACLRuleCreate{ZoneID: zone.ID, Scope: "authoritative", Action: "deny", CIDR: "192.0.2.7"}
+		body, _ := json.Marshal(newRule)
+		resp, err := http.Post("http://localhost:8080/api/acl", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create ACL rule, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&createdRule)
+		if createdRule.ZoneID != zone.ID || createdRule.CIDR != "192.0.2.7/32" {
+			t.Errorf("Expected a rule for 192.0.2.7/32 in zone %v, got %+v", zone.ID, createdRule)
+		}
+	})
+
+	t.Run("CreateRuleInvalidCIDR", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ACLRuleCreate{Scope: "recursion", Action: "allow", CIDR: "192.0.2.0/33"})
+		resp, err := http.Post("http://localhost:8080/api/acl", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("CreateZoneRecursionRule", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ACLRuleCreate{ZoneID: zone.ID, Scope: "recursion", Action: "allow", CIDR: "192.0.2.0/24"})
+		resp, err := http.Post("http://localhost:8080/api/acl", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("GetZoneRules", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/acl?zone_id=" + zone.ID)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get ACL rules, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var rules []daos.ACLRule
+		json.NewDecoder(resp.Body).Decode(&rules)
+		if len(rules) != 1 || rules[0].ID != createdRule.ID {
+			t.Errorf("Expected rule %v, got %+v", createdRule.ID, rules)
+		}
+	})
+
+	t.Run("DeleteRule", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/acl/"+createdRule.ID, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to delete ACL rule, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp, err = http.Get("http://localhost:8080/api/acl/" + createdRule.ID)
+		if err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected not found, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestRRL(t *testing.T) {
+	t.Run("Configure", func(t *testing.T) {
+		body, _ := json.Marshal(daos.RRLConfig{ResponsesPerSecond: 100, Slip: 2, LogOnly: true})
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/rrl", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to configure RRL, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp.Body.Close()
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		body, _ := json.Marshal(daos.RRLConfig{ResponsesPerSecond: -1})
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/rrl", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("Counters", func(t *testing.T) {
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8153")
+		if err != nil {
+			t.Fatalf("Failed to create DNS client: %v", err)
+		}
+		defer dnsClient.Close()
+		if _, err := dnsClient.SendQuery("example.com", utils.TypeA); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+
+		resp, err := http.Get("http://localhost:8080/api/rrl")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get RRL stats, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var stats daos.RRLStats
+		json.NewDecoder(resp.Body).Decode(&stats)
+		if stats.Config.ResponsesPerSecond != 100 || !stats.Config.LogOnly || stats.Responses == 0 {
+			t.Errorf("Expected the configuration and a counted response, got %+v", stats)
+		}
+	})
+}
+
+func TestViews(t *testing.T) {
+	var view daos.View
+	t.Run("CreateView", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ViewCreate{Name: uuid.NewString(), Networks: []string{"10.0.0.0/8", "192.0.2.1"}})
+		resp, err := http.Post("http://localhost:8080/api/view", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create view, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&view)
+		if len(view.Networks) != 2 || view.Networks[1] != "192.0.2.1/32" {
+			t.Errorf("Expected the view networks, got %+v", view)
+		}
+	})
+
+	t.Run("CreateViewWithoutMatch", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ViewCreate{Name: uuid.NewString()})
+		resp, err := http.Post("http://localhost:8080/api/view", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	zoneName := uuid.NewString() + ".com"
+	var viewZone daos.DNSZone
+	t.Run("SameZoneInView", func(t *testing.T) {
+		for _, viewId := range []string{"", view.ID} {
+			body, _ := json.Marshal(daos.DNSZoneCreate{Name: zoneName, ViewID: viewId})
+			resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+			}
+			json.NewDecoder(resp.Body).Decode(&viewZone)
+			resp.Body.Close()
+		}
+		if viewZone.ViewID != view.ID {
+			t.Fatalf("Expected a zone in view %v, got %+v", view.ID, viewZone)
+		}
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:8080/api/view/%s/zone", view.ID))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get view zones, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var zones []daos.DNSZone
+		json.NewDecoder(resp.Body).Decode(&zones)
+		if len(zones) != 1 || zones[0].ID != viewZone.ID {
+			t.Errorf("Expected zone %v in the view, got %+v", viewZone.ID, zones)
+		}
+	})
+
+	t.Run("DeleteViewWithZones", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/view/"+view.ID, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusConflict {
+			t.Fatalf("Expected conflict, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestSteering(t *testing.T) {
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	t.Run("CreateSteeredRecord", func(t *testing.T) {
+		newRecord := daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60,
+			Steering: &daos.RecordSteering{Policy: daos.SteeringGeo, Countries: []string{"DE"}}}
+		body, _ := json.Marshal(newRecord)
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/record", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create record, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var record daos.DNSRecord
+		json.NewDecoder(resp.Body).Decode(&record)
+		if record.Steering == nil || record.Steering.Policy != daos.SteeringGeo || len(record.Steering.Countries) != 1 {
+			t.Errorf("Expected the steering settings back, got %+v", record.Steering)
+		}
+	})
+
+	t.Run("UnknownPolicy", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.2", TTL: 60,
+			Steering: &daos.RecordSteering{Policy: "round-robin"}})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/record", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestHealthChecks(t *testing.T) {
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v, status code: %v", err, resp.StatusCode)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	var record daos.DNSRecord
+	t.Run("CreateCheckedRecord", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60,
+			HealthCheck: &daos.HealthCheck{Type: daos.HealthCheckHTTP, Path: "/health", ExpectedStatus: 204}})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/record", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create record, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&record)
+		if record.HealthCheck == nil || record.HealthCheck.Path != "/health" {
+			t.Errorf("Expected the health check back, got %+v", record.HealthCheck)
+		}
+	})
+
+	t.Run("CheckOnText", func(t *testing.T) {
+		body, _ := json.Marshal(daos.DNSRecordCreate{Name: "www", Type: "TXT", Value: "text", TTL: 60,
+			HealthCheck: &daos.HealthCheck{Type: daos.HealthCheckPing}})
+		resp, err := http.Post(fmt.Sprintf("http://localhost:8080/api/zone/%s/record", zone.ID), "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("GetHealth", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/record/" + record.ID + "/health")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get record health, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var health daos.RecordHealth
+		json.NewDecoder(resp.Body).Decode(&health)
+		if health.RecordID != record.ID || !health.Healthy || health.Check == nil {
+			t.Errorf("Expected a healthy checked record, got %+v", health)
+		}
+	})
+
+	t.Run("GetHealthOfMissingRecord", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/record/" + uuid.NewString() + "/health")
+		if err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("Expected not found, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestCookies(t *testing.T) {
+	t.Run("Configure", func(t *testing.T) {
+		body, _ := json.Marshal(daos.CookieConfig{ExemptRRL: true, ExemptACL: true})
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/cookies", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to configure cookies, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp.Body.Close()
+
+		resp, err = http.Get("http://localhost:8080/api/cookies")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get cookie configuration, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var config daos.CookieConfig
+		json.NewDecoder(resp.Body).Decode(&config)
+		if !config.ExemptRRL || !config.ExemptACL {
+			t.Errorf("Expected both exemptions, got %+v", config)
+		}
+	})
+
+	t.Run("ServerCookie", func(t *testing.T) {
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8153")
+		if err != nil {
+			t.Fatalf("Failed to create DNS client: %v", err)
+		}
+		defer dnsClient.Close()
+		dnsClient.EnableCookies()
+		response, err := dnsClient.SendQuery("example.com", utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		edns := response.EDNS()
+		if edns == nil {
+			t.Fatalf("Expected an OPT record in the response")
+		}
+		if cookie, err := edns.Cookie(); err != nil || cookie == nil || len(cookie.Server) != 16 {
+			t.Errorf("Expected a server cookie, got %+v, err: %v", cookie, err)
+		}
+	})
+}
+
+func TestListenerOptions(t *testing.T) {
+	t.Run("Configure", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ListenerOptions{PaddingBlockSize: 128, MinimalResponses: true})
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/listener/tls", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to configure listener, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var options map[string]daos.ListenerOptions
+		json.NewDecoder(resp.Body).Decode(&options)
+		if options["tls"].PaddingBlockSize != 128 || !options["tls"].MinimalResponses || options["udp"].MinimalResponses {
+			t.Errorf("Expected only the TLS listener to change, got %+v", options)
+		}
+	})
+
+	t.Run("UnknownListener", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ListenerOptions{})
+		req, err := http.NewRequest(http.MethodPut, "http://localhost:8080/api/listener/quic", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	createKey := func(t *testing.T, create daos.APIKeyCreate) daos.APIKey {
+		body, _ := json.Marshal(create)
+		resp, err := http.Post("http://localhost:8080/api/key", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create API key, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var key daos.APIKey
+		json.NewDecoder(resp.Body).Decode(&key)
+		if key.Token == "" || key.Scope != create.Scope {
+			t.Fatalf("Expected a %s key with a token, got %+v", create.Scope, key)
+		}
+		return key
+	}
+	request := func(t *testing.T, method string, url string, token string, body any) int {
+		var reader io.Reader
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			reader = bytes.NewReader(encoded)
+		}
+		req, err := http.NewRequest(method, url, reader)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := unauthenticated.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("MissingKey", func(t *testing.T) {
+		resp, err := unauthenticated.Get("http://localhost:8080/api/zone")
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected unauthorized, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var body map[string]string
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body["error"] == "" {
+			t.Errorf("Expected a JSON error body, got %v, err: %v", body, err)
+		}
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a WWW-Authenticate header")
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", "dnsk_nope", nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected unauthorized, got %d", code)
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		key := createKey(t, daos.APIKeyCreate{Name: "read-" + uuid.NewString(), Scope: daos.APIScopeReadOnly})
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", key.Token, nil); code != http.StatusOK {
+			t.Errorf("Expected a read-only key to list zones, got %d", code)
+		}
+		zone := daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/zone", key.Token, zone); code != http.StatusForbidden {
+			t.Errorf("Expected a read-only key not to create zones, got %d", code)
+		}
+	})
+
+	t.Run("ZoneAdmin", func(t *testing.T) {
+		key := createKey(t, daos.APIKeyCreate{Name: "zone-" + uuid.NewString(), Scope: daos.APIScopeZoneAdmin})
+		zone := daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/zone", key.Token, zone); code != http.StatusOK {
+			t.Errorf("Expected a zone admin key to create zones, got %d", code)
+		}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/acl", key.Token, daos.ACLRuleCreate{}); code != http.StatusForbidden {
+			t.Errorf("Expected a zone admin key not to change ACLs, got %d", code)
+		}
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/key", key.Token, nil); code != http.StatusForbidden {
+			t.Errorf("Expected a zone admin key not to list keys, got %d", code)
+		}
+	})
+
+	t.Run("UnknownScope", func(t *testing.T) {
+		body, _ := json.Marshal(daos.APIKeyCreate{Name: uuid.NewString(), Scope: "root"})
+		resp, err := http.Post("http://localhost:8080/api/key", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("DeletedKey", func(t *testing.T) {
+		key := createKey(t, daos.APIKeyCreate{Name: "deleted-" + uuid.NewString(), Scope: daos.APIScopeReadOnly})
+		if code := request(t, http.MethodDelete, "http://localhost:8080/api/key/"+key.ID, testAPIToken, nil); code != http.StatusOK {
+			t.Fatalf("Failed to delete key, status code: %d", code)
+		}
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", key.Token, nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected a deleted key to be refused, got %d", code)
+		}
+	})
+
+	t.Run("ExpiredKey", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		key := createKey(t, daos.APIKeyCreate{Name: "expired-" + uuid.NewString(), Scope: daos.APIScopeReadOnly, ExpiresAt: &expired})
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", key.Token, nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected an expired key to be refused, got %d", code)
+		}
+	})
+}
+
+func TestZoneRoles(t *testing.T) {
+	post := func(t *testing.T, url string, token string, body any, out any) int {
+		encoded, _ := json.Marshal(body)
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if out != nil && resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+	get := func(t *testing.T, url string, token string, out any) int {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if out != nil && resp.StatusCode == http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+	hasZone := func(zones []daos.DNSZone, id string) bool {
+		for _, zone := range zones {
+			if zone.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	var user daos.User
+	if code := post(t, "http://localhost:8080/api/user", "", daos.UserCreate{Name: "user-" + uuid.NewString()}, &user); code != http.StatusOK {
+		t.Fatalf("Failed to create user, status code: %d", code)
+	}
+	var team daos.Team
+	if code := post(t, "http://localhost:8080/api/team", "", daos.TeamCreate{Name: "team-" + uuid.NewString(), Members: []string{user.ID}}, &team); code != http.StatusOK {
+		t.Fatalf("Failed to create team, status code: %d", code)
+	}
+	var key daos.APIKey
+	if code := post(t, "http://localhost:8080/api/key", "", daos.APIKeyCreate{Name: "user-" + uuid.NewString(), Scope: daos.APIScopeZoneAdmin, UserID: user.ID}, &key); code != http.StatusOK {
+		t.Fatalf("Failed to create key, status code: %d", code)
+	}
+	var zone, other daos.DNSZone
+	post(t, "http://localhost:8080/api/zone", "", daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}, &zone)
+	post(t, "http://localhost:8080/api/zone", "", daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}, &other)
+
+	t.Run("NoRole", func(t *testing.T) {
+		var zones []daos.DNSZone
+		if code := get(t, "http://localhost:8080/api/zone", key.Token, &zones); code != http.StatusOK {
+			t.Fatalf("Failed to list zones, status code: %d", code)
+		}
+		if hasZone(zones, zone.ID) {
+			t.Errorf("Expected the zone to be hidden before a role is given")
+		}
+		if code := get(t, "http://localhost:8080/api/zone/"+zone.ID, key.Token, nil); code != http.StatusNotFound {
+			t.Errorf("Expected not found, got %d", code)
+		}
+	})
+
+	binding := daos.RoleBindingCreate{TeamID: team.ID, Role: daos.ZoneRoleEditor, RecordTypes: []string{"TXT"}, NamePatterns: []string{"_acme-challenge*"}}
+	if code := post(t, "http://localhost:8080/api/zone/"+zone.ID+"/role", "", binding, nil); code != http.StatusOK {
+		t.Fatalf("Failed to bind role, status code: %d", code)
+	}
+
+	t.Run("Editor", func(t *testing.T) {
+		var zones []daos.DNSZone
+		get(t, "http://localhost:8080/api/zone", key.Token, &zones)
+		if !hasZone(zones, zone.ID) || hasZone(zones, other.ID) {
+			t.Errorf("Expected only the zone of the team to be listed")
+		}
+		allowed := daos.DNSRecordCreate{Name: "_acme-challenge.www", Type: "TXT", Value: "token", TTL: 60}
+		if code := post(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", key.Token, allowed, nil); code != http.StatusOK {
+			t.Errorf("Expected the editor to create the TXT record, got %d", code)
+		}
+		wrongType := daos.DNSRecordCreate{Name: "_acme-challenge", Type: "A", Value: "192.0.2.1", TTL: 60}
+		if code := post(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", key.Token, wrongType, nil); code != http.StatusForbidden {
+			t.Errorf("Expected an A record to be refused, got %d", code)
+		}
+		wrongName := daos.DNSRecordCreate{Name: "www", Type: "TXT", Value: "hello", TTL: 60}
+		if code := post(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", key.Token, wrongName, nil); code != http.StatusForbidden {
+			t.Errorf("Expected a record outside the name patterns to be refused, got %d", code)
+		}
+		if code := post(t, "http://localhost:8080/api/zone/"+zone.ID+"/role", key.Token, binding, nil); code != http.StatusForbidden {
+			t.Errorf("Expected an editor not to give roles, got %d", code)
+		}
+		if code := post(t, "http://localhost:8080/api/zone/"+other.ID+"/record", key.Token, allowed, nil); code != http.StatusNotFound {
+			t.Errorf("Expected the zone of another team to be hidden, got %d", code)
+		}
+	})
+
+	t.Run("Creator", func(t *testing.T) {
+		var own daos.DNSZone
+		if code := post(t, "http://localhost:8080/api/zone", key.Token, daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}, &own); code != http.StatusOK {
+			t.Fatalf("Failed to create zone, status code: %d", code)
+		}
+		record := daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}
+		if code := post(t, "http://localhost:8080/api/zone/"+own.ID+"/record", key.Token, record, nil); code != http.StatusOK {
+			t.Errorf("Expected the owner to create any record, got %d", code)
+		}
+	})
+}
+
+// writeJWKS writes the public keys of a key set file
+func writeJWKS(path string, keys map[string]*rsa.PrivateKey) {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	raw, _ := json.Marshal(set)
+	os.WriteFile(path, raw, 0600)
+}
+
+// signJWT issues an RS256 token as the test identity provider would
+func signJWT(key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDC(t *testing.T) {
+	claims := func(subject string, groups ...string) map[string]any {
+		return map[string]any{
+			"iss":    testIssuer,
+			"aud":    testAudience,
+			"sub":    subject,
+			"groups": groups,
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	request := func(t *testing.T, method string, url string, token string, body any) int {
+		var reader io.Reader
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			reader = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, url, reader)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := unauthenticated.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("DefaultScope", func(t *testing.T) {
+		token := signJWT(testJWTKey, "test-1", claims("reader-"+uuid.NewString()))
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", token, nil); code != http.StatusOK {
+			t.Errorf("Expected a logged in engineer to list zones, got %d", code)
+		}
+		zone := daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/zone", token, zone); code != http.StatusForbidden {
+			t.Errorf("Expected the default scope to be read-only, got %d", code)
+		}
+	})
+
+	t.Run("GroupRoles", func(t *testing.T) {
+		teamName := "ops-" + uuid.NewString()
+		var team daos.Team
+		var zone, other daos.DNSZone
+		encoded, _ := json.Marshal(daos.TeamCreate{Name: teamName})
+		resp, err := http.Post("http://localhost:8080/api/team", "application/json", bytes.NewReader(encoded))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create team, err: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&team)
+		resp.Body.Close()
+		for _, created := range []*daos.DNSZone{&zone, &other} {
+			encoded, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+			resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(encoded))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("Failed to create zone, err: %v", err)
+			}
+			json.NewDecoder(resp.Body).Decode(created)
+			resp.Body.Close()
+		}
+		encoded, _ = json.Marshal(daos.RoleBindingCreate{TeamID: team.ID, Role: daos.ZoneRoleEditor})
+		resp, err = http.Post("http://localhost:8080/api/zone/"+zone.ID+"/role", "application/json", bytes.NewReader(encoded))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to bind role, err: %v", err)
+		}
+		resp.Body.Close()
+
+		token := signJWT(testJWTKey, "test-1", claims("operator-"+uuid.NewString(), "dns-operators", teamName))
+		record := daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/zone/"+zone.ID+"/record", token, record); code != http.StatusOK {
+			t.Errorf("Expected the group to edit the zone of its team, got %d", code)
+		}
+		if code := request(t, http.MethodPost, "http://localhost:8080/api/zone/"+other.ID+"/record", token, record); code != http.StatusNotFound {
+			t.Errorf("Expected other zones to be hidden, got %d", code)
+		}
+		admin := signJWT(testJWTKey, "test-1", claims("admin-"+uuid.NewString(), "dns-admins"))
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/key", admin, nil); code != http.StatusOK {
+			t.Errorf("Expected the admin group to manage keys, got %d", code)
+		}
+	})
+
+	t.Run("IdentityNotName", func(t *testing.T) {
+		name := "local-" + uuid.NewString()
+		encoded, _ := json.Marshal(daos.UserCreate{Name: name})
+		resp, err := http.Post("http://localhost:8080/api/user", "application/json", bytes.NewReader(encoded))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to create user, err: %v", err)
+		}
+		resp.Body.Close()
+		// A token whose name claim is that of another user is not given its account
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", signJWT(testJWTKey, "test-1", claims(name)), nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected a token naming a local user to be refused, got %d", code)
+		}
+		token := signJWT(testJWTKey, "test-1", claims("returning-"+uuid.NewString()))
+		for i := 0; i < 2; i++ {
+			if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", token, nil); code != http.StatusOK {
+				t.Errorf("Expected login %d to find the same user, got %d", i+1, code)
+			}
+		}
+	})
+
+	t.Run("InvalidTokens", func(t *testing.T) {
+		expired := claims("late-" + uuid.NewString())
+		expired["exp"] = time.Now().Add(-time.Hour).Unix()
+		wrongAudience := claims("other-" + uuid.NewString())
+		wrongAudience["aud"] = "another-api"
+		noAudience := claims("other-" + uuid.NewString())
+		delete(noAudience, "aud")
+		wrongIssuer := claims("other-" + uuid.NewString())
+		wrongIssuer["iss"] = "https://evil.example.test"
+		forger, _ := rsa.GenerateKey(rand.Reader, 2048)
+		for name, token := range map[string]string{
+			"expired":        signJWT(testJWTKey, "test-1", expired),
+			"wrong audience": signJWT(testJWTKey, "test-1", wrongAudience),
+			"no audience":    signJWT(testJWTKey, "test-1", noAudience),
+			"wrong issuer":   signJWT(testJWTKey, "test-1", wrongIssuer),
+			"forged":         signJWT(forger, "test-1", claims("forger")),
+			"unknown key":    signJWT(forger, "forged", claims("forger")),
+		} {
+			if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", token, nil); code != http.StatusUnauthorized {
+				t.Errorf("Expected the %s token to be refused, got %d", name, code)
+			}
+		}
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+		writeJWKS(testJWKSFile, map[string]*rsa.PrivateKey{"test-2": rotated})
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(testJWKSFile, later, later)
+		token := signJWT(rotated, "test-2", claims("rotated-"+uuid.NewString()))
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", token, nil); code != http.StatusOK {
+			t.Errorf("Expected a token of the new key to be accepted, got %d", code)
+		}
+		old := signJWT(testJWTKey, "test-1", claims("old-"+uuid.NewString()))
+		if code := request(t, http.MethodGet, "http://localhost:8080/api/zone", old, nil); code != http.StatusUnauthorized {
+			t.Errorf("Expected a token of the retired key to be refused, got %d", code)
+		}
+	})
+}
+
+func TestAudit(t *testing.T) {
+	var zone daos.DNSZone
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	var record daos.DNSRecord
+	body, _ = json.Marshal(daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60})
+	resp, err = http.Post("http://localhost:8080/api/zone/"+zone.ID+"/record", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create record, err: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&record)
+	resp.Body.Close()
+
+	body, _ = json.Marshal(daos.DNSRecordUpdate{ID: record.ID, DNSRecordCreate: daos.DNSRecordCreate{Value: "192.0.2.2"}})
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/api/record", bytes.NewReader(body))
+	req.Header.Set("If-Match", "*")
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to update record, err: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, "http://localhost:8080/api/record/"+record.ID, nil)
+	req.Header.Set("If-Match", "*")
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to delete record, err: %v", err)
+	}
+
+	getEntries := func(t *testing.T, query string) []daos.AuditEntry {
+		resp, err := http.Get("http://localhost:8080/api/audit?" + query)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to get audit entries, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var entries []daos.AuditEntry
+		json.NewDecoder(resp.Body).Decode(&entries)
+		return entries
+	}
+
+	t.Run("ByZone", func(t *testing.T) {
+		entries := getEntries(t, "zone="+zone.ID)
+		if len(entries) != 4 {
+			t.Fatalf("Expected 4 entries, got %d", len(entries))
+		}
+		// Most recent first
+		expected := []string{daos.AuditDelete, daos.AuditUpdate, daos.AuditCreate, daos.AuditCreate}
+		for i, entry := range entries {
+			if entry.Action != expected[i] || entry.Actor != "bootstrap" || entry.Source != daos.SourceAPI || entry.SourceIP == "" {
+				t.Errorf("Unexpected entry %d: %+v", i, entry)
+			}
+		}
+		var before, after daos.DNSRecord
+		json.Unmarshal(entries[1].Before, &before)
+		json.Unmarshal(entries[1].After, &after)
+		if before.Value != "192.0.2.1" || after.Value != "192.0.2.2" {
+			t.Errorf("Expected the update to hold both values, got %s and %s", entries[1].Before, entries[1].After)
+		}
+		if string(entries[0].After) != "null" || string(entries[3].Before) != "null" {
+			t.Errorf("Expected no record after the delete nor zone before the create")
+		}
+	})
+
+	t.Run("ByTime", func(t *testing.T) {
+		since := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		if entries := getEntries(t, "zone="+zone.ID+"&since="+since); len(entries) != 0 {
+			t.Errorf("Expected no entries in the future, got %d", len(entries))
+		}
+		until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		if entries := getEntries(t, "zone="+zone.ID+"&until="+until+"&limit=2"); len(entries) != 2 {
+			t.Errorf("Expected the limit to apply, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("ByActor", func(t *testing.T) {
+		for _, entry := range getEntries(t, "actor=bootstrap&limit=10") {
+			if entry.Actor != "bootstrap" {
+				t.Errorf("Expected only entries of the actor, got %+v", entry)
+			}
+		}
+		if entries := getEntries(t, "actor="+uuid.NewString()); len(entries) != 0 {
+			t.Errorf("Expected no entries of an unknown actor, got %d", len(entries))
+		}
+	})
+
+	t.Run("InvalidTime", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/audit?since=yesterday")
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+
+	t.Run("LimitTooHigh", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/audit?limit=1000000")
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	post := func(t *testing.T, url string, body any, out any) {
+		encoded, _ := json.Marshal(body)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(encoded))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request to %s failed, err: %v, status code: %v", url, err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+	}
+	get := func(t *testing.T, url string, out any) {
+		resp, err := http.Get(url)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request to %s failed, err: %v, status code: %v", url, err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var zone daos.DNSZone
+	post(t, "http://localhost:8080/api/zone", daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}, &zone)
+	var www, mail daos.DNSRecord
+	post(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}, &www)
+	post(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", daos.DNSRecordCreate{Name: "mail", Type: "A", Value: "192.0.2.25", TTL: 60}, &mail)
+	// Serial 3 has both records
+	body, _ := json.Marshal(daos.DNSRecordUpdate{ID: www.ID, DNSRecordCreate: daos.DNSRecordCreate{Value: "192.0.2.2"}})
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8080/api/record", bytes.NewReader(body))
+	req.Header.Set("If-Match", "*")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to update record, err: %v", err)
+	}
+	req, _ = http.NewRequest(http.MethodDelete, "http://localhost:8080/api/record/"+mail.ID, nil)
+	req.Header.Set("If-Match", "*")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to delete record, err: %v", err)
+	}
+
+	t.Run("Versions", func(t *testing.T) {
+		var versions []daos.RecordVersion
+		get(t, "http://localhost:8080/api/zone/"+zone.ID+"/history?record="+www.ID, &versions)
+		if len(versions) != 2 || versions[0].Record.Value != "192.0.2.2" || versions[1].Record.Value != "192.0.2.1" {
+			t.Errorf("Expected both versions of the record, most recent first, got %+v", versions)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		var snapshot daos.ZoneSnapshot
+		get(t, "http://localhost:8080/api/zone/"+zone.ID+"/snapshot?serial=3", &snapshot)
+		if len(snapshot.Records) != 2 || snapshot.Serial != 3 {
+			t.Fatalf("Expected both records at serial 3, got %+v", snapshot)
+		}
+		for _, record := range snapshot.Records {
+			if record.ID == www.ID && record.Value != "192.0.2.1" {
+				t.Errorf("Expected the old address at serial 3, got %s", record.Value)
+			}
+		}
+		at := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		get(t, "http://localhost:8080/api/zone/"+zone.ID+"/snapshot?at="+at, &snapshot)
+		if len(snapshot.Records) != 0 {
+			t.Errorf("Expected no records an hour ago, got %+v", snapshot.Records)
+		}
+	})
+
+	t.Run("Diff", func(t *testing.T) {
+		var diff daos.ZoneDiff
+		get(t, "http://localhost:8080/api/zone/"+zone.ID+"/diff?from=3&to=5", &diff)
+		if len(diff.Removed) != 1 || diff.Removed[0].ID != mail.ID {
+			t.Errorf("Expected the mail record to be removed, got %+v", diff.Removed)
+		}
+		if len(diff.Changed) != 1 || diff.Changed[0].Before.Value != "192.0.2.1" || diff.Changed[0].After.Value != "192.0.2.2" {
+			t.Errorf("Expected the www record to change, got %+v", diff.Changed)
+		}
+		if len(diff.Added) != 0 {
+			t.Errorf("Expected no added records, got %+v", diff.Added)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		serial := uint32(3)
+		var diff daos.ZoneDiff
+		post(t, "http://localhost:8080/api/zone/"+zone.ID+"/rollback", daos.ZoneVersion{Serial: &serial}, &diff)
+		if len(diff.Added) != 1 || len(diff.Changed) != 1 || diff.FromSerial != 5 || diff.ToSerial != 6 {
+			t.Errorf("Expected the rollback to restore and revert a record in serial 6, got %+v", diff)
+		}
+		var records []daos.DNSRecord
+		get(t, "http://localhost:8080/api/zone/"+zone.ID+"/record", &records)
+		values := map[string]string{}
+		for _, record := range records {
+			values[record.ID] = record.Value
+		}
+		if values[www.ID] != "192.0.2.1" || values[mail.ID] != "192.0.2.25" {
+			t.Errorf("Expected the records of serial 3, got %v", values)
+		}
+		var current daos.DNSZone
+		get(t, "http://localhost:8080/api/zone/"+zone.ID, &current)
+		if current.Serial != 6 {
+			t.Errorf("Expected a single serial bump, got serial %d", current.Serial)
+		}
+	})
+
+	t.Run("InvalidVersion", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/zone/" + zone.ID + "/diff?from=yesterday")
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestZoneFile(t *testing.T) {
+	importFile := func(t *testing.T, url string, contentType string, body io.Reader) daos.ZoneDiff {
+		resp, err := http.Post(url, contentType, body)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Request to %s failed, err: %v, status code: %v", url, err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var diff daos.ZoneDiff
+		json.NewDecoder(resp.Body).Decode(&diff)
+		return diff
+	}
+	zoneName := uuid.NewString() + ".com"
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: zoneName})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v", err)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+	zoneFile := `$TTL 1h
+@	IN SOA ns1 hostmaster ( 1 3600 600 604800 300 ) ; replaced by the zone's own
+	IN NS ns1
+ns1	300 IN A 192.0.2.1
+www	60 IN A 192.0.2.10
+	60 IN A 192.0.2.11
+@	MX 10 mail.example.net.
+txt	TXT "v=spf1 -all" ";x"
+`
+
+	t.Run("DryRun", func(t *testing.T) {
+		diff := importFile(t, "http://localhost:8080/api/zone/"+zone.ID+"/import?dryRun=true", "text/dns", strings.NewReader(zoneFile))
+		if len(diff.Added) != 6 || diff.ToSerial != zone.Serial {
+			t.Fatalf("Expected 6 records to be added without changing the zone, got %+v", diff)
+		}
+		var records []daos.DNSRecord
+		resp, _ := http.Get("http://localhost:8080/api/zone/" + zone.ID + "/record")
+		json.NewDecoder(resp.Body).Decode(&records)
+		resp.Body.Close()
+		if len(records) != 0 {
+			t.Errorf("Expected a dry run to leave the zone empty, got %+v", records)
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		diff := importFile(t, "http://localhost:8080/api/zone/"+zone.ID+"/import", "text/dns", strings.NewReader(zoneFile))
+		if len(diff.Added) != 6 || diff.ToSerial != zone.Serial+1 {
+			t.Fatalf("Expected 6 records added in a single serial bump, got %+v", diff)
+		}
+		// Importing the same file again changes nothing
+		diff = importFile(t, "http://localhost:8080/api/zone/"+zone.ID+"/import", "text/dns", strings.NewReader(zoneFile))
+		if len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 {
+			t.Errorf("Expected no changes, got %+v", diff)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/zone/" + zone.ID + "/export")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to export zone, err: %v", err)
+		}
+		defer resp.Body.Close()
+		exported, _ := io.ReadAll(resp.Body)
+		records, err := utils.ParseZoneFile("export", exported, "", nil)
+		if err != nil {
+			t.Fatalf("Failed to parse the exported zone: %v\n%s", err, exported)
+		}
+		if len(records) != 7 || records[0].Type != utils.TypeSOA || records[0].Name != zoneName {
+			t.Errorf("Expected the SOA and the 6 records, got %+v", records)
+		}
+		for _, record := range records {
+			if record.Type == utils.TypeTXT && record.Value != "v=spf1 -all;x" {
+				t.Errorf("Expected the TXT strings to be kept, got %q", record.Value)
+			}
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		var form bytes.Buffer
+		writer := multipart.NewWriter(&form)
+		part, _ := writer.CreateFormFile("zone", "main.zone")
+		part.Write([]byte("$TTL 300\n@ NS ns1\nns1 A 192.0.2.1\n$INCLUDE hosts.zone\n"))
+		part, _ = writer.CreateFormFile("hosts", "hosts.zone")
+		part.Write([]byte("www 60 A 192.0.2.10\n"))
+		writer.Close()
+		diff := importFile(t, "http://localhost:8080/api/zone/"+zone.ID+"/import?mode=replace", writer.FormDataContentType(), &form)
+		// ns1 and the first www record are kept, the NS record changes TTL
+		if len(diff.Added) != 0 || len(diff.Removed) != 3 || len(diff.Changed) != 1 {
+			t.Errorf("Expected 3 records removed and 1 changed, got %+v", diff)
+		}
+	})
+
+	t.Run("InvalidFile", func(t *testing.T) {
+		resp, err := http.Post("http://localhost:8080/api/zone/"+zone.ID+"/import", "text/dns", strings.NewReader("www 60 IN A not-an-address\n"))
+		if err != nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected bad request, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		resp, err = http.Post("http://localhost:8080/api/zone/"+zone.ID+"/import", "text/dns", strings.NewReader("www.example.org. 60 IN A 192.0.2.1\n"))
+		if err != nil || resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected unprocessable for a name out of the zone, err: %v, status code: %v", err, resp.StatusCode)
+		}
+	})
+}
+
+func TestChangeSets(t *testing.T) {
+	submit := func(t *testing.T, zoneId string, create daos.ChangeSetCreate, status int) daos.ChangeSet {
+		body, _ := json.Marshal(create)
+		resp, err := http.Post("http://localhost:8080/api/zone/"+zoneId+"/changes", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != status {
+			t.Fatalf("Expected status %d submitting the change set, err: %v, status code: %v", status, err, resp.StatusCode)
+		}
+		defer resp.Body.Close()
+		var changeSet daos.ChangeSet
+		json.NewDecoder(resp.Body).Decode(&changeSet)
+		return changeSet
+	}
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v", err)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	created := submit(t, zone.ID, daos.ChangeSetCreate{Comment: "initial", Changes: []daos.RecordOperation{
+		{Action: daos.ChangeCreate, Record: &daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}},
+		{Action: daos.ChangeCreate, Record: &daos.DNSRecordCreate{Name: "old", Type: "A", Value: "192.0.2.2", TTL: 60}},
+	}}, http.StatusOK)
+	if created.Status != daos.ChangeSetApplied || created.Serial != zone.Serial+1 || len(created.Records) != 2 {
+		t.Fatalf("Expected both records created in a single serial bump, got %+v", created)
+	}
+	www, old := created.Records[0], created.Records[1]
+
+	t.Run("Batch", func(t *testing.T) {
+		changeSet := submit(t, zone.ID, daos.ChangeSetCreate{Changes: []daos.RecordOperation{
+			{Action: daos.ChangeUpdate, ID: www.ID, Record: &daos.DNSRecordCreate{Value: "192.0.2.10"}},
+			{Action: daos.ChangeDelete, ID: old.ID},
+			{Action: daos.ChangeCreate, Record: &daos.DNSRecordCreate{Name: "new", Type: "CNAME", Value: "www." + zone.Name, TTL: 60}},
+		}}, http.StatusOK)
+		if changeSet.Status != daos.ChangeSetApplied || changeSet.Serial != created.Serial+1 {
+			t.Fatalf("Expected the change set applied in a single serial bump, got %+v", changeSet)
+		}
+
+		var polled daos.ChangeSet
+		resp, err := http.Get("http://localhost:8080/api/change/" + changeSet.ID)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to poll the change set, err: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&polled)
+		resp.Body.Close()
+		if polled.Status != daos.ChangeSetApplied || polled.AppliedAt == nil {
+			t.Errorf("Expected an applied change set, got %+v", polled)
+		}
+	})
+
+	t.Run("Atomic", func(t *testing.T) {
+		// The CNAME would sit next to the A record, nothing is applied
+		body, _ := json.Marshal(daos.ChangeSetCreate{Changes: []daos.RecordOperation{
+			{Action: daos.ChangeDelete, ID: www.ID},
+			{Action: daos.ChangeCreate, Record: &daos.DNSRecordCreate{Name: "new", Type: "A", Value: "192.0.2.3", TTL: 60}},
+		}})
+		resp, err := http.Post("http://localhost:8080/api/zone/"+zone.ID+"/changes", "application/json", bytes.NewReader(body))
+		if err != nil || resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected the change set to be unprocessable, err: %v, status code: %v", err, resp.StatusCode)
+		}
+		var failure daos.Error
+		json.NewDecoder(resp.Body).Decode(&failure)
+		resp.Body.Close()
+		if failure.Code != daos.ErrorUnprocessable || failure.Message == "" {
+			t.Errorf("Expected an unprocessable error, got %+v", failure)
+		}
+		var changeSet daos.ChangeSet
+		resp, err = http.Get("http://localhost:8080" + resp.Header.Get("Location"))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to poll the failed change set, err: %v", err)
+		}
+		json.NewDecoder(resp.Body).Decode(&changeSet)
+		resp.Body.Close()
+		if changeSet.Status != daos.ChangeSetFailed || changeSet.Error != failure.Message {
+			t.Errorf("Expected a failed change set with the error returned, got %+v", changeSet)
+		}
+		var records []daos.DNSRecord
+		resp, _ = http.Get("http://localhost:8080/api/zone/" + zone.ID + "/record")
+		json.NewDecoder(resp.Body).Decode(&records)
+		resp.Body.Close()
+		if len(records) != 2 {
+			t.Errorf("Expected the www and new records to be left as they were, got %+v", records)
+		}
+		var current daos.DNSZone
+		resp, _ = http.Get("http://localhost:8080/api/zone/" + zone.ID)
+		json.NewDecoder(resp.Body).Decode(&current)
+		resp.Body.Close()
+		if current.Serial != created.Serial+1 {
+			t.Errorf("Expected the serial to be left as it was, got %d", current.Serial)
+		}
+	})
+
+	t.Run("InvalidChange", func(t *testing.T) {
+		submit(t, zone.ID, daos.ChangeSetCreate{Changes: []daos.RecordOperation{{Action: "upsert"}}}, http.StatusBadRequest)
+		submit(t, zone.ID, daos.ChangeSetCreate{Changes: []daos.RecordOperation{{Action: daos.ChangeDelete, ID: uuid.NewString()}}}, http.StatusBadRequest)
+	})
+}
+
+func TestETags(t *testing.T) {
+	send := func(method string, url string, body any, ifMatch string) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			reader = bytes.NewReader(encoded)
+		}
+		req, _ := http.NewRequest(method, url, reader)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", url, err)
+		}
+		return resp
+	}
+	resp := send(http.MethodPost, "http://localhost:8080/api/zone", daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}, "")
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+	resp = send(http.MethodPost, "http://localhost:8080/api/zone/"+zone.ID+"/record", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}, "")
+	var record daos.DNSRecord
+	json.NewDecoder(resp.Body).Decode(&record)
+	resp.Body.Close()
+
+	resp = send(http.MethodGet, "http://localhost:8080/api/record/"+record.ID, nil, "")
+	resp.Body.Close()
+	tag := resp.Header.Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("Expected the ETag of the first version, got %q", tag)
+	}
+
+	t.Run("MissingIfMatch", func(t *testing.T) {
+		update := daos.DNSRecordUpdate{ID: record.ID, DNSRecordCreate: daos.DNSRecordCreate{Value: "192.0.2.9"}}
+		for _, resp := range []*http.Response{
+			send(http.MethodPut, "http://localhost:8080/api/record", update, ""),
+			send(http.MethodDelete, "http://localhost:8080/api/record/"+record.ID, nil, ""),
+			send(http.MethodDelete, "http://localhost:8080/api/zone/"+zone.ID, nil, ""),
+		} {
+			var body daos.Error
+			json.NewDecoder(resp.Body).Decode(&body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusPreconditionRequired || body.Code != daos.ErrorPreconditionRequired {
+				t.Errorf("Expected precondition required for %s %s, got status %d and code %q", resp.Request.Method, resp.Request.URL, resp.StatusCode, body.Code)
+			}
+		}
+		resp := send(http.MethodGet, "http://localhost:8080/api/record/"+record.ID, nil, "")
+		resp.Body.Close()
+		if resp.Header.Get("ETag") != tag {
+			t.Fatalf("Expected the record to be unchanged, got ETag %q", resp.Header.Get("ETag"))
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		update := daos.DNSRecordUpdate{ID: record.ID, DNSRecordCreate: daos.DNSRecordCreate{Value: "192.0.2.2"}}
+		resp := send(http.MethodPut, "http://localhost:8080/api/record", update, tag)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"2"` {
+			t.Fatalf("Expected the update to succeed with a new ETag, got status %d and ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+		}
+		// A second update based on the first version is refused
+		update.Value = "192.0.2.3"
+		resp = send(http.MethodPut, "http://localhost:8080/api/record", update, tag)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed || resp.Header.Get("ETag") != `"2"` {
+			t.Errorf("Expected precondition failed with the current ETag, got status %d and ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+		}
+		update.Version = 1
+		resp = send(http.MethodPut, "http://localhost:8080/api/record", update, "*")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("Expected precondition failed for an outdated version in the body, got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp := send(http.MethodDelete, "http://localhost:8080/api/record/"+record.ID, nil, tag)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("Expected precondition failed, got status %d", resp.StatusCode)
+		}
+		resp = send(http.MethodDelete, "http://localhost:8080/api/record/"+record.ID, nil, `"2"`)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected the record to be deleted, got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Zone", func(t *testing.T) {
+		resp := send(http.MethodGet, "http://localhost:8080/api/zone/"+zone.ID, nil, "")
+		resp.Body.Close()
+		zoneTag := resp.Header.Get("ETag")
+		update := daos.DNSZoneUpdate{ID: zone.ID, DNSZoneCreate: daos.DNSZoneCreate{Name: uuid.NewString() + ".com"}}
+		resp = send(http.MethodPut, "http://localhost:8080/api/zone", update, `"7", `+zoneTag)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected the update to succeed, got status %d", resp.StatusCode)
+		}
+		resp = send(http.MethodPut, "http://localhost:8080/api/zone", update, zoneTag)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("Expected precondition failed, got status %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestErrors(t *testing.T) {
+	decode := func(t *testing.T, resp *http.Response, status int) daos.Error {
+		defer resp.Body.Close()
+		var body daos.Error
+		if resp.StatusCode != status || resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("Expected a JSON error with status %d, got status %d and content type %q", status, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code == "" || body.Message == "" {
+			t.Fatalf("Expected an error body, got %+v, err: %v", body, err)
+		}
+		return body
+	}
+	name := uuid.NewString() + ".com"
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: name})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v", err)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+
+	t.Run("DuplicateZone", func(t *testing.T) {
+		resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if code := decode(t, resp, http.StatusConflict).Code; code != daos.ErrorConflict {
+			t.Errorf("Expected the conflict code, got %q", code)
+		}
+	})
+
+	t.Run("DeleteMissingRecord", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/record/"+uuid.NewString(), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if code := decode(t, resp, http.StatusNotFound).Code; code != daos.ErrorNotFound {
+			t.Errorf("Expected the not found code, got %q", code)
+		}
+	})
+
+	t.Run("FieldDetails", func(t *testing.T) {
+		body, _ := json.Marshal(daos.ViewCreate{Name: uuid.NewString(), Networks: []string{"192.0.2.0/33"}})
+		resp, err := http.Post("http://localhost:8080/api/view", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		failure := decode(t, resp, http.StatusBadRequest)
+		if failure.Code != daos.ErrorValidation || len(failure.Details) != 1 || failure.Details[0].Field != "networks" {
+			t.Errorf("Expected a validation error of the networks, got %+v", failure)
+		}
+	})
+
+	t.Run("UnknownRoute", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8080/api/nothing-here")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		decode(t, resp, http.StatusNotFound)
+	})
+}
+
+func TestRecordValidation(t *testing.T) {
+	body, _ := json.Marshal(daos.DNSZoneCreate{Name: uuid.NewString() + ".com"})
+	resp, err := http.Post("http://localhost:8080/api/zone", "application/json", bytes.NewReader(body))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to create zone, err: %v", err)
+	}
+	var zone daos.DNSZone
+	json.NewDecoder(resp.Body).Decode(&zone)
+	resp.Body.Close()
+	create := func(record daos.DNSRecordCreate) (*http.Response, error) {
+		body, _ := json.Marshal(record)
+		return http.Post("http://localhost:8080/api/zone/"+zone.ID+"/record", "application/json", bytes.NewReader(body))
+	}
+
+	invalid := []struct {
+		record daos.DNSRecordCreate
+		fields []string
+	}{
+		{daos.DNSRecordCreate{Name: "www", Type: "A", Value: "hello", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "www", Type: "AAAA", Value: "192.0.2.1", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "www", Type: "BOGUS", Value: "x", TTL: 60}, []string{"type"}},
+		{daos.DNSRecordCreate{Name: "www", Type: "SOA", Value: "ns1 hostmaster 1 2 3 4 5", TTL: 60}, []string{"type"}},
+		{daos.DNSRecordCreate{Name: "@", Type: "MX", Value: "mail.example.com", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "@", Type: "MX", Value: "10 mail!.example.com", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "_sip._tcp", Type: "SRV", Value: "10 5 port sip.example.com", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "@", Type: "CAA", Value: "0 is-sue \"ca.example.net\"", TTL: 60}, []string{"value"}},
+		{daos.DNSRecordCreate{Name: "www.example.org.", Type: "A", Value: "192.0.2.1", TTL: 60}, []string{"name"}},
+		{daos.DNSRecordCreate{Name: "-www", Type: "A", Value: "192.0.2.1", TTL: -1}, []string{"name", "ttl"}},
+	}
+	for _, test := range invalid {
+		resp, err := create(test.record)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var failure daos.Error
+		json.NewDecoder(resp.Body).Decode(&failure)
+		resp.Body.Close()
+		var fields []string
+		for _, detail := range failure.Details {
+			fields = append(fields, detail.Field)
+		}
+		if resp.StatusCode != http.StatusBadRequest || failure.Code != daos.ErrorValidation || strings.Join(fields, ",") != strings.Join(test.fields, ",") {
+			t.Errorf("Expected invalid %v for %+v, got status %d and %+v", test.fields, test.record, resp.StatusCode, failure)
+		}
+	}
+
+	valid := []daos.DNSRecordCreate{
+		{Name: "@", Type: "caa", Value: "0 issue \"ca.example.net\"", TTL: 3600},
+		{Name: "_sip._tcp", Type: "SRV", Value: "10 5 5060 sip.example.com", TTL: 60},
+		{Name: "*", Type: "A", Value: "192.0.2.1", TTL: 60},
+		{Name: "@", Type: "MX", Value: "10 mail.example.com", TTL: 60},
+	}
+	for _, record := range valid {
+		resp, err := create(record)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var created daos.DNSRecord
+		json.NewDecoder(resp.Body).Decode(&created)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || created.Type != strings.ToUpper(record.Type) {
+			t.Errorf("Expected %+v to be created, got status %d and %+v", record, resp.StatusCode, created)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getAuditEntries lists the changes made to zones and records, filtered by
+// the zone, actor, since, until and limit query parameters. Callers limited
+// to some zones only see the changes made to them.
+func getAuditEntries(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	query := r.URL.Query()
+	filter := daos.AuditFilter{ZoneID: query.Get("zone"), Actor: query.Get("actor")}
+	for name, bound := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				jsonError(w, fmt.Sprintf("Invalid %s time, expected RFC 3339", name), http.StatusBadRequest)
+				return
+			}
+			*bound = &at
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 || limit > service.MaxAuditLimit {
+			jsonError(w, fmt.Sprintf("The limit must be between 0 and %d", service.MaxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	auditService, ok := r.Context().Value("auditService").(*service.AuditService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	accessService, ok := r.Context().Value("accessService").(*service.AccessService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	zoneIds, err := accessService.VisibleZoneIDs(callerKey(r))
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	filter.ZoneIDs = zoneIds
+	entries, err := auditService.GetEntries(filter)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
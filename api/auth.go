@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"dnsServer/daos"
+	"dnsServer/service"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// apiKeyHeader is accepted besides the Authorization header for clients
+// that cannot send bearer tokens
+const apiKeyHeader = "X-API-Key"
+
+// authenticate rejects requests without a valid API key or JWT, or whose
+// caller has a scope too narrow for the route, and puts the caller in the
+// context. JWTs are only accepted when oidcService is set.
+func authenticate(apiKeyService *service.APIKeyService, oidcService *service.OIDCService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				jsonError(w, "Missing API key", http.StatusUnauthorized)
+				return
+			}
+			var key *daos.APIKey
+			var err error
+			if oidcService != nil && strings.Count(token, ".") == 2 {
+				key, err = oidcService.Authenticate(token)
+			} else {
+				key, err = apiKeyService.Authenticate(token)
+			}
+			if err != nil {
+				if !errors.Is(err, service.ErrInvalidAPIKey) && !errors.Is(err, service.ErrInvalidToken) {
+					fmt.Println("Error:", err)
+					jsonError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				jsonError(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if required := requiredScope(r); !service.APIScopeAllows(key.Scope, required) {
+				jsonError(w, fmt.Sprintf("Scope %s does not allow this, %s is needed", key.Scope, required), http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), "apiKey", key)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerToken returns the token of the Authorization or X-API-Key header
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get(apiKeyHeader))
+}
+
+// requiredScope returns the scope needed for a request: reading needs any
+// key, changing zones and their records needs a zone admin and everything
+// else, like keys, ACLs and server settings, a global admin
+func requiredScope(r *http.Request) string {
+	template := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			template = t
+		}
+	}
+	if strings.HasPrefix(template, "/api/key") {
+		return daos.APIScopeGlobalAdmin
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return daos.APIScopeReadOnly
+	}
+	for _, prefix := range []string{"/api/zone", "/api/record", "/api/tsig-policy", "/api/role"} {
+		if strings.HasPrefix(template, prefix) {
+			return daos.APIScopeZoneAdmin
+		}
+	}
+	return daos.APIScopeGlobalAdmin
+}
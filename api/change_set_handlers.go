@@ -0,0 +1,159 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// maxChangeSetSize bounds the operations of a change set
+const maxChangeSetSize = 1000
+
+// authorizeOperation checks an operation of a change set is well formed and
+// that the caller may make it, replying bad request or forbidden when not
+func authorizeOperation(w http.ResponseWriter, r *http.Request, access service.ZoneAccess, i int, operation daos.RecordOperation) bool {
+	recordService, ok := r.Context().Value("recordService").(*service.RecordService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return false
+	}
+	var existing *daos.DNSRecord
+	switch operation.Action {
+	case daos.ChangeCreate:
+		if operation.Record == nil {
+			jsonError(w, fmt.Sprintf("Change %d has no record", i), http.StatusBadRequest)
+			return false
+		}
+	case daos.ChangeUpdate, daos.ChangeDelete:
+		record, err := recordService.GetRecord(operation.ID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			serviceError(w, err)
+			return false
+		}
+		if err != nil || record.DNSZoneID != access.Zone.ID {
+			jsonError(w, fmt.Sprintf("Change %d: record %q is not in the zone", i, operation.ID), http.StatusBadRequest)
+			return false
+		}
+		if operation.Action == daos.ChangeUpdate && operation.Record == nil {
+			jsonError(w, fmt.Sprintf("Change %d has no record", i), http.StatusBadRequest)
+			return false
+		}
+		if !authorizeRecordEdit(w, access, record.Type, record.Name) {
+			return false
+		}
+		existing = record
+	default:
+		jsonError(w, fmt.Sprintf("Change %d has unknown action %q", i, operation.Action), http.StatusBadRequest)
+		return false
+	}
+	if operation.Record == nil {
+		return true
+	}
+
+	recordType, recordName := operation.Record.Type, operation.Record.Name
+	if existing != nil && recordType == "" {
+		recordType = existing.Type
+	}
+	if existing != nil && recordName == "" {
+		recordName = existing.Name
+	}
+	return authorizeRecordEdit(w, access, recordType, recordName)
+}
+
+// createChangeSet applies a batch of record operations to a zone, all of
+// them or none, and returns the applied change set. When it could not be
+// applied the error is returned instead, unprocessable when the changes are
+// invalid or a failed precondition when a record is no longer at the
+// version a change is based on, with the failed change set at Location.
+func createChangeSet(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	var data daos.ChangeSetCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(data.Changes) == 0 || len(data.Changes) > maxChangeSetSize {
+		jsonError(w, fmt.Sprintf("A change set has 1 to %d changes", maxChangeSetSize), http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	changeSetService, ok := r.Context().Value("changeSetService").(*service.ChangeSetService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	access, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleEditor)
+	if !ok {
+		return
+	}
+	for i, operation := range data.Changes {
+		if !authorizeOperation(w, r, access, i, operation) {
+			return
+		}
+	}
+	changeSet, err := changeSetService.Submit(callerActor(r), zoneId, data)
+	if err != nil {
+		if changeSet.ID != "" {
+			w.Header().Set("Location", "/api/change/"+changeSet.ID)
+		}
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changeSet)
+}
+
+// getChangeSets lists the recent change sets of a zone
+func getChangeSets(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	changeSetService, ok := r.Context().Value("changeSetService").(*service.ChangeSetService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	changeSets, err := changeSetService.GetChangeSets(zoneId)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changeSets)
+}
+
+// getChangeSet returns a change set with its status
+func getChangeSet(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	changeSetService, ok := r.Context().Value("changeSetService").(*service.ChangeSetService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	changeSet, err := changeSetService.GetChangeSet(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if _, ok := authorizeZone(w, r, changeSet.ZoneID, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changeSet)
+}
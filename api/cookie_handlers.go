@@ -0,0 +1,43 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/server"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// getCookieConfig returns what clients with a valid DNS cookie are trusted with
+func getCookieConfig(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.CookieConfig())
+}
+
+func updateCookieConfig(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.CookieConfig
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	dnsServer.SetCookieConfig(data)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.CookieConfig())
+}
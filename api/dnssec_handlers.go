@@ -0,0 +1,139 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func enableDNSSEC(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var data daos.DNSSECEnable
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	dnssecService, ok := r.Context().Value("dnssecService").(*service.DNSSECService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	status, err := dnssecService.EnableDNSSEC(id, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func getDNSSEC(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	dnssecService, ok := r.Context().Value("dnssecService").(*service.DNSSECService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	status, err := dnssecService.GetStatus(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func disableDNSSEC(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	dnssecService, ok := r.Context().Value("dnssecService").(*service.DNSSECService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	if err := dnssecService.DisableDNSSEC(id); err != nil {
+		serviceError(w, err)
+	}
+}
+
+// confirmDS tells the key manager the parent publishes the DS of a new KSK,
+// which the KSK it replaces is kept for
+func confirmDS(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var data daos.DSSeen
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	dnssecService, ok := r.Context().Value("dnssecService").(*service.DNSSECService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	status, err := dnssecService.ConfirmDS(id, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func startDNSSECRollover(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var data daos.DNSSECRollover
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	dnssecService, ok := r.Context().Value("dnssecService").(*service.DNSSECService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	status, err := dnssecService.StartRollover(id, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"dnsServer/server"
+	"dnsServer/utils"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DNS over HTTPS media types (RFC 8484 and the Google/Cloudflare JSON API)
+const (
+	dnsMessageType = "application/dns-message"
+	dnsJSONType    = "application/dns-json"
+)
+
+// maxDNSMessageSize is the largest message carried over DNS over HTTPS
+const maxDNSMessageSize = 65535
+
+// dnsJSONQuestion and dnsJSONRecord follow the JSON API of public resolvers
+type dnsJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dnsJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type dnsJSONResponse struct {
+	Status     uint16            `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []dnsJSONQuestion `json:"Question"`
+	Answer     []dnsJSONRecord   `json:"Answer,omitempty"`
+	Authority  []dnsJSONRecord   `json:"Authority,omitempty"`
+	Additional []dnsJSONRecord   `json:"Additional,omitempty"`
+}
+
+// dnsQuery serves DNS over HTTPS: wire format messages in the dns parameter
+// of GET requests or the body of POST requests, and JSON answers to GET
+// requests with name and type parameters
+func dnsQuery(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Query().Get("dns") == "" {
+		dnsJSONQuery(w, r, dnsServer)
+		return
+	}
+
+	var message []byte
+	if r.Method == http.MethodPost {
+		if mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]); mediaType != dnsMessageType {
+			jsonError(w, "Expected a body of type "+dnsMessageType, http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxDNSMessageSize+1))
+		if err != nil || len(body) > maxDNSMessageSize {
+			jsonError(w, "Invalid DNS message", http.StatusBadRequest)
+			return
+		}
+		message = body
+	} else {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(r.URL.Query().Get("dns"), "="))
+		if err != nil {
+			jsonError(w, "Invalid dns parameter, expected a base64url encoded DNS message", http.StatusBadRequest)
+			return
+		}
+		message = decoded
+	}
+
+	responseBytes := dnsServer.HandleMessage(message, remoteAddr(r))
+	if responseBytes == nil {
+		jsonError(w, "Invalid DNS message", http.StatusBadRequest)
+		return
+	}
+	response, err := utils.ParseDNSResponse(responseBytes)
+	if err == nil {
+		setCacheControl(w, response)
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseBytes)))
+	w.Write(responseBytes)
+}
+
+// dnsJSONQuery answers the name and type parameters in the JSON format, with
+// the do and cd parameters setting the DO and CD bits of the query
+func dnsJSONQuery(w http.ResponseWriter, r *http.Request, dnsServer *server.DNSServer) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" || len(name) > 253 {
+		jsonError(w, "Invalid name parameter", http.StatusBadRequest)
+		return
+	}
+	qtype := utils.TypeA
+	if typeParam := query.Get("type"); typeParam != "" {
+		if number, err := strconv.ParseUint(typeParam, 10, 16); err == nil {
+			qtype = utils.DNSRecordType(number)
+		} else if parsed, ok := utils.ParseDNSRecordType(typeParam); ok {
+			qtype = parsed
+		} else {
+			jsonError(w, "Invalid type parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	packet := utils.DNSPacket{
+		Header:    utils.DNSHeader{Flags: utils.FlagRD},
+		Questions: []utils.DNSQuestion{{Name: strings.TrimSuffix(name, "."), Type: qtype, Class: utils.ClassIN}},
+	}
+	if isTrue(query.Get("cd")) {
+		packet.Header.Flags |= utils.FlagCD
+	}
+	if isTrue(query.Get("do")) {
+		packet.Additional = []utils.DNSAnswer{utils.EDNS{UDPSize: utils.DefaultEDNSUDPSize, DO: true}.ToAnswer()}
+	}
+	responseBytes := dnsServer.HandleMessage(packet.Serialize(), remoteAddr(r))
+	response, err := utils.ParseDNSResponse(responseBytes)
+	if err != nil {
+		jsonError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	flags := response.Header.Flags
+	toRet := dnsJSONResponse{
+		Status: response.Header.Rcode(),
+		TC:     flags&utils.FlagTC != 0,
+		RD:     flags&utils.FlagRD != 0,
+		RA:     flags&utils.FlagRA != 0,
+		AD:     flags&utils.FlagAD != 0,
+		CD:     flags&utils.FlagCD != 0,
+	}
+	for _, question := range response.Questions {
+		toRet.Question = append(toRet.Question, dnsJSONQuestion{Name: fqdn(question.Name), Type: uint16(question.Type)})
+	}
+	toRet.Answer = dnsJSONRecords(response.Answers)
+	toRet.Authority = dnsJSONRecords(response.Authority)
+	toRet.Additional = dnsJSONRecords(response.Additional)
+
+	setCacheControl(w, response)
+	w.Header().Set("Content-Type", dnsJSONType)
+	json.NewEncoder(w).Encode(toRet)
+}
+
+func dnsJSONRecords(section []utils.DNSAnswer) []dnsJSONRecord {
+	var records []dnsJSONRecord
+	for _, answer := range section {
+		if answer.Type == utils.TypeOPT {
+			continue
+		}
+		records = append(records, dnsJSONRecord{Name: fqdn(answer.Name), Type: uint16(answer.Type), TTL: answer.TTL, Data: answer.Value()})
+	}
+	return records
+}
+
+// setCacheControl lets HTTP caches keep the response as long as its
+// shortest lived record (RFC 8484 section 5.1)
+func setCacheControl(w http.ResponseWriter, response utils.DNSResponse) {
+	var ttl uint32
+	found := false
+	for _, answer := range append(response.Answers, response.Authority...) {
+		if !found || answer.TTL < ttl {
+			ttl, found = answer.TTL, true
+		}
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+}
+
+// remoteAddr returns the address of the HTTP client, for the DNS pipeline
+func remoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	return addr
+}
+
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+func isTrue(value string) bool {
+	return value == "1" || strings.EqualFold(value, "true")
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// writeError replies with an error of the API's error model
+func writeError(w http.ResponseWriter, status int, body daos.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// jsonError replies with an error whose code follows from its status
+func jsonError(w http.ResponseWriter, message string, status int) {
+	writeError(w, status, daos.Error{Code: errorCode(status), Message: message})
+}
+
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return daos.ErrorBadRequest
+	case http.StatusUnauthorized:
+		return daos.ErrorUnauthorized
+	case http.StatusForbidden:
+		return daos.ErrorForbidden
+	case http.StatusNotFound:
+		return daos.ErrorNotFound
+	case http.StatusConflict:
+		return daos.ErrorConflict
+	case http.StatusPreconditionFailed:
+		return daos.ErrorPreconditionFailed
+	case http.StatusPreconditionRequired:
+		return daos.ErrorPreconditionRequired
+	case http.StatusUnprocessableEntity:
+		return daos.ErrorUnprocessable
+	case http.StatusInternalServerError:
+		return daos.ErrorInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// errorStatus is the status of the kind of an error returned by a service
+func errorStatus(err error) int {
+	var validation *service.ValidationError
+	var versionConflict *service.VersionConflictError
+	var conflict *service.ConflictError
+	switch {
+	case errors.As(err, &validation):
+		return http.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &versionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.As(err, &conflict), errors.Is(err, service.ErrViewInUse):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrInvalidChangeSet), errors.Is(err, service.ErrInvalidZoneFile):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// serviceError replies with an error returned by a service, with the
+// status of its kind. Unexpected errors are logged and hidden from clients.
+func serviceError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	var validation *service.ValidationError
+	var versionConflict *service.VersionConflictError
+	switch {
+	case errors.As(err, &validation):
+		writeError(w, status, daos.Error{Code: daos.ErrorValidation, Message: service.ErrorMessage(err), Details: validation.Fields})
+		return
+	case errors.As(err, &versionConflict):
+		w.Header().Set("ETag", etag(versionConflict.Current))
+	case status == http.StatusInternalServerError:
+		fmt.Println("Error:", err)
+	}
+	jsonError(w, service.ErrorMessage(err), status)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of a version of a zone or a record
+func etag(version uint32) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// checkIfMatch compares the If-Match header of a request with the current
+// version of the resource. It returns the version the change must be based
+// on, 0 for any. Changes must be conditional: it replies precondition
+// required without the header and precondition failed when no tag matches.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, current uint32) (uint32, bool) {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		w.Header().Set("ETag", etag(current))
+		jsonError(w, "An If-Match header with the ETag of the resource, or *, is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	for _, tag := range strings.Split(strings.Join(values, ","), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, true
+		}
+		if tag == etag(current) {
+			return current, true
+		}
+	}
+	w.Header().Set("ETag", etag(current))
+	jsonError(w, "The resource has changed, its current ETag is "+etag(current), http.StatusPreconditionFailed)
+	return 0, false
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/server"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// getRecordHealth returns the health of a record and its recent transitions
+func getRecordHealth(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	record, _, ok := authorizeRecord(w, r, id, daos.ZoneRoleViewer)
+	if !ok {
+		return
+	}
+	health := dnsServer.RecordHealth(id)
+	if health.Check == nil {
+		health.Check = record.HealthCheck
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseZoneVersion reads a zone version given as a serial or an RFC 3339
+// time, empty for the current version
+func parseZoneVersion(value string) (daos.ZoneVersion, error) {
+	if value == "" {
+		return daos.ZoneVersion{}, nil
+	}
+	if serial, err := strconv.ParseUint(value, 10, 32); err == nil {
+		s := uint32(serial)
+		return daos.ZoneVersion{Serial: &s}, nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return daos.ZoneVersion{}, fmt.Errorf("invalid version %q, expected a serial or an RFC 3339 time", value)
+	}
+	return daos.ZoneVersion{At: &at}, nil
+}
+
+// getZoneHistory lists the versions of the records of a zone, or of the
+// record given with the record query parameter
+func getZoneHistory(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	historyService, ok := r.Context().Value("historyService").(*service.HistoryService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	versions, err := historyService.GetVersions(id, r.URL.Query().Get("record"))
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// getZoneSnapshot returns the records of a zone as of the serial or at
+// query parameter
+func getZoneSnapshot(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	query := r.URL.Query()
+	var version daos.ZoneVersion
+	if value := query.Get("serial"); value != "" {
+		serial, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			jsonError(w, "Invalid serial", http.StatusBadRequest)
+			return
+		}
+		s := uint32(serial)
+		version.Serial = &s
+	}
+	if value := query.Get("at"); value != "" {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil || version.Serial != nil {
+			jsonError(w, "Give either a serial or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		version.At = &at
+	}
+	historyService, ok := r.Context().Value("historyService").(*service.HistoryService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	snapshot, err := historyService.Snapshot(id, version)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// getZoneDiff returns the changes between the from and to versions of a
+// zone, to defaulting to the current version
+func getZoneDiff(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	query := r.URL.Query()
+	if query.Get("from") == "" {
+		jsonError(w, "A from version is needed", http.StatusBadRequest)
+		return
+	}
+	from, err := parseZoneVersion(query.Get("from"))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseZoneVersion(query.Get("to"))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	historyService, ok := r.Context().Value("historyService").(*service.HistoryService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	diff, err := historyService.Diff(id, from, to)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// rollbackZone brings the records of a zone back to a previous version and
+// returns the changes made
+func rollbackZone(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var data daos.ZoneVersion
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	if (data.Serial == nil) == (data.At == nil) {
+		jsonError(w, "Give either a serial or a time to roll back to", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	historyService, ok := r.Context().Value("historyService").(*service.HistoryService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, id, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	diff, err := historyService.Rollback(callerActor(r), id, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
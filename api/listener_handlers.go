@@ -0,0 +1,51 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/server"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// getListenerOptions returns the options of every listener, by name
+func getListenerOptions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.ListenerOptions())
+}
+
+// updateListenerOptions sets the options of the listener named in the
+// path, or of all of them when none is
+func updateListenerOptions(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var data daos.ListenerOptions
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	if err := dnsServer.SetListenerOptions(name, data); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.ListenerOptions())
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/server"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// getRRL returns the response rate limiting configuration and counters
+func getRRL(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.RateLimitStats())
+}
+
+func updateRRL(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.RRLConfig
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received data: %+v\n", data)
+
+	dnsServer, ok := r.Context().Value("dnsServer").(*server.DNSServer)
+	if !ok {
+		jsonError(w, "DNS server is not available", http.StatusInternalServerError)
+		return
+	}
+	if err := dnsServer.SetRateLimit(data); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dnsServer.RateLimitStats())
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"dnsServer/daos"
+	"dnsServer/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+func createTSIGKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+
+	var data daos.TSIGKeyCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+	fmt.Printf("Received TSIG key: %s\n", data.Name)
+
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	key, err := tsigService.CreateKey(data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func getTSIGKeys(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	keys, err := tsigService.GetKeys()
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func getTSIGKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	key, err := tsigService.GetKey(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+func deleteTSIGKey(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if err := tsigService.DeleteKey(id); err != nil {
+		serviceError(w, err)
+	}
+}
+
+func createTSIGPolicy(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	var data daos.TSIGPolicyCreate
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		jsonError(w, "Error parsing JSON body", http.StatusBadRequest)
+		return
+	}
+
+	fmt.Printf("Received data: %+v\n", data)
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	policy, err := tsigService.CreatePolicy(zoneId, data)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func getTSIGPolicies(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	zoneId := vars["zone_id"]
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := authorizeZone(w, r, zoneId, daos.ZoneRoleViewer); !ok {
+		return
+	}
+	policies, err := tsigService.GetPolicies(zoneId)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policies)
+}
+
+func deleteTSIGPolicy(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	tsigService, ok := r.Context().Value("tsigService").(*service.TSIGService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	policy, err := tsigService.GetPolicy(id)
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	if _, ok := authorizeZone(w, r, policy.ZoneID, daos.ZoneRoleOwner); !ok {
+		return
+	}
+	if err := tsigService.DeletePolicy(id); err != nil {
+		serviceError(w, err)
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"dnsServer/daos"
+	"dnsServer/service"
+	"dnsServer/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxZoneFileSize bounds the zone files, and their includes, that can be imported
+const maxZoneFileSize = 16 << 20
+
+// readZoneFile reads the zone file of an import request, sent as the body or
+// as the "zone" part of a multipart form. The other parts of the form are
+// the files its $INCLUDE directives can name.
+func readZoneFile(r *http.Request) ([]byte, utils.IncludeFunc, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		content, err := io.ReadAll(r.Body)
+		return content, nil, err
+	}
+	if err := r.ParseMultipartForm(maxZoneFileSize); err != nil {
+		return nil, nil, err
+	}
+	files := map[string][]byte{}
+	for field, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			files[field] = content
+			files[header.Filename] = content
+		}
+	}
+	for field, values := range r.MultipartForm.Value {
+		files[field] = []byte(strings.Join(values, "\n"))
+	}
+	zone, ok := files["zone"]
+	if !ok {
+		return nil, nil, fmt.Errorf("the form has no zone part")
+	}
+	include := func(name string) ([]byte, error) {
+		content, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("included file %s was not sent", name)
+		}
+		return content, nil
+	}
+	return zone, include, nil
+}
+
+// importZone applies a zone file to a zone, merging its records with those
+// of the zone or replacing them according to the mode query parameter, and
+// returns the changes. Nothing is changed when dryRun is true.
+func importZone(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	query := r.URL.Query()
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = daos.ImportMerge
+	}
+	if mode != daos.ImportMerge && mode != daos.ImportReplace {
+		jsonError(w, "The mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+	dryRun := query.Get("dryRun") == "true"
+
+	zoneFileService, ok := r.Context().Value("zoneFileService").(*service.ZoneFileService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	access, ok := authorizeZone(w, r, id, daos.ZoneRoleEditor)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxZoneFileSize)
+	content, include, err := readZoneFile(r)
+	if err != nil {
+		jsonError(w, "Error reading zone file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := utils.ParseZoneFile("zone", content, access.Zone.Name, include)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Every change is checked in the transaction making them, so that none
+	// can slip in between
+	var denied *daos.DNSRecord
+	diff, err := zoneFileService.Import(callerActor(r), id, records, mode, dryRun, func(diff daos.ZoneDiff) error {
+		changes := append(append([]daos.DNSRecord{}, diff.Added...), diff.Removed...)
+		for _, change := range diff.Changed {
+			changes = append(changes, change.After)
+		}
+		for i, change := range changes {
+			if !access.AllowsRecord(change.Type, change.Name) {
+				denied = &changes[i]
+				return errors.New("record edit not allowed")
+			}
+		}
+		return nil
+	})
+	if denied != nil {
+		authorizeRecordEdit(w, access, denied.Type, denied.Name)
+		return
+	}
+	if err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// exportZone returns a zone as a master file that BIND can load
+func exportZone(w http.ResponseWriter, r *http.Request) {
+
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	zoneFileService, ok := r.Context().Value("zoneFileService").(*service.ZoneFileService)
+	if !ok {
+		jsonError(w, "Could not get database connection", http.StatusInternalServerError)
+		return
+	}
+	access, ok := authorizeZone(w, r, id, daos.ZoneRoleViewer)
+	if !ok {
+		return
+	}
+	var zoneFile bytes.Buffer
+	if err := zoneFileService.Export(&zoneFile, id); err != nil {
+		serviceError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/dns")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimSuffix(access.Zone.Name, ".")+".zone"))
+	w.Write(zoneFile.Bytes())
+}
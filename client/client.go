@@ -1,12 +1,32 @@
 package client
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
 	"dnsServer/utils"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"net"
+	"strings"
+	"sync"
+	"time"
 )
 
+// queryTimeout bounds how long the client waits for a response
+const queryTimeout = 5 * time.Second
+
+// DNSClient sends queries to a DNS server. It may be used concurrently:
+// over UDP each exchange has its own socket, over TCP they take turns.
 type DNSClient struct {
-	conn *net.UDPConn
+	mu        sync.Mutex   // Guards the connection of TCP clients and the cookie
+	conn      net.Conn     // Connection of TCP and TLS clients
+	addr      *net.UDPAddr // Server of UDP clients
+	tcp       bool
+	tsigKey   *utils.TSIGKey
+	validator *validator    // Set when DNSSEC is enabled
+	cookie    *utils.Cookie // Set when cookies are enabled
 }
 
 func NewDNSClient(serverAddress string) (*DNSClient, error) {
@@ -15,42 +35,314 @@ func NewDNSClient(serverAddress string) (*DNSClient, error) {
 		return nil, err
 	}
 
-	conn, err := net.DialUDP("udp", nil, addr)
+	return &DNSClient{addr: addr}, nil
+}
+
+// NewTCPDNSClient creates a client that sends its queries over TCP, as
+// needed for zone transfers
+func NewTCPDNSClient(serverAddress string) (*DNSClient, error) {
+	conn, err := net.Dial("tcp", serverAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DNSClient{conn: conn, tcp: true}, nil
+}
+
+// NewTLSDNSClient creates a client that sends its queries over TLS (RFC 7858),
+// reusing the connection for all of them. A nil config verifies the server
+// certificate against the system roots.
+func NewTLSDNSClient(serverAddress string, config *tls.Config) (*DNSClient, error) {
+	dialer := &net.Dialer{Timeout: queryTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", serverAddress, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DNSClient{conn: conn}, nil
+	return &DNSClient{conn: conn, tcp: true}, nil
 }
 
-func (client *DNSClient) SendQuery(name string, requestType utils.DNSRecordType) (utils.DNSResponse, error) {
+// SetTSIGKey makes the client sign its requests and verify the responses with key
+func (client *DNSClient) SetTSIGKey(key utils.TSIGKey) {
+	client.tsigKey = &key
+}
+
+// EnableDNSSEC makes the client request DNSSEC records with the DO bit, so
+// ValidatedQuery can validate answers from the trust anchors down
+func (client *DNSClient) EnableDNSSEC(anchors ...TrustAnchor) {
+	client.validator = newValidator(client, anchors)
+}
+
+// SendQuery sends a query, with the given EDNS options if any, and returns the response
+func (client *DNSClient) SendQuery(name string, requestType utils.DNSRecordType, options ...utils.EDNSOption) (utils.DNSResponse, error) {
 	header := utils.DNSHeader{
-		ID:      0xABCD, // Transaction ID
 		Flags:   0x0100, // Standard query
 		Qdcount: 1,      // One question
 	}
 
 	packet := utils.DNSPacket{
 		Header:    header,
-		Questions: []utils.DNSQuestion{{name, requestType, 1}},
+		Questions: []utils.DNSQuestion{{Name: name, Type: requestType, Class: 1}},
+	}
+	if client.validator != nil || len(options) > 0 {
+		packet.Additional = []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: client.validator != nil, Options: options}.ToAnswer()}
 	}
 
-	sentData := packet.Serialize()
-	_, err := client.conn.Write(sentData)
+	return client.Exchange(packet)
+}
+
+// ValidatedQuery sends a query and validates the chain of trust of the
+// response. Bogus responses are returned too, callers decide what to do.
+// The EDNS options are only sent with the query itself, not with the
+// queries for the keys proving it.
+func (client *DNSClient) ValidatedQuery(name string, requestType utils.DNSRecordType, options ...utils.EDNSOption) (utils.DNSResponse, Security, error) {
+	if client.validator == nil {
+		return utils.DNSResponse{}, Insecure, fmt.Errorf("DNSSEC is not enabled")
+	}
+	response, err := client.validator.query(name, requestType, options...)
 	if err != nil {
-		return utils.DNSResponse{}, err
+		return response, Bogus, err
 	}
+	security, err := client.validator.validate(name, requestType, response)
+	return response, security, err
+}
 
-	buffer := make([]byte, 1024)
-	n, _, err := client.conn.ReadFromUDP(buffer)
-	if err != nil {
+// EnableCookies makes the client send a DNS cookie (RFC 7873) with its
+// requests, along with the server cookie of the last response, proving to
+// the server that the requests come from the address they claim
+func (client *DNSClient) EnableCookies() {
+	cookie := make([]byte, 8)
+	rand.Read(cookie)
+	client.cookie = &utils.Cookie{Client: cookie}
+}
+
+// Exchange sends any message, such as an UPDATE or NOTIFY, and returns the
+// response. The message is given a random ID.
+func (client *DNSClient) Exchange(packet utils.DNSPacket) (utils.DNSResponse, error) {
+	response, err := client.exchange(packet)
+	if err == nil && client.cookie != nil && response.ExtendedRcode() == utils.RcodeBadCookie {
+		// Retry once with the server cookie that came with the error
+		response, err = client.exchange(packet)
+	}
+	return response, err
+}
+
+func (client *DNSClient) exchange(packet utils.DNSPacket) (utils.DNSResponse, error) {
+	var id [2]byte
+	rand.Read(id[:])
+	packet.Header.ID = binary.BigEndian.Uint16(id[:])
+	if client.cookie != nil {
+		packet = client.withCookie(packet)
+	}
+	sentData := packet.Serialize()
+	var requestMAC []byte
+	if client.tsigKey != nil {
+		var err error
+		sentData, requestMAC, err = utils.SignTSIG(sentData, *client.tsigKey, nil, time.Now())
+		if err != nil {
+			return utils.DNSResponse{}, err
+		}
+	}
+
+	conn := client.conn
+	if client.tcp {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(sentData)))
+		sentData = append(length, sentData...)
+	} else {
+		// A socket of its own gives each query a random source port too
+		udp, err := net.DialUDP("udp", nil, client.addr)
+		if err != nil {
+			return utils.DNSResponse{}, err
+		}
+		defer udp.Close()
+		conn = udp
+	}
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+	if _, err := conn.Write(sentData); err != nil {
 		return utils.DNSResponse{}, err
 	}
 
-	response := utils.ParseDNSResponse(buffer[:n])
+	// Late replies to earlier queries and spoofed ones are skipped until the deadline
+	for {
+		buffer, err := client.read(conn)
+		if err != nil {
+			return utils.DNSResponse{}, err
+		}
+		response, err := utils.ParseDNSResponse(buffer)
+		if err != nil || !answers(response, packet) {
+			continue
+		}
+		if client.tsigKey != nil {
+			record, _, err := utils.ReadTSIG(buffer)
+			if err != nil {
+				return response, err
+			}
+			if record == nil {
+				return response, fmt.Errorf("response is not signed")
+			}
+			if record.Error != 0 {
+				return response, &utils.TSIGError{Code: record.Error}
+			}
+			if record, err = utils.VerifyTSIG(buffer, *client.tsigKey, requestMAC, time.Now()); err != nil {
+				return response, err
+			}
+			requestMAC = record.MAC
+			response.Additional = response.Additional[:len(response.Additional)-1]
+		}
+		if client.cookie != nil {
+			client.rememberCookie(response)
+		}
+		if client.tcp && isTransfer(packet) && response.Header.Rcode() == utils.RcodeSuccess {
+			return client.readTransfer(conn, response, requestMAC)
+		}
+		return response, nil
+	}
+}
+
+// isTransfer reports whether the query asks for a zone transfer
+func isTransfer(packet utils.DNSPacket) bool {
+	return len(packet.Questions) == 1 &&
+		(packet.Questions[0].Type == utils.TypeAXFR || packet.Questions[0].Type == utils.TypeIXFR)
+}
+
+// readTransfer reads the messages following the first one of a zone transfer
+// until the closing SOA record, and returns the response with the answers of
+// all of them. mac is the MAC of the first message when the transfer is
+// signed; each message must be signed as a continuation of the one before.
+func (client *DNSClient) readTransfer(conn net.Conn, response utils.DNSResponse, mac []byte) (utils.DNSResponse, error) {
+	for !transferComplete(response) {
+		buffer, err := client.read(conn)
+		if err != nil {
+			return response, err
+		}
+		next, err := utils.ParseDNSResponse(buffer)
+		if err != nil {
+			return response, err
+		}
+		if next.Header.ID != response.Header.ID {
+			return response, fmt.Errorf("unexpected message %d in zone transfer", next.Header.ID)
+		}
+		if next.Header.Rcode() != utils.RcodeSuccess {
+			return next, fmt.Errorf("zone transfer failed with rcode %d", next.Header.Rcode())
+		}
+		if client.tsigKey != nil {
+			record, err := utils.VerifyTSIGContinuation(buffer, *client.tsigKey, mac, time.Now())
+			if err != nil {
+				return response, err
+			}
+			mac = record.MAC
+			next.Additional = next.Additional[:len(next.Additional)-1]
+		}
+		response.Answers = append(response.Answers, next.Answers...)
+	}
 	return response, nil
 }
 
+// transferComplete reports whether the answers of a transfer end with the
+// SOA record they start with. An IXFR answered with the SOA alone is complete
+// too, the zone being up to date, and so is one without answers.
+func transferComplete(response utils.DNSResponse) bool {
+	answers := response.Answers
+	if len(answers) == 0 {
+		return true
+	}
+	if len(answers) == 1 && answers[0].Type == utils.TypeSOA {
+		return response.Questions[0].Type == utils.TypeIXFR
+	}
+	return len(answers) > 1 && answers[len(answers)-1].Type == utils.TypeSOA
+}
+
+// answers reports whether a response has the ID and questions of a query.
+// Errors may come without the question.
+func answers(response utils.DNSResponse, query utils.DNSPacket) bool {
+	if response.Header.ID != query.Header.ID || response.Header.Flags&utils.FlagQR == 0 {
+		return false
+	}
+	if len(response.Questions) == 0 {
+		return response.Header.Rcode() != utils.RcodeSuccess
+	}
+	if len(response.Questions) != len(query.Questions) {
+		return false
+	}
+	for i, question := range response.Questions {
+		asked := query.Questions[i]
+		if question.Type != asked.Type || question.Class != asked.Class ||
+			!strings.EqualFold(strings.TrimSuffix(question.Name, "."), strings.TrimSuffix(asked.Name, ".")) {
+			return false
+		}
+	}
+	return true
+}
+
+// withCookie returns the packet with our cookies in its OPT record, adding one if needed
+func (client *DNSClient) withCookie(packet utils.DNSPacket) utils.DNSPacket {
+	client.mu.Lock()
+	cookie := *client.cookie
+	client.mu.Unlock()
+	additional := append([]utils.DNSAnswer(nil), packet.Additional...)
+	edns := packet.EDNS()
+	if edns == nil {
+		edns = &utils.EDNS{UDPSize: 4096}
+		additional = append(additional, utils.DNSAnswer{Type: utils.TypeOPT})
+	}
+	var options []utils.EDNSOption
+	for _, option := range edns.Options {
+		if option.Code != utils.EDNSOptionCookie {
+			options = append(options, option)
+		}
+	}
+	edns.Options = append(options, cookie.ToOption())
+	for i, answer := range additional {
+		if answer.Type == utils.TypeOPT {
+			additional[i] = edns.ToAnswer()
+			break
+		}
+	}
+	packet.Additional = additional
+	return packet
+}
+
+// rememberCookie keeps the server cookie of a response to our client cookie
+func (client *DNSClient) rememberCookie(response utils.DNSResponse) {
+	edns := response.EDNS()
+	if edns == nil {
+		return
+	}
+	cookie, err := edns.Cookie()
+	if err != nil || cookie == nil || len(cookie.Server) == 0 || !bytes.Equal(cookie.Client, client.cookie.Client) {
+		return
+	}
+	client.mu.Lock()
+	client.cookie.Server = cookie.Server
+	client.mu.Unlock()
+}
+
+func (client *DNSClient) read(conn net.Conn) ([]byte, error) {
+	if !client.tcp {
+		buffer := make([]byte, 65535)
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		return buffer[:n], nil
+	}
+
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, length)
+	if _, err := io.ReadFull(conn, buffer); err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
+
 func (client *DNSClient) Close() {
-	client.conn.Close()
+	if client.conn != nil {
+		client.conn.Close()
+	}
 }
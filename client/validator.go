@@ -0,0 +1,594 @@
+package client
+
+import (
+	"bytes"
+	"dnsServer/utils"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrustCache bounds how long proven keys and delegations are cached
+const maxTrustCache = time.Hour
+
+// Security is the outcome of validating a response (RFC 4035 section 4.3)
+type Security int
+
+const (
+	Insecure Security = iota // No chain of trust covers the answer, or it proves an insecure delegation
+	Secure                   // Every record of the answer is signed by keys chained to a trust anchor
+	Bogus                    // The answer should be signed, but signatures or proofs are missing or invalid
+)
+
+func (security Security) String() string {
+	switch security {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	}
+	return "insecure"
+}
+
+// TrustAnchor is the DS of a key trusted without proof, usually the root KSK
+type TrustAnchor struct {
+	Zone string
+	DS   utils.DS
+}
+
+// RootTrustAnchor is the DS of the root zone KSK-2017, published by IANA
+var RootTrustAnchor = TrustAnchor{Zone: ".", DS: utils.DS{KeyTag: 20326, Algorithm: 8, DigestType: utils.DigestSHA256,
+	Digest: []byte{0xe0, 0x6d, 0x44, 0xb8, 0x0b, 0x8f, 0x1d, 0x39, 0xa9, 0x5c, 0x0b, 0x0d, 0x7c, 0x65, 0xd0, 0x84,
+		0x58, 0xe8, 0x80, 0x40, 0x9b, 0xbc, 0x68, 0x34, 0x57, 0x10, 0x42, 0x37, 0xc7, 0xf8, 0xec, 0x8d}}}
+
+// zoneTrust is what the validator proved about the zone starting at a name
+type zoneTrust struct {
+	cut      bool // Whether a zone starts at the name
+	security Security
+	keys     []utils.DNSKEY // Validated keys of secure zones
+	expires  time.Time
+}
+
+// validator follows the chain of trust from the trust anchors down to the
+// zones of the records it validates, caching what it proves along the way
+type validator struct {
+	client  *DNSClient
+	anchors map[string][]utils.DS
+	mu      sync.Mutex // Guards the cache
+	cache   map[string]*zoneTrust
+}
+
+func newValidator(client *DNSClient, anchors []TrustAnchor) *validator {
+	v := &validator{client: client, anchors: map[string][]utils.DS{}, cache: map[string]*zoneTrust{}}
+	for _, anchor := range anchors {
+		zone := canonicalName(anchor.Zone)
+		v.anchors[zone] = append(v.anchors[zone], anchor.DS)
+	}
+	return v
+}
+
+// validate returns the security status of the response to a query
+func (v *validator) validate(name string, qtype utils.DNSRecordType, response utils.DNSResponse) (Security, error) {
+	security := Secure
+	merge := func(s Security) {
+		if s == Bogus || (s == Insecure && security == Secure) {
+			security = s
+		}
+	}
+
+	for _, section := range [][]utils.DNSAnswer{response.Answers, response.Authority} {
+		for _, rrset := range groupRRSets(section) {
+			if rrset[0].Type == utils.TypeRRSIG || rrset[0].Type == utils.TypeOPT {
+				continue
+			}
+			s, err := v.validateRRSet(rrset, section, response.Authority)
+			if err != nil {
+				return Bogus, err
+			}
+			merge(s)
+		}
+	}
+
+	// Follow the CNAME chain to the name the answer is about
+	name = canonicalName(name)
+	for i := 0; i < len(response.Answers) && qtype != utils.TypeCNAME; i++ {
+		for _, answer := range response.Answers {
+			if answer.Type == utils.TypeCNAME && canonicalName(answer.Name) == name {
+				name = canonicalName(answer.Cname)
+				break
+			}
+		}
+	}
+	for _, answer := range response.Answers {
+		if canonicalName(answer.Name) == name && (answer.Type == qtype || qtype == utils.TypeANY) {
+			return security, nil
+		}
+	}
+
+	// A negative answer is only secure with a proof of the denial
+	_, trust, err := v.trustFor(name)
+	if err != nil {
+		return Bogus, err
+	}
+	if trust.security != Secure {
+		merge(trust.security)
+		return security, nil
+	}
+	var optOut, ok bool
+	if response.Header.Rcode() == utils.RcodeNameError {
+		optOut, ok = deniesName(response.Authority, name)
+	} else {
+		_, optOut, ok = deniesType(response.Authority, name, qtype)
+	}
+	if !ok {
+		return Bogus, nil
+	}
+	if optOut {
+		merge(Insecure)
+	}
+	return security, nil
+}
+
+// validateRRSet checks the signatures of an RRset against the keys of its zone
+func (v *validator) validateRRSet(rrset []utils.DNSAnswer, section []utils.DNSAnswer, authority []utils.DNSAnswer) (Security, error) {
+	owner := canonicalName(rrset[0].Name)
+	var sigs []utils.RRSIG
+	for _, rr := range section {
+		if rr.Type != utils.TypeRRSIG || canonicalName(rr.Name) != owner {
+			continue
+		}
+		if sig, err := utils.ParseRRSIG(rr.RData); err == nil && sig.TypeCovered == rrset[0].Type {
+			sigs = append(sigs, sig)
+		}
+	}
+
+	for _, sig := range sigs {
+		signer := canonicalName(sig.SignerName)
+		if !isSubdomain(owner, signer) {
+			continue
+		}
+		zone, trust, err := v.trustFor(signer)
+		if err != nil {
+			return Bogus, err
+		}
+		if trust.security != Secure {
+			return trust.security, nil
+		}
+		if zone != signer {
+			continue
+		}
+		for _, key := range trust.keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if utils.VerifyRRSet(rrset, sig, key, time.Now()) != nil {
+				continue
+			}
+			if int(sig.Labels) < len(strings.Split(owner, ".")) && !wildcardAllowed(authority, owner, int(sig.Labels)) {
+				return Bogus, nil
+			}
+			return Secure, nil
+		}
+	}
+
+	// Unsigned records are fine in insecure zones only
+	_, trust, err := v.trustFor(owner)
+	if err != nil {
+		return Bogus, err
+	}
+	if trust.security == Secure {
+		return Bogus, nil
+	}
+	return trust.security, nil
+}
+
+// trustFor returns the zone containing name, as far as the chain of trust
+// goes, and what is proven about it
+func (v *validator) trustFor(name string) (string, *zoneTrust, error) {
+	name = canonicalName(name)
+	zone := ""
+	found := false
+	for anchor := range v.anchors {
+		if isSubdomain(name, anchor) && (!found || len(anchor) > len(zone)) {
+			zone, found = anchor, true
+		}
+	}
+	if !found {
+		return name, &zoneTrust{security: Insecure}, nil
+	}
+
+	trust, err := v.cached(zone, func() (*zoneTrust, error) { return v.zoneKeys(zone, v.anchors[zone]) })
+	if err != nil {
+		return zone, nil, err
+	}
+	labels := strings.Split(strings.TrimSuffix(name, zone), ".")
+	for i := len(labels) - 1; i >= 0 && trust.security == Secure; i-- {
+		if labels[i] == "" {
+			continue
+		}
+		candidate := strings.Join(labels[i:], ".") + zone
+		if zone == "" {
+			candidate = strings.TrimSuffix(candidate, ".")
+		}
+		parent, parentTrust := zone, trust
+		delegation, err := v.cached(candidate, func() (*zoneTrust, error) { return v.delegation(parent, parentTrust, candidate) })
+		if err != nil {
+			return zone, nil, err
+		}
+		if delegation.cut {
+			zone, trust = candidate, delegation
+		}
+	}
+	return zone, trust, nil
+}
+
+func (v *validator) cached(name string, prove func() (*zoneTrust, error)) (*zoneTrust, error) {
+	v.mu.Lock()
+	trust, ok := v.cache[name]
+	v.mu.Unlock()
+	if ok && time.Now().Before(trust.expires) {
+		return trust, nil
+	}
+	trust, err := prove()
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	v.cache[name] = trust
+	v.mu.Unlock()
+	return trust, nil
+}
+
+// delegation finds out whether a zone starts at name below the secure zone
+// parent, using the DS records of name or the proof of their absence
+func (v *validator) delegation(parent string, parentTrust *zoneTrust, name string) (*zoneTrust, error) {
+	response, err := v.query(name, utils.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(minTTL(response))
+
+	var dsRecords []utils.DNSAnswer
+	var ds []utils.DS
+	for _, answer := range response.Answers {
+		if answer.Type == utils.TypeDS && canonicalName(answer.Name) == name {
+			parsed, err := utils.ParseDS(answer.RData)
+			if err != nil {
+				return nil, err
+			}
+			dsRecords = append(dsRecords, answer)
+			ds = append(ds, parsed)
+		}
+	}
+	if len(ds) > 0 {
+		if !signedBy(dsRecords, response.Answers, parent, parentTrust.keys) {
+			return &zoneTrust{cut: true, security: Bogus, expires: expires}, nil
+		}
+		trust, err := v.zoneKeys(name, ds)
+		if err != nil {
+			return nil, err
+		}
+		trust.cut = true
+		return trust, nil
+	}
+
+	// Names without DS records are either inside the parent zone or insecure
+	// delegations. Only the latter weakens security, so only it needs a proof.
+	if response.Header.Rcode() != utils.RcodeSuccess {
+		return &zoneTrust{security: Secure, expires: expires}, nil
+	}
+	types, optOut, ok := deniesType(response.Authority, name, utils.TypeDS)
+	if !ok || !utils.HasType(types, utils.TypeNS) && !optOut {
+		return &zoneTrust{security: Secure, expires: expires}, nil
+	}
+	for _, rrset := range groupRRSets(response.Authority) {
+		if (rrset[0].Type == utils.TypeNSEC || rrset[0].Type == utils.TypeNSEC3) &&
+			!signedBy(rrset, response.Authority, parent, parentTrust.keys) {
+			return &zoneTrust{cut: true, security: Bogus, expires: expires}, nil
+		}
+	}
+	return &zoneTrust{cut: true, security: Insecure, expires: expires}, nil
+}
+
+// zoneKeys fetches the DNSKEY set of the zone and validates it with the
+// keys the DS records point to
+func (v *validator) zoneKeys(zone string, ds []utils.DS) (*zoneTrust, error) {
+	supported := false
+	for _, d := range ds {
+		supported = supported || (d.DigestType == utils.DigestSHA256 && utils.SupportedAlgorithm(d.Algorithm))
+	}
+	if !supported {
+		// Validators treat zones signed with algorithms they lack as insecure
+		return &zoneTrust{security: Insecure, expires: time.Now().Add(maxTrustCache)}, nil
+	}
+
+	response, err := v.query(zone, utils.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	trust := &zoneTrust{security: Bogus, expires: time.Now().Add(minTTL(response))}
+	var keySet []utils.DNSAnswer
+	var keys, entryPoints []utils.DNSKEY
+	for _, answer := range response.Answers {
+		if answer.Type != utils.TypeDNSKEY || canonicalName(answer.Name) != zone {
+			continue
+		}
+		key, err := utils.ParseDNSKEY(answer.RData)
+		if err != nil {
+			return nil, err
+		}
+		keySet = append(keySet, answer)
+		keys = append(keys, key)
+		for _, d := range ds {
+			if d.DigestType == utils.DigestSHA256 && bytes.Equal(key.DS(zone).RData(), d.RData()) {
+				entryPoints = append(entryPoints, key)
+			}
+		}
+	}
+	if len(entryPoints) > 0 && signedBy(keySet, response.Answers, zone, entryPoints) {
+		trust.security = Secure
+		trust.keys = keys
+	}
+	return trust, nil
+}
+
+// query asks for DNSSEC records with checking disabled, validation is our job
+func (v *validator) query(name string, qtype utils.DNSRecordType, options ...utils.EDNSOption) (utils.DNSResponse, error) {
+	response, err := v.client.Exchange(utils.DNSPacket{
+		Header:     utils.DNSHeader{ID: 0xABCD, Flags: utils.FlagRD | utils.FlagCD},
+		Questions:  []utils.DNSQuestion{{Name: name, Type: qtype, Class: utils.ClassIN}},
+		Additional: []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: true, Options: options}.ToAnswer()},
+	})
+	if err != nil {
+		return response, err
+	}
+	if response.Header.Flags&utils.FlagTC != 0 {
+		return response, fmt.Errorf("response to %s %s is truncated", name, qtype)
+	}
+	if rcode := response.Header.Rcode(); rcode != utils.RcodeSuccess && rcode != utils.RcodeNameError {
+		return response, fmt.Errorf("query for %s %s failed with rcode %d", name, qtype, rcode)
+	}
+	return response, nil
+}
+
+// signedBy reports whether the RRset has a valid signature by one of the keys of zone
+func signedBy(rrset []utils.DNSAnswer, section []utils.DNSAnswer, zone string, keys []utils.DNSKEY) bool {
+	owner := canonicalName(rrset[0].Name)
+	for _, rr := range section {
+		if rr.Type != utils.TypeRRSIG || canonicalName(rr.Name) != owner {
+			continue
+		}
+		sig, err := utils.ParseRRSIG(rr.RData)
+		if err != nil || sig.TypeCovered != rrset[0].Type || canonicalName(sig.SignerName) != zone {
+			continue
+		}
+		for _, key := range keys {
+			if utils.VerifyRRSet(rrset, sig, key, time.Now()) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deniesType looks for the NSEC or NSEC3 record proving that name has no
+// record of the type, and returns the types name has
+func deniesType(authority []utils.DNSAnswer, name string, qtype utils.DNSRecordType) ([]utils.DNSRecordType, bool, bool) {
+	for _, rr := range authority {
+		switch rr.Type {
+		case utils.TypeNSEC:
+			nsec, err := utils.ParseNSEC(rr.RData)
+			if err != nil {
+				continue
+			}
+			owner := canonicalName(rr.Name)
+			if owner == name && !utils.HasType(nsec.Types, qtype) && !utils.HasType(nsec.Types, utils.TypeCNAME) {
+				return nsec.Types, false, true
+			}
+			// Empty non-terminals sit between an NSEC and its next name, below name
+			if covers(owner, canonicalName(nsec.NextName), name) && isSubdomain(canonicalName(nsec.NextName), name) {
+				return nil, false, true
+			}
+		case utils.TypeNSEC3:
+			nsec3, zone, ok := parseNSEC3(rr)
+			if ok && isSubdomain(name, zone) && nsec3Matches(rr, nsec3, name) &&
+				!utils.HasType(nsec3.Types, qtype) && !utils.HasType(nsec3.Types, utils.TypeCNAME) {
+				return nsec3.Types, false, true
+			}
+		}
+	}
+	if qtype == utils.TypeDS {
+		// Insecure delegations may be left out of opt-out NSEC3 chains
+		if optOut, ok := nsec3ClosestEncloserProof(authority, name); ok && optOut {
+			return nil, true, true
+		}
+	}
+	return nil, false, false
+}
+
+// deniesName looks for the NSEC or NSEC3 records proving that name and the
+// wildcard that could have matched it do not exist
+func deniesName(authority []utils.DNSAnswer, name string) (bool, bool) {
+	var hasNSEC3 bool
+	for _, rr := range authority {
+		hasNSEC3 = hasNSEC3 || rr.Type == utils.TypeNSEC3
+		if rr.Type != utils.TypeNSEC {
+			continue
+		}
+		nsec, err := utils.ParseNSEC(rr.RData)
+		owner, next := canonicalName(rr.Name), canonicalName(nsec.NextName)
+		if err != nil || !covers(owner, next, name) {
+			continue
+		}
+		// The closest encloser is the longest ancestor shared with the NSEC ends
+		encloser := commonAncestor(name, owner)
+		if other := commonAncestor(name, next); len(other) > len(encloser) {
+			encloser = other
+		}
+		if nsecCovered(authority, "*."+encloser) {
+			return false, true
+		}
+	}
+	if hasNSEC3 {
+		return nsec3ClosestEncloserProof(authority, name)
+	}
+	return false, false
+}
+
+// nsec3ClosestEncloserProof checks the proof of RFC 5155 section 8.4: an
+// NSEC3 matching the closest encloser, one covering the next closer name
+// and one covering the wildcard at the closest encloser
+func nsec3ClosestEncloserProof(authority []utils.DNSAnswer, name string) (bool, bool) {
+	labels := strings.Split(name, ".")
+	for i := 1; i < len(labels); i++ {
+		encloser := strings.Join(labels[i:], ".")
+		if !nsec3Exists(authority, encloser) {
+			continue
+		}
+		nextCloser := strings.Join(labels[i-1:], ".")
+		covered, optOut := nsec3Covered(authority, nextCloser)
+		if !covered {
+			return false, false
+		}
+		if wildcard, _ := nsec3Covered(authority, "*."+encloser); !wildcard && !optOut {
+			return false, false
+		}
+		return optOut, true
+	}
+	return false, false
+}
+
+// wildcardAllowed checks that an answer synthesized from a wildcard comes with
+// the proof that the name it was synthesized for does not exist
+func wildcardAllowed(authority []utils.DNSAnswer, owner string, labels int) bool {
+	parts := strings.Split(owner, ".")
+	nextCloser := strings.Join(parts[len(parts)-labels-1:], ".")
+	if nsecCovered(authority, owner) {
+		return true
+	}
+	covered, _ := nsec3Covered(authority, nextCloser)
+	return covered
+}
+
+func nsecCovered(authority []utils.DNSAnswer, name string) bool {
+	for _, rr := range authority {
+		if rr.Type != utils.TypeNSEC {
+			continue
+		}
+		if nsec, err := utils.ParseNSEC(rr.RData); err == nil && covers(canonicalName(rr.Name), canonicalName(nsec.NextName), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func nsec3Exists(authority []utils.DNSAnswer, name string) bool {
+	for _, rr := range authority {
+		if nsec3, zone, ok := parseNSEC3(rr); ok && isSubdomain(name, zone) && nsec3Matches(rr, nsec3, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsec3Covered reports whether an NSEC3 record covers the hash of name, and
+// whether it has the opt-out flag
+func nsec3Covered(authority []utils.DNSAnswer, name string) (bool, bool) {
+	for _, rr := range authority {
+		nsec3, zone, ok := parseNSEC3(rr)
+		if !ok || !isSubdomain(name, zone) {
+			continue
+		}
+		owner, err := utils.DecodeNSEC3Hash(strings.SplitN(rr.Name, ".", 2)[0])
+		if err != nil {
+			continue
+		}
+		hash := utils.HashNSEC3Name(name, nsec3.Salt, nsec3.Iterations)
+		var inside bool
+		if bytes.Compare(owner, nsec3.NextHashed) < 0 {
+			inside = bytes.Compare(owner, hash) < 0 && bytes.Compare(hash, nsec3.NextHashed) < 0
+		} else {
+			// Last record of the chain
+			inside = bytes.Compare(owner, hash) < 0 || bytes.Compare(hash, nsec3.NextHashed) < 0
+		}
+		if inside {
+			return true, nsec3.Flags&utils.NSEC3FlagOptOut != 0
+		}
+	}
+	return false, false
+}
+
+// parseNSEC3 parses an NSEC3 record and returns the zone it belongs to
+func parseNSEC3(rr utils.DNSAnswer) (utils.NSEC3, string, bool) {
+	if rr.Type != utils.TypeNSEC3 {
+		return utils.NSEC3{}, "", false
+	}
+	nsec3, err := utils.ParseNSEC3(rr.RData)
+	parts := strings.SplitN(canonicalName(rr.Name), ".", 2)
+	if err != nil || nsec3.HashAlgorithm != utils.NSEC3HashSHA1 || len(parts) != 2 {
+		return utils.NSEC3{}, "", false
+	}
+	return nsec3, parts[1], true
+}
+
+func nsec3Matches(rr utils.DNSAnswer, nsec3 utils.NSEC3, name string) bool {
+	hash := utils.HashNSEC3Name(name, nsec3.Salt, nsec3.Iterations)
+	return strings.EqualFold(strings.SplitN(rr.Name, ".", 2)[0], utils.EncodeNSEC3Hash(hash))
+}
+
+// covers reports whether name sorts strictly between the owner and the next
+// name of an NSEC record, the last record of the chain wrapping around
+func covers(owner string, next string, name string) bool {
+	if utils.CompareCanonical(owner, next) < 0 {
+		return utils.CompareCanonical(owner, name) < 0 && utils.CompareCanonical(name, next) < 0
+	}
+	return utils.CompareCanonical(owner, name) < 0 || utils.CompareCanonical(name, next) < 0
+}
+
+// commonAncestor returns the longest name both a and b are equal to or below
+func commonAncestor(a string, b string) string {
+	la, lb := strings.Split(a, "."), strings.Split(b, ".")
+	var common []string
+	for i := 1; i <= len(la) && i <= len(lb) && la[len(la)-i] == lb[len(lb)-i]; i++ {
+		common = append([]string{la[len(la)-i]}, common...)
+	}
+	return strings.Join(common, ".")
+}
+
+// minTTL returns how long what was learned from a response may be cached
+func minTTL(response utils.DNSResponse) time.Duration {
+	ttl := maxTrustCache
+	for _, rr := range append(response.Answers, response.Authority...) {
+		if d := time.Duration(rr.TTL) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+// groupRRSets splits a section into RRsets, keeping their order of appearance
+func groupRRSets(section []utils.DNSAnswer) [][]utils.DNSAnswer {
+	var rrsets [][]utils.DNSAnswer
+	index := map[string]int{}
+	for _, rr := range section {
+		key := fmt.Sprintf("%s/%d/%d", canonicalName(rr.Name), rr.Type, rr.Class)
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []utils.DNSAnswer{rr})
+	}
+	return rrsets
+}
+
+// canonicalName lower-cases a name and strips its trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// isSubdomain reports whether name is equal to or below parent
+func isSubdomain(name string, parent string) bool {
+	return parent == "" || name == parent || strings.HasSuffix(name, "."+parent)
+}
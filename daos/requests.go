@@ -1,22 +1,155 @@
 package daos
 
+import "time"
+
 type DNSRecordCreate struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	Value string `json:"value"`
-	TTL   int    `json:"ttl"`
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Value       string          `json:"value"`
+	TTL         int             `json:"ttl"`
+	Steering    *RecordSteering `json:"steering,omitempty"`
+	HealthCheck *HealthCheck    `json:"healthCheck,omitempty"`
 }
 
 type DNSRecordUpdate struct {
 	DNSRecordCreate
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Version uint32 `json:"version,omitempty"` // Version the update is based on, 0 for any
 }
 
 type DNSZoneCreate struct {
-	Name string `json:"name"`
+	Name   string `json:"name"`
+	ViewID string `json:"viewID,omitempty"` // Empty for a zone served to all clients
 }
 
 type DNSZoneUpdate struct {
 	DNSZoneCreate
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Version uint32 `json:"version,omitempty"` // Version the update is based on, 0 for any
+}
+
+type TSIGKeyCreate struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret,omitempty"` // base64, generated when empty
+}
+
+type TSIGPolicyCreate struct {
+	KeyName       string `json:"keyName"`
+	AllowTransfer bool   `json:"allowTransfer"`
+	AllowUpdate   bool   `json:"allowUpdate"`
+	NamePattern   string `json:"namePattern"`
+}
+
+type DNSSECEnable struct {
+	Algorithm string       `json:"algorithm"`       // ECDSAP256SHA256 (default) or ED25519
+	NSEC3     *NSEC3Params `json:"nsec3,omitempty"` // Use NSEC3 instead of NSEC when set
+	// Automatic rollover schedule, defaults to monthly ZSK and yearly KSK rollovers
+	Rollover *RolloverPolicy `json:"rollover,omitempty"`
+}
+
+// DSSeen confirms the parent zone publishes the DS record of a KSK
+type DSSeen struct {
+	KeyTag uint16 `json:"keyTag"`
+}
+
+type DNSSECRollover struct {
+	KeyType string `json:"keyType"` // KSK or ZSK
+}
+
+type ACLRuleCreate struct {
+	ZoneID string `json:"zoneID,omitempty"` // Empty for a rule applying to all zones
+	Scope  string `json:"scope"`            // authoritative or recursion
+	Action string `json:"action"`           // allow or deny
+	CIDR   string `json:"cidr"`             // A network, or a single address
+}
+
+type ViewCreate struct {
+	Name     string   `json:"name"`
+	Priority int      `json:"priority"` // Views are matched in increasing priority order
+	Networks []string `json:"networks"` // Client networks or addresses matching the view
+	TSIGKeys []string `json:"tsigKeys"` // Names of the keys whose signed queries match the view
+}
+
+type APIKeyCreate struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	UserID    string     `json:"userID,omitempty"`    // Limits the key to the zones the user has roles in
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"` // Never expires when nil
+}
+
+type UserCreate struct {
+	Name string `json:"name"`
+}
+
+type TeamCreate struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members,omitempty"` // IDs of the users in the team
+}
+
+type RoleBindingCreate struct {
+	UserID string `json:"userID,omitempty"` // Either a user or a team
+	TeamID string `json:"teamID,omitempty"`
+	Role   string `json:"role"` // viewer, editor or owner
+	// Record types and names, relative to the zone, the role may edit. Empty
+	// allows all of them.
+	RecordTypes  []string `json:"recordTypes,omitempty"`
+	NamePatterns []string `json:"namePatterns,omitempty"` // e.g. "*.dev" or "_acme-challenge*"
+}
+
+// Actor is who makes a change, as recorded in the audit log
+type Actor struct {
+	Name     string `json:"name"`               // API key, user or TSIG key name
+	Source   string `json:"source"`             // api or dns-update
+	SourceIP string `json:"sourceIP,omitempty"` // Address the change came from
+}
+
+// Sources of changes
+const (
+	SourceAPI       = "api"
+	SourceDNSUpdate = "dns-update"
+)
+
+// ZoneVersion names a past state of a zone, by serial or by time. Neither
+// being set names the current state.
+type ZoneVersion struct {
+	Serial *uint32    `json:"serial,omitempty"`
+	At     *time.Time `json:"at,omitempty"`
+}
+
+// AuditFilter selects audit entries, empty fields matching any
+type AuditFilter struct {
+	ZoneID  string
+	ZoneIDs []string // Only entries of these zones when not nil
+	Actor   string
+	Since   *time.Time
+	Until   *time.Time
+	Limit   int // Most recent entries returned, a default limit applies when 0
+}
+
+// Modes of zone file imports
+const (
+	ImportMerge   = "merge"   // Adds the records of the file to those of the zone
+	ImportReplace = "replace" // Also removes the records missing from the file
+)
+
+// Actions of the operations of a change set
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// RecordOperation creates, updates or deletes a record as part of a change set
+type RecordOperation struct {
+	Action  string           `json:"action"`
+	ID      string           `json:"id,omitempty"`      // Record updated or deleted
+	Version uint32           `json:"version,omitempty"` // Version of the record the change is based on, 0 for any
+	Record  *DNSRecordCreate `json:"record,omitempty"`  // Record created, or fields updated
+}
+
+// ChangeSetCreate is a batch of record operations applied all at once
+type ChangeSetCreate struct {
+	Comment string            `json:"comment,omitempty"`
+	Changes []RecordOperation `json:"changes"`
 }
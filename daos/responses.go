@@ -1,15 +1,372 @@
 package daos
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type DNSRecord struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Value       string          `json:"value"`
+	TTL         int             `json:"ttl"`
+	DNSZoneID   string          `json:"dnsZoneID"`
+	Steering    *RecordSteering `json:"steering,omitempty"`
+	HealthCheck *HealthCheck    `json:"healthCheck,omitempty"`
+	Version     uint32          `json:"version"` // Also the ETag of the record
+}
+
+// Kinds of health checks of A and AAAA records
+const (
+	HealthCheckTCP   = "tcp"   // Connecting to the port succeeds
+	HealthCheckPing  = "ping"  // The address answers a TCP connection, even with a reset
+	HealthCheckHTTP  = "http"  // A GET request returns the expected status
+	HealthCheckHTTPS = "https" // Same as http, over TLS
+)
+
+// HealthCheck probes the address of a record. Records failing
+// FailureThreshold checks in a row are left out of answers until they pass
+// RecoveryThreshold checks in a row.
+type HealthCheck struct {
+	Type              string `json:"type"`
+	Port              int    `json:"port,omitempty"`           // Defaults to 80 for http and ping, 443 for https
+	Path              string `json:"path,omitempty"`           // Path of http checks, defaults to /
+	Host              string `json:"host,omitempty"`           // Host header and TLS server name of http checks
+	ExpectedStatus    int    `json:"expectedStatus,omitempty"` // Defaults to 200
+	IntervalSeconds   int    `json:"intervalSeconds,omitempty"`
+	TimeoutSeconds    int    `json:"timeoutSeconds,omitempty"`
+	FailureThreshold  int    `json:"failureThreshold,omitempty"`
+	RecoveryThreshold int    `json:"recoveryThreshold,omitempty"`
+}
+
+type HealthTransition struct {
+	At      time.Time `json:"at"`
+	Healthy bool      `json:"healthy"`
+	Reason  string    `json:"reason"`
+}
+
+type RecordHealth struct {
+	RecordID             string             `json:"recordID"`
+	Healthy              bool               `json:"healthy"`
+	Check                *HealthCheck       `json:"check,omitempty"`
+	ConsecutiveFailures  int                `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int                `json:"consecutiveSuccesses"`
+	LastCheck            *time.Time         `json:"lastCheck,omitempty"`
+	LastError            string             `json:"lastError,omitempty"`
+	Transitions          []HealthTransition `json:"transitions"`
+}
+
+// Traffic steering policies choosing which records of a set are returned
+const (
+	SteeringWeighted = "weighted" // One record, picked at random by weight
+	SteeringGeo      = "geo"      // The records serving the location of the client
+	SteeringFailover = "failover" // The healthy records with the lowest priority
+)
+
+// RecordSteering places a record in a steered record set. All the records
+// of a set are expected to use the same policy.
+type RecordSteering struct {
+	Policy     string   `json:"policy"`
+	Weight     int      `json:"weight,omitempty"`
+	Priority   int      `json:"priority,omitempty"`
+	Countries  []string `json:"countries,omitempty"`  // ISO 3166 country codes, e.g. "DE"
+	Continents []string `json:"continents,omitempty"` // Continent codes, e.g. "EU"; records without locations are the default
+}
+
+type DNSZone struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	ViewID        string       `json:"viewID,omitempty"`
+	Serial        uint32       `json:"serial"`
+	DNSSECEnabled bool         `json:"dnssecEnabled"`
+	NSEC3         *NSEC3Params `json:"nsec3,omitempty"`
+	Version       uint32       `json:"version"` // Also the ETag of the zone
+}
+
+type NSEC3Params struct {
+	Salt       string `json:"salt"` // hex encoded, empty for no salt
+	Iterations uint16 `json:"iterations"`
+	OptOut     bool   `json:"optOut"`
+}
+
+type TSIGKey struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
-	Type      string `json:"type"`
-	Value     string `json:"value"`
-	TTL       int    `json:"ttl"`
-	DNSZoneID string `json:"dnsZoneID"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret,omitempty"` // Only returned when the key is created
 }
 
-type DNSZone struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+// Scopes of API keys, each allowing what the previous ones do
+const (
+	APIScopeReadOnly    = "read-only"    // Read everything but the API keys
+	APIScopeZoneAdmin   = "zone-admin"   // Manage zones and their records
+	APIScopeGlobalAdmin = "global-admin" // Manage everything, including API keys
+)
+
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	Prefix    string     `json:"prefix"` // Start of the token, to tell keys apart
+	UserID    string     `json:"userID,omitempty"`
+	Groups    []string   `json:"groups,omitempty"` // Identity provider groups of callers logged in with a JWT
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Token     string     `json:"token,omitempty"` // Only returned when the key is created
+}
+
+// Roles of users and teams in a zone, each allowing what the previous ones do
+const (
+	ZoneRoleViewer = "viewer" // Read the zone and its records
+	ZoneRoleEditor = "editor" // Change the records
+	ZoneRoleOwner  = "owner"  // Change the zone itself, its DNSSEC, TSIG policies and roles
+)
+
+type User struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Identity string   `json:"identity,omitempty"` // Issuer and subject of users logging in with OIDC
+	Teams    []string `json:"teams"`              // IDs of the teams of the user
+}
+
+type Team struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"` // IDs of the users in the team
+}
+
+type RoleBinding struct {
+	ID           string   `json:"id"`
+	ZoneID       string   `json:"zoneID"`
+	UserID       string   `json:"userID,omitempty"`
+	TeamID       string   `json:"teamID,omitempty"`
+	Role         string   `json:"role"`
+	RecordTypes  []string `json:"recordTypes,omitempty"`
+	NamePatterns []string `json:"namePatterns,omitempty"`
+}
+
+// RecordVersion is the state of a record after a change
+type RecordVersion struct {
+	ID      string    `json:"id"`
+	Serial  uint32    `json:"serial"` // Serial of the zone from which on the record was in this state
+	At      time.Time `json:"at"`
+	Deleted bool      `json:"deleted"`
+	Record  DNSRecord `json:"record"`
+}
+
+// ZoneSnapshot is a zone as it was at a serial or time
+type ZoneSnapshot struct {
+	ZoneID  string      `json:"zoneID"`
+	Serial  uint32      `json:"serial"`
+	Records []DNSRecord `json:"records"`
+}
+
+type RecordChange struct {
+	Before DNSRecord `json:"before"`
+	After  DNSRecord `json:"after"`
+}
+
+// ZoneDiff holds the record changes between two versions of a zone
+type ZoneDiff struct {
+	FromSerial uint32         `json:"fromSerial"`
+	ToSerial   uint32         `json:"toSerial"`
+	Added      []DNSRecord    `json:"added"`
+	Removed    []DNSRecord    `json:"removed"`
+	Changed    []RecordChange `json:"changed"`
+}
+
+// Statuses of change sets
+const (
+	ChangeSetPending = "pending" // Being applied
+	ChangeSetApplied = "applied" // All changes made, in the zone of the serial
+	ChangeSetFailed  = "failed"  // No change made
+)
+
+// ChangeSet is a batch of record operations and the outcome of applying it
+type ChangeSet struct {
+	ID          string            `json:"id"`
+	ZoneID      string            `json:"zoneID"`
+	Status      string            `json:"status"`
+	Comment     string            `json:"comment,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Actor       string            `json:"actor"`
+	Serial      uint32            `json:"serial,omitempty"` // Serial of the zone with the changes applied
+	SubmittedAt time.Time         `json:"submittedAt"`
+	AppliedAt   *time.Time        `json:"appliedAt,omitempty"`
+	Changes     []RecordOperation `json:"changes"`
+	// The record each operation resulted in, as it was before for deletions
+	Records []DNSRecord `json:"records,omitempty"`
+}
+
+// Actions and resources of audit entries
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+
+	AuditZone   = "zone"
+	AuditRecord = "record"
+)
+
+// AuditEntry is a change made to a zone or a record, with the resource as it
+// was before and after it, null when it did not exist
+type AuditEntry struct {
+	ID           string          `json:"id"`
+	At           time.Time       `json:"at"`
+	Actor        string          `json:"actor"`
+	Source       string          `json:"source"`
+	SourceIP     string          `json:"sourceIP,omitempty"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   string          `json:"resourceID"`
+	ZoneID       string          `json:"zoneID"`
+	Before       json.RawMessage `json:"before"`
+	After        json.RawMessage `json:"after"`
+}
+
+type TSIGPolicy struct {
+	ID            string `json:"id"`
+	ZoneID        string `json:"zoneID"`
+	KeyName       string `json:"keyName"`
+	AllowTransfer bool   `json:"allowTransfer"`
+	AllowUpdate   bool   `json:"allowUpdate"`
+	NamePattern   string `json:"namePattern"`
+}
+
+type View struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Priority int      `json:"priority"`
+	Networks []string `json:"networks"`
+	TSIGKeys []string `json:"tsigKeys"`
+}
+
+// Scopes and actions of ACL rules. Authoritative rules apply to answers from
+// the hosted zones, recursion rules to the queries forwarded upstream.
+const (
+	ACLScopeAuthoritative = "authoritative"
+	ACLScopeRecursion     = "recursion"
+	ACLAllow              = "allow"
+	ACLDeny               = "deny"
+)
+
+type ACLRule struct {
+	ID     string `json:"id"`
+	ZoneID string `json:"zoneID,omitempty"`
+	Scope  string `json:"scope"`
+	Action string `json:"action"`
+	CIDR   string `json:"cidr"`
+}
+
+// RRLConfig configures response rate limiting, which is off while
+// ResponsesPerSecond is 0
+type RRLConfig struct {
+	ResponsesPerSecond int  `json:"responsesPerSecond"`
+	Slip               int  `json:"slip"`    // Every Nth limited response is sent truncated, 0 to drop all of them
+	LogOnly            bool `json:"logOnly"` // Only log the responses that would be limited
+	IPv4PrefixLength   int  `json:"ipv4PrefixLength"`
+	IPv6PrefixLength   int  `json:"ipv6PrefixLength"`
+}
+
+// CookieConfig sets what clients proving their address with a valid DNS
+// server cookie (RFC 7873) are trusted with
+type CookieConfig struct {
+	ExemptRRL bool `json:"exemptRRL"` // Responses to them are not rate limited
+	ExemptACL bool `json:"exemptACL"` // They are not refused by ACL rules
+}
+
+// ListenerOptions shapes the responses sent on a listener
+type ListenerOptions struct {
+	PaddingBlockSize int  `json:"paddingBlockSize"` // Pad responses to a multiple of this length (RFC 8467), 0 to not pad
+	MinimalResponses bool `json:"minimalResponses"` // Leave out the authority and additional data not needed by the answer
+}
+
+// OIDCConfig lets callers of the API log in with JWTs issued by an
+// identity provider. Their groups are matched to teams by name.
+type OIDCConfig struct {
+	Issuer        string            `json:"issuer"`
+	Audience      string            `json:"audience"`                // Expected in the aud claim of every token
+	JWKSURL       string            `json:"jwksURL,omitempty"`       // Where the signing keys are fetched from
+	JWKSFile      string            `json:"jwksFile,omitempty"`      // Or the file holding them
+	UsernameClaim string            `json:"usernameClaim,omitempty"` // Defaults to sub
+	GroupsClaim   string            `json:"groupsClaim,omitempty"`   // Defaults to groups
+	GroupScopes   map[string]string `json:"groupScopes,omitempty"`   // API scope given to the members of a group
+	DefaultScope  string            `json:"defaultScope,omitempty"`  // Defaults to read-only
+}
+
+type RRLStats struct {
+	Config    RRLConfig `json:"config"`
+	Responses uint64    `json:"responses"` // UDP responses checked against the limit
+	Limited   uint64    `json:"limited"`   // Responses over the limit, including slipped and logged ones
+	Dropped   uint64    `json:"dropped"`
+	Slipped   uint64    `json:"slipped"`
+	Buckets   int       `json:"buckets"` // Client netblock and response pairs being tracked
+}
+
+type DNSSECKey struct {
+	ID          string     `json:"id"`
+	ZoneID      string     `json:"zoneID"`
+	KeyType     string     `json:"keyType"` // KSK or ZSK
+	Flags       uint16     `json:"flags"`
+	Algorithm   uint8      `json:"algorithm"`
+	KeyTag      uint16     `json:"keyTag"`
+	PublicKey   string     `json:"publicKey"`
+	State       string     `json:"state"` // published, active, retired or removed
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	ActivatedAt *time.Time `json:"activatedAt,omitempty"`
+	RetiredAt   *time.Time `json:"retiredAt,omitempty"`
+	RemovedAt   *time.Time `json:"removedAt,omitempty"`
+	DSSeenAt    *time.Time `json:"dsSeenAt,omitempty"` // When the parent was confirmed to publish the DS of a KSK
+}
+
+type RolloverPolicy struct {
+	ZSKLifetimeDays int `json:"zskLifetimeDays"` // 0 disables automatic ZSK rollovers
+	KSKLifetimeDays int `json:"kskLifetimeDays"` // 0 disables automatic KSK rollovers
+}
+
+// DNSSECEvent is a key state change, past or planned by the key manager
+type DNSSECEvent struct {
+	KeyTag  uint16    `json:"keyTag,omitempty"` // Not set for keys yet to be generated
+	KeyType string    `json:"keyType"`
+	State   string    `json:"state"` // State the key enters
+	At      time.Time `json:"at"`
+	Planned bool      `json:"planned"`
+}
+
+type DNSSECStatus struct {
+	ZoneID   string          `json:"zoneID"`
+	Enabled  bool            `json:"enabled"`
+	NSEC3    *NSEC3Params    `json:"nsec3,omitempty"`
+	Rollover *RolloverPolicy `json:"rollover,omitempty"`
+	Keys     []DNSSECKey     `json:"keys"`
+	DS       []string        `json:"ds"` // DS records to publish at the parent
+	Timeline []DNSSECEvent   `json:"timeline"`
+}
+
+// Codes of error responses
+const (
+	ErrorBadRequest           = "bad_request"
+	ErrorValidation           = "validation_failed" // Invalid fields, given in the details
+	ErrorUnauthorized         = "unauthorized"
+	ErrorForbidden            = "forbidden"
+	ErrorNotFound             = "not_found"
+	ErrorConflict             = "conflict"
+	ErrorPreconditionFailed   = "precondition_failed"
+	ErrorPreconditionRequired = "precondition_required" // A change without an If-Match header
+	ErrorUnprocessable        = "unprocessable"
+	ErrorInternal             = "internal"
+)
+
+// Error is the body of error responses
+type Error struct {
+	Code    string       `json:"code"`
+	Message string       `json:"error"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// FieldError tells why a field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field,omitempty"` // Empty for the request as a whole
+	Message string `json:"message"`
 }
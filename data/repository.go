@@ -2,6 +2,7 @@ package data
 
 import (
 	"dnsServer/daos"
+	"encoding/json"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
@@ -13,51 +14,398 @@ type Base struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// Incremented by updates, changes based on an older version are refused
+	Version uint32 `gorm:"not null;default:1"`
 }
 
 type Zone struct {
 	Base
-	Name    string `gorm:"unique"`
-	Records []Record
+	// The same zone can exist once per view
+	Name          string `gorm:"uniqueIndex:idx_zone_name_view"`
+	ViewID        string `gorm:"uniqueIndex:idx_zone_name_view"` // Empty for zones served to all clients
+	Serial        uint32
+	DNSSECEnabled bool
+	// Denial of existence uses NSEC3 with these parameters instead of NSEC
+	NSEC3Enabled    bool
+	NSEC3Salt       string // hex encoded
+	NSEC3Iterations uint16
+	NSEC3OptOut     bool
+	// Keys are rolled over automatically after these many days, 0 disables it
+	ZSKLifetimeDays int
+	KSKLifetimeDays int
+	Records         []Record
+	TSIGPolicies    []TSIGPolicy
+	DNSSECKeys      []DNSSECKey
 }
 
 type Record struct {
 	Base
-	Name   string
-	Type   string
-	Value  string
-	TTL    int
-	ZoneID string
+	Name        string
+	Type        string
+	Value       string
+	TTL         int
+	ZoneID      string
+	Steering    *daos.RecordSteering `gorm:"serializer:json"` // nil to always return the record
+	HealthCheck *daos.HealthCheck    `gorm:"serializer:json"` // nil for records that are not checked
+}
+
+// TSIGKey is a shared secret that can sign zone transfers, updates and notifies
+type TSIGKey struct {
+	Base
+	Name      string `gorm:"unique"`
+	Algorithm string
+	Secret    string // base64 encoded
+}
+
+// APIKey authenticates requests to the management API. Only a hash of its
+// token is stored, the token itself is shown once when the key is created.
+type APIKey struct {
+	Base
+	Name      string `gorm:"unique"`
+	Scope     string
+	Prefix    string
+	Hash      string `gorm:"uniqueIndex"` // hex encoded SHA-256 of the token
+	ExpiresAt *time.Time
+	UserID    string `gorm:"index"` // Empty for keys not limited to the zones of a user
+}
+
+// User is someone given roles in zones, directly or through teams
+type User struct {
+	Base
+	Name     string  `gorm:"unique"`
+	Identity *string `gorm:"unique"` // Issuer and subject of users logging in with OIDC, nil for others
+	Teams    []Team  `gorm:"many2many:team_members;"`
+}
+
+type Team struct {
+	Base
+	Name  string `gorm:"unique"`
+	Users []User `gorm:"many2many:team_members;"`
+}
+
+// RoleBinding gives a user or a team a role in a zone, optionally limited to
+// some record types and names
+type RoleBinding struct {
+	Base
+	ZoneID       string `gorm:"index"`
+	UserID       string `gorm:"index"`
+	TeamID       string `gorm:"index"`
+	Role         string
+	RecordTypes  []string `gorm:"serializer:json"`
+	NamePatterns []string `gorm:"serializer:json"`
+}
+
+// RecordVersion is the state of a record after a change, which the zone
+// has from the serial on. Deleted versions mark the removal of the record.
+type RecordVersion struct {
+	Base
+	RecordID    string `gorm:"index"`
+	ZoneID      string `gorm:"index"`
+	Serial      uint32
+	Deleted     bool
+	Name        string
+	Type        string
+	Value       string
+	TTL         int
+	Steering    *daos.RecordSteering `gorm:"serializer:json"`
+	HealthCheck *daos.HealthCheck    `gorm:"serializer:json"`
+}
+
+// AuditEntry records a change to a zone or a record. Before and After hold
+// the JSON of the resource, empty when it did not exist.
+type AuditEntry struct {
+	Base
+	Actor        string `gorm:"index"`
+	Source       string
+	SourceIP     string
+	Action       string
+	ResourceType string
+	ResourceID   string `gorm:"index"`
+	ZoneID       string `gorm:"index"`
+	Before       string
+	After        string
+}
+
+// ChangeSet is a batch of record operations applied in a single transaction
+type ChangeSet struct {
+	Base
+	ZoneID    string `gorm:"index"`
+	Status    string
+	Comment   string
+	Error     string
+	Actor     string
+	Serial    uint32
+	AppliedAt *time.Time
+	Changes   []daos.RecordOperation `gorm:"serializer:json"`
+	Records   []daos.DNSRecord       `gorm:"serializer:json"`
+}
+
+// TSIGPolicy allows a TSIG key to transfer a zone or update names within it
+type TSIGPolicy struct {
+	Base
+	ZoneID        string `gorm:"index"`
+	KeyName       string
+	AllowTransfer bool
+	AllowUpdate   bool
+	NamePattern   string // Names the key may update, e.g. "*.dyn", empty for any
+}
+
+// View serves its own version of zones to the clients it matches, by
+// address or by the TSIG key signing their queries
+type View struct {
+	Base
+	Name     string   `gorm:"unique"`
+	Priority int      // Views are matched in increasing priority order
+	Networks []string `gorm:"serializer:json"`
+	TSIGKeys []string `gorm:"serializer:json"`
+}
+
+// ACLRule allows or denies queries from a network, for the whole server when
+// ZoneID is empty or for a single zone
+type ACLRule struct {
+	Base
+	ZoneID string `gorm:"index"`
+	Scope  string // authoritative or recursion
+	Action string // allow or deny
+	CIDR   string
+}
+
+// States of a DNSSEC key, in the order a key goes through them
+const (
+	KeyStatePublished = "published" // In the DNSKEY set ahead of its activation
+	KeyStateActive    = "active"    // Signing the zone
+	KeyStateRetired   = "retired"   // Replaced, still published until caches forget it
+	KeyStateRemoved   = "removed"   // No longer published, kept for the timeline
+)
+
+// DNSSECKey is a key signing key or zone signing key of a signed zone
+type DNSSECKey struct {
+	Base
+	ZoneID      string `gorm:"index"`
+	Flags       uint16
+	Algorithm   uint8
+	KeyTag      uint16
+	PublicKey   string // base64 encoded DNSKEY public key
+	PrivateKey  string // base64 encoded PKCS #8
+	State       string `gorm:"default:active"`
+	PublishedAt *time.Time
+	ActivatedAt *time.Time
+	RetiredAt   *time.Time
+	RemovedAt   *time.Time
+	DSSeenAt    *time.Time // When the parent was confirmed to publish the DS of the KSK
 }
 
 func (zs *Zone) ToDNSZone() daos.DNSZone {
-	return daos.DNSZone{
-		ID:   zs.ID,
-		Name: zs.Name,
+	zone := daos.DNSZone{
+		ID:            zs.ID,
+		Name:          zs.Name,
+		ViewID:        zs.ViewID,
+		Serial:        zs.Serial,
+		DNSSECEnabled: zs.DNSSECEnabled,
+		Version:       zs.Version,
+	}
+	if zs.NSEC3Enabled {
+		zone.NSEC3 = &daos.NSEC3Params{
+			Salt:       zs.NSEC3Salt,
+			Iterations: zs.NSEC3Iterations,
+			OptOut:     zs.NSEC3OptOut,
+		}
 	}
+	return zone
 }
 
 func (zs *Record) ToDNSRecord() daos.DNSRecord {
 	return daos.DNSRecord{
-		ID:        zs.ID,
-		Name:      zs.Name,
-		Type:      zs.Type,
-		Value:     zs.Value,
-		TTL:       zs.TTL,
-		DNSZoneID: zs.ZoneID,
+		ID:          zs.ID,
+		Name:        zs.Name,
+		Type:        zs.Type,
+		Value:       zs.Value,
+		TTL:         zs.TTL,
+		DNSZoneID:   zs.ZoneID,
+		Steering:    zs.Steering,
+		HealthCheck: zs.HealthCheck,
+		Version:     zs.Version,
+	}
+}
+
+func (key *TSIGKey) ToTSIGKey() daos.TSIGKey {
+	return daos.TSIGKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Algorithm: key.Algorithm,
+	}
+}
+
+func (key *APIKey) ToAPIKey() daos.APIKey {
+	return daos.APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scope:     key.Scope,
+		Prefix:    key.Prefix,
+		UserID:    key.UserID,
+		CreatedAt: key.CreatedAt,
+		ExpiresAt: key.ExpiresAt,
+	}
+}
+
+func (user *User) ToUser() daos.User {
+	teams := []string{}
+	for _, team := range user.Teams {
+		teams = append(teams, team.ID)
+	}
+	dto := daos.User{ID: user.ID, Name: user.Name, Teams: teams}
+	if user.Identity != nil {
+		dto.Identity = *user.Identity
+	}
+	return dto
+}
+
+func (team *Team) ToTeam() daos.Team {
+	members := []string{}
+	for _, user := range team.Users {
+		members = append(members, user.ID)
+	}
+	return daos.Team{ID: team.ID, Name: team.Name, Members: members}
+}
+
+func (binding *RoleBinding) ToRoleBinding() daos.RoleBinding {
+	return daos.RoleBinding{
+		ID:           binding.ID,
+		ZoneID:       binding.ZoneID,
+		UserID:       binding.UserID,
+		TeamID:       binding.TeamID,
+		Role:         binding.Role,
+		RecordTypes:  binding.RecordTypes,
+		NamePatterns: binding.NamePatterns,
+	}
+}
+
+func (version *RecordVersion) ToRecord() Record {
+	return Record{
+		Base:        Base{ID: version.RecordID},
+		Name:        version.Name,
+		Type:        version.Type,
+		Value:       version.Value,
+		TTL:         version.TTL,
+		ZoneID:      version.ZoneID,
+		Steering:    version.Steering,
+		HealthCheck: version.HealthCheck,
+	}
+}
+
+func (version *RecordVersion) ToRecordVersion() daos.RecordVersion {
+	record := version.ToRecord()
+	return daos.RecordVersion{
+		ID:      version.ID,
+		Serial:  version.Serial,
+		At:      version.CreatedAt,
+		Deleted: version.Deleted,
+		Record:  record.ToDNSRecord(),
+	}
+}
+
+func (entry *AuditEntry) ToAuditEntry() daos.AuditEntry {
+	rawJSON := func(value string) json.RawMessage {
+		if value == "" {
+			return json.RawMessage("null")
+		}
+		return json.RawMessage(value)
+	}
+	return daos.AuditEntry{
+		ID:           entry.ID,
+		At:           entry.CreatedAt,
+		Actor:        entry.Actor,
+		Source:       entry.Source,
+		SourceIP:     entry.SourceIP,
+		Action:       entry.Action,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		ZoneID:       entry.ZoneID,
+		Before:       rawJSON(entry.Before),
+		After:        rawJSON(entry.After),
+	}
+}
+
+func (changeSet *ChangeSet) ToChangeSet() daos.ChangeSet {
+	return daos.ChangeSet{
+		ID:          changeSet.ID,
+		ZoneID:      changeSet.ZoneID,
+		Status:      changeSet.Status,
+		Comment:     changeSet.Comment,
+		Error:       changeSet.Error,
+		Actor:       changeSet.Actor,
+		Serial:      changeSet.Serial,
+		SubmittedAt: changeSet.CreatedAt,
+		AppliedAt:   changeSet.AppliedAt,
+		Changes:     changeSet.Changes,
+		Records:     changeSet.Records,
+	}
+}
+
+func (policy *TSIGPolicy) ToTSIGPolicy() daos.TSIGPolicy {
+	return daos.TSIGPolicy{
+		ID:            policy.ID,
+		ZoneID:        policy.ZoneID,
+		KeyName:       policy.KeyName,
+		AllowTransfer: policy.AllowTransfer,
+		AllowUpdate:   policy.AllowUpdate,
+		NamePattern:   policy.NamePattern,
+	}
+}
+
+func (view *View) ToView() daos.View {
+	return daos.View{
+		ID:       view.ID,
+		Name:     view.Name,
+		Priority: view.Priority,
+		Networks: view.Networks,
+		TSIGKeys: view.TSIGKeys,
+	}
+}
+
+func (rule *ACLRule) ToACLRule() daos.ACLRule {
+	return daos.ACLRule{
+		ID:     rule.ID,
+		ZoneID: rule.ZoneID,
+		Scope:  rule.Scope,
+		Action: rule.Action,
+		CIDR:   rule.CIDR,
+	}
+}
+
+func (key *DNSSECKey) ToDNSSECKey() daos.DNSSECKey {
+	keyType := "ZSK"
+	if key.Flags&1 != 0 {
+		keyType = "KSK"
+	}
+	return daos.DNSSECKey{
+		ID:          key.ID,
+		ZoneID:      key.ZoneID,
+		KeyType:     keyType,
+		Flags:       key.Flags,
+		Algorithm:   key.Algorithm,
+		KeyTag:      key.KeyTag,
+		PublicKey:   key.PublicKey,
+		State:       key.State,
+		PublishedAt: key.PublishedAt,
+		ActivatedAt: key.ActivatedAt,
+		RetiredAt:   key.RetiredAt,
+		RemovedAt:   key.RemovedAt,
+		DSSeenAt:    key.DSSeenAt,
 	}
 }
 
 func InitDB() *gorm.DB {
 	dsn := "user=dns password=dns dbname=dns"
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// Unique violations are reported as gorm.ErrDuplicatedKey
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
 	// AutoMigrate the Zone and Record structs
-	err = db.AutoMigrate(&Zone{}, &Record{})
+	err = db.AutoMigrate(&Zone{}, &Record{}, &TSIGKey{}, &TSIGPolicy{}, &DNSSECKey{}, &ACLRule{}, &View{}, &APIKey{}, &User{}, &Team{}, &RoleBinding{}, &AuditEntry{}, &RecordVersion{}, &ChangeSet{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
@@ -3,15 +3,31 @@ package main
 import (
 	"context"
 	"dnsServer/api"
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/data"
 	"dnsServer/server"
+	"dnsServer/service"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
 )
 
+// forwarderEnv names the environment variable holding the address of the
+// resolver queries for names outside the hosted zones are forwarded to,
+// forwarderValidateEnv the one that turns off DNSSEC validation of its
+// answers with "false", and forwarderECSEnv the one that sends the networks
+// of clients upstream with "true"
+const (
+	forwarderEnv         = "DNS_FORWARDER"
+	forwarderValidateEnv = "DNS_FORWARDER_VALIDATE"
+	forwarderECSEnv      = "DNS_FORWARDER_ECS"
+)
+
 func main() {
 	// Start the DNS server and get the stop channel
 	Run()
@@ -25,9 +41,39 @@ func Run() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	// The DNS server, the key manager and the API share one connection pool
+	db := data.InitDB()
+	dnsServer.SetZoneStore(service.NewDNSStore(db))
+	// Serve DNS over TLS when a certificate is installed
+	if err := dnsServer.ListenTLS(":853", "certs/dns.crt", "certs/dns.key"); err != nil {
+		fmt.Println("DNS over TLS disabled:", err)
+	}
+	// Locate clients for geo steering when a GeoIP database is installed
+	if err := dnsServer.SetGeoDatabase("geo/GeoLite2-Country.mmdb"); err != nil {
+		fmt.Println("Geo steering disabled:", err)
+	}
+	if address := os.Getenv(forwarderEnv); address != "" {
+		anchors := []client.TrustAnchor{client.RootTrustAnchor}
+		if validate, err := strconv.ParseBool(os.Getenv(forwarderValidateEnv)); err == nil && !validate {
+			anchors = nil
+		}
+		if err := dnsServer.SetForwarder(address, anchors...); err != nil {
+			fmt.Println("Forwarding disabled:", err)
+		} else if derive, err := strconv.ParseBool(os.Getenv(forwarderECSEnv)); err == nil && derive {
+			dnsServer.DeriveClientSubnets(true)
+		}
+	}
+	// Keep the server from being used as an amplifier
+	if err := dnsServer.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 20, Slip: 2}); err != nil {
+		fmt.Println("Error:", err)
+	}
 	dnsServer.Start()
 
-	handle := api.StartApiServer(":8080")
+	// Roll the DNSSEC keys of signed zones over on their schedule
+	keyManager := service.NewKeyManager(db)
+	keyManager.Start()
+
+	handle := api.StartApiServer(":8080", db, dnsServer)
 	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
@@ -44,6 +90,7 @@ func Run() {
 			println("Server gracefully stopped")
 		}
 		dnsServer.Stop()
+		keyManager.Stop()
 
 		// Wait a moment for the server to shut down gracefully
 		time.Sleep(time.Second)
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// allowedBy reports whether the client of a request may get answers of the
+// scope, checking the global rules and then the rules of the zone, if any.
+// Queries are refused when the client matches a deny rule, or when a list
+// has allow rules and the client matches none of them. Clients with a valid
+// server cookie may be exempt.
+func (server *DNSServer) allowedBy(req *request, scope string, zoneId string) (bool, error) {
+	if server.store == nil || req.cookieValid && server.CookieConfig().ExemptACL {
+		return true, nil
+	}
+	lists := []string{""}
+	if zoneId != "" {
+		lists = append(lists, zoneId)
+	}
+	ip, known := clientIP(req.addr)
+	for _, list := range lists {
+		rules, err := server.aclRules(list)
+		if err != nil {
+			return false, err
+		}
+		if !aclAllows(rules, scope, ip, known) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// aclRules returns the ACL rules of a zone, or the global ones for an empty
+// zoneId, through the store cache
+func (server *DNSServer) aclRules(zoneId string) ([]daos.ACLRule, error) {
+	rules, err := server.cache.read("acl/"+zoneId, func() (any, error) {
+		return server.store.GetACLRules(zoneId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules.([]daos.ACLRule), nil
+}
+
+// refuse answers a query denied by an ACL with REFUSED, or with SERVFAIL
+// when the rules could not be loaded
+func refuse(req *request, err error) utils.DNSResponse {
+	if err != nil {
+		fmt.Println("Error:", err)
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	return newResponse(req, utils.RcodeRefused)
+}
+
+// aclAllows evaluates a list of rules for a client address. Clients of
+// unknown address are only allowed by a list without rules for the scope.
+func aclAllows(rules []daos.ACLRule, scope string, ip netip.Addr, known bool) bool {
+	hasAllow, allowed := false, false
+	for _, rule := range rules {
+		if rule.Scope != scope {
+			continue
+		}
+		if !known {
+			return false
+		}
+		prefix, err := netip.ParsePrefix(rule.CIDR)
+		if err != nil {
+			continue
+		}
+		matches := prefix.Contains(ip)
+		switch rule.Action {
+		case daos.ACLDeny:
+			if matches {
+				return false
+			}
+		case daos.ACLAllow:
+			hasAllow = true
+			allowed = allowed || matches
+		}
+	}
+	return !hasAllow || allowed
+}
+
+// clientIP returns the address a message was received from
+func clientIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return netip.Addr{}, false
+	}
+	parsed, ok := netip.AddrFromSlice(ip)
+	// IPv4 clients of a dual stack socket show up as IPv4-mapped IPv6 addresses
+	return parsed.Unmap(), ok
+}
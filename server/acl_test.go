@@ -0,0 +1,97 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"testing"
+	"time"
+)
+
+func Test_ACL(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("example.org")
+	store.addRecord(zoneId, "www", "A", "192.0.2.1")
+	otherId := store.addZone("other.org")
+	store.addRecord(otherId, "www", "A", "192.0.2.2")
+
+	server, err := NewDNSServer("127.0.0.1:8061")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	setRules := func(zoneId string, rules ...daos.ACLRule) {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.acl[zoneId] = rules
+	}
+	expectRcode := func(t *testing.T, tcp bool, name string, rcode uint16) {
+		t.Helper()
+		var dnsClient *client.DNSClient
+		var err error
+		if tcp {
+			dnsClient, err = client.NewTCPDNSClient("127.0.0.1:8061")
+		} else {
+			dnsClient, err = client.NewDNSClient("127.0.0.1:8061")
+		}
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		response, err := dnsClient.SendQuery(name, utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.Header.Rcode() != rcode {
+			t.Errorf("Expected rcode %d for %s, got %d", rcode, name, response.Header.Rcode())
+		}
+	}
+
+	t.Run("NoRules", func(t *testing.T) {
+		expectRcode(t, false, "www.example.org", utils.RcodeSuccess)
+		expectRcode(t, false, "www.outside.test", utils.RcodeSuccess)
+	})
+
+	t.Run("GlobalAuthoritativeDeny", func(t *testing.T) {
+		setRules("", daos.ACLRule{Scope: daos.ACLScopeAuthoritative, Action: daos.ACLDeny, CIDR: "127.0.0.0/8"})
+		defer setRules("")
+		expectRcode(t, false, "www.example.org", utils.RcodeRefused)
+		expectRcode(t, true, "www.other.org", utils.RcodeRefused)
+		// Recursion is governed by its own list
+		expectRcode(t, false, "www.outside.test", utils.RcodeSuccess)
+	})
+
+	t.Run("ZoneAllowList", func(t *testing.T) {
+		setRules(zoneId, daos.ACLRule{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLAllow, CIDR: "192.0.2.0/24"})
+		defer setRules(zoneId)
+		expectRcode(t, false, "www.example.org", utils.RcodeRefused)
+		expectRcode(t, true, "www.example.org", utils.RcodeRefused)
+		expectRcode(t, false, "www.other.org", utils.RcodeSuccess)
+
+		setRules(zoneId,
+			daos.ACLRule{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLAllow, CIDR: "192.0.2.0/24"},
+			daos.ACLRule{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLAllow, CIDR: "127.0.0.1/32"})
+		expectRcode(t, false, "www.example.org", utils.RcodeSuccess)
+	})
+
+	t.Run("DenyWinsOverAllow", func(t *testing.T) {
+		setRules("", daos.ACLRule{Scope: daos.ACLScopeAuthoritative, Action: daos.ACLAllow, CIDR: "127.0.0.0/8"})
+		setRules(zoneId, daos.ACLRule{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLDeny, CIDR: "127.0.0.1/32"})
+		defer setRules("")
+		defer setRules(zoneId)
+		expectRcode(t, false, "www.example.org", utils.RcodeRefused)
+		expectRcode(t, false, "www.other.org", utils.RcodeSuccess)
+	})
+
+	t.Run("RecursionAllowList", func(t *testing.T) {
+		setRules("", daos.ACLRule{Scope: daos.ACLScopeRecursion, Action: daos.ACLAllow, CIDR: "10.0.0.0/8"})
+		defer setRules("")
+		expectRcode(t, false, "www.outside.test", utils.RcodeRefused)
+		expectRcode(t, true, "www.outside.test", utils.RcodeRefused)
+		expectRcode(t, false, "www.example.org", utils.RcodeSuccess)
+	})
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"dnsServer/utils"
+	"strings"
+)
+
+// maxCNAMEChain limits how many CNAMEs are followed within a zone
+const maxCNAMEChain = 8
+
+// answerAuthoritative answers a query for a name inside a hosted zone
+func (server *DNSServer) answerAuthoritative(req *request, zone *zoneData) utils.DNSResponse {
+	response := newResponse(req, utils.RcodeSuccess)
+	response.Header.Flags |= utils.FlagAA
+
+	question := req.packet.Questions[0]
+	name := canonicalName(question.Name)
+
+	steer := server.steeringFor(req)
+	for i := 0; i < maxCNAMEChain; i++ {
+		answers := zone.steeredAnswers(name, question.Type, steer)
+		if len(answers) > 0 {
+			response.Answers = append(response.Answers, withName(answers, question.Name, name)...)
+			if !req.minimal {
+				zone.addExtraData(&response, server.healthyRecords)
+			}
+			return server.secure(req, zone, response)
+		}
+
+		cnames := zone.steeredAnswers(name, utils.TypeCNAME, steer)
+		if len(cnames) == 0 || question.Type == utils.TypeCNAME {
+			break
+		}
+		response.Answers = append(response.Answers, withName(cnames, question.Name, name)...)
+		name = canonicalName(cnames[0].Cname)
+		if !isSubdomain(name, zone.name) {
+			// The target lives elsewhere, the resolver follows it
+			return server.secure(req, zone, response)
+		}
+	}
+
+	nxdomain := !zone.nameExists(name)
+	if nxdomain {
+		response.Header.Flags |= utils.RcodeNameError
+	}
+	response.Authority = append(response.Authority, zone.negativeSOA())
+	if len(zone.keys) > 0 && req.dnssecOK() {
+		zone.addDenial(&response, name, nxdomain)
+	}
+	return server.secure(req, zone, response)
+}
+
+// addExtraData adds what full responses carry beside the answer: the name
+// servers of the zone in the authority section, and the addresses of the
+// name server, mail exchanger and service targets inside the zone in the
+// additional section. Records failing their health checks are left out
+// through healthy.
+func (zd *zoneData) addExtraData(response *utils.DNSResponse, healthy steering) {
+	answered := map[string]bool{}
+	for _, answer := range response.Answers {
+		answered[canonicalName(answer.Name)+"/"+answer.Type.String()] = true
+	}
+	if !answered[zd.name+"/"+utils.TypeNS.String()] {
+		response.Authority = append(response.Authority, zd.steeredAnswers(zd.name, utils.TypeNS, healthy)...)
+	}
+
+	added := map[string]bool{}
+	for _, rr := range append(append([]utils.DNSAnswer(nil), response.Answers...), response.Authority...) {
+		target := targetName(rr)
+		if target == "" || added[target] || !isSubdomain(target, zd.name) {
+			continue
+		}
+		added[target] = true
+		for _, rtype := range []utils.DNSRecordType{utils.TypeA, utils.TypeAAAA} {
+			if !answered[target+"/"+rtype.String()] {
+				response.Additional = append(response.Additional, zd.steeredAnswers(target, rtype, healthy)...)
+			}
+		}
+	}
+}
+
+// targetName returns the host a record points to that resolvers will look
+// up next, or an empty string for other records
+func targetName(rr utils.DNSAnswer) string {
+	fields := strings.Fields(rr.Value())
+	switch {
+	case rr.Type == utils.TypeNS && len(fields) == 1:
+		return canonicalName(fields[0])
+	case rr.Type == utils.TypeMX && len(fields) == 2:
+		return canonicalName(fields[1])
+	case rr.Type == utils.TypeSRV && len(fields) == 4:
+		return canonicalName(fields[3])
+	}
+	return ""
+}
+
+// secure adds the DNSSEC records to answers from signed zones when the
+// client asked for them
+func (server *DNSServer) secure(req *request, zone *zoneData, response utils.DNSResponse) utils.DNSResponse {
+	if len(zone.keys) == 0 || !req.dnssecOK() {
+		return response
+	}
+	server.addSignatures(zone, &response)
+	return response
+}
+
+// withName keeps the spelling of the question in answers owned by it
+func withName(answers []utils.DNSAnswer, questionName string, name string) []utils.DNSAnswer {
+	if canonicalName(questionName) != name {
+		return answers
+	}
+	for i := range answers {
+		answers[i].Name = questionName
+	}
+	return answers
+}
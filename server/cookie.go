@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Validity of server cookies (RFC 9018 section 4.3). The secret rotates as
+// often as cookies expire, so the previous one outlives the cookies it made.
+const (
+	cookieLifetime       = time.Hour
+	cookieClockSkew      = 5 * time.Minute // Cookies from this far in the future are still valid
+	cookieSecretRotation = cookieLifetime
+)
+
+// cookieJar makes and checks the server cookies given to clients
+type cookieJar struct {
+	mu       sync.Mutex
+	config   daos.CookieConfig
+	current  [16]byte
+	previous *[16]byte // Accepted until the next rotation
+	rotated  time.Time
+	now      func() time.Time
+}
+
+func newCookieJar() *cookieJar {
+	jar := &cookieJar{config: daos.CookieConfig{ExemptRRL: true}, now: time.Now}
+	rand.Read(jar.current[:])
+	jar.rotated = jar.now()
+	return jar
+}
+
+// SetCookieConfig sets what clients with a valid server cookie are trusted with
+func (server *DNSServer) SetCookieConfig(config daos.CookieConfig) {
+	server.cookies.mu.Lock()
+	defer server.cookies.mu.Unlock()
+	server.cookies.config = config
+}
+
+// CookieConfig returns what clients with a valid server cookie are trusted with
+func (server *DNSServer) CookieConfig() daos.CookieConfig {
+	server.cookies.mu.Lock()
+	defer server.cookies.mu.Unlock()
+	return server.cookies.config
+}
+
+// secrets returns the current and previous secrets, rotating them when due.
+// The jar must be locked.
+func (jar *cookieJar) secrets(now time.Time) ([16]byte, *[16]byte) {
+	if now.Sub(jar.rotated) >= cookieSecretRotation {
+		previous := jar.current
+		if now.Sub(jar.rotated) >= 2*cookieSecretRotation {
+			// Cookies made with the current secret expired too
+			jar.previous = nil
+		} else {
+			jar.previous = &previous
+		}
+		rand.Read(jar.current[:])
+		jar.rotated = now
+	}
+	return jar.current, jar.previous
+}
+
+// serverCookie makes a server cookie for the client cookie of a request
+func (jar *cookieJar) serverCookie(req *request) []byte {
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := jar.now()
+	current, _ := jar.secrets(now)
+	ip, _ := clientIP(req.addr)
+	return utils.ServerCookie(current, req.cookie.Client, ip, uint32(now.Unix()))
+}
+
+// valid reports whether the server cookie of a request was made by us, for
+// the client cookie and address, recently enough
+func (jar *cookieJar) valid(req *request) bool {
+	cookie := req.cookie.Server
+	if len(cookie) != 16 || cookie[0] != 1 {
+		return false
+	}
+	jar.mu.Lock()
+	defer jar.mu.Unlock()
+	now := jar.now()
+	// Serial number arithmetic, timestamps wrap around in 2106
+	age := time.Duration(int32(uint32(now.Unix())-binary.BigEndian.Uint32(cookie[4:8]))) * time.Second
+	if age > cookieLifetime || age < -cookieClockSkew {
+		return false
+	}
+	ip, _ := clientIP(req.addr)
+	current, previous := jar.secrets(now)
+	for _, secret := range []*[16]byte{&current, previous} {
+		if secret == nil {
+			continue
+		}
+		expected := utils.ServerCookie(*secret, req.cookie.Client, ip, binary.BigEndian.Uint32(cookie[4:8]))
+		if subtle.ConstantTimeCompare(expected, cookie) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieOption returns the cookie option of the response to a request, with
+// a fresh server cookie, or nil if the request had no cookie
+func (server *DNSServer) cookieOption(req *request) []utils.EDNSOption {
+	if req.cookie == nil {
+		return nil
+	}
+	return []utils.EDNSOption{utils.Cookie{Client: req.cookie.Client, Server: server.cookies.serverCookie(req)}.ToOption()}
+}
+
+// badCookie answers a query with an invalid server cookie with BADCOOKIE and
+// a fresh server cookie to retry with (RFC 7873 section 5.2.4)
+func (server *DNSServer) badCookie(req *request, edns *utils.EDNS) utils.DNSResponse {
+	response := newResponse(req, utils.RcodeBadCookie&0xF)
+	response.Additional = append(response.Additional, utils.EDNS{
+		UDPSize:       utils.DefaultEDNSUDPSize,
+		ExtendedRcode: uint8(utils.RcodeBadCookie >> 4),
+		DO:            edns.DO,
+		Options:       server.cookieOption(req),
+	}.ToAnswer())
+	return response
+}
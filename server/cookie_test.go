@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"encoding/hex"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func Test_ServerCookie(t *testing.T) {
+	// Test vector of RFC 9018 appendix A.1
+	var secret [16]byte
+	raw, _ := hex.DecodeString("e5e973e5a6b2a43f48e7dc849e37bfcf")
+	copy(secret[:], raw)
+	clientCookie, _ := hex.DecodeString("2464c4abcf10c957")
+	cookie := utils.ServerCookie(secret, clientCookie, netip.MustParseAddr("198.51.100.100"), 1559731985)
+	if expected := "010000005cf79f111f8130c3eee29480"; hex.EncodeToString(cookie) != expected {
+		t.Errorf("Expected server cookie %s, got %x", expected, cookie)
+	}
+}
+
+func Test_CookieRotation(t *testing.T) {
+	jar := newCookieJar()
+	start := time.Now()
+	now := start
+	jar.now = func() time.Time { return now }
+	jar.rotated = start
+	req := &request{addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, cookie: &utils.Cookie{Client: []byte("clientck")}}
+
+	now = start.Add(50 * time.Minute)
+	req.cookie.Server = jar.serverCookie(req)
+	if !jar.valid(req) {
+		t.Fatalf("Expected a fresh cookie to be valid")
+	}
+
+	now = start.Add(70 * time.Minute)
+	if !jar.valid(req) {
+		t.Errorf("Expected the cookie made with the previous secret to stay valid")
+	}
+	other := &request{addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 53}, cookie: req.cookie}
+	if jar.valid(other) {
+		t.Errorf("Expected the cookie to be invalid for another address")
+	}
+
+	now = start.Add(115 * time.Minute)
+	if jar.valid(req) {
+		t.Errorf("Expected an expired cookie to be invalid")
+	}
+}
+
+func Test_Cookies(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("cookie.example")
+	store.addRecord(zoneId, "www", "A", "192.0.2.1")
+
+	server, err := NewDNSServer("127.0.0.1:8068")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	newClient := func(t *testing.T, cookies bool) *client.DNSClient {
+		t.Helper()
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8068")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		if cookies {
+			dnsClient.EnableCookies()
+		}
+		return dnsClient
+	}
+	withCookie := func(data []byte) utils.DNSPacket {
+		return utils.DNSPacket{
+			Header:     utils.DNSHeader{ID: 11},
+			Questions:  []utils.DNSQuestion{{Name: "www.cookie.example", Type: utils.TypeA, Class: utils.ClassIN}},
+			Additional: []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, Options: []utils.EDNSOption{{Code: utils.EDNSOptionCookie, Data: data}}}.ToAnswer()},
+		}
+	}
+
+	t.Run("ClientCookie", func(t *testing.T) {
+		dnsClient := newClient(t, false)
+		defer dnsClient.Close()
+		response, err := dnsClient.Exchange(withCookie([]byte("clientck")))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		cookie, err := response.EDNS().Cookie()
+		if err != nil || cookie == nil || !bytes.Equal(cookie.Client, []byte("clientck")) || len(cookie.Server) != 16 {
+			t.Errorf("Expected the client cookie and a server cookie, got %+v, err: %v", cookie, err)
+		}
+		if len(response.Answers) != 1 {
+			t.Errorf("Expected an answer, got %v", response.Answers)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		dnsClient := newClient(t, false)
+		defer dnsClient.Close()
+		response, err := dnsClient.Exchange(withCookie([]byte("short")))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeFormatError {
+			t.Errorf("Expected FORMERR, got rcode %d", response.Header.Rcode())
+		}
+	})
+
+	t.Run("BadCookie", func(t *testing.T) {
+		dnsClient := newClient(t, false)
+		defer dnsClient.Close()
+		forged := append([]byte("clientck\x01\x00\x00\x00"), make([]byte, 12)...)
+		response, err := dnsClient.Exchange(withCookie(forged))
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.ExtendedRcode() != utils.RcodeBadCookie || len(response.Answers) != 0 {
+			t.Errorf("Expected BADCOOKIE, got rcode %d", response.ExtendedRcode())
+		}
+		if cookie, _ := response.EDNS().Cookie(); cookie == nil || len(cookie.Server) != 16 {
+			t.Errorf("Expected a fresh server cookie, got %+v", cookie)
+		}
+	})
+
+	t.Run("ClientRetries", func(t *testing.T) {
+		dnsClient := newClient(t, true)
+		defer dnsClient.Close()
+		if _, err := dnsClient.SendQuery("www.cookie.example", utils.TypeA); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		server.cookies.mu.Lock()
+		server.cookies.now = func() time.Time { return time.Now().Add(2 * cookieLifetime) }
+		server.cookies.mu.Unlock()
+		defer func() {
+			server.cookies.mu.Lock()
+			server.cookies.now = time.Now
+			server.cookies.mu.Unlock()
+		}()
+		// The server cookie expired, the client retries with the one sent with BADCOOKIE
+		response, err := dnsClient.SendQuery("www.cookie.example", utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.ExtendedRcode() != utils.RcodeSuccess || len(response.Answers) != 1 {
+			t.Errorf("Expected an answer after the retry, got rcode %d", response.ExtendedRcode())
+		}
+	})
+
+	t.Run("ExemptFromRRL", func(t *testing.T) {
+		if err := server.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 1, Slip: 1}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer server.SetRateLimit(daos.RRLConfig{})
+		truncated := func(dnsClient *client.DNSClient) int {
+			count := 0
+			for i := 0; i < 5; i++ {
+				response, err := dnsClient.SendQuery("www.cookie.example", utils.TypeA)
+				if err != nil {
+					t.Fatalf("Query failed: %v", err)
+				}
+				if response.Header.Flags&utils.FlagTC != 0 {
+					count++
+				}
+			}
+			return count
+		}
+		withCookies := newClient(t, true)
+		defer withCookies.Close()
+		withCookies.SendQuery("www.cookie.example", utils.TypeA)
+		if count := truncated(withCookies); count != 0 {
+			t.Errorf("Expected no limited responses with cookies, got %d", count)
+		}
+		without := newClient(t, false)
+		defer without.Close()
+		if count := truncated(without); count == 0 {
+			t.Errorf("Expected limited responses without cookies")
+		}
+	})
+
+	t.Run("ExemptFromACL", func(t *testing.T) {
+		store.mu.Lock()
+		store.acl[zoneId] = []daos.ACLRule{{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLDeny, CIDR: "127.0.0.0/8"}}
+		store.mu.Unlock()
+		defer func() {
+			store.mu.Lock()
+			delete(store.acl, zoneId)
+			store.mu.Unlock()
+		}()
+		dnsClient := newClient(t, true)
+		defer dnsClient.Close()
+		query := func() uint16 {
+			response, err := dnsClient.SendQuery("www.cookie.example", utils.TypeA)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			return response.Header.Rcode()
+		}
+		if rcode := query(); rcode != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED without a server cookie, got rcode %d", rcode)
+		}
+		if rcode := query(); rcode != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED until clients with cookies are exempt, got rcode %d", rcode)
+		}
+		server.SetCookieConfig(daos.CookieConfig{ExemptRRL: true, ExemptACL: true})
+		defer server.SetCookieConfig(daos.CookieConfig{ExemptRRL: true})
+		if rcode := query(); rcode != utils.RcodeSuccess {
+			t.Errorf("Expected an answer with a valid server cookie, got rcode %d", rcode)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"dnsServer/utils"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// nsec3Entry is a name of the zone at its place in the NSEC3 chain
+type nsec3Entry struct {
+	hash  []byte
+	types []utils.DNSRecordType
+}
+
+// addDenial adds the NSEC or NSEC3 records proving that name does not exist
+// (nxdomain) or has no records of the queried type
+func (zd *zoneData) addDenial(response *utils.DNSResponse, name string, nxdomain bool) {
+	var proof []utils.DNSAnswer
+	if zd.zone.NSEC3 != nil {
+		proof = zd.nsec3Denial(name, nxdomain)
+	} else {
+		proof = zd.nsecDenial(name, nxdomain)
+	}
+	response.Authority = append(response.Authority, proof...)
+}
+
+// typesAt returns the types present at name, as listed in its NSEC or NSEC3 record
+func (zd *zoneData) typesAt(name string) []utils.DNSRecordType {
+	seen := map[utils.DNSRecordType]bool{}
+	var types []utils.DNSRecordType
+	for _, answer := range zd.answers(name, utils.TypeANY) {
+		if !seen[answer.Type] {
+			seen[answer.Type] = true
+			types = append(types, answer.Type)
+		}
+	}
+	if len(types) > 0 {
+		types = append(types, utils.TypeRRSIG)
+	}
+	if zd.zone.NSEC3 == nil {
+		types = append(types, utils.TypeNSEC)
+	}
+	return types
+}
+
+// ownerNames returns the names owning records in the zone, in canonical order
+func (zd *zoneData) ownerNames() []string {
+	seen := map[string]bool{zd.name: true}
+	names := []string{zd.name}
+	for _, record := range zd.records {
+		owner := zd.owner(record)
+		if !seen[owner] {
+			seen[owner] = true
+			names = append(names, owner)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return utils.CompareCanonical(names[i], names[j]) < 0 })
+	return names
+}
+
+// closestEncloser returns the longest existing ancestor of name
+func (zd *zoneData) closestEncloser(name string) string {
+	for name != zd.name && !zd.nameExists(name) {
+		if !strings.Contains(name, ".") {
+			return zd.name
+		}
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return name
+}
+
+// nextCloser returns the name one label longer than the closest encloser on
+// the way to name
+func nextCloser(name string, closestEncloser string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."+closestEncloser), ".")
+	return labels[len(labels)-1] + "." + closestEncloser
+}
+
+// nsecDenial returns the NSEC records for a negative answer: the NSEC at the
+// name for NODATA, or the NSECs covering the name and the wildcard that could
+// have matched it for NXDOMAIN
+func (zd *zoneData) nsecDenial(name string, nxdomain bool) []utils.DNSAnswer {
+	names := zd.ownerNames()
+	if !nxdomain {
+		// Empty non-terminals have no NSEC and are covered by their predecessor
+		return []utils.DNSAnswer{zd.nsecCovering(names, name)}
+	}
+	proof := []utils.DNSAnswer{zd.nsecCovering(names, name)}
+	wildcard := zd.nsecCovering(names, "*."+zd.closestEncloser(name))
+	if wildcard.Name != proof[0].Name {
+		proof = append(proof, wildcard)
+	}
+	return proof
+}
+
+// nsecCovering returns the NSEC record owned by name, or by the last name
+// sorting before it
+func (zd *zoneData) nsecCovering(names []string, name string) utils.DNSAnswer {
+	i := sort.Search(len(names), func(i int) bool { return utils.CompareCanonical(names[i], name) > 0 }) - 1
+	if i < 0 {
+		i = len(names) - 1
+	}
+	nsec := utils.NSEC{NextName: names[(i+1)%len(names)], Types: zd.typesAt(names[i])}
+	return utils.DNSAnswer{Name: names[i], Type: utils.TypeNSEC, Class: utils.ClassIN, TTL: soaMinimum, RData: nsec.RData()}
+}
+
+func (zd *zoneData) nsec3Salt() []byte {
+	salt, _ := hex.DecodeString(zd.zone.NSEC3.Salt)
+	return salt
+}
+
+// nsec3Chain returns the hashes of every name of the zone, including empty
+// non-terminals, in hash order. With opt-out, insecure delegations are left out.
+func (zd *zoneData) nsec3Chain() []nsec3Entry {
+	salt := zd.nsec3Salt()
+	seen := map[string]bool{}
+	var chain []nsec3Entry
+	for _, owner := range zd.ownerNames() {
+		types := zd.typesAt(owner)
+		if zd.zone.NSEC3.OptOut && owner != zd.name && utils.HasType(types, utils.TypeNS) && !utils.HasType(types, utils.TypeDS) {
+			continue
+		}
+		for name := owner; ; name = name[strings.Index(name, ".")+1:] {
+			if seen[name] {
+				break
+			}
+			seen[name] = true
+			entry := nsec3Entry{hash: utils.HashNSEC3Name(name, salt, zd.zone.NSEC3.Iterations)}
+			if name == owner {
+				entry.types = types
+			}
+			chain = append(chain, entry)
+			if name == zd.name || !strings.Contains(name, ".") {
+				break
+			}
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool { return bytes.Compare(chain[i].hash, chain[j].hash) < 0 })
+	return chain
+}
+
+// nsec3Denial returns the NSEC3 records for a negative answer: the NSEC3
+// matching the name for NODATA, or the closest encloser proof and the NSEC3
+// covering the wildcard for NXDOMAIN (RFC 5155 section 7.2)
+func (zd *zoneData) nsec3Denial(name string, nxdomain bool) []utils.DNSAnswer {
+	chain := zd.nsec3Chain()
+	salt := zd.nsec3Salt()
+	hash := func(name string) []byte { return utils.HashNSEC3Name(name, salt, zd.zone.NSEC3.Iterations) }
+
+	if !nxdomain {
+		return []utils.DNSAnswer{zd.nsec3Record(chain, hash(name))}
+	}
+	encloser := zd.closestEncloser(name)
+	var proof []utils.DNSAnswer
+	seen := map[string]bool{}
+	for _, h := range [][]byte{hash(encloser), hash(nextCloser(name, encloser)), hash("*." + encloser)} {
+		record := zd.nsec3Record(chain, h)
+		if !seen[record.Name] {
+			seen[record.Name] = true
+			proof = append(proof, record)
+		}
+	}
+	return proof
+}
+
+// nsec3Record returns the NSEC3 record matching hash, or covering it when no
+// name of the zone has that hash
+func (zd *zoneData) nsec3Record(chain []nsec3Entry, hash []byte) utils.DNSAnswer {
+	i := sort.Search(len(chain), func(i int) bool { return bytes.Compare(chain[i].hash, hash) > 0 }) - 1
+	if i < 0 {
+		i = len(chain) - 1
+	}
+	var flags uint8
+	if zd.zone.NSEC3.OptOut {
+		flags = utils.NSEC3FlagOptOut
+	}
+	nsec3 := utils.NSEC3{
+		HashAlgorithm: utils.NSEC3HashSHA1,
+		Flags:         flags,
+		Iterations:    zd.zone.NSEC3.Iterations,
+		Salt:          zd.nsec3Salt(),
+		NextHashed:    chain[(i+1)%len(chain)].hash,
+		Types:         chain[i].types,
+	}
+	owner := utils.EncodeNSEC3Hash(chain[i].hash) + "." + zd.name
+	return utils.DNSAnswer{Name: owner, Type: utils.TypeNSEC3, Class: utils.ClassIN, TTL: soaMinimum, RData: nsec3.RData()}
+}
+
+// nsec3Param returns the NSEC3PARAM record published at the apex
+func (zd *zoneData) nsec3Param() utils.DNSAnswer {
+	param := utils.NSEC3PARAM{
+		HashAlgorithm: utils.NSEC3HashSHA1,
+		Iterations:    zd.zone.NSEC3.Iterations,
+		Salt:          zd.nsec3Salt(),
+	}
+	return utils.DNSAnswer{Name: zd.name, Type: utils.TypeNSEC3PARAM, Class: utils.ClassIN, TTL: 0, RData: param.RData()}
+}
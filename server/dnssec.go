@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dnsServer/utils"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signatures are valid for a week and re-generated once they are three days
+// old, so cached signatures always have plenty of validity left
+const (
+	signatureValidity  = 7 * 24 * time.Hour
+	signatureRefresh   = 3 * 24 * time.Hour
+	signatureBackdate  = time.Hour // Tolerates resolvers with slow clocks
+	maxCachedSignature = 10000
+)
+
+type cachedSignature struct {
+	rrsig     utils.DNSAnswer
+	refreshAt time.Time
+}
+
+// signatureCache keeps the signatures of hot RRsets so they are not re-signed
+// for every response
+type signatureCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedSignature
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{entries: map[string]cachedSignature{}}
+}
+
+// sign returns a signature of the RRset made with key, from the cache if possible
+func (cache *signatureCache) sign(rrset []utils.DNSAnswer, key *utils.SigningKey, signer string) (utils.DNSAnswer, error) {
+	cacheKey := signatureCacheKey(rrset, key, signer)
+	now := time.Now()
+
+	cache.mu.Lock()
+	entry, ok := cache.entries[cacheKey]
+	cache.mu.Unlock()
+	if ok && now.Before(entry.refreshAt) {
+		rrsig := entry.rrsig
+		rrsig.Name = rrset[0].Name
+		return rrsig, nil
+	}
+
+	rrsig, err := utils.SignRRSet(rrset, key, signer, now.Add(-signatureBackdate), now.Add(signatureValidity))
+	if err != nil {
+		return rrsig, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.entries) >= maxCachedSignature {
+		for k, e := range cache.entries {
+			if !now.Before(e.refreshAt) {
+				delete(cache.entries, k)
+			}
+		}
+		if len(cache.entries) >= maxCachedSignature {
+			cache.entries = map[string]cachedSignature{}
+		}
+	}
+	cache.entries[cacheKey] = cachedSignature{rrsig: rrsig, refreshAt: now.Add(signatureRefresh)}
+	return rrsig, nil
+}
+
+// signatureCacheKey identifies an RRset and the key signing it
+func signatureCacheKey(rrset []utils.DNSAnswer, key *utils.SigningKey, signer string) string {
+	var rdatas [][]byte
+	for _, rr := range rrset {
+		rdatas = append(rdatas, rr.RDataBytes())
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	digest := sha256.New()
+	digest.Write([]byte(canonicalName(rrset[0].Name) + "/" + canonicalName(signer)))
+	binary.Write(digest, binary.BigEndian, rrset[0].Type)
+	binary.Write(digest, binary.BigEndian, rrset[0].TTL)
+	for _, rdata := range rdatas {
+		binary.Write(digest, binary.BigEndian, uint16(len(rdata)))
+		digest.Write(rdata)
+	}
+	return fmt.Sprintf("%d/%d/%x", key.Algorithm, key.KeyTag(), digest.Sum(nil))
+}
+
+// dnssecOK reports whether the request asked for DNSSEC records
+func (req *request) dnssecOK() bool {
+	edns := req.packet.EDNS()
+	return edns != nil && edns.DO
+}
+
+// addSignatures signs every RRset in the sections of the response with the
+// keys of the zone
+func (server *DNSServer) addSignatures(zone *zoneData, response *utils.DNSResponse) {
+	response.Answers = server.signSection(zone, response.Answers)
+	response.Authority = server.signSection(zone, response.Authority)
+	response.Additional = server.signSection(zone, response.Additional)
+}
+
+func (server *DNSServer) signSection(zone *zoneData, section []utils.DNSAnswer) []utils.DNSAnswer {
+	var signed []utils.DNSAnswer
+	for _, rrset := range groupRRSets(section) {
+		signed = append(signed, rrset...)
+		if rrset[0].Type == utils.TypeRRSIG || !isSubdomain(canonicalName(rrset[0].Name), zone.name) {
+			continue
+		}
+		for _, key := range zone.signingKeysFor(rrset[0].Type) {
+			rrsig, err := server.signatures.sign(rrset, key, zone.name)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			signed = append(signed, rrsig)
+		}
+	}
+	return signed
+}
+
+// signingKeysFor returns the keys signing RRsets of the given type: the key
+// signing keys for the apex key sets, the zone signing keys for the rest
+func (zd *zoneData) signingKeysFor(rtype utils.DNSRecordType) []*utils.SigningKey {
+	wantKSK := rtype == utils.TypeDNSKEY || rtype == utils.TypeCDNSKEY || rtype == utils.TypeCDS
+	var keys, fallback []*utils.SigningKey
+	for i := range zd.keys {
+		key := &zd.keys[i]
+		// Retired key signing keys keep signing until the parent drops their
+		// DS, so the key set stays valid through a double signature rollover
+		if key.State == utils.KeyPublished || (key.State == utils.KeyRetired && !key.IsKSK()) {
+			continue
+		}
+		if key.IsKSK() == wantKSK {
+			keys = append(keys, key)
+		} else {
+			fallback = append(fallback, key)
+		}
+	}
+	if len(keys) == 0 {
+		// Zones with a single combined key sign everything with it
+		return fallback
+	}
+	return keys
+}
+
+// groupRRSets splits a section into RRsets, keeping their order of appearance
+func groupRRSets(section []utils.DNSAnswer) [][]utils.DNSAnswer {
+	var rrsets [][]utils.DNSAnswer
+	index := map[string]int{}
+	for _, rr := range section {
+		key := fmt.Sprintf("%s/%d/%d", strings.ToLower(rr.Name), rr.Type, rr.Class)
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []utils.DNSAnswer{rr})
+	}
+	return rrsets
+}
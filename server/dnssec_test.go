@@ -0,0 +1,381 @@
+package server
+
+import (
+	"bytes"
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_DNSSECSigning(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("example.net")
+	store.addRecord(zoneId, "www", "A", "192.0.2.10")
+	store.signZone(zoneId, utils.AlgorithmECDSAP256SHA256)
+	ksk, zsk := store.dnssecKeys[zoneId][0], store.dnssecKeys[zoneId][1]
+
+	server, err := NewDNSServer("127.0.0.1:8054")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8054")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+
+	query := func(t *testing.T, name string, qtype utils.DNSRecordType, do bool) utils.DNSResponse {
+		response, err := dnsClient.Exchange(utils.DNSPacket{
+			Header:     utils.DNSHeader{ID: 7},
+			Questions:  []utils.DNSQuestion{{Name: name, Type: qtype, Class: utils.ClassIN}},
+			Additional: []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: do}.ToAnswer()},
+		})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+	signatures := func(response utils.DNSResponse, covered utils.DNSRecordType) []utils.RRSIG {
+		var sigs []utils.RRSIG
+		for _, answer := range append(response.Answers, response.Authority...) {
+			if answer.Type != utils.TypeRRSIG {
+				continue
+			}
+			sig, err := utils.ParseRRSIG(answer.RData)
+			if err == nil && sig.TypeCovered == covered {
+				sigs = append(sigs, sig)
+			}
+		}
+		return sigs
+	}
+
+	t.Run("UnsignedWithoutDO", func(t *testing.T) {
+		response := query(t, "www.example.net", utils.TypeA, false)
+		if len(response.Answers) != 1 || len(signatures(response, utils.TypeA)) != 0 {
+			t.Errorf("Expected a single unsigned answer, got %v", response.Answers)
+		}
+	})
+
+	t.Run("SignedWithDO", func(t *testing.T) {
+		response := query(t, "www.example.net", utils.TypeA, true)
+		sigs := signatures(response, utils.TypeA)
+		if len(sigs) != 1 || sigs[0].KeyTag != zsk.KeyTag() || sigs[0].SignerName != "example.net" {
+			t.Fatalf("Expected an RRSIG by the ZSK, got %+v", sigs)
+		}
+		if edns := response.EDNS(); edns == nil || !edns.DO {
+			t.Errorf("Expected the DO bit to be echoed")
+		}
+	})
+
+	t.Run("SignatureCache", func(t *testing.T) {
+		first := query(t, "www.example.net", utils.TypeA, true)
+		second := query(t, "www.example.net", utils.TypeA, true)
+		a, b := signatures(first, utils.TypeA), signatures(second, utils.TypeA)
+		if len(a) != 1 || len(b) != 1 || !bytes.Equal(a[0].Signature, b[0].Signature) {
+			t.Errorf("Expected the cached signature to be reused")
+		}
+	})
+
+	t.Run("DNSKEY", func(t *testing.T) {
+		response := query(t, "example.net", utils.TypeDNSKEY, true)
+		var keys int
+		for _, answer := range response.Answers {
+			if answer.Type == utils.TypeDNSKEY {
+				keys++
+			}
+		}
+		sigs := signatures(response, utils.TypeDNSKEY)
+		if keys != 2 || len(sigs) != 1 || sigs[0].KeyTag != ksk.KeyTag() {
+			t.Errorf("Expected 2 DNSKEYs signed by the KSK, got %d keys and %+v", keys, sigs)
+		}
+	})
+
+	t.Run("CDS", func(t *testing.T) {
+		response := query(t, "example.net", utils.TypeCDS, false)
+		if len(response.Answers) != 1 {
+			t.Fatalf("Expected a single CDS record, got %v", response.Answers)
+		}
+		cds, err := utils.ParseDS(response.Answers[0].RData)
+		if err != nil || !bytes.Equal(cds.RData(), ksk.DS("example.net").RData()) {
+			t.Errorf("CDS does not match the KSK, got %+v", cds)
+		}
+	})
+
+	t.Run("NegativeAnswerSigned", func(t *testing.T) {
+		response := query(t, "missing.example.net", utils.TypeA, true)
+		if response.Header.Rcode() != utils.RcodeNameError || len(signatures(response, utils.TypeSOA)) != 1 {
+			t.Errorf("Expected NXDOMAIN with a signed SOA, got %v", response)
+		}
+	})
+}
+
+func Test_DNSSECDenial(t *testing.T) {
+	store := newMemStore()
+	nsecZone := store.addZone("example.org")
+	store.addRecord(nsecZone, "www", "A", "192.0.2.20")
+	store.addRecord(nsecZone, "mail.eu", "A", "192.0.2.21")
+	store.signZone(nsecZone, utils.AlgorithmED25519)
+	nsec3Zone := store.addZone("example.com")
+	store.addRecord(nsec3Zone, "www", "A", "192.0.2.30")
+	store.addRecord(nsec3Zone, "mail.eu", "A", "192.0.2.31")
+	store.signZone(nsec3Zone, utils.AlgorithmED25519)
+	store.useNSEC3(nsec3Zone, daos.NSEC3Params{Salt: "aabbccdd", Iterations: 5})
+
+	server, err := NewDNSServer("127.0.0.1:8055")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8055")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+
+	query := func(t *testing.T, name string, qtype utils.DNSRecordType) utils.DNSResponse {
+		response, err := dnsClient.Exchange(utils.DNSPacket{
+			Header:     utils.DNSHeader{ID: 8},
+			Questions:  []utils.DNSQuestion{{Name: name, Type: qtype, Class: utils.ClassIN}},
+			Additional: []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: true}.ToAnswer()},
+		})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+	records := func(response utils.DNSResponse, rtype utils.DNSRecordType) []utils.DNSAnswer {
+		var found []utils.DNSAnswer
+		for _, answer := range response.Authority {
+			if answer.Type == rtype {
+				found = append(found, answer)
+			}
+		}
+		return found
+	}
+	signed := func(response utils.DNSResponse, covered utils.DNSRecordType) int {
+		var count int
+		for _, answer := range response.Authority {
+			if sig, err := utils.ParseRRSIG(answer.RData); answer.Type == utils.TypeRRSIG && err == nil && sig.TypeCovered == covered {
+				count++
+			}
+		}
+		return count
+	}
+	// covers reports whether the NSEC3 record proves that name has no hash in the chain
+	covers := func(record utils.DNSAnswer, name string) bool {
+		nsec3, err := utils.ParseNSEC3(record.RData)
+		if err != nil {
+			return false
+		}
+		owner, err := utils.DecodeNSEC3Hash(record.Name[:strings.Index(record.Name, ".")])
+		if err != nil {
+			return false
+		}
+		hash := utils.HashNSEC3Name(name, nsec3.Salt, nsec3.Iterations)
+		if bytes.Compare(owner, nsec3.NextHashed) >= 0 {
+			// Last record of the chain, wrapping around to the first
+			return bytes.Compare(hash, owner) > 0 || bytes.Compare(hash, nsec3.NextHashed) < 0
+		}
+		return bytes.Compare(hash, owner) > 0 && bytes.Compare(hash, nsec3.NextHashed) < 0
+	}
+	matches := func(record utils.DNSAnswer, name string) bool {
+		nsec3, err := utils.ParseNSEC3(record.RData)
+		if err != nil {
+			return false
+		}
+		hash := utils.HashNSEC3Name(name, nsec3.Salt, nsec3.Iterations)
+		return strings.EqualFold(record.Name, utils.EncodeNSEC3Hash(hash)+".example.com")
+	}
+
+	t.Run("NSECNameError", func(t *testing.T) {
+		response := query(t, "mx.example.org", utils.TypeA)
+		nsecs := records(response, utils.TypeNSEC)
+		if response.Header.Rcode() != utils.RcodeNameError || len(nsecs) != 2 || signed(response, utils.TypeNSEC) != 2 {
+			t.Fatalf("Expected NXDOMAIN with 2 signed NSECs, got %v", response)
+		}
+		// example.org < *.example.org < eu.example.org (empty) < mail.eu < mx < www
+		owners := map[string]string{}
+		for _, record := range nsecs {
+			nsec, err := utils.ParseNSEC(record.RData)
+			if err != nil {
+				t.Fatalf("Invalid NSEC: %v", err)
+			}
+			owners[record.Name] = nsec.NextName
+		}
+		if owners["mail.eu.example.org"] != "www.example.org" || owners["example.org"] != "mail.eu.example.org" {
+			t.Errorf("Unexpected NSEC ranges %v", owners)
+		}
+	})
+
+	t.Run("NSECNoData", func(t *testing.T) {
+		response := query(t, "www.example.org", utils.TypeAAAA)
+		nsecs := records(response, utils.TypeNSEC)
+		if response.Header.Rcode() != utils.RcodeSuccess || len(nsecs) != 1 || nsecs[0].Name != "www.example.org" {
+			t.Fatalf("Expected NODATA with the NSEC of the name, got %v", response)
+		}
+		nsec, _ := utils.ParseNSEC(nsecs[0].RData)
+		if !utils.HasType(nsec.Types, utils.TypeA) || utils.HasType(nsec.Types, utils.TypeAAAA) ||
+			!utils.HasType(nsec.Types, utils.TypeNSEC) || nsec.NextName != "example.org" {
+			t.Errorf("Unexpected NSEC %+v", nsec)
+		}
+	})
+
+	t.Run("NSECApexTypes", func(t *testing.T) {
+		response := query(t, "example.org", utils.TypeMX)
+		nsecs := records(response, utils.TypeNSEC)
+		if len(nsecs) != 1 {
+			t.Fatalf("Expected the apex NSEC, got %v", response)
+		}
+		nsec, _ := utils.ParseNSEC(nsecs[0].RData)
+		for _, rtype := range []utils.DNSRecordType{utils.TypeSOA, utils.TypeDNSKEY, utils.TypeRRSIG, utils.TypeNSEC} {
+			if !utils.HasType(nsec.Types, rtype) {
+				t.Errorf("Expected type %d in the apex bitmap, got %v", rtype, nsec.Types)
+			}
+		}
+	})
+
+	t.Run("NSECNotWithoutDO", func(t *testing.T) {
+		response, err := dnsClient.SendQuery("mx.example.org", utils.TypeA)
+		if err != nil || len(records(response, utils.TypeNSEC)) != 0 {
+			t.Errorf("Expected no NSEC without the DO bit, got %v", response)
+		}
+	})
+
+	t.Run("NSEC3NameError", func(t *testing.T) {
+		response := query(t, "mx.example.com", utils.TypeA)
+		nsec3s := records(response, utils.TypeNSEC3)
+		if response.Header.Rcode() != utils.RcodeNameError || len(nsec3s) == 0 || signed(response, utils.TypeNSEC3) != len(nsec3s) {
+			t.Fatalf("Expected NXDOMAIN with signed NSEC3s, got %v", response)
+		}
+		var encloser, nextCloser, wildcard bool
+		for _, record := range nsec3s {
+			encloser = encloser || matches(record, "example.com")
+			nextCloser = nextCloser || covers(record, "mx.example.com")
+			wildcard = wildcard || covers(record, "*.example.com")
+		}
+		if !encloser || !nextCloser || !wildcard {
+			t.Errorf("Incomplete closest encloser proof: encloser %v, next closer %v, wildcard %v", encloser, nextCloser, wildcard)
+		}
+	})
+
+	t.Run("NSEC3NoData", func(t *testing.T) {
+		response := query(t, "eu.example.com", utils.TypeA)
+		nsec3s := records(response, utils.TypeNSEC3)
+		if response.Header.Rcode() != utils.RcodeSuccess || len(nsec3s) != 1 || !matches(nsec3s[0], "eu.example.com") {
+			t.Fatalf("Expected NODATA with the NSEC3 of the empty non-terminal, got %v", response)
+		}
+		if nsec3, _ := utils.ParseNSEC3(nsec3s[0].RData); len(nsec3.Types) != 0 || nsec3.Iterations != 5 {
+			t.Errorf("Unexpected NSEC3 %+v", nsec3)
+		}
+	})
+
+	t.Run("NSEC3PARAM", func(t *testing.T) {
+		response := query(t, "example.com", utils.TypeNSEC3PARAM)
+		if len(response.Answers) == 0 || response.Answers[0].Type != utils.TypeNSEC3PARAM {
+			t.Fatalf("Expected the NSEC3PARAM record, got %v", response)
+		}
+		if !bytes.Equal(response.Answers[0].RData, []byte{1, 0, 0, 5, 4, 0xaa, 0xbb, 0xcc, 0xdd}) {
+			t.Errorf("Unexpected NSEC3PARAM %x", response.Answers[0].RData)
+		}
+	})
+}
+
+func Test_DNSSECRolloverStates(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("example.info")
+	store.addRecord(zoneId, "www", "A", "192.0.2.40")
+	store.signZone(zoneId, utils.AlgorithmED25519)
+	oldKSK, activeZSK := store.dnssecKeys[zoneId][0], store.dnssecKeys[zoneId][1]
+	// A KSK double signature rollover and a ZSK pre-publish rollover in progress
+	store.dnssecKeys[zoneId][0].State = utils.KeyRetired
+	for _, state := range []utils.KeyState{utils.KeyActive, utils.KeyPublished} {
+		flags := utils.DNSKEYFlagZone
+		if state == utils.KeyActive {
+			flags |= utils.DNSKEYFlagSEP
+		}
+		key, err := utils.GenerateSigningKey(utils.AlgorithmED25519, flags)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		key.State = state
+		store.dnssecKeys[zoneId] = append(store.dnssecKeys[zoneId], *key)
+	}
+	newKSK, publishedZSK := store.dnssecKeys[zoneId][2], store.dnssecKeys[zoneId][3]
+
+	server, err := NewDNSServer("127.0.0.1:8056")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8056")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+
+	query := func(t *testing.T, name string, qtype utils.DNSRecordType) utils.DNSResponse {
+		response, err := dnsClient.Exchange(utils.DNSPacket{
+			Header:     utils.DNSHeader{ID: 9},
+			Questions:  []utils.DNSQuestion{{Name: name, Type: qtype, Class: utils.ClassIN}},
+			Additional: []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: true}.ToAnswer()},
+		})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+	signers := func(response utils.DNSResponse, covered utils.DNSRecordType) map[uint16]bool {
+		tags := map[uint16]bool{}
+		for _, answer := range response.Answers {
+			if sig, err := utils.ParseRRSIG(answer.RData); answer.Type == utils.TypeRRSIG && err == nil && sig.TypeCovered == covered {
+				tags[sig.KeyTag] = true
+			}
+		}
+		return tags
+	}
+
+	t.Run("KeySetDoubleSigned", func(t *testing.T) {
+		response := query(t, "example.info", utils.TypeDNSKEY)
+		tags := signers(response, utils.TypeDNSKEY)
+		if len(response.Answers)-len(tags) != 4 || !tags[oldKSK.KeyTag()] || !tags[newKSK.KeyTag()] || len(tags) != 2 {
+			t.Errorf("Expected 4 DNSKEYs signed by both KSKs, got %v", response.Answers)
+		}
+	})
+
+	t.Run("PublishedZSKNotSigning", func(t *testing.T) {
+		tags := signers(query(t, "www.example.info", utils.TypeA), utils.TypeA)
+		if len(tags) != 1 || !tags[activeZSK.KeyTag()] || tags[publishedZSK.KeyTag()] {
+			t.Errorf("Expected a signature by the active ZSK only, got %v", tags)
+		}
+	})
+
+	t.Run("CDSOfActiveKSK", func(t *testing.T) {
+		response := query(t, "example.info", utils.TypeCDS)
+		var cds []utils.DS
+		for _, answer := range response.Answers {
+			if answer.Type == utils.TypeCDS {
+				ds, _ := utils.ParseDS(answer.RData)
+				cds = append(cds, ds)
+			}
+		}
+		if len(cds) != 1 || cds[0].KeyTag != newKSK.KeyTag() {
+			t.Errorf("Expected the CDS of the new KSK only, got %+v", cds)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/utils"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source prefix lengths of the client subnets sent upstream (RFC 7871 section 11.1)
+const (
+	ecsIPv4PrefixLength = 24
+	ecsIPv6PrefixLength = 56
+)
+
+// Bounds of the forwarding cache
+const (
+	maxCachedResponses = 10000
+	maxCacheTTL        = time.Hour
+)
+
+// upstreamSubnet returns the client subnet to send upstream for a request:
+// the one of the query truncated so as not to disclose more than needed, or
+// else, when derive is set, the network of a client with a public address.
+// Nil means no client subnet is sent.
+func upstreamSubnet(req *request, derive bool) *utils.ClientSubnet {
+	if req.subnet != nil {
+		prefix := req.subnet.Prefix
+		if bits := ecsPrefixLength(prefix.Addr()); prefix.Bits() > bits {
+			prefix = netip.PrefixFrom(prefix.Addr(), bits).Masked()
+		}
+		return &utils.ClientSubnet{Prefix: prefix}
+	}
+	if !derive {
+		return nil
+	}
+	ip, known := clientIP(req.addr)
+	if !known || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return nil
+	}
+	prefix, _ := ip.Prefix(ecsPrefixLength(ip))
+	return &utils.ClientSubnet{Prefix: prefix}
+}
+
+func ecsPrefixLength(ip netip.Addr) int {
+	if ip.Is4() {
+		return ecsIPv4PrefixLength
+	}
+	return ecsIPv6PrefixLength
+}
+
+// cachedResponse is an upstream response valid for the clients of a network
+type cachedResponse struct {
+	network  netip.Prefix // Subnet sent upstream truncated to the scope of the answer
+	response utils.DNSResponse
+	security client.Security
+	stored   time.Time
+	expires  time.Time
+}
+
+// forwardCache keeps the responses of the upstream resolver. Answers to
+// queries with a client subnet are kept per scope, so that clients of
+// different networks do not get each other's answers.
+type forwardCache struct {
+	mu      sync.Mutex
+	entries map[string][]cachedResponse
+	size    int
+}
+
+func newForwardCache() *forwardCache {
+	return &forwardCache{entries: map[string][]cachedResponse{}}
+}
+
+// forwardCacheKey identifies a question. Answers to queries sent without a
+// client subnet are kept apart, their scope says nothing about clients.
+func forwardCacheKey(question utils.DNSQuestion, subnet *utils.ClientSubnet) string {
+	key := strings.ToLower(strings.TrimSuffix(question.Name, ".")) + "/" + question.Type.String()
+	if subnet != nil {
+		key += "/ecs"
+	}
+	return key
+}
+
+// get returns the most specific response cached for the question and the
+// client subnet, with its TTLs lowered by the time it spent in the cache,
+// and its scope prefix length
+func (cache *forwardCache) get(question utils.DNSQuestion, subnet *utils.ClientSubnet, now time.Time) (utils.DNSResponse, client.Security, uint8, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entries := cache.entries[forwardCacheKey(question, subnet)]
+	best := -1
+	for i, entry := range entries {
+		if now.After(entry.expires) {
+			continue
+		}
+		if subnet != nil && entry.network.Bits() > 0 && !entry.network.Contains(subnet.Prefix.Addr()) {
+			continue
+		}
+		if best < 0 || entry.network.Bits() > entries[best].network.Bits() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return utils.DNSResponse{}, client.Insecure, 0, false
+	}
+	entry := entries[best]
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	response := entry.response
+	response.Answers = agedRecords(response.Answers, age)
+	response.Authority = agedRecords(response.Authority, age)
+	return response, entry.security, uint8(entry.network.Bits()), true
+}
+
+// put caches a response for the clients of subnet within the scope prefix
+// length returned upstream. Only answers and name errors with records to
+// take a TTL from are cached.
+func (cache *forwardCache) put(question utils.DNSQuestion, subnet *utils.ClientSubnet, scope uint8, response utils.DNSResponse, security client.Security, now time.Time) {
+	rcode := response.Header.Rcode()
+	if rcode != utils.RcodeSuccess && rcode != utils.RcodeNameError {
+		return
+	}
+	ttl, ok := minimumTTL(response)
+	if !ok || ttl == 0 {
+		return
+	}
+	var network netip.Prefix
+	if subnet != nil {
+		network = netip.PrefixFrom(subnet.Prefix.Addr(), int(scope)).Masked()
+	}
+	entry := cachedResponse{network: network, response: response, security: security, stored: now, expires: now.Add(ttl)}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.size >= maxCachedResponses {
+		cache.sweep(now)
+	}
+	key := forwardCacheKey(question, subnet)
+	entries := cache.entries[key]
+	for i := range entries {
+		if entries[i].network == network {
+			entries[i] = entry
+			return
+		}
+	}
+	cache.entries[key] = append(entries, entry)
+	cache.size++
+}
+
+// sweep drops the expired responses, or all of them if the cache is still
+// full. The cache must be locked.
+func (cache *forwardCache) sweep(now time.Time) {
+	for key, entries := range cache.entries {
+		var kept []cachedResponse
+		for _, entry := range entries {
+			if !now.After(entry.expires) {
+				kept = append(kept, entry)
+			}
+		}
+		cache.size -= len(entries) - len(kept)
+		if len(kept) == 0 {
+			delete(cache.entries, key)
+		} else {
+			cache.entries[key] = kept
+		}
+	}
+	if cache.size >= maxCachedResponses {
+		cache.entries, cache.size = map[string][]cachedResponse{}, 0
+	}
+}
+
+// minimumTTL returns the lowest TTL of the records of a response, capped
+// to maxCacheTTL
+func minimumTTL(response utils.DNSResponse) (time.Duration, bool) {
+	ttl := maxCacheTTL
+	found := false
+	for _, section := range [][]utils.DNSAnswer{response.Answers, response.Authority} {
+		for _, rr := range section {
+			if d := time.Duration(rr.TTL) * time.Second; d < ttl {
+				ttl = d
+			}
+			found = true
+		}
+	}
+	return ttl, found
+}
+
+// agedRecords returns a copy of the records with their TTLs lowered by age
+func agedRecords(section []utils.DNSAnswer, age uint32) []utils.DNSAnswer {
+	aged := make([]utils.DNSAnswer, len(section))
+	for i, rr := range section {
+		if rr.TTL > age {
+			rr.TTL -= age
+		} else {
+			rr.TTL = 0
+		}
+		aged[i] = rr
+	}
+	return aged
+}
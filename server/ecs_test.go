@@ -0,0 +1,157 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ClientSubnet(t *testing.T) {
+	geoFile := filepath.Join(t.TempDir(), "geo.mmdb")
+	writeGeoDatabase(t, geoFile, map[string][2]string{
+		"198.51.100.0/24": {"DE", "EU"},
+		"203.0.113.0/24":  {"US", "NA"},
+	})
+	store := newMemStore()
+	zoneId := store.addZone("ecs.example")
+	store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: "cdn", Type: "A", Value: "192.0.2.10", TTL: 60,
+		Steering: &daos.RecordSteering{Policy: daos.SteeringGeo, Countries: []string{"DE"}}})
+	us, _ := store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: "cdn", Type: "A", Value: "192.0.2.20", TTL: 60,
+		Steering: &daos.RecordSteering{Policy: daos.SteeringGeo, Countries: []string{"US"}}})
+	store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: "cdn", Type: "A", Value: "192.0.2.30", TTL: 60,
+		Steering: &daos.RecordSteering{Policy: daos.SteeringGeo}})
+	store.addRecord(zoneId, "static", "A", "192.0.2.40")
+
+	authoritative, err := NewDNSServer("127.0.0.1:8066")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := authoritative.SetGeoDatabase(geoFile); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	authoritative.SetZoneStore(store)
+	authoritative.Start()
+	defer authoritative.Stop()
+
+	forwarding, err := NewDNSServer("127.0.0.1:8067")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := forwarding.SetForwarder("127.0.0.1:8066"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	forwarding.Start()
+	defer forwarding.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	query := func(t *testing.T, address string, name string, subnet string) (string, *utils.ClientSubnet) {
+		t.Helper()
+		dnsClient, err := client.NewDNSClient(address)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		ecs := utils.ClientSubnet{Prefix: netip.MustParsePrefix(subnet).Masked()}
+		response, err := dnsClient.SendQuery(name, utils.TypeA, ecs.ToOption())
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(response.Answers) != 1 {
+			t.Fatalf("Expected a single answer, got %v", response.Answers)
+		}
+		edns := response.EDNS()
+		if edns == nil {
+			t.Fatalf("Expected an OPT record in the response")
+		}
+		echoed, err := edns.ClientSubnet()
+		if err != nil || echoed == nil {
+			t.Fatalf("Expected the client subnet to be echoed, got %v", err)
+		}
+		if echoed.Prefix != ecs.Prefix {
+			t.Errorf("Expected the client subnet %v to be echoed, got %v", ecs.Prefix, echoed.Prefix)
+		}
+		return response.Answers[0].Value(), echoed
+	}
+
+	t.Run("AuthoritativeScope", func(t *testing.T) {
+		value, echoed := query(t, "127.0.0.1:8066", "cdn.ecs.example", "198.51.100.0/24")
+		if value != "192.0.2.10" || echoed.ScopeLength != 24 {
+			t.Errorf("Expected the DE record scoped to /24, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+		value, echoed = query(t, "127.0.0.1:8066", "static.ecs.example", "198.51.100.0/24")
+		if value != "192.0.2.40" || echoed.ScopeLength != 0 {
+			t.Errorf("Expected a global answer, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+	})
+
+	t.Run("ForwardedPerSubnet", func(t *testing.T) {
+		value, echoed := query(t, "127.0.0.1:8067", "cdn.ecs.example", "198.51.100.0/24")
+		if value != "192.0.2.10" || echoed.ScopeLength != 24 {
+			t.Errorf("Expected the DE record scoped to /24, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+		value, echoed = query(t, "127.0.0.1:8067", "cdn.ecs.example", "203.0.113.0/24")
+		if value != "192.0.2.20" || echoed.ScopeLength != 24 {
+			t.Errorf("Expected the US record scoped to /24, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+	})
+
+	t.Run("TruncatedUpstream", func(t *testing.T) {
+		// A /32 subnet is sent upstream as /24, the scope echoed never exceeds the source
+		value, echoed := query(t, "127.0.0.1:8067", "cdn.ecs.example", "203.0.113.77/32")
+		if value != "192.0.2.20" || echoed.ScopeLength != 24 {
+			t.Errorf("Expected the US record scoped to /24, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+		value, echoed = query(t, "127.0.0.1:8067", "cdn.ecs.example", "203.0.0.0/16")
+		if value != "192.0.2.30" || echoed.ScopeLength != 16 {
+			t.Errorf("Expected the fallback record scoped to /16, got %s scoped to /%d", value, echoed.ScopeLength)
+		}
+	})
+
+	t.Run("CachedPerScope", func(t *testing.T) {
+		store.DeleteRecord(daos.Actor{}, us.ID)
+		value, _ := query(t, "127.0.0.1:8067", "cdn.ecs.example", "203.0.113.128/25")
+		if value != "192.0.2.20" {
+			t.Errorf("Expected the cached US record, got %s", value)
+		}
+		value, _ = query(t, "127.0.0.1:8067", "cdn.ecs.example", "198.51.100.1/32")
+		if value != "192.0.2.10" {
+			t.Errorf("Expected the cached DE record, got %s", value)
+		}
+	})
+
+	t.Run("MalformedOption", func(t *testing.T) {
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8066")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		// Address bits set beyond the source prefix length
+		option := utils.EDNSOption{Code: utils.EDNSOptionClientSubnet, Data: []byte{0, 1, 7, 0, 199}}
+		response, err := dnsClient.SendQuery("cdn.ecs.example", utils.TypeA, option)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeFormatError {
+			t.Errorf("Expected FORMERR, got rcode %d", response.Header.Rcode())
+		}
+	})
+}
+
+func Test_UpstreamSubnet(t *testing.T) {
+	req := &request{addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.77"), Port: 53}}
+	if subnet := upstreamSubnet(req, false); subnet != nil {
+		t.Errorf("Expected no client subnet unless enabled, got %v", subnet.Prefix)
+	}
+	if subnet := upstreamSubnet(req, true); subnet == nil || subnet.Prefix != netip.MustParsePrefix("203.0.113.0/24") {
+		t.Errorf("Expected the network of the client, got %v", subnet)
+	}
+	req.subnet = &utils.ClientSubnet{Prefix: netip.MustParsePrefix("198.51.100.77/32")}
+	if subnet := upstreamSubnet(req, false); subnet == nil || subnet.Prefix != netip.MustParsePrefix("198.51.100.0/24") {
+		t.Errorf("Expected the truncated subnet of the query, got %v", subnet)
+	}
+}
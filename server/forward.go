@@ -0,0 +1,135 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/utils"
+	"fmt"
+	"time"
+)
+
+// forwarder sends the queries the server is not authoritative for to an
+// upstream resolver, validating its answers when trust anchors are configured
+type forwarder struct {
+	client   *client.DNSClient
+	validate bool
+	cache    *forwardCache
+	// deriveSubnets sends the networks of clients whose queries carry no
+	// client subnet upstream
+	deriveSubnets bool
+}
+
+// SetForwarder makes the server forward queries for names outside its zones
+// to the resolver at address. With trust anchors, answers are validated:
+// secure ones get the AD bit and bogus ones are answered with SERVFAIL.
+func (server *DNSServer) SetForwarder(address string, anchors ...client.TrustAnchor) error {
+	dnsClient, err := client.NewDNSClient(address)
+	if err != nil {
+		return err
+	}
+	dnsClient.EnableCookies()
+	if len(anchors) > 0 {
+		dnsClient.EnableDNSSEC(anchors...)
+	}
+	server.forwarder = &forwarder{client: dnsClient, validate: len(anchors) > 0, cache: newForwardCache()}
+	return nil
+}
+
+// DeriveClientSubnets makes the forwarder send the network of clients with
+// a public address upstream when their queries carry no client subnet. This
+// discloses where clients are to the upstream resolver, so it is off unless
+// enabled. It must be called after SetForwarder and before Start.
+func (server *DNSServer) DeriveClientSubnets(enabled bool) error {
+	if server.forwarder == nil {
+		return fmt.Errorf("no forwarder is set")
+	}
+	server.forwarder.deriveSubnets = enabled
+	return nil
+}
+
+// forward answers a query with the response of the upstream resolver,
+// from the cache when possible
+func (server *DNSServer) forward(req *request) utils.DNSResponse {
+	question := req.packet.Questions[0]
+	fwd := server.forwarder
+	subnet := upstreamSubnet(req, fwd.deriveSubnets)
+
+	upstream, security, scope, cached := fwd.cache.get(question, subnet, time.Now())
+	if !cached {
+		var err error
+		upstream, security, scope, err = fwd.query(question, subnet)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return newResponse(req, utils.RcodeServerFailure)
+		}
+		fwd.cache.put(question, subnet, scope, upstream, security, time.Now())
+	}
+	if req.subnet != nil {
+		req.scope = min(scope, uint8(req.subnet.Prefix.Bits()))
+	}
+
+	checkingDisabled := req.packet.Header.Flags&utils.FlagCD != 0
+	if security == client.Bogus && !checkingDisabled {
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	response := newResponse(req, upstream.Header.Rcode())
+	response.Header.Flags |= utils.FlagRA | req.packet.Header.Flags&utils.FlagCD
+	// Clients asking for DNSSEC or authenticated data learn the answer was validated (RFC 6840 section 5.7)
+	if security == client.Secure && (req.dnssecOK() || req.packet.Header.Flags&utils.FlagAD != 0) {
+		response.Header.Flags |= utils.FlagAD
+	}
+	response.Answers = withoutDNSSEC(req, upstream.Answers)
+	response.Authority = withoutDNSSEC(req, upstream.Authority)
+	return response
+}
+
+// query sends a question upstream along with the client subnet, if any, and
+// returns the response with the scope prefix length it is valid for
+func (fwd *forwarder) query(question utils.DNSQuestion, subnet *utils.ClientSubnet) (utils.DNSResponse, client.Security, uint8, error) {
+	var options []utils.EDNSOption
+	if subnet != nil {
+		options = append(options, subnet.ToOption())
+	}
+
+	var upstream utils.DNSResponse
+	security := client.Insecure
+	var err error
+	if fwd.validate {
+		upstream, security, err = fwd.client.ValidatedQuery(question.Name, question.Type, options...)
+	} else {
+		upstream, err = fwd.client.SendQuery(question.Name, question.Type, options...)
+	}
+	if err != nil {
+		return upstream, security, 0, err
+	}
+
+	// Responses without a client subnet are valid for all clients (RFC 7871 section 7.3.1)
+	var scope uint8
+	if subnet != nil {
+		if edns := upstream.EDNS(); edns != nil {
+			if echoed, err := edns.ClientSubnet(); err == nil && echoed != nil && echoed.Prefix == subnet.Prefix {
+				scope = min(echoed.ScopeLength, uint8(subnet.Prefix.Bits()))
+			}
+		}
+	}
+	return upstream, security, scope, nil
+}
+
+// withoutDNSSEC strips the DNSSEC records of a section for clients that did
+// not ask for them, unless they queried them explicitly
+func withoutDNSSEC(req *request, section []utils.DNSAnswer) []utils.DNSAnswer {
+	if req.dnssecOK() {
+		return section
+	}
+	qtype := req.packet.Questions[0].Type
+	var kept []utils.DNSAnswer
+	for _, rr := range section {
+		switch rr.Type {
+		case utils.TypeRRSIG, utils.TypeNSEC, utils.TypeNSEC3:
+			if rr.Type != qtype {
+				continue
+			}
+		}
+		kept = append(kept, rr)
+	}
+	return kept
+}
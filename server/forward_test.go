@@ -0,0 +1,252 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+// signedHierarchy hosts a fake hierarchy under the "test" zone: a secure
+// child signed with NSEC, one signed with NSEC3, an insecure delegation and
+// a child whose DS does not match its keys
+func signedHierarchy() (*memStore, client.TrustAnchor) {
+	store := newMemStore()
+	parent := store.addZone("test")
+	store.signZone(parent, utils.AlgorithmECDSAP256SHA256)
+	for _, child := range []string{"secure", "nsec3", "insecure", "bogus"} {
+		zoneId := store.addZone(child + ".test")
+		store.addRecord(zoneId, "www", "A", "192.0.2.50")
+		store.addRecord(parent, child, "NS", "ns1."+child+".test")
+		switch child {
+		case "insecure":
+			continue
+		case "nsec3":
+			store.useNSEC3(zoneId, daos.NSEC3Params{Salt: "beef", Iterations: 1})
+		}
+		store.signZone(zoneId, utils.AlgorithmED25519)
+		ds := store.dnssecKeys[zoneId][0].DS(child + ".test")
+		if child == "bogus" {
+			other, _ := utils.GenerateSigningKey(utils.AlgorithmED25519, utils.DNSKEYFlagZone|utils.DNSKEYFlagSEP)
+			ds = other.DS(child + ".test")
+		}
+		store.addRecord(parent, child, "DS", ds.String())
+	}
+	anchor := client.TrustAnchor{Zone: "test", DS: store.dnssecKeys[parent][0].DS("test")}
+	return store, anchor
+}
+
+func Test_DNSSECValidation(t *testing.T) {
+	store, anchor := signedHierarchy()
+	authoritative, err := NewDNSServer("127.0.0.1:8057")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	authoritative.SetZoneStore(store)
+	authoritative.Start()
+	defer authoritative.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8057")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+	dnsClient.EnableDNSSEC(anchor)
+
+	tests := []struct {
+		name     string
+		qtype    utils.DNSRecordType
+		security client.Security
+	}{
+		{"www.secure.test", utils.TypeA, client.Secure},
+		{"missing.secure.test", utils.TypeA, client.Secure},
+		{"www.secure.test", utils.TypeAAAA, client.Secure},
+		{"secure.test", utils.TypeDS, client.Secure},
+		{"www.nsec3.test", utils.TypeA, client.Secure},
+		{"missing.nsec3.test", utils.TypeA, client.Secure},
+		{"www.nsec3.test", utils.TypeTXT, client.Secure},
+		{"www.insecure.test", utils.TypeA, client.Insecure},
+		{"missing.test", utils.TypeA, client.Secure},
+		{"www.bogus.test", utils.TypeA, client.Bogus},
+	}
+	for _, test := range tests {
+		t.Run(test.name+"/"+test.qtype.String(), func(t *testing.T) {
+			_, security, err := dnsClient.ValidatedQuery(test.name, test.qtype)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if security != test.security {
+				t.Errorf("Expected %s, got %s", test.security, security)
+			}
+		})
+	}
+
+	t.Run("WithoutTrustAnchor", func(t *testing.T) {
+		other, err := client.NewDNSClient("127.0.0.1:8057")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer other.Close()
+		other.EnableDNSSEC(client.TrustAnchor{Zone: "example", DS: anchor.DS})
+		if _, security, err := other.ValidatedQuery("www.secure.test", utils.TypeA); err != nil || security != client.Insecure {
+			t.Errorf("Expected insecure, got %s (%v)", security, err)
+		}
+	})
+
+	t.Run("WrongTrustAnchor", func(t *testing.T) {
+		other, err := client.NewDNSClient("127.0.0.1:8057")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer other.Close()
+		wrong := anchor
+		wrong.DS.Digest = append([]byte{^anchor.DS.Digest[0]}, anchor.DS.Digest[1:]...)
+		other.EnableDNSSEC(wrong)
+		if _, security, err := other.ValidatedQuery("www.secure.test", utils.TypeA); err != nil || security != client.Bogus {
+			t.Errorf("Expected bogus, got %s (%v)", security, err)
+		}
+	})
+}
+
+func Test_ValidatingForwarder(t *testing.T) {
+	store, anchor := signedHierarchy()
+	authoritative, err := NewDNSServer("127.0.0.1:8058")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	authoritative.SetZoneStore(store)
+	authoritative.Start()
+	defer authoritative.Stop()
+
+	forwarding, err := NewDNSServer("127.0.0.1:8059")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := forwarding.SetForwarder("127.0.0.1:8058", anchor); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	forwarding.Start()
+	defer forwarding.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8059")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+
+	query := func(t *testing.T, name string, flags uint16, do bool) utils.DNSResponse {
+		packet := utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 10, Flags: utils.FlagRD | flags},
+			Questions: []utils.DNSQuestion{{Name: name, Type: utils.TypeA, Class: utils.ClassIN}},
+		}
+		if do {
+			packet.Additional = []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, DO: true}.ToAnswer()}
+		}
+		response, err := dnsClient.Exchange(packet)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+	hasType := func(section []utils.DNSAnswer, rtype utils.DNSRecordType) bool {
+		for _, rr := range section {
+			if rr.Type == rtype {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("SecureWithDO", func(t *testing.T) {
+		response := query(t, "www.secure.test", 0, true)
+		if response.Header.Flags&utils.FlagAD == 0 || !hasType(response.Answers, utils.TypeA) || !hasType(response.Answers, utils.TypeRRSIG) {
+			t.Errorf("Expected a signed answer with the AD bit, got %v", response)
+		}
+	})
+
+	t.Run("SecureWithoutDO", func(t *testing.T) {
+		response := query(t, "www.secure.test", 0, false)
+		if response.Header.Flags&utils.FlagAD != 0 || !hasType(response.Answers, utils.TypeA) || hasType(response.Answers, utils.TypeRRSIG) {
+			t.Errorf("Expected a plain answer without the AD bit, got %v", response)
+		}
+		response = query(t, "www.secure.test", utils.FlagAD, false)
+		if response.Header.Flags&utils.FlagAD == 0 {
+			t.Errorf("Expected the AD bit when asked for, got %v", response)
+		}
+	})
+
+	t.Run("SecureNameError", func(t *testing.T) {
+		response := query(t, "missing.nsec3.test", 0, true)
+		if response.Header.Rcode() != utils.RcodeNameError || response.Header.Flags&utils.FlagAD == 0 {
+			t.Errorf("Expected an authenticated NXDOMAIN, got %v", response)
+		}
+	})
+
+	t.Run("Insecure", func(t *testing.T) {
+		response := query(t, "www.insecure.test", 0, true)
+		if response.Header.Flags&utils.FlagAD != 0 || !hasType(response.Answers, utils.TypeA) {
+			t.Errorf("Expected an answer without the AD bit, got %v", response)
+		}
+	})
+
+	t.Run("Bogus", func(t *testing.T) {
+		response := query(t, "www.bogus.test", 0, true)
+		if response.Header.Rcode() != utils.RcodeServerFailure || len(response.Answers) != 0 {
+			t.Errorf("Expected SERVFAIL, got %v", response)
+		}
+		response = query(t, "www.bogus.test", utils.FlagCD, true)
+		if response.Header.Rcode() != utils.RcodeSuccess || response.Header.Flags&utils.FlagAD != 0 || !hasType(response.Answers, utils.TypeA) {
+			t.Errorf("Expected the unvalidated answer with checking disabled, got %v", response)
+		}
+	})
+}
+
+func Test_ClientSkipsMismatchedResponses(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:8075")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer upstream.Close()
+	// A fake resolver sending a spoofed response, then one to another
+	// question, before the real answer
+	go func() {
+		buffer := make([]byte, 512)
+		n, addr, err := upstream.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		query, err := utils.ParseDNSPacket(buffer[:n])
+		if err != nil {
+			return
+		}
+		reply := func(id uint16, name string, value string) {
+			answer, _ := utils.NewDNSAnswer(name, utils.TypeA, 60, value)
+			response := utils.DNSResponse{
+				Header:    utils.DNSHeader{ID: id, Flags: utils.FlagQR, Qdcount: 1, Ancount: 1},
+				Questions: []utils.DNSQuestion{{Name: name, Type: utils.TypeA, Class: utils.ClassIN}},
+				Answers:   []utils.DNSAnswer{answer},
+			}
+			upstream.WriteTo(response.Serialize(), addr)
+		}
+		reply(query.Header.ID+1, "www.example.org", "192.0.2.66")
+		reply(query.Header.ID, "other.example.org", "192.0.2.67")
+		reply(query.Header.ID, "www.example.org", "192.0.2.1")
+	}()
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8075")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+	response, err := dnsClient.SendQuery("www.example.org", utils.TypeA)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(response.Answers) != 1 || response.Answers[0].Value() != "192.0.2.1" {
+		t.Errorf("Expected the answer to the query, got %+v", response.Answers)
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/tls"
+	"dnsServer/daos"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults of health checks
+const (
+	healthCheckInterval  = 30 * time.Second
+	healthCheckTimeout   = 5 * time.Second
+	healthFailures       = 3
+	healthRecoveries     = 1
+	healthTick           = time.Second      // Resolution of the check schedule
+	healthReloadInterval = 10 * time.Second // How often the checked records are reloaded
+	maxHealthTransitions = 20               // Transitions kept per record
+)
+
+// healthTable holds the health of records, records without a known state
+// being healthy
+type healthTable struct {
+	mu      sync.RWMutex
+	states  map[string]*daos.RecordHealth
+	stop    chan struct{}
+	checkMu sync.Mutex       // Serializes the check rounds
+	records []daos.DNSRecord // Records with a health check
+	loaded  time.Time
+	due     map[string]time.Time
+}
+
+func newHealthTable() *healthTable {
+	return &healthTable{states: map[string]*daos.RecordHealth{}, stop: make(chan struct{}), due: map[string]time.Time{}}
+}
+
+func (table *healthTable) isHealthy(recordId string) bool {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	state, known := table.states[recordId]
+	return !known || state.Healthy
+}
+
+// SetRecordHealth marks a record as healthy or not, until its health check,
+// if any, decides otherwise
+func (server *DNSServer) SetRecordHealth(recordId string, healthy bool) {
+	table := server.health
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	state := table.state(recordId)
+	if state.Healthy != healthy {
+		table.transition(state, healthy, "set manually", time.Now())
+	}
+}
+
+// RecordHealth returns the health of a record and the transitions it went
+// through. Records never checked nor marked are reported healthy.
+func (server *DNSServer) RecordHealth(recordId string) daos.RecordHealth {
+	table := server.health
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	state, known := table.states[recordId]
+	if !known {
+		return daos.RecordHealth{RecordID: recordId, Healthy: true, Transitions: []daos.HealthTransition{}}
+	}
+	health := *state
+	health.Transitions = append([]daos.HealthTransition{}, state.Transitions...)
+	return health
+}
+
+// state returns the state of a record, creating a healthy one if needed.
+// The table must be locked.
+func (table *healthTable) state(recordId string) *daos.RecordHealth {
+	state, known := table.states[recordId]
+	if !known {
+		state = &daos.RecordHealth{RecordID: recordId, Healthy: true}
+		table.states[recordId] = state
+	}
+	return state
+}
+
+// transition changes the health of a record. The table must be locked.
+func (table *healthTable) transition(state *daos.RecordHealth, healthy bool, reason string, now time.Time) {
+	state.Healthy = healthy
+	state.Transitions = append(state.Transitions, daos.HealthTransition{At: now, Healthy: healthy, Reason: reason})
+	if len(state.Transitions) > maxHealthTransitions {
+		state.Transitions = state.Transitions[len(state.Transitions)-maxHealthTransitions:]
+	}
+	status := "unhealthy"
+	if healthy {
+		status = "healthy"
+	}
+	fmt.Printf("Record %s is now %s: %s\n", state.RecordID, status, reason)
+}
+
+// runHealthChecks checks the records on their schedule until the server stops
+func (server *DNSServer) runHealthChecks() {
+	ticker := time.NewTicker(healthTick)
+	defer ticker.Stop()
+	for {
+		if err := server.checkHealth(time.Now()); err != nil {
+			fmt.Println("Error:", err)
+		}
+		select {
+		case <-server.health.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth runs the checks due at now and waits for their results
+func (server *DNSServer) checkHealth(now time.Time) error {
+	table := server.health
+	table.checkMu.Lock()
+	defer table.checkMu.Unlock()
+	if now.Sub(table.loaded) >= healthReloadInterval {
+		records, err := server.store.GetHealthChecks()
+		if err != nil {
+			return err
+		}
+		table.forget(records)
+		table.records, table.loaded = records, now
+	}
+
+	var wg sync.WaitGroup
+	for _, record := range table.records {
+		check := withCheckDefaults(*record.HealthCheck)
+		if due, ok := table.due[record.ID]; ok && now.Before(due) {
+			continue
+		}
+		table.due[record.ID] = now.Add(time.Duration(check.IntervalSeconds) * time.Second)
+		wg.Add(1)
+		go func(record daos.DNSRecord) {
+			defer wg.Done()
+			err := probe(check, record.Value)
+			table.record(record.ID, check, err, now)
+		}(record)
+	}
+	wg.Wait()
+	return nil
+}
+
+// forget drops the state of the records whose health check was removed,
+// so that they are served again
+func (table *healthTable) forget(records []daos.DNSRecord) {
+	checked := map[string]bool{}
+	for _, record := range records {
+		checked[record.ID] = true
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, record := range table.records {
+		if !checked[record.ID] {
+			delete(table.states, record.ID)
+			delete(table.due, record.ID)
+		}
+	}
+}
+
+// record updates the health of a record with the result of a check
+func (table *healthTable) record(recordId string, check daos.HealthCheck, err error, now time.Time) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	state := table.state(recordId)
+	state.Check = &check
+	state.LastCheck = &now
+	if err != nil {
+		state.LastError = err.Error()
+		state.ConsecutiveFailures++
+		state.ConsecutiveSuccesses = 0
+		if state.Healthy && state.ConsecutiveFailures >= check.FailureThreshold {
+			table.transition(state, false, fmt.Sprintf("%d failed checks: %v", state.ConsecutiveFailures, err), now)
+		}
+		return
+	}
+	state.LastError = ""
+	state.ConsecutiveSuccesses++
+	state.ConsecutiveFailures = 0
+	if !state.Healthy && state.ConsecutiveSuccesses >= check.RecoveryThreshold {
+		table.transition(state, true, fmt.Sprintf("%d passed checks", state.ConsecutiveSuccesses), now)
+	}
+}
+
+// withCheckDefaults fills the settings left out of a health check
+func withCheckDefaults(check daos.HealthCheck) daos.HealthCheck {
+	if check.Port == 0 {
+		check.Port = 80
+		if check.Type == daos.HealthCheckHTTPS {
+			check.Port = 443
+		}
+	}
+	if check.Path == "" {
+		check.Path = "/"
+	}
+	if check.ExpectedStatus == 0 {
+		check.ExpectedStatus = http.StatusOK
+	}
+	if check.IntervalSeconds == 0 {
+		check.IntervalSeconds = int(healthCheckInterval / time.Second)
+	}
+	if check.TimeoutSeconds == 0 {
+		check.TimeoutSeconds = int(healthCheckTimeout / time.Second)
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = healthFailures
+	}
+	if check.RecoveryThreshold == 0 {
+		check.RecoveryThreshold = healthRecoveries
+	}
+	return check
+}
+
+// probe runs a health check against the address of a record
+func probe(check daos.HealthCheck, address string) error {
+	target := net.JoinHostPort(address, strconv.Itoa(check.Port))
+	timeout := time.Duration(check.TimeoutSeconds) * time.Second
+	switch check.Type {
+	case daos.HealthCheckTCP, daos.HealthCheckPing:
+		conn, err := net.DialTimeout("tcp", target, timeout)
+		if err == nil {
+			return conn.Close()
+		}
+		if check.Type == daos.HealthCheckPing && errors.Is(err, syscall.ECONNREFUSED) {
+			// A reset proves the host is up
+			return nil
+		}
+		return err
+	case daos.HealthCheckHTTP, daos.HealthCheckHTTPS:
+		scheme := "http"
+		if check.Type == daos.HealthCheckHTTPS {
+			scheme = "https"
+		}
+		request, err := http.NewRequest(http.MethodGet, scheme+"://"+target+check.Path, nil)
+		if err != nil {
+			return err
+		}
+		if check.Host != "" {
+			request.Host = check.Host
+		}
+		client := &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{ServerName: check.Host}, DisableKeepAlives: true},
+			// A redirect is a response of its own
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode != check.ExpectedStatus {
+			return fmt.Errorf("status %d, expected %d", response.StatusCode, check.ExpectedStatus)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown health check type %q", check.Type)
+}
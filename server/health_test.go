@@ -0,0 +1,137 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_HealthChecks(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer web.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	server, err := NewDNSServer("127.0.0.1:8065")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	store := newMemStore()
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	zoneId := store.addZone("health.example")
+	addChecked := func(name string, check daos.HealthCheck) string {
+		check.IntervalSeconds, check.FailureThreshold = 1, 2
+		record, _ := store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: name, Type: "A", Value: "127.0.0.1", TTL: 60, HealthCheck: &check})
+		return record.ID
+	}
+	webId := addChecked("web", daos.HealthCheck{Type: daos.HealthCheckHTTP, Port: web.Listener.Addr().(*net.TCPAddr).Port, Path: "/health"})
+	store.addRecord(zoneId, "web", "A", "192.0.2.99")
+	tcpId := addChecked("tcp", daos.HealthCheck{Type: daos.HealthCheckTCP, Port: listener.Addr().(*net.TCPAddr).Port})
+	pingId := addChecked("ping", daos.HealthCheck{Type: daos.HealthCheckPing, Port: closedPort})
+	downId := addChecked("down", daos.HealthCheck{Type: daos.HealthCheckTCP, Port: closedPort})
+
+	// Rounds are run ahead of the clock so the background checker stays out of the way
+	start := time.Now().Add(time.Hour)
+	round := 0
+	check := func(t *testing.T) {
+		t.Helper()
+		if err := server.checkHealth(start.Add(time.Duration(round) * time.Second)); err != nil {
+			t.Fatalf("Health check failed: %v", err)
+		}
+		round++
+	}
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8065")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+	lookup := func(t *testing.T) []string {
+		t.Helper()
+		response, err := dnsClient.SendQuery("web.health.example", utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		var values []string
+		for _, answer := range response.Answers {
+			values = append(values, answer.Value())
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	t.Run("Probes", func(t *testing.T) {
+		check(t)
+		check(t)
+		for id, healthy := range map[string]bool{webId: true, tcpId: true, pingId: true, downId: false} {
+			health := server.RecordHealth(id)
+			if health.Healthy != healthy || health.LastCheck == nil {
+				t.Errorf("Expected record %s to be checked and healthy %v, got %+v", id, healthy, health)
+			}
+		}
+		if health := server.RecordHealth(downId); health.ConsecutiveFailures != 2 || len(health.Transitions) != 1 {
+			t.Errorf("Expected a transition after 2 failures, got %+v", health)
+		}
+	})
+
+	t.Run("Failing", func(t *testing.T) {
+		status.Store(http.StatusServiceUnavailable)
+		check(t)
+		if health := server.RecordHealth(webId); !health.Healthy || health.ConsecutiveFailures != 1 {
+			t.Errorf("Expected the record to stay healthy after a single failure, got %+v", health)
+		}
+		check(t)
+		if health := server.RecordHealth(webId); health.Healthy || len(health.Transitions) != 1 {
+			t.Errorf("Expected the record to be unhealthy, got %+v", health)
+		}
+		if values := lookup(t); len(values) != 1 || values[0] != "192.0.2.99" {
+			t.Errorf("Expected the unhealthy record to be excluded, got %v", values)
+		}
+	})
+
+	t.Run("Recovering", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		check(t)
+		health := server.RecordHealth(webId)
+		if !health.Healthy || len(health.Transitions) != 2 || !health.Transitions[1].Healthy {
+			t.Errorf("Expected the record to recover, got %+v", health)
+		}
+		if values := lookup(t); len(values) != 2 {
+			t.Errorf("Expected both records, got %v", values)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Listeners the server receives queries on, each with its own options
+const (
+	ListenerUDP   = "udp"
+	ListenerTCP   = "tcp"
+	ListenerTLS   = "tls"   // DNS over TLS
+	ListenerHTTPS = "https" // DNS over HTTPS
+)
+
+// paddingBlockSize is the block length encrypted responses are padded to by
+// default (RFC 8467 section 4.1)
+const paddingBlockSize = 468
+
+// listenerOptions holds the options of every listener
+type listenerOptions struct {
+	mu      sync.RWMutex
+	options map[string]daos.ListenerOptions
+}
+
+// newListenerOptions pads the responses sent over encrypted transports, so
+// their length tells less about their content
+func newListenerOptions() *listenerOptions {
+	return &listenerOptions{options: map[string]daos.ListenerOptions{
+		ListenerUDP:   {},
+		ListenerTCP:   {},
+		ListenerTLS:   {PaddingBlockSize: paddingBlockSize},
+		ListenerHTTPS: {PaddingBlockSize: paddingBlockSize},
+	}}
+}
+
+// SetListenerOptions configures the responses sent on a listener, or on
+// all of them when listener is empty
+func (server *DNSServer) SetListenerOptions(listener string, options daos.ListenerOptions) error {
+	if options.PaddingBlockSize < 0 || options.PaddingBlockSize > 65535 {
+		return fmt.Errorf("invalid padding block size %d", options.PaddingBlockSize)
+	}
+	server.listeners.mu.Lock()
+	defer server.listeners.mu.Unlock()
+	if listener == "" {
+		for name := range server.listeners.options {
+			server.listeners.options[name] = options
+		}
+		return nil
+	}
+	if _, ok := server.listeners.options[listener]; !ok {
+		return fmt.Errorf("unknown listener %q", listener)
+	}
+	server.listeners.options[listener] = options
+	return nil
+}
+
+// ListenerOptions returns the options of every listener, by name
+func (server *DNSServer) ListenerOptions() map[string]daos.ListenerOptions {
+	server.listeners.mu.RLock()
+	defer server.listeners.mu.RUnlock()
+	options := map[string]daos.ListenerOptions{}
+	for name, o := range server.listeners.options {
+		options[name] = o
+	}
+	return options
+}
+
+func (server *DNSServer) optionsFor(listener string) daos.ListenerOptions {
+	server.listeners.mu.RLock()
+	defer server.listeners.mu.RUnlock()
+	return server.listeners.options[listener]
+}
+
+// pad serializes a response with a Padding option (RFC 7830) bringing its
+// length to a multiple of block, unless that would exceed limit. The
+// response must have an OPT record, responseBytes is its serialized form.
+func pad(response utils.DNSResponse, responseBytes []byte, block int, limit int) []byte {
+	padding := (block - (len(responseBytes)+4)%block) % block
+	if len(responseBytes)+4+padding > limit {
+		return responseBytes
+	}
+	additional := append([]utils.DNSAnswer(nil), response.Additional...)
+	for i, answer := range additional {
+		if answer.Type != utils.TypeOPT {
+			continue
+		}
+		option := make([]byte, 4+padding)
+		binary.BigEndian.PutUint16(option[0:2], utils.EDNSOptionPadding)
+		binary.BigEndian.PutUint16(option[2:4], uint16(padding))
+		additional[i].RData = append(append([]byte(nil), answer.RData...), option...)
+		response.Additional = additional
+		return response.Serialize()
+	}
+	return responseBytes
+}
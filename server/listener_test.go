@@ -0,0 +1,133 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"net"
+	"testing"
+)
+
+func Test_ListenerOptions(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("privacy.example")
+	store.addRecord(zoneId, "@", "NS", "ns1.privacy.example")
+	store.addRecord(zoneId, "ns1", "A", "192.0.2.53")
+	store.addRecord(zoneId, "@", "MX", "10 mail.privacy.example")
+	store.addRecord(zoneId, "mail", "A", "192.0.2.25")
+	store.addRecord(zoneId, "mail", "AAAA", "2001:db8::25")
+
+	server, err := NewDNSServer("127.0.0.1:8069")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer server.conn.Close()
+	defer server.tcpListener.Close()
+	server.SetZoneStore(store)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	query := func(qtype utils.DNSRecordType, edns bool) []byte {
+		packet := utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 12},
+			Questions: []utils.DNSQuestion{{Name: "privacy.example", Type: qtype, Class: utils.ClassIN}},
+		}
+		if edns {
+			packet.Additional = []utils.DNSAnswer{utils.EDNS{UDPSize: 4096}.ToAnswer()}
+		}
+		return packet.Serialize()
+	}
+	exchange := func(t *testing.T, listener string, message []byte) ([]byte, utils.DNSResponse) {
+		t.Helper()
+		responseBytes := server.handleMessage(message, addr, listener)[0]
+		response, err := utils.ParseDNSResponse(responseBytes)
+		if err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		return responseBytes, response
+	}
+	paddingOf := func(response utils.DNSResponse) ([]byte, bool) {
+		if edns := response.EDNS(); edns != nil {
+			return edns.Option(utils.EDNSOptionPadding)
+		}
+		return nil, false
+	}
+
+	t.Run("PaddedOverEncryptedTransports", func(t *testing.T) {
+		for _, listener := range []string{ListenerTLS, ListenerHTTPS} {
+			for _, qtype := range []utils.DNSRecordType{utils.TypeMX, utils.TypeA, utils.TypeTXT} {
+				responseBytes, response := exchange(t, listener, query(qtype, true))
+				if len(responseBytes)%paddingBlockSize != 0 {
+					t.Errorf("Expected the %s response over %s padded to a multiple of %d, got %d bytes", qtype, listener, paddingBlockSize, len(responseBytes))
+				}
+				if padding, ok := paddingOf(response); !ok || len(padding) == 0 {
+					t.Errorf("Expected a padding option in the %s response over %s", qtype, listener)
+				}
+			}
+		}
+	})
+
+	t.Run("NotPaddedInClear", func(t *testing.T) {
+		for _, listener := range []string{ListenerUDP, ListenerTCP} {
+			if _, response := exchange(t, listener, query(utils.TypeMX, true)); response.EDNS() == nil {
+				t.Fatalf("Expected an OPT record over %s", listener)
+			} else if _, ok := paddingOf(response); ok {
+				t.Errorf("Expected no padding over %s", listener)
+			}
+		}
+	})
+
+	t.Run("NotPaddedWithoutEDNS", func(t *testing.T) {
+		if _, response := exchange(t, ListenerTLS, query(utils.TypeMX, false)); response.EDNS() != nil {
+			t.Errorf("Expected no OPT record in the response to a query without EDNS")
+		}
+	})
+
+	t.Run("CustomBlockSize", func(t *testing.T) {
+		if err := server.SetListenerOptions(ListenerUDP, daos.ListenerOptions{PaddingBlockSize: 128}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer server.SetListenerOptions(ListenerUDP, daos.ListenerOptions{})
+		if responseBytes, _ := exchange(t, ListenerUDP, query(utils.TypeMX, true)); len(responseBytes)%128 != 0 {
+			t.Errorf("Expected the response padded to a multiple of 128, got %d bytes", len(responseBytes))
+		}
+		if err := server.SetListenerOptions("quic", daos.ListenerOptions{}); err == nil {
+			t.Errorf("Expected an error for an unknown listener")
+		}
+	})
+
+	t.Run("MinimalResponses", func(t *testing.T) {
+		fullBytes, full := exchange(t, ListenerTCP, query(utils.TypeMX, false))
+		if len(full.Authority) != 1 || full.Authority[0].Type != utils.TypeNS {
+			t.Errorf("Expected the name servers in the authority section, got %v", full.Authority)
+		}
+		if len(full.Additional) != 3 {
+			t.Errorf("Expected the addresses of the mail exchanger and name server in the additional section, got %v", full.Additional)
+		}
+
+		if err := server.SetListenerOptions(ListenerTCP, daos.ListenerOptions{MinimalResponses: true}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer server.SetListenerOptions(ListenerTCP, daos.ListenerOptions{})
+		minimalBytes, minimal := exchange(t, ListenerTCP, query(utils.TypeMX, false))
+		if len(minimal.Answers) != 1 || len(minimal.Authority) != 0 || len(minimal.Additional) != 0 {
+			t.Errorf("Expected only the answer, got %v", minimal)
+		}
+		if len(minimalBytes) >= len(fullBytes) {
+			t.Errorf("Expected a shorter minimal response, got %d bytes against %d", len(minimalBytes), len(fullBytes))
+		}
+		// Other listeners keep full responses
+		if _, udp := exchange(t, ListenerUDP, query(utils.TypeMX, false)); len(udp.Additional) != 3 {
+			t.Errorf("Expected a full response over UDP, got %v", udp.Additional)
+		}
+	})
+
+	t.Run("ServerWide", func(t *testing.T) {
+		if err := server.SetListenerOptions("", daos.ListenerOptions{MinimalResponses: true}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		for name, options := range server.ListenerOptions() {
+			if !options.MinimalResponses || options.PaddingBlockSize != 0 {
+				t.Errorf("Expected minimal responses without padding on %s, got %+v", name, options)
+			}
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Defaults of response rate limiting. Clients are grouped by netblock, as
+// an attacker spoofing a victim's address can not be told apart from its
+// neighbours anyway.
+const (
+	rrlIPv4PrefixLength = 24
+	rrlIPv6PrefixLength = 56
+	rrlBucketExpiry     = time.Minute // Idle buckets are forgotten after this long
+)
+
+// rrlAction is what to do with a response checked against the rate limit
+type rrlAction int
+
+const (
+	rrlSend rrlAction = iota
+	rrlDrop
+	rrlSlip // Send it truncated, so legitimate clients retry over TCP
+)
+
+// rrlBucket holds the tokens of a client netblock for one kind of response
+type rrlBucket struct {
+	tokens  float64
+	last    time.Time
+	limited int // Responses limited since the bucket last had tokens
+}
+
+// rateLimiter limits the rate of identical UDP responses sent to a client
+// netblock with token buckets, so the server is useless as an amplifier
+type rateLimiter struct {
+	mu        sync.Mutex
+	config    daos.RRLConfig
+	buckets   map[string]*rrlBucket
+	swept     time.Time
+	now       func() time.Time
+	responses uint64
+	limited   uint64
+	dropped   uint64
+	slipped   uint64
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*rrlBucket{}, now: time.Now}
+}
+
+// SetRateLimit configures response rate limiting of UDP responses. Prefix
+// lengths left at 0 default to /24 for IPv4 and /56 for IPv6 clients.
+func (server *DNSServer) SetRateLimit(config daos.RRLConfig) error {
+	if config.IPv4PrefixLength == 0 {
+		config.IPv4PrefixLength = rrlIPv4PrefixLength
+	}
+	if config.IPv6PrefixLength == 0 {
+		config.IPv6PrefixLength = rrlIPv6PrefixLength
+	}
+	switch {
+	case config.ResponsesPerSecond < 0:
+		return fmt.Errorf("responses per second must not be negative")
+	case config.Slip < 0:
+		return fmt.Errorf("slip must not be negative")
+	case config.IPv4PrefixLength < 0 || config.IPv4PrefixLength > 32:
+		return fmt.Errorf("invalid IPv4 prefix length %d", config.IPv4PrefixLength)
+	case config.IPv6PrefixLength < 0 || config.IPv6PrefixLength > 128:
+		return fmt.Errorf("invalid IPv6 prefix length %d", config.IPv6PrefixLength)
+	}
+
+	rrl := server.rrl
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+	rrl.config = config
+	rrl.buckets = map[string]*rrlBucket{}
+	return nil
+}
+
+// RateLimitStats returns the rate limiting configuration and counters
+func (server *DNSServer) RateLimitStats() daos.RRLStats {
+	rrl := server.rrl
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+	return daos.RRLStats{
+		Config:    rrl.config,
+		Responses: rrl.responses,
+		Limited:   rrl.limited,
+		Dropped:   rrl.dropped,
+		Slipped:   rrl.slipped,
+		Buckets:   len(rrl.buckets),
+	}
+}
+
+// check takes a token for the response from the bucket of the client and
+// tells what to do with the response when there is none left
+func (rrl *rateLimiter) check(addr net.Addr, response utils.DNSResponse) rrlAction {
+	rrl.mu.Lock()
+	defer rrl.mu.Unlock()
+	config := rrl.config
+	if config.ResponsesPerSecond == 0 {
+		return rrlSend
+	}
+	ip, known := clientIP(addr)
+	if !known {
+		return rrlSend
+	}
+	prefixLength := config.IPv6PrefixLength
+	if ip.Is4() {
+		prefixLength = config.IPv4PrefixLength
+	}
+	netblock, _ := ip.Prefix(prefixLength)
+
+	now := rrl.now()
+	rrl.sweep(now)
+	rrl.responses++
+	key := netblock.String() + " " + responseKey(response)
+	bucket, ok := rrl.buckets[key]
+	rate := float64(config.ResponsesPerSecond)
+	if !ok {
+		bucket = &rrlBucket{tokens: rate, last: now}
+		rrl.buckets[key] = bucket
+	}
+	bucket.tokens = min(rate, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limited = 0
+		return rrlSend
+	}
+
+	rrl.limited++
+	bucket.limited++
+	if bucket.limited == 1 {
+		// Log once per stretch of limited responses
+		if config.LogOnly {
+			fmt.Printf("RRL: would limit responses to %s\n", key)
+		} else {
+			fmt.Printf("RRL: limiting responses to %s\n", key)
+		}
+	}
+	if config.LogOnly {
+		return rrlSend
+	}
+	if config.Slip > 0 && bucket.limited%config.Slip == 0 {
+		rrl.slipped++
+		return rrlSlip
+	}
+	rrl.dropped++
+	return rrlDrop
+}
+
+// sweep forgets the buckets that stayed idle long enough to be full again
+func (rrl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rrl.swept) < rrlBucketExpiry {
+		return
+	}
+	rrl.swept = now
+	for key, bucket := range rrl.buckets {
+		if now.Sub(bucket.last) > rrlBucketExpiry {
+			delete(rrl.buckets, key)
+		}
+	}
+}
+
+// responseKey identifies identical responses. Negative answers are keyed by
+// zone so queries for random names share a bucket, errors only by rcode.
+func responseKey(response utils.DNSResponse) string {
+	rcode := response.Header.Rcode()
+	switch rcode {
+	case utils.RcodeSuccess:
+		if len(response.Questions) == 0 {
+			return "noerror"
+		}
+		question := response.Questions[0]
+		return fmt.Sprintf("%s %d", canonicalName(question.Name), question.Type)
+	case utils.RcodeNameError:
+		for _, record := range response.Authority {
+			if record.Type == utils.TypeSOA {
+				return canonicalName(record.Name) + " nxdomain"
+			}
+		}
+		return "nxdomain"
+	}
+	return fmt.Sprintf("rcode %d", rcode)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_RateLimiterBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rrl := newRateLimiter()
+	rrl.now = func() time.Time { return now }
+	server := &DNSServer{rrl: rrl}
+	if err := server.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 2, Slip: 2}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	answer := func(name string) utils.DNSResponse {
+		return utils.DNSResponse{Questions: []utils.DNSQuestion{{Name: name, Type: utils.TypeA, Class: utils.ClassIN}}}
+	}
+	clientAddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5300}
+	neighbour := &net.UDPAddr{IP: net.ParseIP("192.0.2.99"), Port: 5300}
+	other := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5300}
+
+	var actions []rrlAction
+	for i := 0; i < 5; i++ {
+		actions = append(actions, rrl.check(clientAddr, answer("www.example.org")))
+	}
+	expected := []rrlAction{rrlSend, rrlSend, rrlDrop, rrlSlip, rrlDrop}
+	for i := range expected {
+		if actions[i] != expected[i] {
+			t.Fatalf("Expected actions %v, got %v", expected, actions)
+		}
+	}
+
+	if action := rrl.check(neighbour, answer("www.example.org")); action == rrlSend {
+		t.Errorf("Expected the netblock to share the bucket, got action %d", action)
+	}
+	if action := rrl.check(clientAddr, answer("mail.example.org")); action != rrlSend {
+		t.Errorf("Expected another response to have its own bucket, got action %d", action)
+	}
+	if action := rrl.check(other, answer("www.example.org")); action != rrlSend {
+		t.Errorf("Expected another netblock to have its own bucket, got action %d", action)
+	}
+
+	nxdomain := func(name string) utils.DNSResponse {
+		response := answer(name)
+		response.Header.Flags = utils.RcodeNameError
+		response.Authority = []utils.DNSAnswer{utils.NewSOAAnswer("example.org", 300, "ns1.example.org", "admin.example.org", 1, 1, 1, 1, 1)}
+		return response
+	}
+	rrl.check(other, nxdomain("a.example.org"))
+	rrl.check(other, nxdomain("b.example.org"))
+	if action := rrl.check(other, nxdomain("c.example.org")); action == rrlSend {
+		t.Errorf("Expected negative answers of a zone to share a bucket")
+	}
+
+	now = now.Add(time.Second)
+	if action := rrl.check(clientAddr, answer("www.example.org")); action != rrlSend {
+		t.Errorf("Expected tokens back after a second, got action %d", action)
+	}
+
+	stats := server.RateLimitStats()
+	if stats.Responses != 12 || stats.Limited != 5 || stats.Dropped != 3 || stats.Slipped != 2 {
+		t.Errorf("Unexpected counters %+v", stats)
+	}
+	if stats.Config.IPv4PrefixLength != 24 || stats.Config.IPv6PrefixLength != 56 {
+		t.Errorf("Expected default prefix lengths, got %+v", stats.Config)
+	}
+}
+
+func Test_RateLimiting(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("example.org")
+	store.addRecord(zoneId, "www", "A", "192.0.2.1")
+
+	server, err := NewDNSServer("127.0.0.1:8062")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	query := func(t *testing.T, tcp bool) utils.DNSResponse {
+		t.Helper()
+		var dnsClient *client.DNSClient
+		var err error
+		if tcp {
+			dnsClient, err = client.NewTCPDNSClient("127.0.0.1:8062")
+		} else {
+			dnsClient, err = client.NewDNSClient("127.0.0.1:8062")
+		}
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		response, err := dnsClient.SendQuery("www.example.org", utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+
+	t.Run("Slip", func(t *testing.T) {
+		if err := server.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 1, Slip: 1}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		if response := query(t, false); response.Header.Flags&utils.FlagTC != 0 || len(response.Answers) != 1 {
+			t.Fatalf("Expected the first response to be sent in full")
+		}
+		response := query(t, false)
+		if response.Header.Flags&utils.FlagTC == 0 || len(response.Answers) != 0 {
+			t.Errorf("Expected a truncated response, got flags %x and %d answers", response.Header.Flags, len(response.Answers))
+		}
+		// Rate limiting does not apply to TCP, which can not be spoofed
+		if response := query(t, true); response.Header.Flags&utils.FlagTC != 0 || len(response.Answers) != 1 {
+			t.Errorf("Expected a full response over TCP")
+		}
+		stats := server.RateLimitStats()
+		if stats.Limited != 1 || stats.Slipped != 1 {
+			t.Errorf("Unexpected counters %+v", stats)
+		}
+	})
+
+	t.Run("LogOnly", func(t *testing.T) {
+		if err := server.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 1, LogOnly: true}); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if response := query(t, false); response.Header.Flags&utils.FlagTC != 0 || len(response.Answers) != 1 {
+				t.Fatalf("Expected full responses in log-only mode")
+			}
+		}
+		if stats := server.RateLimitStats(); stats.Limited < 3 || stats.Dropped != 0 {
+			t.Errorf("Expected limited responses to be counted only, got %+v", stats)
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		if err := server.SetRateLimit(daos.RRLConfig{ResponsesPerSecond: 5, IPv4PrefixLength: 33}); err == nil {
+			t.Errorf("Expected an invalid prefix length to be rejected")
+		}
+	})
+}
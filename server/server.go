@@ -1,16 +1,50 @@
 package server
 
 import (
+	"dnsServer/daos"
 	"dnsServer/utils"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"time"
 )
 
+// tcpIdleTimeout is how long an idle TCP connection is kept open
+const tcpIdleTimeout = 10 * time.Second
+
 type DNSServer struct {
-	addr       string
-	conn       *net.UDPConn
-	stopSignal chan struct{}
+	addr        string
+	conn        *net.UDPConn
+	tcpListener net.Listener
+	tlsListener net.Listener // DNS over TLS, nil unless ListenTLS was called
+	tlsIdle     time.Duration
+	stopSignal  chan struct{}
+	store       ZoneStore
+	cache       *storeCache // Reads of the store, nil when it does not report its changes
+	signatures  *signatureCache
+	forwarder   *forwarder // Resolves names outside the hosted zones, nil to not forward
+	rrl         *rateLimiter
+	geo         *geoDatabase // Locates clients for geo steering
+	health      *healthTable // Health of the records, from their health checks
+	cookies     *cookieJar
+	listeners   *listenerOptions
+}
+
+// request carries what the server learned about a message while handling it
+type request struct {
+	packet  utils.DNSPacket
+	addr    net.Addr
+	tsigKey *utils.TSIGKey // Key that signed the request, nil if unsigned
+	tsigMAC []byte
+	view    string              // ID of the view matching the client, empty for none
+	subnet  *utils.ClientSubnet // EDNS Client Subnet of the query, nil if none
+	scope   uint8               // Prefix length of the client subnet the answer depends on
+	cookie  *utils.Cookie       // DNS cookies of the query, nil if none
+	// cookieValid is set when the query had a server cookie of ours,
+	// proving the client owns its address
+	cookieValid bool
+	minimal     bool // Leave out the authority and additional data not needed
 }
 
 func NewDNSServer(address string) (*DNSServer, error) {
@@ -29,10 +63,33 @@ func NewDNSServer(address string) (*DNSServer, error) {
 		fmt.Println("Error:", err)
 		return nil, err
 	}
-	return &DNSServer{addr: address, conn: conn, stopSignal: stopSignal}, nil
+	// Zone transfers and large responses are served over TCP
+	tcpListener, err := net.Listen("tcp", address)
+	if err != nil {
+		fmt.Println("Error:", err)
+		conn.Close()
+		return nil, err
+	}
+	return &DNSServer{addr: address, conn: conn, tcpListener: tcpListener, stopSignal: stopSignal,
+		signatures: newSignatureCache(), tlsIdle: tlsIdleTimeout, rrl: newRateLimiter(),
+		geo: &geoDatabase{}, health: newHealthTable(), cookies: newCookieJar(),
+		listeners: newListenerOptions()}, nil
 
 }
 
+// SetZoneStore makes the server answer authoritatively for the zones in
+// store. Zones and ACLs are kept in memory when the store reports its
+// changes.
+func (server *DNSServer) SetZoneStore(store ZoneStore) {
+	server.store = store
+	server.cache = nil
+	if notifier, ok := store.(ChangeNotifier); ok {
+		cache := newStoreCache()
+		notifier.OnChange(cache.clear)
+		server.cache = cache
+	}
+}
+
 func (server *DNSServer) Start() {
 
 	fmt.Printf("DNS Server is listening on %s\n", server.addr)
@@ -41,7 +98,7 @@ func (server *DNSServer) Start() {
 			// Set a timeout for reading from the connection
 			server.conn.SetReadDeadline(time.Now().Add(time.Second))
 
-			buffer := make([]byte, 1024)
+			buffer := make([]byte, 65535)
 			n, addr, err := server.conn.ReadFromUDP(buffer)
 
 			// Check for timeout
@@ -51,6 +108,13 @@ func (server *DNSServer) Start() {
 				case <-server.stopSignal:
 					fmt.Println("Stopping DNS server")
 					server.conn.Close()
+					server.tcpListener.Close()
+					if server.tlsListener != nil {
+						server.tlsListener.Close()
+					}
+					if server.forwarder != nil {
+						server.forwarder.client.Close()
+					}
 					return
 				default:
 					// No stop signal, continue to the next iteration
@@ -65,27 +129,261 @@ func (server *DNSServer) Start() {
 			}
 
 			// Handle the packet
-			go handlePacket(server.conn, buffer[:n], addr)
+			go server.handlePacket(buffer[:n], addr)
 		}
 	}()
 
+	go server.serveTCP(server.tcpListener, ListenerTCP, tcpIdleTimeout)
+	if server.store != nil {
+		go server.runHealthChecks()
+	}
+	if server.tlsListener != nil {
+		fmt.Printf("DNS over TLS is listening on %s\n", server.tlsListener.Addr())
+		go server.serveTCP(server.tlsListener, ListenerTLS, server.tlsIdle)
+	}
 }
 
 func (server *DNSServer) Stop() {
 	server.stopSignal <- struct{}{}
+	close(server.health.stop)
 }
 
 // handlePacket processes the incoming packet and sends a response
-func handlePacket(conn *net.UDPConn, data []byte, addr *net.UDPAddr) {
-	//fmt.Printf("Received: %s from %s\n", string(data), addr)
-	request := utils.ParseDNSPacket(data)
+func (server *DNSServer) handlePacket(data []byte, addr *net.UDPAddr) {
+	for _, responseBytes := range server.handleMessage(data, addr, ListenerUDP) {
+		if _, err := server.conn.WriteToUDP(responseBytes, addr); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// serveTCP accepts connections until the listener is closed
+func (server *DNSServer) serveTCP(listener net.Listener, name string, idleTimeout time.Duration) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handleConn(conn, name, idleTimeout)
+	}
+}
+
+// handleConn serves length-prefixed messages on a TCP or TLS connection
+// until the client closes it or it stays idle for too long
+func (server *DNSServer) handleConn(conn net.Conn, listener string, idleTimeout time.Duration) {
+	defer conn.Close()
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		for _, responseBytes := range server.handleMessage(data, conn.RemoteAddr(), listener) {
+			message := make([]byte, 2, 2+len(responseBytes))
+			binary.BigEndian.PutUint16(message, uint16(len(responseBytes)))
+			if _, err := conn.Write(append(message, responseBytes...)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleMessage runs a DNS message received over a stream transport, such
+// as DNS over HTTPS, through the query pipeline and returns the response,
+// or nil if no response should be sent
+func (server *DNSServer) HandleMessage(data []byte, addr net.Addr) []byte {
+	// Responses are never split over HTTPS
+	if responses := server.handleMessage(data, addr, ListenerHTTPS); len(responses) > 0 {
+		return responses[0]
+	}
+	return nil
+}
+
+// handleMessage runs a raw DNS message through the query pipeline and
+// returns the serialized response, or nothing if no response should be sent.
+// Responses sent over UDP are truncated to the size the client accepts.
+// Zone transfers over TCP and TLS are split into several messages.
+func (server *DNSServer) handleMessage(data []byte, addr net.Addr, listener string) [][]byte {
+	udp := listener == ListenerUDP
+	options := server.optionsFor(listener)
+	packet, err := utils.ParseDNSPacket(data)
+	if err != nil {
+		if len(data) < utils.HEADER_SIZE {
+			return nil
+		}
+		response := utils.DNSResponse{Header: utils.DNSHeader{
+			ID:    packet.Header.ID,
+			Flags: utils.FlagQR | utils.RcodeFormatError,
+		}}
+		return [][]byte{response.Serialize()}
+	}
+	if packet.Header.Flags&utils.FlagQR != 0 {
+		// Never answer responses
+		return nil
+	}
+	req := &request{packet: packet, addr: addr, minimal: options.MinimalResponses}
+
+	if errorResponse := server.verifyTSIG(req, data); errorResponse != nil {
+		return [][]byte{errorResponse}
+	}
+	if req.view, err = server.selectView(req); err != nil {
+		fmt.Println("Error:", err)
+		return [][]byte{server.signResponse(req, newResponse(req, utils.RcodeServerFailure).Serialize())}
+	}
+
+	edns := packet.EDNS()
+	if edns != nil && edns.Version > 0 {
+		response := newResponse(req, utils.RcodeSuccess)
+		response.Additional = append(response.Additional, utils.EDNS{
+			UDPSize:       utils.DefaultEDNSUDPSize,
+			ExtendedRcode: utils.ExtendedRcodeBadVers,
+		}.ToAnswer())
+		return [][]byte{server.signResponse(req, response.Serialize())}
+	}
+	if edns != nil {
+		if req.subnet, err = edns.ClientSubnet(); err != nil {
+			fmt.Println("Error:", err)
+			return [][]byte{server.signResponse(req, newResponse(req, utils.RcodeFormatError).Serialize())}
+		}
+		if req.cookie, err = edns.Cookie(); err != nil {
+			fmt.Println("Error:", err)
+			return [][]byte{server.signResponse(req, newResponse(req, utils.RcodeFormatError).Serialize())}
+		}
+		if req.cookie != nil && len(req.cookie.Server) > 0 {
+			req.cookieValid = server.cookies.valid(req)
+			// Clients over TCP proved their address already
+			if !req.cookieValid && udp {
+				return [][]byte{server.signResponse(req, server.badCookie(req, edns).Serialize())}
+			}
+		}
+	}
+
+	var response utils.DNSResponse
+	switch packet.Header.Opcode() {
+	case utils.OpcodeQuery:
+		if isTransfer(packet) {
+			response = server.handleTransfer(req)
+		} else {
+			response = server.handleQuery(req)
+		}
+	case utils.OpcodeNotify:
+		response = server.handleNotify(req)
+	case utils.OpcodeUpdate:
+		response = server.handleUpdate(req)
+	default:
+		response = newResponse(req, utils.RcodeNotImplemented)
+	}
+
+	if edns != nil {
+		opt := utils.EDNS{UDPSize: utils.DefaultEDNSUDPSize, DO: edns.DO, Options: server.cookieOption(req)}
+		if req.subnet != nil {
+			// Echo the client subnet with the scope the answer is valid for (RFC 7871 section 7.2.1)
+			opt.Options = append(opt.Options, utils.ClientSubnet{Prefix: req.subnet.Prefix, ScopeLength: req.scope}.ToOption())
+		}
+		response.Additional = append(response.Additional, opt.ToAnswer())
+	}
+
+	if udp && !(req.cookieValid && server.CookieConfig().ExemptRRL) {
+		switch server.rrl.check(addr, response) {
+		case rrlDrop:
+			return nil
+		case rrlSlip:
+			response = truncate(response)
+		}
+	}
+
+	limit := 65535
+	if udp {
+		limit = maxUDPSize(edns)
+	}
+	responses := []utils.DNSResponse{response}
+	if (listener == ListenerTCP || listener == ListenerTLS) && isTransfer(packet) {
+		responses = splitTransfer(response)
+	}
+	messages := make([][]byte, len(responses))
+	for i, response := range responses {
+		responseBytes := response.Serialize()
+		if len(responseBytes) > limit {
+			response = truncate(response)
+			responseBytes = response.Serialize()
+		}
+		if options.PaddingBlockSize > 0 && edns != nil {
+			responseBytes = pad(response, responseBytes, options.PaddingBlockSize, limit)
+		}
+		messages[i] = responseBytes
+	}
+	return server.signResponses(req, messages)
+}
+
+// maxUDPSize returns the largest UDP response the client accepts
+func maxUDPSize(edns *utils.EDNS) int {
+	if edns == nil || edns.UDPSize < 512 {
+		return 512
+	}
+	return int(edns.UDPSize)
+}
 
-	//fmt.Printf("Received DNS query from %v\n", addr)
-	//fmt.Printf("Header: %+v\n", request.Header)
-	for i := 0; i < len(request.Questions); i++ {
-		fmt.Printf("Question: %+v\n", request.Questions[i])
+// truncate strips the records of a response that does not fit in a UDP
+// datagram and sets the TC flag, so the client retries over TCP
+func truncate(response utils.DNSResponse) utils.DNSResponse {
+	response.Header.Flags |= utils.FlagTC
+	response.Answers = nil
+	response.Authority = nil
+	var additional []utils.DNSAnswer
+	for _, answer := range response.Additional {
+		if answer.Type == utils.TypeOPT {
+			additional = append(additional, answer)
+		}
+	}
+	response.Additional = additional
+	return response
+}
 
+// newResponse creates an empty response to the request with the given rcode
+func newResponse(req *request, rcode uint16) utils.DNSResponse {
+	header := req.packet.Header
+	return utils.DNSResponse{
+		Header: utils.DNSHeader{
+			ID:    header.ID, // Use the same ID as the request
+			Flags: utils.FlagQR | header.Flags&(0xF<<11|utils.FlagRD) | rcode,
+		},
+		Questions: req.packet.Questions,
 	}
+}
+
+// handleQuery answers standard queries
+func (server *DNSServer) handleQuery(req *request) utils.DNSResponse {
+	request := req.packet
+	if len(request.Questions) == 1 && server.store != nil {
+		zone, err := server.loadZone(request.Questions[0].Name, req.view)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return newResponse(req, utils.RcodeServerFailure)
+		}
+		if zone != nil && request.Questions[0].Type == utils.TypeDS && zone.name == canonicalName(request.Questions[0].Name) {
+			// DS records of a zone are published by its parent
+			if parent, err := server.loadZone(parentName(zone.name), req.view); err == nil && parent != nil {
+				zone = parent
+			}
+		}
+		if zone != nil {
+			if allowed, err := server.allowedBy(req, daos.ACLScopeAuthoritative, zone.zone.ID); err != nil || !allowed {
+				return refuse(req, err)
+			}
+			return server.answerAuthoritative(req, zone)
+		}
+	}
+	if allowed, err := server.allowedBy(req, daos.ACLScopeRecursion, ""); err != nil || !allowed {
+		return refuse(req, err)
+	}
+	if len(request.Questions) == 1 && server.forwarder != nil {
+		return server.forward(req)
+	}
+
 	response := utils.DNSResponse{
 		Header: utils.DNSHeader{
 			ID:      request.Header.ID, // Use the same ID as the request
@@ -99,12 +397,7 @@ func handlePacket(conn *net.UDPConn, data []byte, addr *net.UDPAddr) {
 		answer := getAnswer(question)
 		response.Answers = append(response.Answers, answer)
 	}
-	fmt.Printf(response.ToString())
-	responseBytes := response.Serialize()
-	_, err := conn.WriteToUDP(responseBytes, addr)
-	if err != nil {
-		fmt.Println(err)
-	}
+	return response
 }
 
 func getAnswer(question utils.DNSQuestion) utils.DNSAnswer {
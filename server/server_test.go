@@ -2,8 +2,13 @@ package server
 
 import (
 	"dnsServer/client"
+	"dnsServer/daos"
 	"dnsServer/utils"
+	"fmt"
+	"github.com/google/uuid"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -95,3 +100,212 @@ func answersEqual(a, b utils.DNSAnswer) bool {
 		a.Addr.Equal(b.Addr) // Use .Equal for net.IP comparison
 	// Add more comparisons for other fields if necessary
 }
+
+// memStore is an in-memory ZoneStore for tests
+type memStore struct {
+	mu         sync.Mutex
+	zones      []daos.DNSZone
+	records    map[string][]daos.DNSRecord
+	keys       map[string]utils.TSIGKey
+	policies   map[string][]daos.TSIGPolicy
+	dnssecKeys map[string][]utils.SigningKey
+	acl        map[string][]daos.ACLRule // By zone, "" for the global rules
+	views      []daos.View
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		records:    map[string][]daos.DNSRecord{},
+		keys:       map[string]utils.TSIGKey{},
+		policies:   map[string][]daos.TSIGPolicy{},
+		dnssecKeys: map[string][]utils.SigningKey{},
+		acl:        map[string][]daos.ACLRule{},
+	}
+}
+
+func (ms *memStore) addZone(name string) string {
+	return ms.addViewZone(name, "")
+}
+
+// addViewZone adds a zone served only to the clients of a view
+func (ms *memStore) addViewZone(name string, viewId string) string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	id := uuid.NewString()
+	ms.zones = append(ms.zones, daos.DNSZone{ID: id, Name: name, ViewID: viewId, Serial: 1})
+	return id
+}
+
+// signZone enables DNSSEC on the zone with a new KSK and ZSK
+func (ms *memStore) signZone(zoneId string, algorithm uint8) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for i := range ms.zones {
+		if ms.zones[i].ID == zoneId {
+			ms.zones[i].DNSSECEnabled = true
+		}
+	}
+	for _, flags := range []uint16{utils.DNSKEYFlagZone | utils.DNSKEYFlagSEP, utils.DNSKEYFlagZone} {
+		key, err := utils.GenerateSigningKey(algorithm, flags)
+		if err != nil {
+			panic(err)
+		}
+		ms.dnssecKeys[zoneId] = append(ms.dnssecKeys[zoneId], *key)
+	}
+}
+
+// useNSEC3 makes the zone deny existence with NSEC3 instead of NSEC
+func (ms *memStore) useNSEC3(zoneId string, params daos.NSEC3Params) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for i := range ms.zones {
+		if ms.zones[i].ID == zoneId {
+			ms.zones[i].NSEC3 = &params
+		}
+	}
+}
+
+func (ms *memStore) addRecord(zoneId string, name string, rtype string, value string) {
+	ms.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: name, Type: rtype, Value: value, TTL: 300})
+}
+
+func (ms *memStore) FindZone(name string, viewId string) (*daos.DNSZone, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var best *daos.DNSZone
+	for i := range ms.zones {
+		zone := &ms.zones[i]
+		if zone.ViewID != "" && zone.ViewID != viewId || !isSubdomain(canonicalName(name), canonicalName(zone.Name)) {
+			continue
+		}
+		if best == nil || len(zone.Name) > len(best.Name) || len(zone.Name) == len(best.Name) && zone.ViewID != "" {
+			best = zone
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	zone := *best
+	return &zone, nil
+}
+
+func (ms *memStore) GetRecords(zoneId string) ([]daos.DNSRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]daos.DNSRecord(nil), ms.records[zoneId]...), nil
+}
+
+func (ms *memStore) CreateRecord(actor daos.Actor, zoneId string, create daos.DNSRecordCreate) (daos.DNSRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	record := daos.DNSRecord{ID: uuid.NewString(), Name: create.Name, Type: create.Type,
+		Value: create.Value, TTL: create.TTL, DNSZoneID: zoneId, Steering: create.Steering, HealthCheck: create.HealthCheck}
+	ms.records[zoneId] = append(ms.records[zoneId], record)
+	return record, nil
+}
+
+func (ms *memStore) DeleteRecord(actor daos.Actor, recordId string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for zoneId, records := range ms.records {
+		for i, record := range records {
+			if record.ID == recordId {
+				ms.records[zoneId] = append(records[:i:i], records[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateRecords applies the operations all or none, refusing a CNAME next to
+// other records like the database store does
+func (ms *memStore) UpdateRecords(actor daos.Actor, zoneId string, plan func(records []daos.DNSRecord) ([]daos.RecordOperation, error)) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	operations, err := plan(append([]daos.DNSRecord(nil), ms.records[zoneId]...))
+	if err != nil {
+		return err
+	}
+	records := append([]daos.DNSRecord(nil), ms.records[zoneId]...)
+	for _, operation := range operations {
+		switch operation.Action {
+		case daos.ChangeCreate:
+			create := operation.Record
+			records = append(records, daos.DNSRecord{ID: uuid.NewString(), Name: create.Name, Type: create.Type,
+				Value: create.Value, TTL: create.TTL, DNSZoneID: zoneId})
+		case daos.ChangeDelete:
+			for i, record := range records {
+				if record.ID == operation.ID {
+					records = append(records[:i:i], records[i+1:]...)
+					break
+				}
+			}
+		default:
+			return fmt.Errorf("unexpected action %s", operation.Action)
+		}
+	}
+	types := map[string]map[string]bool{}
+	for _, record := range records {
+		name := strings.ToLower(record.Name)
+		if types[name] == nil {
+			types[name] = map[string]bool{}
+		}
+		types[name][strings.ToUpper(record.Type)] = true
+	}
+	for name, found := range types {
+		if found["CNAME"] && len(found) > 1 {
+			return fmt.Errorf("CNAME at %s next to other records", name)
+		}
+	}
+	ms.records[zoneId] = records
+	return nil
+}
+
+func (ms *memStore) GetTSIGKey(name string) (*utils.TSIGKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	key, ok := ms.keys[canonicalName(name)]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (ms *memStore) GetTSIGPolicies(zoneId string) ([]daos.TSIGPolicy, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.policies[zoneId], nil
+}
+
+func (ms *memStore) GetDNSSECKeys(zoneId string) ([]utils.SigningKey, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.dnssecKeys[zoneId], nil
+}
+
+func (ms *memStore) GetACLRules(zoneId string) ([]daos.ACLRule, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.acl[zoneId], nil
+}
+
+func (ms *memStore) GetViews() ([]daos.View, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.views, nil
+}
+
+func (ms *memStore) GetHealthChecks() ([]daos.DNSRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var checked []daos.DNSRecord
+	for _, records := range ms.records {
+		for _, record := range records {
+			if record.HealthCheck != nil {
+				checked = append(checked, record)
+			}
+		}
+	}
+	return checked, nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// steering picks the records of a set to return to a client
+type steering func(records []daos.DNSRecord) []daos.DNSRecord
+
+// geoDatabase locates clients with a MaxMind format (mmdb) database
+type geoDatabase struct {
+	mu     sync.RWMutex
+	reader *maxminddb.Reader
+}
+
+// geoRecord holds the fields of a GeoIP2 or GeoLite2 country record used for steering
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// SetGeoDatabase loads the mmdb file used to locate clients for geo steering,
+// replacing the one loaded before
+func (server *DNSServer) SetGeoDatabase(path string) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	server.geo.mu.Lock()
+	previous := server.geo.reader
+	server.geo.reader = reader
+	server.geo.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// steeringFor returns the steering of the record sets answering req
+func (server *DNSServer) steeringFor(req *request) steering {
+	return func(records []daos.DNSRecord) []daos.DNSRecord {
+		return server.steer(req, records)
+	}
+}
+
+// steer applies the policy of a record set, records without one being all
+// returned unless their health checks fail
+func (server *DNSServer) steer(req *request, records []daos.DNSRecord) []daos.DNSRecord {
+	policy := ""
+	for _, record := range records {
+		if record.Steering != nil {
+			policy = record.Steering.Policy
+			break
+		}
+	}
+	switch policy {
+	case daos.SteeringWeighted:
+		return pickWeighted(server.healthyRecords(records))
+	case daos.SteeringGeo:
+		return server.pickGeo(req, server.healthyRecords(records))
+	case daos.SteeringFailover:
+		return server.pickFailover(records)
+	}
+	return server.healthyRecords(records)
+}
+
+// healthyRecords drops the unhealthy records of a set. A set with no
+// healthy record is left empty, answered with no data.
+func (server *DNSServer) healthyRecords(records []daos.DNSRecord) []daos.DNSRecord {
+	var healthy []daos.DNSRecord
+	for _, record := range records {
+		if server.health.isHealthy(record.ID) {
+			healthy = append(healthy, record)
+		}
+	}
+	return healthy
+}
+
+// pickWeighted returns one record picked at random, in proportion to its weight
+func pickWeighted(records []daos.DNSRecord) []daos.DNSRecord {
+	if len(records) == 0 {
+		return nil
+	}
+	total := 0
+	for _, record := range records {
+		total += weight(record)
+	}
+	if total == 0 {
+		return []daos.DNSRecord{records[rand.Intn(len(records))]}
+	}
+	n := rand.Intn(total)
+	for _, record := range records {
+		if n -= weight(record); n < 0 {
+			return []daos.DNSRecord{record}
+		}
+	}
+	return records[:1]
+}
+
+func weight(record daos.DNSRecord) int {
+	if record.Steering == nil {
+		return 0
+	}
+	return record.Steering.Weight
+}
+
+// pickFailover returns the healthy records with the lowest priority, or
+// none when no record is healthy
+func (server *DNSServer) pickFailover(records []daos.DNSRecord) []daos.DNSRecord {
+	sorted := append([]daos.DNSRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return priority(sorted[i]) < priority(sorted[j]) })
+	for i := 0; i < len(sorted); {
+		j := i
+		var healthy []daos.DNSRecord
+		for ; j < len(sorted) && priority(sorted[j]) == priority(sorted[i]); j++ {
+			if server.health.isHealthy(sorted[j].ID) {
+				healthy = append(healthy, sorted[j])
+			}
+		}
+		if len(healthy) > 0 {
+			return healthy
+		}
+		i = j
+	}
+	return nil
+}
+
+func priority(record daos.DNSRecord) int {
+	if record.Steering == nil {
+		return 0
+	}
+	return record.Steering.Priority
+}
+
+// pickGeo returns the records serving the country of the client, or else
+// its continent, or else the records without locations
+func (server *DNSServer) pickGeo(req *request, records []daos.DNSRecord) []daos.DNSRecord {
+	country, continent := server.locate(req)
+	var byCountry, byContinent, fallback []daos.DNSRecord
+	for _, record := range records {
+		if record.Steering == nil || len(record.Steering.Countries) == 0 && len(record.Steering.Continents) == 0 {
+			fallback = append(fallback, record)
+			continue
+		}
+		if country != "" && containsFold(record.Steering.Countries, country) {
+			byCountry = append(byCountry, record)
+		}
+		if continent != "" && containsFold(record.Steering.Continents, continent) {
+			byContinent = append(byContinent, record)
+		}
+	}
+	for _, picked := range [][]daos.DNSRecord{byCountry, byContinent, fallback} {
+		if len(picked) > 0 {
+			return picked
+		}
+	}
+	return records
+}
+
+// locate returns the country and continent codes of the client, from the
+// EDNS Client Subnet sent by its resolver or else from its own address.
+// Answers located by client subnet are scoped to it.
+func (server *DNSServer) locate(req *request) (string, string) {
+	server.geo.mu.RLock()
+	defer server.geo.mu.RUnlock()
+	if server.geo.reader == nil {
+		return "", ""
+	}
+	ip, known := clientIP(req.addr)
+	if req.subnet != nil && req.subnet.Prefix.Bits() > 0 {
+		ip, known = req.subnet.Prefix.Addr(), true
+		req.scope = uint8(req.subnet.Prefix.Bits())
+	}
+	if !known {
+		return "", ""
+	}
+	var record geoRecord
+	if err := server.geo.reader.Lookup(net.IP(ip.AsSlice()), &record); err != nil {
+		fmt.Println("Error:", err)
+		return "", ""
+	}
+	return record.Country.ISOCode, record.Continent.Code
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_Steering(t *testing.T) {
+	geoFile := filepath.Join(t.TempDir(), "geo.mmdb")
+	writeGeoDatabase(t, geoFile, map[string][2]string{
+		"198.51.100.0/24": {"DE", "EU"},
+		"203.0.113.0/24":  {"US", "NA"},
+		"127.0.0.0/8":     {"JP", "AS"},
+	})
+
+	store := newMemStore()
+	zoneId := store.addZone("steer.example")
+	addSteered := func(name string, value string, steering daos.RecordSteering) string {
+		record, _ := store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: name, Type: "A", Value: value, TTL: 60, Steering: &steering})
+		return record.ID
+	}
+	addSteered("geo", "192.0.2.10", daos.RecordSteering{Policy: daos.SteeringGeo, Countries: []string{"DE"}})
+	addSteered("geo", "192.0.2.20", daos.RecordSteering{Policy: daos.SteeringGeo, Continents: []string{"NA"}})
+	addSteered("geo", "192.0.2.30", daos.RecordSteering{Policy: daos.SteeringGeo})
+	addSteered("weighted", "192.0.2.40", daos.RecordSteering{Policy: daos.SteeringWeighted, Weight: 1})
+	addSteered("weighted", "192.0.2.41", daos.RecordSteering{Policy: daos.SteeringWeighted, Weight: 1})
+	primary := addSteered("failover", "192.0.2.50", daos.RecordSteering{Policy: daos.SteeringFailover, Priority: 1})
+	backup := addSteered("failover", "192.0.2.51", daos.RecordSteering{Policy: daos.SteeringFailover, Priority: 2})
+	store.addRecord(zoneId, "plain", "A", "192.0.2.60")
+	store.addRecord(zoneId, "plain", "A", "192.0.2.61")
+	store.addRecord(zoneId, "alias", "CNAME", "failover.steer.example")
+	store.addRecord(zoneId, "steer.example.", "MX", "10 failover.steer.example")
+
+	server, err := NewDNSServer("127.0.0.1:8064")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := server.SetGeoDatabase(geoFile); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8064")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+
+	lookup := func(t *testing.T, name string, subnet string) []string {
+		t.Helper()
+		packet := utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 9},
+			Questions: []utils.DNSQuestion{{Name: name, Type: utils.TypeA, Class: utils.ClassIN}},
+		}
+		if subnet != "" {
+			ecs := utils.ClientSubnet{Prefix: netip.MustParsePrefix(subnet)}
+			packet.Additional = []utils.DNSAnswer{utils.EDNS{UDPSize: 4096, Options: []utils.EDNSOption{ecs.ToOption()}}.ToAnswer()}
+		}
+		response, err := dnsClient.Exchange(packet)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		var values []string
+		for _, answer := range response.Answers {
+			values = append(values, answer.Value())
+		}
+		sort.Strings(values)
+		return values
+	}
+	expect := func(t *testing.T, values []string, expected ...string) {
+		t.Helper()
+		if len(values) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, values)
+		}
+		for i := range expected {
+			if values[i] != expected[i] {
+				t.Fatalf("Expected %v, got %v", expected, values)
+			}
+		}
+	}
+
+	t.Run("GeoByClientSubnet", func(t *testing.T) {
+		expect(t, lookup(t, "geo.steer.example", "198.51.100.0/24"), "192.0.2.10")
+		expect(t, lookup(t, "geo.steer.example", "203.0.113.0/24"), "192.0.2.20")
+	})
+
+	t.Run("GeoByClientAddress", func(t *testing.T) {
+		// 127.0.0.1 is located in Japan, served by no record in particular
+		expect(t, lookup(t, "geo.steer.example", ""), "192.0.2.30")
+	})
+
+	t.Run("Weighted", func(t *testing.T) {
+		if values := lookup(t, "weighted.steer.example", ""); len(values) != 1 {
+			t.Errorf("Expected a single record, got %v", values)
+		}
+	})
+
+	t.Run("Failover", func(t *testing.T) {
+		expect(t, lookup(t, "failover.steer.example", ""), "192.0.2.50")
+		server.SetRecordHealth(primary, false)
+		expect(t, lookup(t, "failover.steer.example", ""), "192.0.2.51")
+		server.SetRecordHealth(backup, false)
+		// With every record down the name has no data rather than a dead address
+		expect(t, lookup(t, "failover.steer.example", ""))
+		server.SetRecordHealth(primary, true)
+		server.SetRecordHealth(backup, true)
+	})
+
+	t.Run("UnhealthyTargets", func(t *testing.T) {
+		defer server.SetRecordHealth(primary, true)
+		server.SetRecordHealth(primary, false)
+		expect(t, lookup(t, "alias.steer.example", ""), "192.0.2.51", "failover.steer.example")
+		response, err := dnsClient.SendQuery("steer.example", utils.TypeMX)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		var addresses []string
+		for _, rr := range response.Additional {
+			if rr.Type == utils.TypeA {
+				addresses = append(addresses, rr.Value())
+			}
+		}
+		if len(addresses) != 1 || addresses[0] != "192.0.2.51" {
+			t.Errorf("Expected only the healthy address in the additional data, got %v", addresses)
+		}
+	})
+
+	t.Run("WithoutPolicy", func(t *testing.T) {
+		expect(t, lookup(t, "plain.steer.example", "198.51.100.0/24"), "192.0.2.60", "192.0.2.61")
+	})
+}
+
+func Test_PickWeighted(t *testing.T) {
+	records := []daos.DNSRecord{
+		{ID: "heavy", Steering: &daos.RecordSteering{Policy: daos.SteeringWeighted, Weight: 3}},
+		{ID: "light", Steering: &daos.RecordSteering{Policy: daos.SteeringWeighted, Weight: 1}},
+		{ID: "off", Steering: &daos.RecordSteering{Policy: daos.SteeringWeighted}},
+	}
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		picked := pickWeighted(records)
+		if len(picked) != 1 {
+			t.Fatalf("Expected a single record, got %d", len(picked))
+		}
+		counts[picked[0].ID]++
+	}
+	if counts["off"] != 0 || counts["heavy"] < 2700 || counts["heavy"] > 3300 {
+		t.Errorf("Expected picks in proportion to the weights, got %v", counts)
+	}
+}
+
+// writeGeoDatabase writes a MaxMind format database of IPv4 networks and
+// their country and continent codes
+func writeGeoDatabase(t *testing.T, path string, networks map[string][2]string) {
+	type node [2]int // Child node, -1 for no data or -2-n for the nth data entry
+	nodes := []node{{-1, -1}}
+	data := new(bytes.Buffer)
+	var offsets []int
+
+	var prefixes []string
+	for prefix := range networks {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, p := range prefixes {
+		prefix := netip.MustParsePrefix(p)
+		offsets = append(offsets, data.Len())
+		location := networks[p]
+		mmdbEncode(data, map[string]any{
+			"country":   map[string]any{"iso_code": location[0]},
+			"continent": map[string]any{"code": location[1]},
+		})
+		address := prefix.Addr().As4()
+		current := 0
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := int(address[i/8]>>(7-i%8)) & 1
+			if i == prefix.Bits()-1 {
+				nodes[current][bit] = -2 - (len(offsets) - 1)
+				break
+			}
+			if nodes[current][bit] < 0 {
+				nodes = append(nodes, node{-1, -1})
+				nodes[current][bit] = len(nodes) - 1
+			}
+			current = nodes[current][bit]
+		}
+	}
+
+	file := new(bytes.Buffer)
+	for _, n := range nodes {
+		for _, child := range n {
+			value := child
+			switch {
+			case child == -1:
+				value = len(nodes)
+			case child < -1:
+				value = len(nodes) + 16 + offsets[-2-child]
+			}
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	mmdbEncode(file, map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               "Test-Country",
+		"description":                 map[string]any{"en": "Test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []any{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	})
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// mmdbEncode writes a value in the MaxMind DB data section format
+func mmdbEncode(buffer *bytes.Buffer, value any) {
+	control := func(kind int, size int) {
+		if kind < 8 {
+			buffer.WriteByte(byte(kind<<5 | size))
+		} else {
+			buffer.Write([]byte{byte(size), byte(kind - 7)})
+		}
+	}
+	unsigned := func(kind int, n uint64) {
+		raw := make([]byte, 8)
+		binary.BigEndian.PutUint64(raw, n)
+		raw = bytes.TrimLeft(raw, "\x00")
+		control(kind, len(raw))
+		buffer.Write(raw)
+	}
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		buffer.WriteString(v)
+	case map[string]any:
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		control(7, len(keys))
+		for _, key := range keys {
+			mmdbEncode(buffer, key)
+			mmdbEncode(buffer, v[key])
+		}
+	case []any:
+		control(11, len(v))
+		for _, element := range v {
+			mmdbEncode(buffer, element)
+		}
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case uint64:
+		unsigned(9, v)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Bounds of the store cache. Entries are dropped on every change the store
+// reports, and also expire so that a change committed while an entry was
+// being read is picked up soon anyway.
+const (
+	storeCacheTTL       = 5 * time.Second
+	maxCachedStoreReads = 10000
+)
+
+// ChangeNotifier is implemented by zone stores that report changes to their
+// zones, records, DNSSEC keys, ACLs, views and TSIG keys and policies,
+// letting the server keep what it read from them in memory
+type ChangeNotifier interface {
+	// OnChange registers a function called after each change
+	OnChange(changed func())
+}
+
+type cachedRead struct {
+	value   any
+	expires time.Time
+}
+
+// storeCache keeps the zone snapshots, ACL rules, views and TSIG keys and
+// policies read from the store so queries do not read them again
+type storeCache struct {
+	mu         sync.Mutex
+	entries    map[string]cachedRead
+	generation uint64 // Incremented on each change
+}
+
+func newStoreCache() *storeCache {
+	return &storeCache{entries: map[string]cachedRead{}}
+}
+
+// read returns the value cached under key, or else loads and caches it. A
+// nil cache always loads.
+func (cache *storeCache) read(key string, load func() (any, error)) (any, error) {
+	if cache == nil {
+		return load()
+	}
+	now := time.Now()
+	cache.mu.Lock()
+	entry, ok := cache.entries[key]
+	generation := cache.generation
+	cache.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.generation != generation {
+		// The store changed while loading, the value may be outdated
+		return value, nil
+	}
+	if len(cache.entries) >= maxCachedStoreReads {
+		for k, e := range cache.entries {
+			if !now.Before(e.expires) {
+				delete(cache.entries, k)
+			}
+		}
+		if len(cache.entries) >= maxCachedStoreReads {
+			cache.entries = map[string]cachedRead{}
+		}
+	}
+	cache.entries[key] = cachedRead{value: value, expires: now.Add(storeCacheTTL)}
+	return value, nil
+}
+
+// clear drops everything cached, after a change to the store
+func (cache *storeCache) clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = map[string]cachedRead{}
+	cache.generation++
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// notifyingStore is a memStore reporting its changes, counting its reads
+type notifyingStore struct {
+	*memStore
+	reads   atomic.Int32
+	mu      sync.Mutex
+	changed []func()
+}
+
+func (store *notifyingStore) OnChange(changed func()) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.changed = append(store.changed, changed)
+}
+
+func (store *notifyingStore) notify() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, changed := range store.changed {
+		changed()
+	}
+}
+
+func (store *notifyingStore) GetRecords(zoneId string) ([]daos.DNSRecord, error) {
+	store.reads.Add(1)
+	return store.memStore.GetRecords(zoneId)
+}
+
+func (store *notifyingStore) GetACLRules(zoneId string) ([]daos.ACLRule, error) {
+	store.reads.Add(1)
+	return store.memStore.GetACLRules(zoneId)
+}
+
+func (store *notifyingStore) GetViews() ([]daos.View, error) {
+	store.reads.Add(1)
+	return store.memStore.GetViews()
+}
+
+func (store *notifyingStore) CreateRecord(actor daos.Actor, zoneId string, create daos.DNSRecordCreate) (daos.DNSRecord, error) {
+	defer store.notify()
+	return store.memStore.CreateRecord(actor, zoneId, create)
+}
+
+func Test_StoreCache(t *testing.T) {
+	store := &notifyingStore{memStore: newMemStore()}
+	zoneId := store.addZone("cached.example")
+	store.addRecord(zoneId, "www", "A", "192.0.2.1")
+
+	server, err := NewDNSServer("127.0.0.1:8076")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	dnsClient, err := client.NewDNSClient("127.0.0.1:8076")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer dnsClient.Close()
+	lookup := func(t *testing.T) []string {
+		t.Helper()
+		response, err := dnsClient.SendQuery("www.cached.example", utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		var values []string
+		for _, answer := range response.Answers {
+			values = append(values, answer.Value())
+		}
+		return values
+	}
+
+	t.Run("Cached", func(t *testing.T) {
+		lookup(t)
+		reads := store.reads.Load()
+		for i := 0; i < 5; i++ {
+			if values := lookup(t); len(values) != 1 {
+				t.Fatalf("Expected the record, got %v", values)
+			}
+		}
+		if store.reads.Load() != reads {
+			t.Errorf("Expected the zone, ACLs and views to be read once, got %d more reads", store.reads.Load()-reads)
+		}
+	})
+
+	t.Run("InvalidatedOnChange", func(t *testing.T) {
+		store.CreateRecord(daos.Actor{}, zoneId, daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.2", TTL: 300})
+		if values := lookup(t); len(values) != 2 {
+			t.Errorf("Expected the new record to be served at once, got %v", values)
+		}
+		store.memStore.mu.Lock()
+		store.acl[zoneId] = []daos.ACLRule{{ZoneID: zoneId, Scope: daos.ACLScopeAuthoritative, Action: daos.ACLDeny, CIDR: "127.0.0.0/8"}}
+		store.memStore.mu.Unlock()
+		store.notify()
+		response, err := dnsClient.SendQuery("www.cached.example", utils.TypeA)
+		if err != nil || response.Header.Rcode() != utils.RcodeRefused {
+			t.Errorf("Expected the new ACL rule to refuse the query, got %v (%v)", response, err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsIdleTimeout is how long an idle DNS over TLS connection is kept open.
+// Clients reuse these connections, so they are kept longer than plain TCP ones.
+const tlsIdleTimeout = 30 * time.Second
+
+// certificateLoader serves a certificate read from files, reloading it when
+// the files change so certificates can be renewed without a restart
+type certificateLoader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertificateLoader(certFile string, keyFile string) (*certificateLoader, error) {
+	loader := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	if _, err := loader.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// getCertificate returns the current certificate, reloading it if the files
+// were modified. A broken renewal keeps the previous certificate in use.
+func (loader *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	certInfo, certErr := os.Stat(loader.certFile)
+	keyInfo, keyErr := os.Stat(loader.keyFile)
+	if certErr != nil || keyErr != nil {
+		if loader.certificate != nil {
+			return loader.certificate, nil
+		}
+		return nil, fmt.Errorf("reading TLS certificate: %w", errors.Join(certErr, keyErr))
+	}
+	if loader.certificate != nil && certInfo.ModTime().Equal(loader.certModTime) && keyInfo.ModTime().Equal(loader.keyModTime) {
+		return loader.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(loader.certFile, loader.keyFile)
+	if err != nil {
+		if loader.certificate != nil {
+			fmt.Println("Error reloading TLS certificate:", err)
+			return loader.certificate, nil
+		}
+		return nil, err
+	}
+	loader.certificate = &certificate
+	loader.certModTime = certInfo.ModTime()
+	loader.keyModTime = keyInfo.ModTime()
+	return loader.certificate, nil
+}
+
+// ListenTLS adds a DNS over TLS listener (RFC 7858) on address, usually
+// port 853, serving the certificate and key found in the given PEM files.
+// It must be called before Start.
+func (server *DNSServer) ListenTLS(address string, certFile string, keyFile string) error {
+	loader, err := newCertificateLoader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server.tlsListener = tls.NewListener(listener, &tls.Config{
+		GetCertificate: loader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	})
+	return nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"dnsServer/client"
+	"dnsServer/utils"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its
+// key, and returns the pool trusting it
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) *x509.CertPool {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+
+	certificate, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return pool
+}
+
+func Test_DNSOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "dns.crt"), filepath.Join(dir, "dns.key")
+	pool := writeCertificate(t, certFile, keyFile, "first", time.Now().Add(-time.Minute))
+
+	server, err := NewDNSServer("127.0.0.1:8060")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := server.ListenTLS("127.0.0.1:8853", certFile, keyFile); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.tlsIdle = 500 * time.Millisecond
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("ConnectionReuse", func(t *testing.T) {
+		dnsClient, err := client.NewTLSDNSClient("127.0.0.1:8853", &tls.Config{RootCAs: pool})
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer dnsClient.Close()
+		for _, qtype := range []utils.DNSRecordType{utils.TypeA, utils.TypeAAAA, utils.TypeA} {
+			response, err := dnsClient.SendQuery("google.com", qtype)
+			if err != nil || len(response.Answers) != 1 || response.Answers[0].Type != qtype {
+				t.Fatalf("Expected an answer over the same connection, got %v (%v)", response, err)
+			}
+		}
+	})
+
+	t.Run("UntrustedCertificate", func(t *testing.T) {
+		if _, err := client.NewTLSDNSClient("127.0.0.1:8853", &tls.Config{RootCAs: x509.NewCertPool()}); err == nil {
+			t.Errorf("Expected the self-signed certificate to be rejected without its root")
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		dnsClient, err := client.NewTLSDNSClient("127.0.0.1:8853", &tls.Config{RootCAs: pool})
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer dnsClient.Close()
+		if _, err := dnsClient.SendQuery("google.com", utils.TypeA); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		time.Sleep(time.Second)
+		if _, err := dnsClient.SendQuery("google.com", utils.TypeA); err == nil {
+			t.Errorf("Expected the idle connection to be closed")
+		}
+	})
+
+	t.Run("CertificateReload", func(t *testing.T) {
+		pool := writeCertificate(t, certFile, keyFile, "second", time.Now())
+		conn, err := tls.Dial("tcp", "127.0.0.1:8853", &tls.Config{RootCAs: pool})
+		if err != nil {
+			t.Fatalf("Failed to connect with the renewed certificate: %v", err)
+		}
+		defer conn.Close()
+		if name := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "second" {
+			t.Errorf("Expected the renewed certificate, got %s", name)
+		}
+	})
+}
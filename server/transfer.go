@@ -0,0 +1,109 @@
+package server
+
+import (
+	"dnsServer/utils"
+	"fmt"
+)
+
+// transferMessageSize bounds the records of each message of a zone transfer
+const transferMessageSize = 16 * 1024
+
+// handleTransfer answers AXFR and IXFR requests with the full zone, framed
+// by its SOA record. IXFR is answered the same way, as RFC 1995 allows when
+// no incremental history is available.
+func (server *DNSServer) handleTransfer(req *request) utils.DNSResponse {
+	question := req.packet.Questions[0]
+	zone, rcode := server.zoneForApex(question.Name, req.view)
+	if zone == nil {
+		return newResponse(req, rcode)
+	}
+
+	policies, err := server.tsigPolicies(req, zone)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	if !canTransfer(policies) {
+		fmt.Printf("Refused transfer of %s to %v\n", zone.name, req.addr)
+		return newResponse(req, utils.RcodeRefused)
+	}
+
+	response := newResponse(req, utils.RcodeSuccess)
+	response.Header.Flags |= utils.FlagAA
+	soa := zone.soa()
+	response.Answers = append(response.Answers, soa)
+	response.Answers = append(response.Answers, zone.allAnswers()...)
+	response.Answers = append(response.Answers, soa)
+	return response
+}
+
+// isTransfer reports whether the message asks for a zone transfer
+func isTransfer(packet utils.DNSPacket) bool {
+	return packet.Header.Opcode() == utils.OpcodeQuery && len(packet.Questions) == 1 &&
+		(packet.Questions[0].Type == utils.TypeAXFR || packet.Questions[0].Type == utils.TypeIXFR)
+}
+
+// splitTransfer splits the answers of a zone transfer into messages of up to
+// transferMessageSize bytes of records, leaving room for the OPT and TSIG
+// records under the 64 KiB limit of TCP messages (RFC 5936 section 2.2)
+func splitTransfer(response utils.DNSResponse) []utils.DNSResponse {
+	var responses []utils.DNSResponse
+	message := response
+	message.Answers = nil
+	size := 0
+	for _, answer := range response.Answers {
+		answerSize := len(utils.DNSResponse{Answers: []utils.DNSAnswer{answer}}.Serialize()) - utils.HEADER_SIZE
+		if len(message.Answers) > 0 && size+answerSize > transferMessageSize {
+			responses = append(responses, message)
+			message.Answers = nil
+			size = 0
+		}
+		message.Answers = append(message.Answers, answer)
+		size += answerSize
+	}
+	return append(responses, message)
+}
+
+// handleNotify acknowledges NOTIFY messages for hosted zones from keys that
+// are allowed to transfer them
+func (server *DNSServer) handleNotify(req *request) utils.DNSResponse {
+	if len(req.packet.Questions) != 1 {
+		return newResponse(req, utils.RcodeFormatError)
+	}
+	zone, rcode := server.zoneForApex(req.packet.Questions[0].Name, req.view)
+	if zone == nil {
+		return newResponse(req, rcode)
+	}
+
+	policies, err := server.tsigPolicies(req, zone)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	if !canTransfer(policies) {
+		fmt.Printf("Refused NOTIFY for %s from %v\n", zone.name, req.addr)
+		return newResponse(req, utils.RcodeRefused)
+	}
+
+	fmt.Printf("Received NOTIFY for %s from %v\n", zone.name, req.addr)
+	response := newResponse(req, utils.RcodeSuccess)
+	response.Header.Flags |= utils.FlagAA
+	return response
+}
+
+// zoneForApex loads the hosted zone whose apex is name. When there is no such
+// zone it returns the rcode to answer with.
+func (server *DNSServer) zoneForApex(name string, viewId string) (*zoneData, uint16) {
+	if server.store == nil {
+		return nil, utils.RcodeNotAuth
+	}
+	zone, err := server.loadZone(name, viewId)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, utils.RcodeServerFailure
+	}
+	if zone == nil || zone.name != canonicalName(name) {
+		return nil, utils.RcodeNotAuth
+	}
+	return zone, utils.RcodeSuccess
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// verifyTSIG checks the signature of a signed request and records the key
+// on success. It returns the NOTAUTH response to send when verification fails.
+func (server *DNSServer) verifyTSIG(req *request, data []byte) []byte {
+	record, _, err := utils.ReadTSIG(data)
+	if err != nil || record == nil {
+		return nil
+	}
+
+	tsigError := utils.TSIGErrBadKey
+	var key *utils.TSIGKey
+	if server.store != nil {
+		key, err = server.tsigKey(record.KeyName)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return newResponse(req, utils.RcodeServerFailure).Serialize()
+		}
+	}
+	if key != nil {
+		_, err = utils.VerifyTSIG(data, *key, nil, time.Now())
+		if err == nil {
+			req.tsigKey = key
+			req.tsigMAC = record.MAC
+			// The TSIG record is not part of the request as far as handlers are concerned
+			req.packet.Additional = req.packet.Additional[:len(req.packet.Additional)-1]
+			return nil
+		}
+		var verifyError *utils.TSIGError
+		if !errors.As(err, &verifyError) {
+			return newResponse(req, utils.RcodeFormatError).Serialize()
+		}
+		tsigError = verifyError.Code
+	}
+
+	fmt.Printf("TSIG verification failed for key %s from %v: error %d\n", record.KeyName, req.addr, tsigError)
+	response := newResponse(req, utils.RcodeNotAuth)
+	if tsigError == utils.TSIGErrBadTime {
+		// The key is valid, so the error response can be signed with it
+		req.tsigKey = key
+		req.tsigMAC = record.MAC
+		return server.signResponse(req, response.Serialize())
+	}
+	return utils.AppendTSIGError(response.Serialize(), *record, tsigError, time.Now())
+}
+
+// tsigKey returns the TSIG key with the name, or nil if there is none,
+// through the store cache
+func (server *DNSServer) tsigKey(name string) (*utils.TSIGKey, error) {
+	key, err := server.cache.read("tsig-key/"+canonicalName(name), func() (any, error) {
+		return server.store.GetTSIGKey(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key.(*utils.TSIGKey), nil
+}
+
+// signResponse signs the response with the key that signed the request
+func (server *DNSServer) signResponse(req *request, response []byte) []byte {
+	if req.tsigKey == nil {
+		return response
+	}
+	signed, _, err := utils.SignTSIG(response, *req.tsigKey, req.tsigMAC, time.Now())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return response
+	}
+	return signed
+}
+
+// signResponses signs the messages of a response spanning several of them,
+// each one covering the MAC of the message before (RFC 8945 section 5.3.1)
+func (server *DNSServer) signResponses(req *request, responses [][]byte) [][]byte {
+	if req.tsigKey == nil {
+		return responses
+	}
+	signed := make([][]byte, len(responses))
+	mac := req.tsigMAC
+	now := time.Now()
+	for i, response := range responses {
+		var message []byte
+		var err error
+		if i == 0 {
+			message, mac, err = utils.SignTSIG(response, *req.tsigKey, mac, now)
+		} else {
+			message, mac, err = utils.SignTSIGContinuation(response, *req.tsigKey, mac, now)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return responses
+		}
+		signed[i] = message
+	}
+	return signed
+}
+
+// tsigPolicies returns the policies of the zone granted to the key that signed the request
+func (server *DNSServer) tsigPolicies(req *request, zone *zoneData) ([]daos.TSIGPolicy, error) {
+	if req.tsigKey == nil {
+		return nil, nil
+	}
+	policies, err := server.cache.read("tsig-policies/"+zone.zone.ID, func() (any, error) {
+		return server.store.GetTSIGPolicies(zone.zone.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var granted []daos.TSIGPolicy
+	for _, policy := range policies.([]daos.TSIGPolicy) {
+		if canonicalName(policy.KeyName) == canonicalName(req.tsigKey.Name) {
+			granted = append(granted, policy)
+		}
+	}
+	return granted, nil
+}
+
+// canTransfer reports whether the policies allow transferring the zone
+func canTransfer(policies []daos.TSIGPolicy) bool {
+	for _, policy := range policies {
+		if policy.AllowTransfer {
+			return true
+		}
+	}
+	return false
+}
+
+// canUpdate reports whether the policies allow updating the name, given
+// relative to the zone ("@" for the apex)
+func canUpdate(policies []daos.TSIGPolicy, relativeName string) bool {
+	for _, policy := range policies {
+		if !policy.AllowUpdate {
+			continue
+		}
+		if policy.NamePattern == "" {
+			return true
+		}
+		if matched, _ := path.Match(strings.ToLower(policy.NamePattern), relativeName); matched {
+			return true
+		}
+	}
+	return false
+}
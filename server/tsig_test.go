@@ -0,0 +1,211 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TSIG(t *testing.T) {
+	store := newMemStore()
+	zoneId := store.addZone("example.org")
+	store.addRecord(zoneId, "@", "NS", "ns1.example.org")
+	store.addRecord(zoneId, "www", "A", "192.0.2.1")
+	transferKey := utils.TSIGKey{Name: "transfer", Algorithm: utils.TSIGHmacSHA256, Secret: []byte("transfer-secret")}
+	updateKey := utils.TSIGKey{Name: "update", Algorithm: utils.TSIGHmacSHA512, Secret: []byte("update-secret")}
+	store.keys["transfer"] = transferKey
+	store.keys["update"] = updateKey
+	store.policies[zoneId] = []daos.TSIGPolicy{
+		{ZoneID: zoneId, KeyName: "transfer", AllowTransfer: true},
+		{ZoneID: zoneId, KeyName: "update", AllowUpdate: true, NamePattern: "*.dyn"},
+	}
+
+	server, err := NewDNSServer("127.0.0.1:8053")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	transfer := func(t *testing.T, key *utils.TSIGKey) (utils.DNSResponse, error) {
+		dnsClient, err := client.NewTCPDNSClient("127.0.0.1:8053")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		if key != nil {
+			dnsClient.SetTSIGKey(*key)
+		}
+		return dnsClient.SendQuery("example.org", utils.TypeAXFR)
+	}
+
+	t.Run("UnsignedTransferRefused", func(t *testing.T) {
+		response, err := transfer(t, nil)
+		if err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED, got rcode %d", response.Header.Rcode())
+		}
+	})
+
+	t.Run("SignedTransfer", func(t *testing.T) {
+		response, err := transfer(t, &transferKey)
+		if err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeSuccess || len(response.Answers) != 4 {
+			t.Fatalf("Expected SOA, 2 records and SOA, got rcode %d and %d answers", response.Header.Rcode(), len(response.Answers))
+		}
+		if response.Answers[0].Type != utils.TypeSOA || response.Answers[3].Type != utils.TypeSOA {
+			t.Errorf("Transfer is not framed by SOA records")
+		}
+	})
+
+	t.Run("SignedTransferInSeveralMessages", func(t *testing.T) {
+		bigId := store.addZone("big.example.org")
+		store.policies[bigId] = []daos.TSIGPolicy{{ZoneID: bigId, KeyName: "transfer", AllowTransfer: true}}
+		for i := 0; i < 2000; i++ {
+			store.addRecord(bigId, fmt.Sprintf("host%d", i), "TXT", strings.Repeat("x", 100))
+		}
+
+		query, mac, _ := utils.SignTSIG((&utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 3},
+			Questions: []utils.DNSQuestion{{Name: "big.example.org", Type: utils.TypeAXFR, Class: utils.ClassIN}},
+		}).Serialize(), transferKey, nil, time.Now())
+		messages := server.handleMessage(query, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, ListenerTCP)
+		if len(messages) < 2 {
+			t.Fatalf("Expected the transfer to span several messages, got %d", len(messages))
+		}
+		for i, message := range messages {
+			if len(message) > 65535 {
+				t.Fatalf("Message %d has %d bytes", i, len(message))
+			}
+			var record *utils.TSIGRecord
+			var err error
+			if i == 0 {
+				record, err = utils.VerifyTSIG(message, transferKey, mac, time.Now())
+			} else {
+				record, err = utils.VerifyTSIGContinuation(message, transferKey, mac, time.Now())
+			}
+			if err != nil {
+				t.Fatalf("Message %d is not signed after the one before: %v", i, err)
+			}
+			mac = record.MAC
+		}
+
+		dnsClient, err := client.NewTCPDNSClient("127.0.0.1:8053")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		dnsClient.SetTSIGKey(transferKey)
+		response, err := dnsClient.SendQuery("big.example.org", utils.TypeAXFR)
+		if err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if len(response.Answers) != 2002 {
+			t.Errorf("Expected SOA, 2000 records and SOA, got %d answers", len(response.Answers))
+		}
+	})
+
+	t.Run("TransferWithUpdateKeyRefused", func(t *testing.T) {
+		response, err := transfer(t, &updateKey)
+		if err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED, got rcode %d", response.Header.Rcode())
+		}
+	})
+
+	t.Run("BadSignature", func(t *testing.T) {
+		badKey := transferKey
+		badKey.Secret = []byte("wrong")
+		_, err := transfer(t, &badKey)
+		var tsigError *utils.TSIGError
+		if !errors.As(err, &tsigError) || tsigError.Code != utils.TSIGErrBadSig {
+			t.Errorf("Expected BADSIG, got %v", err)
+		}
+	})
+
+	update := func(t *testing.T, key *utils.TSIGKey, name string) utils.DNSResponse {
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8053")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		if key != nil {
+			dnsClient.SetTSIGKey(*key)
+		}
+		rr, _ := utils.NewDNSAnswer(name, utils.TypeA, 60, "192.0.2.99")
+		response, err := dnsClient.Exchange(utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 1, Flags: utils.OpcodeUpdate << 11},
+			Questions: []utils.DNSQuestion{{Name: "example.org", Type: utils.TypeSOA, Class: utils.ClassIN}},
+			Authority: []utils.DNSAnswer{rr},
+		})
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		return response
+	}
+
+	t.Run("UnsignedUpdateRefused", func(t *testing.T) {
+		if rcode := update(t, nil, "host.dyn.example.org").Header.Rcode(); rcode != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED, got rcode %d", rcode)
+		}
+	})
+
+	t.Run("UpdateOutsidePolicyRefused", func(t *testing.T) {
+		if rcode := update(t, &updateKey, "www.example.org").Header.Rcode(); rcode != utils.RcodeRefused {
+			t.Errorf("Expected REFUSED, got rcode %d", rcode)
+		}
+	})
+
+	t.Run("SignedUpdate", func(t *testing.T) {
+		if rcode := update(t, &updateKey, "host.dyn.example.org").Header.Rcode(); rcode != utils.RcodeSuccess {
+			t.Fatalf("Expected NOERROR, got rcode %d", rcode)
+		}
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8053")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		response, err := dnsClient.SendQuery("host.dyn.example.org", utils.TypeA)
+		if err != nil || len(response.Answers) != 1 || response.Answers[0].Addr.String() != "192.0.2.99" {
+			t.Errorf("Updated record not served, err: %v, response: %v", err, response)
+		}
+	})
+
+	t.Run("UpdateIsAtomic", func(t *testing.T) {
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8053")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		dnsClient.SetTSIGKey(updateKey)
+		// The CNAME cannot be added next to the address, so neither is
+		address, _ := utils.NewDNSAnswer("both.dyn.example.org", utils.TypeA, 60, "192.0.2.98")
+		alias, _ := utils.NewDNSAnswer("both.dyn.example.org", utils.TypeCNAME, 60, "www.example.org")
+		response, err := dnsClient.Exchange(utils.DNSPacket{
+			Header:    utils.DNSHeader{ID: 2, Flags: utils.OpcodeUpdate << 11},
+			Questions: []utils.DNSQuestion{{Name: "example.org", Type: utils.TypeSOA, Class: utils.ClassIN}},
+			Authority: []utils.DNSAnswer{address, alias},
+		})
+		if err != nil || response.Header.Rcode() != utils.RcodeServerFailure {
+			t.Fatalf("Expected SERVFAIL, got %v (%v)", response, err)
+		}
+		response, err = dnsClient.SendQuery("both.dyn.example.org", utils.TypeA)
+		if err != nil || len(response.Answers) != 0 {
+			t.Errorf("Expected nothing from the failed update, got %v (%v)", response, err)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// handleUpdate applies RFC 2136 dynamic updates to a hosted zone. Updates
+// must be signed with a TSIG key whose policy covers every updated name.
+func (server *DNSServer) handleUpdate(req *request) utils.DNSResponse {
+	packet := req.packet
+	if len(packet.Questions) != 1 || packet.Questions[0].Type != utils.TypeSOA {
+		return newResponse(req, utils.RcodeFormatError)
+	}
+	zone, rcode := server.zoneForApex(packet.Questions[0].Name, req.view)
+	if zone == nil {
+		return newResponse(req, rcode)
+	}
+
+	policies, err := server.tsigPolicies(req, zone)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	for _, rr := range packet.Authority {
+		name := canonicalName(rr.Name)
+		if !isSubdomain(name, zone.name) {
+			return newResponse(req, utils.RcodeNotZone)
+		}
+		if !canUpdate(policies, zone.relativeName(name)) {
+			fmt.Printf("Refused update of %s from %v\n", name, req.addr)
+			return newResponse(req, utils.RcodeRefused)
+		}
+	}
+
+	// The prerequisites are checked against the records the update is made
+	// to, which cannot change until it is
+	err = server.store.UpdateRecords(updateActor(req), zone.zone.ID, func(records []daos.DNSRecord) ([]daos.RecordOperation, error) {
+		current := *zone
+		current.records = records
+		if rcode := current.checkPrerequisites(packet.Answers); rcode != utils.RcodeSuccess {
+			return nil, updateRcode(rcode)
+		}
+		return current.planUpdate(packet.Authority)
+	})
+	var failed updateRcode
+	switch {
+	case errors.As(err, &failed):
+		return newResponse(req, uint16(failed))
+	case err != nil:
+		fmt.Println("Error:", err)
+		return newResponse(req, utils.RcodeServerFailure)
+	}
+	return newResponse(req, utils.RcodeSuccess)
+}
+
+// updateRcode fails an update whose prerequisites are not met with the rcode
+type updateRcode uint16
+
+func (rcode updateRcode) Error() string {
+	return fmt.Sprintf("update prerequisite failed with rcode %d", uint16(rcode))
+}
+
+// updateActor names who makes an update for the audit log: the key that
+// signed it
+func updateActor(req *request) daos.Actor {
+	actor := daos.Actor{Name: "anonymous", Source: daos.SourceDNSUpdate}
+	if req.tsigKey != nil {
+		actor.Name = canonicalName(req.tsigKey.Name)
+	}
+	if ip, known := clientIP(req.addr); known {
+		actor.SourceIP = ip.String()
+	}
+	return actor
+}
+
+// checkPrerequisites evaluates the prerequisite section of an update
+func (zd *zoneData) checkPrerequisites(prerequisites []utils.DNSAnswer) uint16 {
+	for _, rr := range prerequisites {
+		name := canonicalName(rr.Name)
+		if !isSubdomain(name, zd.name) {
+			return utils.RcodeNotZone
+		}
+		switch {
+		case rr.Class == utils.ClassANY && rr.Type == utils.TypeANY:
+			if len(zd.answers(name, utils.TypeANY)) == 0 {
+				return utils.RcodeNameError
+			}
+		case rr.Class == utils.ClassANY:
+			if len(zd.answers(name, rr.Type)) == 0 {
+				return utils.RcodeNXRRSet
+			}
+		case rr.Class == utils.ClassNONE && rr.Type == utils.TypeANY:
+			if len(zd.answers(name, utils.TypeANY)) > 0 {
+				return utils.RcodeYXDomain
+			}
+		case rr.Class == utils.ClassNONE:
+			if len(zd.answers(name, rr.Type)) > 0 {
+				return utils.RcodeYXRRSet
+			}
+		case rr.Class == utils.ClassIN:
+			if len(zd.matching(name, rr)) == 0 {
+				return utils.RcodeNXRRSet
+			}
+		default:
+			return utils.RcodeFormatError
+		}
+	}
+	return utils.RcodeSuccess
+}
+
+// planUpdate turns the update section into the record operations making it,
+// following the records of the zone as each RR of the section changes them
+func (zd *zoneData) planUpdate(updates []utils.DNSAnswer) ([]daos.RecordOperation, error) {
+	var operations []daos.RecordOperation
+	created := map[string]int{} // Operations creating records, by the IDs they are given here
+	for _, rr := range updates {
+		name := canonicalName(rr.Name)
+		var match func(record daos.DNSRecord) bool
+		switch rr.Class {
+		case utils.ClassIN:
+			if len(zd.matching(name, rr)) > 0 {
+				continue
+			}
+			create := daos.DNSRecordCreate{Name: zd.relativeName(name), Type: rr.Type.String(), Value: rr.Value(), TTL: int(rr.TTL)}
+			id := fmt.Sprintf("update-%d", len(operations))
+			created[id] = len(operations)
+			operations = append(operations, daos.RecordOperation{Action: daos.ChangeCreate, Record: &create})
+			zd.records = append(zd.records, daos.DNSRecord{ID: id, Name: create.Name, Type: create.Type, Value: create.Value, TTL: create.TTL})
+			continue
+		case utils.ClassANY:
+			match = func(record daos.DNSRecord) bool {
+				if zd.owner(record) != name {
+					return false
+				}
+				if name == zd.name && (strings.EqualFold(record.Type, "NS") || strings.EqualFold(record.Type, "SOA")) {
+					// The apex SOA and NS records can only be removed one by one
+					return false
+				}
+				return rr.Type == utils.TypeANY || strings.EqualFold(record.Type, rr.Type.String())
+			}
+		case utils.ClassNONE:
+			match = func(record daos.DNSRecord) bool {
+				return zd.owner(record) == name && recordMatches(record, name, rr)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected class %d in update section", rr.Class)
+		}
+
+		var kept []daos.DNSRecord
+		for _, record := range zd.records {
+			if !match(record) {
+				kept = append(kept, record)
+				continue
+			}
+			if i, ok := created[record.ID]; ok {
+				// Added and removed by the same update
+				operations[i].Action = ""
+				continue
+			}
+			operations = append(operations, daos.RecordOperation{Action: daos.ChangeDelete, ID: record.ID})
+		}
+		zd.records = kept
+	}
+
+	var planned []daos.RecordOperation
+	for _, operation := range operations {
+		if operation.Action != "" {
+			planned = append(planned, operation)
+		}
+	}
+	return planned, nil
+}
+
+// matching returns the records of the zone at name with the same type and data as rr
+func (zd *zoneData) matching(name string, rr utils.DNSAnswer) []daos.DNSRecord {
+	var matches []daos.DNSRecord
+	for _, record := range zd.records {
+		if zd.owner(record) == name && recordMatches(record, name, rr) {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+func recordMatches(record daos.DNSRecord, name string, rr utils.DNSAnswer) bool {
+	if !strings.EqualFold(record.Type, rr.Type.String()) {
+		return false
+	}
+	answer, err := utils.NewDNSAnswer(name, rr.Type, 0, record.Value)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(answer.Value(), rr.Value())
+}
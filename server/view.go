@@ -0,0 +1,41 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"net/netip"
+)
+
+// selectView returns the ID of the first view matching the client, by the
+// TSIG key that signed the request or by its address, or an empty ID when
+// no view does
+func (server *DNSServer) selectView(req *request) (string, error) {
+	if server.store == nil {
+		return "", nil
+	}
+	views, err := server.cache.read("views", func() (any, error) {
+		return server.store.GetViews()
+	})
+	if err != nil {
+		return "", err
+	}
+	ip, known := clientIP(req.addr)
+	for _, view := range views.([]daos.View) {
+		if req.tsigKey != nil {
+			for _, keyName := range view.TSIGKeys {
+				if canonicalName(keyName) == canonicalName(req.tsigKey.Name) {
+					return view.ID, nil
+				}
+			}
+		}
+		if !known {
+			continue
+		}
+		for _, network := range view.Networks {
+			prefix, err := netip.ParsePrefix(network)
+			if err == nil && prefix.Contains(ip) {
+				return view.ID, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"dnsServer/client"
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"testing"
+	"time"
+)
+
+func Test_Views(t *testing.T) {
+	store := newMemStore()
+	partnerKey := utils.TSIGKey{Name: "partner", Algorithm: utils.TSIGHmacSHA256, Secret: []byte("partner-secret")}
+	store.keys["partner"] = partnerKey
+	store.views = []daos.View{
+		{ID: "internal", Name: "internal", Networks: []string{"10.0.0.0/8"}},
+		{ID: "partner", Name: "partner", Priority: 1, TSIGKeys: []string{"partner"}},
+		{ID: "local", Name: "local", Priority: 2, Networks: []string{"127.0.0.1/32"}},
+	}
+	publicId := store.addZone("corp.example")
+	store.addRecord(publicId, "app", "A", "203.0.113.5")
+	partnerId := store.addViewZone("corp.example", "partner")
+	store.addRecord(partnerId, "@", "NS", "ns1.corp.example")
+	store.addRecord(partnerId, "app", "A", "10.0.0.5")
+	store.policies[partnerId] = []daos.TSIGPolicy{{ZoneID: partnerId, KeyName: "partner", AllowTransfer: true}}
+	localId := store.addViewZone("corp.example", "local")
+	store.addRecord(localId, "app", "A", "10.0.0.6")
+	otherId := store.addZone("other.example")
+	store.addRecord(otherId, "www", "A", "203.0.113.9")
+
+	server, err := NewDNSServer("127.0.0.1:8063")
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.SetZoneStore(store)
+	server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	lookup := func(t *testing.T, key *utils.TSIGKey, name string) string {
+		t.Helper()
+		dnsClient, err := client.NewDNSClient("127.0.0.1:8063")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		if key != nil {
+			dnsClient.SetTSIGKey(*key)
+		}
+		response, err := dnsClient.SendQuery(name, utils.TypeA)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeSuccess || len(response.Answers) != 1 {
+			t.Fatalf("Expected one answer for %s, got rcode %d and %d answers", name, response.Header.Rcode(), len(response.Answers))
+		}
+		return response.Answers[0].Value()
+	}
+
+	t.Run("MatchedByAddress", func(t *testing.T) {
+		if value := lookup(t, nil, "app.corp.example"); value != "10.0.0.6" {
+			t.Errorf("Expected the local view answer 10.0.0.6, got %s", value)
+		}
+	})
+
+	t.Run("MatchedByTSIGKey", func(t *testing.T) {
+		// The partner view comes before the local one
+		if value := lookup(t, &partnerKey, "app.corp.example"); value != "10.0.0.5" {
+			t.Errorf("Expected the partner view answer 10.0.0.5, got %s", value)
+		}
+	})
+
+	t.Run("SharedZones", func(t *testing.T) {
+		if value := lookup(t, nil, "www.other.example"); value != "203.0.113.9" {
+			t.Errorf("Expected zones without a view in every view, got %s", value)
+		}
+	})
+
+	t.Run("TransferOfViewZone", func(t *testing.T) {
+		dnsClient, err := client.NewTCPDNSClient("127.0.0.1:8063")
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		defer dnsClient.Close()
+		dnsClient.SetTSIGKey(partnerKey)
+		response, err := dnsClient.SendQuery("corp.example", utils.TypeAXFR)
+		if err != nil {
+			t.Fatalf("Transfer failed: %v", err)
+		}
+		if response.Header.Rcode() != utils.RcodeSuccess || len(response.Answers) != 4 {
+			t.Fatalf("Expected SOA, 2 records and SOA, got rcode %d and %d answers", response.Header.Rcode(), len(response.Answers))
+		}
+		if value := response.Answers[2].Value(); value != "10.0.0.5" {
+			t.Errorf("Expected the records of the partner view, got %s", value)
+		}
+	})
+
+	t.Run("NoMatchingView", func(t *testing.T) {
+		store.mu.Lock()
+		store.views = store.views[:1]
+		store.mu.Unlock()
+		if value := lookup(t, nil, "app.corp.example"); value != "203.0.113.5" {
+			t.Errorf("Expected the public answer 203.0.113.5, got %s", value)
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"strings"
+)
+
+// ZoneStore gives the DNS server access to the hosted zones, the TSIG keys
+// allowed to transfer and update them and the client ACLs
+type ZoneStore interface {
+	// FindZone returns the most specific zone containing name visible in the
+	// view, or nil if none does. An empty viewId selects the zones of all clients.
+	FindZone(name string, viewId string) (*daos.DNSZone, error)
+	GetRecords(zoneId string) ([]daos.DNSRecord, error)
+	// UpdateRecords changes a zone on behalf of the actor with the operations
+	// plan returns for its current records, all of them or none. The zone
+	// cannot change in between. Nothing changes when plan fails.
+	UpdateRecords(actor daos.Actor, zoneId string, plan func(records []daos.DNSRecord) ([]daos.RecordOperation, error)) error
+	// GetTSIGKey returns the key with the given name, or nil if there is none
+	GetTSIGKey(name string) (*utils.TSIGKey, error)
+	GetTSIGPolicies(zoneId string) ([]daos.TSIGPolicy, error)
+	// GetDNSSECKeys returns the signing keys of a DNSSEC enabled zone
+	GetDNSSECKeys(zoneId string) ([]utils.SigningKey, error)
+	// GetACLRules returns the ACL rules of a zone, or the global ones when zoneId is empty
+	GetACLRules(zoneId string) ([]daos.ACLRule, error)
+	// GetViews returns the views in the order they are matched
+	GetViews() ([]daos.View, error)
+	// GetHealthChecks returns the records of all zones that have a health check
+	GetHealthChecks() ([]daos.DNSRecord, error)
+}
+
+// Default SOA timers for hosted zones
+const (
+	soaTTL     = utils.SOATTL
+	soaRefresh = utils.SOARefresh
+	soaRetry   = utils.SOARetry
+	soaExpire  = utils.SOAExpire
+	soaMinimum = utils.SOAMinimum
+	dnskeyTTL  = 3600
+)
+
+// zoneData is a snapshot of a hosted zone used to answer a single message
+type zoneData struct {
+	zone    daos.DNSZone
+	name    string // Canonical zone name
+	records []daos.DNSRecord
+	keys    []utils.SigningKey // Signing keys, empty if the zone is not signed
+}
+
+// loadZone returns a snapshot of the zone containing name in the view, or nil
+// if there is none. Snapshots are shared through the store cache, each
+// caller getting its own copy of the record list to change.
+func (server *DNSServer) loadZone(name string, viewId string) (*zoneData, error) {
+	found, err := server.cache.read("find/"+canonicalName(name)+"/"+viewId, func() (any, error) {
+		return server.store.FindZone(name, viewId)
+	})
+	zone, _ := found.(*daos.DNSZone)
+	if err != nil || zone == nil {
+		return nil, err
+	}
+	snapshot, err := server.cache.read("zone/"+zone.ID, func() (any, error) {
+		records, err := server.store.GetRecords(zone.ID)
+		if err != nil {
+			return nil, err
+		}
+		zd := &zoneData{zone: *zone, name: canonicalName(zone.Name), records: records}
+		if zone.DNSSECEnabled {
+			if zd.keys, err = server.store.GetDNSSECKeys(zone.ID); err != nil {
+				return nil, err
+			}
+		}
+		return zd, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	zd := *snapshot.(*zoneData)
+	zd.records = zd.records[:len(zd.records):len(zd.records)]
+	return &zd, nil
+}
+
+// canonicalName lower-cases a name and strips its trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// isSubdomain reports whether name is equal to or below parent
+func isSubdomain(name string, parent string) bool {
+	return parent == "" || name == parent || strings.HasSuffix(name, "."+parent)
+}
+
+// parentName strips the first label of a name
+func parentName(name string) string {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// owner returns the fully qualified owner name of a record, whose name may
+// be relative to the zone ("www"), the apex ("@" or empty) or absolute
+func (zd *zoneData) owner(record daos.DNSRecord) string {
+	name := canonicalName(record.Name)
+	if name == "" || name == "@" {
+		return zd.name
+	}
+	if isSubdomain(name, zd.name) {
+		return name
+	}
+	if zd.name == "" {
+		return name
+	}
+	return name + "." + zd.name
+}
+
+// relativeName returns the name as stored for records of the zone
+func (zd *zoneData) relativeName(name string) string {
+	name = canonicalName(name)
+	if name == zd.name {
+		return "@"
+	}
+	if zd.name == "" {
+		return name
+	}
+	return strings.TrimSuffix(name, "."+zd.name)
+}
+
+func (zd *zoneData) soa() utils.DNSAnswer {
+	mname := "ns1." + zd.name
+	for _, record := range zd.records {
+		if strings.EqualFold(record.Type, "NS") && zd.owner(record) == zd.name {
+			mname = canonicalName(record.Value)
+			break
+		}
+	}
+	return utils.NewSOAAnswer(zd.name, soaTTL, mname, "hostmaster."+zd.name, zd.zone.Serial,
+		soaRefresh, soaRetry, soaExpire, soaMinimum)
+}
+
+// negativeSOA is the SOA placed in the authority section of negative answers
+func (zd *zoneData) negativeSOA() utils.DNSAnswer {
+	soa := zd.soa()
+	soa.TTL = soaMinimum
+	return soa
+}
+
+// answers returns the records of the zone at name with the given type, or
+// of any type for TypeANY
+func (zd *zoneData) answers(name string, qtype utils.DNSRecordType) []utils.DNSAnswer {
+	return zd.steeredAnswers(name, qtype, nil)
+}
+
+// steeredAnswers is answers with each record set going through steer, when
+// given, to pick the records returned to a client
+func (zd *zoneData) steeredAnswers(name string, qtype utils.DNSRecordType, steer steering) []utils.DNSAnswer {
+	var answers []utils.DNSAnswer
+	if name == zd.name {
+		answers = append(answers, zd.apexAnswers(qtype)...)
+	}
+	sets := map[utils.DNSRecordType][]daos.DNSRecord{}
+	var types []utils.DNSRecordType
+	for _, record := range zd.records {
+		if zd.owner(record) != name {
+			continue
+		}
+		rtype, ok := utils.ParseDNSRecordType(record.Type)
+		if !ok || (qtype != utils.TypeANY && rtype != qtype) || rtype == utils.TypeSOA {
+			continue
+		}
+		if _, seen := sets[rtype]; !seen {
+			types = append(types, rtype)
+		}
+		sets[rtype] = append(sets[rtype], record)
+	}
+	for _, rtype := range types {
+		records := sets[rtype]
+		if steer != nil {
+			records = steer(records)
+		}
+		for _, record := range records {
+			answer, err := utils.NewDNSAnswer(name, rtype, uint32(record.TTL), record.Value)
+			if err != nil {
+				continue
+			}
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// apexAnswers returns the records synthesized at the zone apex: the SOA and,
+// for signed zones, the DNSKEY, CDNSKEY, CDS and NSEC3PARAM records
+func (zd *zoneData) apexAnswers(qtype utils.DNSRecordType) []utils.DNSAnswer {
+	var answers []utils.DNSAnswer
+	if qtype == utils.TypeSOA || qtype == utils.TypeANY {
+		answers = append(answers, zd.soa())
+	}
+	for _, key := range zd.keys {
+		if qtype == utils.TypeDNSKEY || qtype == utils.TypeANY {
+			answers = append(answers, key.ToAnswer(zd.name, utils.TypeDNSKEY, dnskeyTTL))
+		}
+		if !key.IsKSK() || key.State != utils.KeyActive {
+			// Only the key signing keys the parent should point to
+			continue
+		}
+		if qtype == utils.TypeCDNSKEY || qtype == utils.TypeANY {
+			answers = append(answers, key.ToAnswer(zd.name, utils.TypeCDNSKEY, dnskeyTTL))
+		}
+		if qtype == utils.TypeCDS || qtype == utils.TypeANY {
+			answers = append(answers, key.DS(zd.name).ToAnswer(zd.name, utils.TypeCDS, dnskeyTTL))
+		}
+	}
+	if len(zd.keys) > 0 && zd.zone.NSEC3 != nil && (qtype == utils.TypeNSEC3PARAM || qtype == utils.TypeANY) {
+		answers = append(answers, zd.nsec3Param())
+	}
+	return answers
+}
+
+// nameExists reports whether name owns records or is an empty non-terminal
+func (zd *zoneData) nameExists(name string) bool {
+	if name == zd.name {
+		return true
+	}
+	for _, record := range zd.records {
+		if isSubdomain(zd.owner(record), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allAnswers returns every record of the zone, used for zone transfers
+func (zd *zoneData) allAnswers() []utils.DNSAnswer {
+	var answers []utils.DNSAnswer
+	for _, answer := range zd.apexAnswers(utils.TypeANY) {
+		if answer.Type != utils.TypeSOA {
+			answers = append(answers, answer)
+		}
+	}
+	for _, record := range zd.records {
+		rtype, ok := utils.ParseDNSRecordType(record.Type)
+		if !ok || rtype == utils.TypeSOA {
+			continue
+		}
+		answer, err := utils.NewDNSAnswer(zd.owner(record), rtype, uint32(record.TTL), record.Value)
+		if err != nil {
+			continue
+		}
+		answers = append(answers, answer)
+	}
+	return answers
+}
@@ -0,0 +1,373 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"path"
+	"strings"
+)
+
+// AccessService manages the users and teams of the API and the roles they
+// have in zones
+type AccessService struct {
+	db *gorm.DB
+}
+
+func NewAccessService(db *gorm.DB) *AccessService {
+	return &AccessService{db: db}
+}
+
+func (as *AccessService) CreateUser(create daos.UserCreate) (daos.User, error) {
+	if create.Name == "" {
+		return daos.User{}, invalid("name", "a user needs a name")
+	}
+	user := data.User{Base: data.Base{ID: uuid.NewString()}, Name: create.Name}
+	if err := as.db.Create(&user).Error; err != nil {
+		return daos.User{}, err
+	}
+	return user.ToUser(), nil
+}
+
+func (as *AccessService) GetUser(userId string) (*daos.User, error) {
+	var user data.User
+	if err := as.db.Preload("Teams").Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	toRet := user.ToUser()
+	return &toRet, nil
+}
+
+func (as *AccessService) GetUsers() ([]daos.User, error) {
+	var users []data.User
+
+	if err := as.db.Preload("Teams").Order("name").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.User
+	for _, user := range users {
+		toRet = append(toRet, user.ToUser())
+	}
+	return toRet, nil
+}
+
+// DeleteUser deletes a user along with its team memberships and roles
+func (as *AccessService) DeleteUser(userId string) error {
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		user := data.User{Base: data.Base{ID: userId}}
+		if err := tx.Model(&user).Association("Teams").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userId).Delete(&data.RoleBinding{}).Error; err != nil {
+			return err
+		}
+		return deleted(tx.Delete(&user))
+	})
+}
+
+func (as *AccessService) CreateTeam(create daos.TeamCreate) (daos.Team, error) {
+	if create.Name == "" {
+		return daos.Team{}, invalid("name", "a team needs a name")
+	}
+	team := data.Team{Base: data.Base{ID: uuid.NewString()}, Name: create.Name}
+	if len(create.Members) > 0 {
+		if err := as.db.Where("id IN ?", create.Members).Find(&team.Users).Error; err != nil {
+			return daos.Team{}, err
+		}
+		if len(team.Users) != len(create.Members) {
+			return daos.Team{}, invalid("members", "unknown team members")
+		}
+	}
+	if err := as.db.Create(&team).Error; err != nil {
+		return daos.Team{}, err
+	}
+	return team.ToTeam(), nil
+}
+
+func (as *AccessService) GetTeam(teamId string) (*daos.Team, error) {
+	var team data.Team
+	if err := as.db.Preload("Users").Where("id = ?", teamId).First(&team).Error; err != nil {
+		return nil, err
+	}
+	toRet := team.ToTeam()
+	return &toRet, nil
+}
+
+func (as *AccessService) GetTeams() ([]daos.Team, error) {
+	var teams []data.Team
+
+	if err := as.db.Preload("Users").Order("name").Find(&teams).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.Team
+	for _, team := range teams {
+		toRet = append(toRet, team.ToTeam())
+	}
+	return toRet, nil
+}
+
+// DeleteTeam deletes a team along with its roles
+func (as *AccessService) DeleteTeam(teamId string) error {
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		team := data.Team{Base: data.Base{ID: teamId}}
+		if err := tx.Model(&team).Association("Users").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", teamId).Delete(&data.RoleBinding{}).Error; err != nil {
+			return err
+		}
+		return deleted(tx.Delete(&team))
+	})
+}
+
+func (as *AccessService) AddTeamMember(teamId string, userId string) error {
+	var team data.Team
+	var user data.User
+	if err := as.db.Where("id = ?", teamId).First(&team).Error; err != nil {
+		return err
+	}
+	if err := as.db.Where("id = ?", userId).First(&user).Error; err != nil {
+		return err
+	}
+	return as.db.Model(&team).Association("Users").Append(&user)
+}
+
+func (as *AccessService) RemoveTeamMember(teamId string, userId string) error {
+	team := data.Team{Base: data.Base{ID: teamId}}
+	user := data.User{Base: data.Base{ID: userId}}
+	return as.db.Model(&team).Association("Users").Delete(&user)
+}
+
+// CreateRoleBinding gives a user or a team a role in a zone
+func (as *AccessService) CreateRoleBinding(zoneId string, create daos.RoleBindingCreate) (daos.RoleBinding, error) {
+	if (create.UserID == "") == (create.TeamID == "") {
+		return daos.RoleBinding{}, invalid("", "a role is given to either a user or a team")
+	}
+	if zoneRoleRank(create.Role) == 0 {
+		return daos.RoleBinding{}, invalid("role", "unknown role %q", create.Role)
+	}
+	for _, pattern := range create.NamePatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return daos.RoleBinding{}, invalid("namePatterns", "invalid name pattern %q", pattern)
+		}
+	}
+	if create.UserID != "" {
+		if err := as.db.Where("id = ?", create.UserID).First(&data.User{}).Error; err != nil {
+			return daos.RoleBinding{}, invalid("userID", "unknown user %s", create.UserID)
+		}
+	} else if err := as.db.Where("id = ?", create.TeamID).First(&data.Team{}).Error; err != nil {
+		return daos.RoleBinding{}, invalid("teamID", "unknown team %s", create.TeamID)
+	}
+	binding := data.RoleBinding{
+		Base:         data.Base{ID: uuid.NewString()},
+		ZoneID:       zoneId,
+		UserID:       create.UserID,
+		TeamID:       create.TeamID,
+		Role:         create.Role,
+		RecordTypes:  create.RecordTypes,
+		NamePatterns: create.NamePatterns,
+	}
+	if err := as.db.Create(&binding).Error; err != nil {
+		return daos.RoleBinding{}, err
+	}
+	return binding.ToRoleBinding(), nil
+}
+
+func (as *AccessService) GetRoleBinding(bindingId string) (*daos.RoleBinding, error) {
+	var binding data.RoleBinding
+	if err := as.db.Where("id = ?", bindingId).First(&binding).Error; err != nil {
+		return nil, err
+	}
+	toRet := binding.ToRoleBinding()
+	return &toRet, nil
+}
+
+func (as *AccessService) GetRoleBindings(zoneId string) ([]daos.RoleBinding, error) {
+	var bindings []data.RoleBinding
+
+	if err := as.db.Where("zone_id = ?", zoneId).Find(&bindings).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.RoleBinding
+	for _, binding := range bindings {
+		toRet = append(toRet, binding.ToRoleBinding())
+	}
+	return toRet, nil
+}
+
+func (as *AccessService) DeleteRoleBinding(bindingId string) error {
+	return deleted(as.db.Where("id = ?", bindingId).Delete(&data.RoleBinding{}))
+}
+
+// ZoneAccess is what the caller of the API may do in a zone
+type ZoneAccess struct {
+	Zone         daos.DNSZone
+	Unrestricted bool // Set for internal callers and global admin keys, not limited to zones with roles
+	Bindings     []daos.RoleBinding
+}
+
+// ZoneAccess returns the access of an API key to a zone. Internal callers,
+// with no key, and keys of global admins have full access to every zone.
+func (as *AccessService) ZoneAccess(key *daos.APIKey, zoneId string) (ZoneAccess, error) {
+	var zone data.Zone
+	if err := as.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return ZoneAccess{}, err
+	}
+	access := ZoneAccess{Zone: zone.ToDNSZone(), Unrestricted: unrestricted(key)}
+	if access.Unrestricted {
+		return access, nil
+	}
+	var bindings []data.RoleBinding
+	if err := as.callerBindings(key).Where("zone_id = ?", zoneId).Find(&bindings).Error; err != nil {
+		return ZoneAccess{}, err
+	}
+	for _, binding := range bindings {
+		access.Bindings = append(access.Bindings, binding.ToRoleBinding())
+	}
+	return access, nil
+}
+
+// VisibleZones keeps the zones an API key may see
+func (as *AccessService) VisibleZones(key *daos.APIKey, zones []daos.DNSZone) ([]daos.DNSZone, error) {
+	zoneIds, err := as.VisibleZoneIDs(key)
+	if err != nil || zoneIds == nil {
+		return zones, err
+	}
+	visible := map[string]bool{}
+	for _, zoneId := range zoneIds {
+		visible[zoneId] = true
+	}
+	var toRet []daos.DNSZone
+	for _, zone := range zones {
+		if visible[zone.ID] {
+			toRet = append(toRet, zone)
+		}
+	}
+	return toRet, nil
+}
+
+// VisibleZoneIDs returns the IDs of the zones an API key may see, or nil
+// when it may see all of them
+func (as *AccessService) VisibleZoneIDs(key *daos.APIKey) ([]string, error) {
+	if unrestricted(key) {
+		return nil, nil
+	}
+	zoneIds := []string{}
+	err := as.callerBindings(key).Model(&data.RoleBinding{}).Distinct().Pluck("zone_id", &zoneIds).Error
+	return zoneIds, err
+}
+
+// GrantCreator makes the user of an API key the owner of a zone it created.
+// Keys not bound to a user have no one to grant it to, a global admin has
+// to give their teams a role in it.
+func (as *AccessService) GrantCreator(key *daos.APIKey, zoneId string) error {
+	if unrestricted(key) || key.UserID == "" {
+		return nil
+	}
+	_, err := as.CreateRoleBinding(zoneId, daos.RoleBindingCreate{UserID: key.UserID, Role: daos.ZoneRoleOwner})
+	return err
+}
+
+// callerBindings selects the role bindings of the user of a key, of its
+// teams and of the teams named after its identity provider groups
+func (as *AccessService) callerBindings(key *daos.APIKey) *gorm.DB {
+	if key.UserID == "" {
+		// Keys not bound to a user, such as team and service keys, only have
+		// the roles of the teams named after their groups, if any
+		groups := as.db.Model(&data.Team{}).Select("id").Where("name IN ?", key.Groups)
+		return as.db.Where("team_id IN (?)", groups)
+	}
+	teams := as.db.Table("team_members").Select("team_id").Where("user_id = ?", key.UserID)
+	if len(key.Groups) == 0 {
+		return as.db.Where("user_id = ? OR team_id IN (?)", key.UserID, teams)
+	}
+	groups := as.db.Model(&data.Team{}).Select("id").Where("name IN ?", key.Groups)
+	return as.db.Where("user_id = ? OR team_id IN (?) OR team_id IN (?)", key.UserID, teams, groups)
+}
+
+// unrestricted reports whether the caller may act on every zone: internal
+// callers, with no key, and global admins
+func unrestricted(key *daos.APIKey) bool {
+	return key == nil || key.Scope == daos.APIScopeGlobalAdmin
+}
+
+// Known reports whether the caller has any role in the zone
+func (access ZoneAccess) Known() bool {
+	return access.Unrestricted || len(access.Bindings) > 0
+}
+
+// Allows reports whether the caller has at least the role in the zone
+func (access ZoneAccess) Allows(role string) bool {
+	if access.Unrestricted {
+		return true
+	}
+	for _, binding := range access.Bindings {
+		if zoneRoleRank(binding.Role) >= zoneRoleRank(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRecord reports whether the caller may edit records of the type at
+// the name, which may be relative to the zone or absolute
+func (access ZoneAccess) AllowsRecord(recordType string, name string) bool {
+	if access.Unrestricted {
+		return true
+	}
+	relative := relativeRecordName(name, access.Zone.Name)
+	for _, binding := range access.Bindings {
+		if zoneRoleRank(binding.Role) < zoneRoleRank(daos.ZoneRoleEditor) {
+			continue
+		}
+		if bindingAllows(binding, recordType, relative) {
+			return true
+		}
+	}
+	return false
+}
+
+func bindingAllows(binding daos.RoleBinding, recordType string, relativeName string) bool {
+	typeAllowed := len(binding.RecordTypes) == 0
+	for _, allowed := range binding.RecordTypes {
+		if strings.EqualFold(allowed, recordType) {
+			typeAllowed = true
+		}
+	}
+	if !typeAllowed {
+		return false
+	}
+	if len(binding.NamePatterns) == 0 {
+		return true
+	}
+	for _, pattern := range binding.NamePatterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), relativeName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeRecordName returns a record name relative to its zone, "@" for
+// the apex
+func relativeRecordName(name string, zoneName string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zoneName = strings.ToLower(strings.TrimSuffix(zoneName, "."))
+	if name == "" || name == "@" || name == zoneName {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zoneName)
+}
+
+func zoneRoleRank(role string) int {
+	switch role {
+	case daos.ZoneRoleViewer:
+		return 1
+	case daos.ZoneRoleEditor:
+		return 2
+	case daos.ZoneRoleOwner:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"net"
+	"strings"
+)
+
+type ACLService struct {
+	db *gorm.DB
+}
+
+func NewACLService(db *gorm.DB) *ACLService {
+	return &ACLService{db: db}
+}
+
+// CreateRule stores a new ACL rule. A single address is stored as a network
+// holding only that address.
+func (as *ACLService) CreateRule(create daos.ACLRuleCreate) (daos.ACLRule, error) {
+	scope := strings.ToLower(create.Scope)
+	if scope != daos.ACLScopeAuthoritative && scope != daos.ACLScopeRecursion {
+		return daos.ACLRule{}, invalid("scope", "invalid scope %q, expected %s or %s", create.Scope, daos.ACLScopeAuthoritative, daos.ACLScopeRecursion)
+	}
+	if scope == daos.ACLScopeRecursion && create.ZoneID != "" {
+		return daos.ACLRule{}, invalid("zoneID", "recursion rules apply to all zones and cannot have a zone")
+	}
+	action := strings.ToLower(create.Action)
+	if action != daos.ACLAllow && action != daos.ACLDeny {
+		return daos.ACLRule{}, invalid("action", "invalid action %q, expected %s or %s", create.Action, daos.ACLAllow, daos.ACLDeny)
+	}
+	cidr, err := parseCIDR(create.CIDR)
+	if err != nil {
+		return daos.ACLRule{}, invalid("cidr", "%v", err)
+	}
+	if create.ZoneID != "" {
+		if err := as.db.Where("id = ?", create.ZoneID).First(&data.Zone{}).Error; err != nil {
+			return daos.ACLRule{}, invalid("zoneID", "unknown zone %q", create.ZoneID)
+		}
+	}
+
+	rule := data.ACLRule{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		ZoneID: create.ZoneID,
+		Scope:  scope,
+		Action: action,
+		CIDR:   cidr,
+	}
+	if err := as.db.Create(&rule).Error; err != nil {
+		return daos.ACLRule{}, err
+	}
+	return rule.ToACLRule(), nil
+}
+
+func (as *ACLService) DeleteRule(ruleId string) error {
+	return deleted(as.db.Where("id = ?", ruleId).Delete(&data.ACLRule{}))
+}
+
+func (as *ACLService) GetRule(ruleId string) (*daos.ACLRule, error) {
+	var rule data.ACLRule
+	if err := as.db.Where("id = ?", ruleId).First(&rule).Error; err != nil {
+		return nil, err
+	}
+	aclRule := rule.ToACLRule()
+	return &aclRule, nil
+}
+
+func (as *ACLService) GetRules() ([]daos.ACLRule, error) {
+	var rules []data.ACLRule
+
+	if err := as.db.Order("created_at").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.ACLRule
+	for _, rule := range rules {
+		toRet = append(toRet, rule.ToACLRule())
+	}
+	return toRet, nil
+}
+
+// GetZoneRules returns the rules of a zone, or the global rules when zoneId is empty
+func (as *ACLService) GetZoneRules(zoneId string) ([]daos.ACLRule, error) {
+	var rules []data.ACLRule
+	if err := as.db.Where("zone_id = ?", zoneId).Order("created_at").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.ACLRule
+	for _, rule := range rules {
+		toRet = append(toRet, rule.ToACLRule())
+	}
+	return toRet, nil
+}
+
+// parseCIDR normalizes a network or a single address to CIDR notation
+func parseCIDR(value string) (string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid network %q", value)
+	}
+	return network.String(), nil
+}
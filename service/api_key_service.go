@@ -0,0 +1,149 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"dnsServer/daos"
+	"dnsServer/data"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// apiKeyTokenPrefix starts every API key token, so leaked tokens are easy to spot
+const apiKeyTokenPrefix = "dnsk_"
+
+// ErrInvalidAPIKey is returned for tokens of no key or of an expired one
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// CreateKey stores a new API key with a random token. The token is only
+// returned by this call.
+func (ks *APIKeyService) CreateKey(create daos.APIKeyCreate) (daos.APIKey, error) {
+	if create.Name == "" {
+		return daos.APIKey{}, invalid("name", "an API key needs a name")
+	}
+	if !ValidAPIScope(create.Scope) {
+		return daos.APIKey{}, invalid("scope", "unknown scope %q", create.Scope)
+	}
+	if create.UserID != "" {
+		if err := ks.db.Where("id = ?", create.UserID).First(&data.User{}).Error; err != nil {
+			return daos.APIKey{}, invalid("userID", "unknown user %s", create.UserID)
+		}
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return daos.APIKey{}, err
+	}
+	token := apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	key := data.APIKey{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		Name:      create.Name,
+		Scope:     create.Scope,
+		Prefix:    token[:len(apiKeyTokenPrefix)+6],
+		Hash:      hashToken(token),
+		ExpiresAt: create.ExpiresAt,
+		UserID:    create.UserID,
+	}
+	if err := ks.db.Create(&key).Error; err != nil {
+		return daos.APIKey{}, err
+	}
+	created := key.ToAPIKey()
+	created.Token = token
+	return created, nil
+}
+
+// BootstrapKey makes token a global admin key named name, so the first keys
+// can be created through the API
+func (ks *APIKeyService) BootstrapKey(name string, token string) error {
+	var key data.APIKey
+	err := ks.db.Where("name = ?", name).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		key = data.APIKey{Base: data.Base{ID: uuid.NewString()}, Name: name}
+	} else if err != nil {
+		return err
+	}
+	key.Scope = daos.APIScopeGlobalAdmin
+	key.Prefix = token[:min(len(token), len(apiKeyTokenPrefix)+6)]
+	key.Hash = hashToken(token)
+	key.ExpiresAt = nil
+	return ks.db.Save(&key).Error
+}
+
+func (ks *APIKeyService) DeleteKey(keyId string) error {
+	return deleted(ks.db.Where("id = ?", keyId).Delete(&data.APIKey{}))
+}
+
+func (ks *APIKeyService) GetKey(keyId string) (*daos.APIKey, error) {
+	var key data.APIKey
+	if err := ks.db.Where("id = ?", keyId).First(&key).Error; err != nil {
+		return nil, err
+	}
+	apiKey := key.ToAPIKey()
+	return &apiKey, nil
+}
+
+func (ks *APIKeyService) GetKeys() ([]daos.APIKey, error) {
+	var keys []data.APIKey
+
+	if err := ks.db.Order("name").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.APIKey
+	for _, key := range keys {
+		toRet = append(toRet, key.ToAPIKey())
+	}
+	return toRet, nil
+}
+
+// Authenticate returns the key a token belongs to, or ErrInvalidAPIKey
+func (ks *APIKeyService) Authenticate(token string) (*daos.APIKey, error) {
+	var key data.APIKey
+	err := ks.db.Where("hash = ?", hashToken(token)).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey := key.ToAPIKey()
+	return &apiKey, nil
+}
+
+// ValidAPIScope reports whether scope is one of the API key scopes
+func ValidAPIScope(scope string) bool {
+	switch scope {
+	case daos.APIScopeReadOnly, daos.APIScopeZoneAdmin, daos.APIScopeGlobalAdmin:
+		return true
+	}
+	return false
+}
+
+// APIScopeAllows reports whether a key of the scope may do what required does
+func APIScopeAllows(scope string, required string) bool {
+	rank := map[string]int{daos.APIScopeReadOnly: 1, daos.APIScopeZoneAdmin: 2, daos.APIScopeGlobalAdmin: 3}
+	return rank[scope] > 0 && rank[scope] >= rank[required]
+}
+
+// hashToken hashes a token for storage. Tokens are random enough for a
+// plain hash, and it lets keys be looked up by token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
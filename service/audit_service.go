@@ -0,0 +1,90 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"encoding/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Entries returned by GetEntries when the filter sets no limit, and the most
+// it returns whatever the limit
+const (
+	defaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+type AuditService struct {
+	db *gorm.DB
+}
+
+func NewAuditService(db *gorm.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// GetEntries returns the audit entries matching the filter, most recent first
+func (as *AuditService) GetEntries(filter daos.AuditFilter) ([]daos.AuditEntry, error) {
+	query := as.db.Order("created_at DESC")
+	if filter.ZoneID != "" {
+		query = query.Where("zone_id = ?", filter.ZoneID)
+	}
+	if filter.ZoneIDs != nil {
+		query = query.Where("zone_id IN ?", filter.ZoneIDs)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	limit = min(limit, MaxAuditLimit)
+
+	var entries []data.AuditEntry
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	toRet := []daos.AuditEntry{}
+	for _, entry := range entries {
+		toRet = append(toRet, entry.ToAuditEntry())
+	}
+	return toRet, nil
+}
+
+// audit records a change in the audit log, along with the resource before
+// and after it, nil when it did not exist
+func audit(db *gorm.DB, actor daos.Actor, action string, resourceType string, zoneId string, resourceId string, before any, after any) error {
+	entry := data.AuditEntry{
+		Base:         data.Base{ID: uuid.NewString()},
+		Actor:        actor.Name,
+		Source:       actor.Source,
+		SourceIP:     actor.SourceIP,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceId,
+		ZoneID:       zoneId,
+	}
+	var err error
+	if entry.Before, err = auditJSON(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditJSON(after); err != nil {
+		return err
+	}
+	return db.Create(&entry).Error
+}
+
+func auditJSON(resource any) (string, error) {
+	if resource == nil {
+		return "", nil
+	}
+	raw, err := json.Marshal(resource)
+	return string(raw), err
+}
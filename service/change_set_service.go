@@ -0,0 +1,71 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ChangeSetService applies batches of record changes and keeps their outcome
+// for clients to poll
+type ChangeSetService struct {
+	db            *gorm.DB
+	recordService *RecordService
+}
+
+func NewChangeSetService(db *gorm.DB, recordService *RecordService) *ChangeSetService {
+	return &ChangeSetService{db: db, recordService: recordService}
+}
+
+// Submit records a change set as pending and applies it, returning it with
+// its outcome. The error is that of applying it when it failed, the change
+// set keeping only the message clients may see.
+func (cs *ChangeSetService) Submit(actor daos.Actor, zoneId string, create daos.ChangeSetCreate) (daos.ChangeSet, error) {
+	changeSet := data.ChangeSet{
+		Base:    data.Base{ID: uuid.NewString()},
+		ZoneID:  zoneId,
+		Status:  daos.ChangeSetPending,
+		Comment: create.Comment,
+		Actor:   actor.Name,
+		Changes: create.Changes,
+	}
+	if err := cs.db.Create(&changeSet).Error; err != nil {
+		return daos.ChangeSet{}, err
+	}
+
+	serial, records, applyErr := cs.recordService.ApplyChanges(actor, zoneId, create.Changes)
+	if applyErr != nil {
+		changeSet.Status, changeSet.Error = daos.ChangeSetFailed, ErrorMessage(applyErr)
+	} else {
+		now := time.Now()
+		changeSet.Status, changeSet.Serial, changeSet.Records, changeSet.AppliedAt = daos.ChangeSetApplied, serial, records, &now
+	}
+	if err := cs.db.Save(&changeSet).Error; err != nil {
+		return changeSet.ToChangeSet(), err
+	}
+	return changeSet.ToChangeSet(), applyErr
+}
+
+func (cs *ChangeSetService) GetChangeSet(id string) (*daos.ChangeSet, error) {
+	var changeSet data.ChangeSet
+	if err := cs.db.Where("id = ?", id).First(&changeSet).Error; err != nil {
+		return nil, err
+	}
+	toRet := changeSet.ToChangeSet()
+	return &toRet, nil
+}
+
+// GetChangeSets returns the most recent change sets of a zone, newest first
+func (cs *ChangeSetService) GetChangeSets(zoneId string) ([]daos.ChangeSet, error) {
+	var changeSets []data.ChangeSet
+	if err := cs.db.Where("zone_id = ?", zoneId).Order("created_at DESC").Limit(100).Find(&changeSets).Error; err != nil {
+		return nil, err
+	}
+	toRet := []daos.ChangeSet{}
+	for _, changeSet := range changeSets {
+		toRet = append(toRet, changeSet.ToChangeSet())
+	}
+	return toRet, nil
+}
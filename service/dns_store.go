@@ -0,0 +1,138 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// DNSStore serves the hosted zones to the DNS server from the database
+type DNSStore struct {
+	db            *gorm.DB
+	recordService *RecordService
+	tsigService   *TSIGService
+	dnssecService *DNSSECService
+	aclService    *ACLService
+	viewService   *ViewService
+}
+
+func NewDNSStore(db *gorm.DB) *DNSStore {
+	return &DNSStore{
+		db:            db,
+		recordService: NewRecordService(db),
+		tsigService:   NewTSIGService(db),
+		dnssecService: NewDNSSECService(db),
+		aclService:    NewACLService(db),
+		viewService:   NewViewService(db),
+	}
+}
+
+// FindZone returns the most specific hosted zone containing name among the
+// zones of the view and those served to all clients, or nil if the name is
+// not part of any of them. A zone of the view wins over one of the same name.
+func (ds *DNSStore) FindZone(name string, viewId string) (*daos.DNSZone, error) {
+	var candidates []string
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	for i := range labels {
+		candidates = append(candidates, strings.Join(labels[i:], "."))
+	}
+
+	var zones []data.Zone
+	if err := ds.db.Where("lower(name) IN ? AND view_id IN ?", candidates, []string{"", viewId}).Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	var best *data.Zone
+	for i := range zones {
+		if best == nil || len(zones[i].Name) > len(best.Name) ||
+			(len(zones[i].Name) == len(best.Name) && zones[i].ViewID != "") {
+			best = &zones[i]
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	zone := best.ToDNSZone()
+	return &zone, nil
+}
+
+func (ds *DNSStore) GetRecords(zoneId string) ([]daos.DNSRecord, error) {
+	var records []data.Record
+	if err := ds.db.Where("zone_id = ?", zoneId).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.DNSRecord
+	for _, record := range records {
+		toRet = append(toRet, record.ToDNSRecord())
+	}
+	return toRet, nil
+}
+
+func (ds *DNSStore) UpdateRecords(actor daos.Actor, zoneId string, plan func(records []daos.DNSRecord) ([]daos.RecordOperation, error)) error {
+	_, _, err := ds.recordService.ApplyPlannedChanges(actor, zoneId, plan)
+	return err
+}
+
+func (ds *DNSStore) GetTSIGKey(name string) (*utils.TSIGKey, error) {
+	key, err := ds.tsigService.GetSigningKey(name)
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (ds *DNSStore) GetTSIGPolicies(zoneId string) ([]daos.TSIGPolicy, error) {
+	return ds.tsigService.GetPolicies(zoneId)
+}
+
+func (ds *DNSStore) GetDNSSECKeys(zoneId string) ([]utils.SigningKey, error) {
+	return ds.dnssecService.GetSigningKeys(zoneId)
+}
+
+func (ds *DNSStore) GetACLRules(zoneId string) ([]daos.ACLRule, error) {
+	return ds.aclService.GetZoneRules(zoneId)
+}
+
+func (ds *DNSStore) GetViews() ([]daos.View, error) {
+	return ds.viewService.GetViews()
+}
+
+// OnChange calls changed after each write to the zones, records, DNSSEC keys,
+// ACL rules, views or TSIG keys and policies made through the database of the store, by the API, the key
+// manager or dynamic updates alike. Writes of unknown tables are taken as
+// changes too.
+func (ds *DNSStore) OnChange(changed func()) {
+	notify := func(tx *gorm.DB) {
+		if tx.Error != nil {
+			return
+		}
+		if tx.Statement.Schema != nil {
+			switch tx.Statement.Schema.Name {
+			case "Zone", "Record", "DNSSECKey", "ACLRule", "View", "TSIGKey", "TSIGPolicy":
+			default:
+				return
+			}
+		}
+		changed()
+	}
+	name := "dns_store:changed:" + uuid.NewString()
+	callbacks := ds.db.Callback()
+	callbacks.Create().After("gorm:create").Register(name, notify)
+	callbacks.Update().After("gorm:update").Register(name, notify)
+	callbacks.Delete().After("gorm:delete").Register(name, notify)
+	callbacks.Raw().After("gorm:raw").Register(name, notify)
+}
+
+func (ds *DNSStore) GetHealthChecks() ([]daos.DNSRecord, error) {
+	var records []data.Record
+	if err := ds.db.Where("health_check IS NOT NULL").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.DNSRecord
+	for _, record := range records {
+		toRet = append(toRet, record.ToDNSRecord())
+	}
+	return toRet, nil
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+type DNSSECService struct {
+	db *gorm.DB
+}
+
+func NewDNSSECService(db *gorm.DB) *DNSSECService {
+	return &DNSSECService{db: db}
+}
+
+// maxNSEC3Iterations caps the hashing work, RFC 9276 recommends 0
+const maxNSEC3Iterations = 100
+
+// Rollover schedule of zones signed without an explicit one
+const (
+	defaultZSKLifetimeDays = 30
+	defaultKSKLifetimeDays = 365
+)
+
+// EnableDNSSEC generates a key signing key and a zone signing key for the
+// zone and starts signing it. Enabling an already signed zone keeps its keys
+// and only changes its denial of existence settings.
+func (ds *DNSSECService) EnableDNSSEC(zoneId string, enable daos.DNSSECEnable) (*daos.DNSSECStatus, error) {
+	algorithm := utils.AlgorithmECDSAP256SHA256
+	if enable.Algorithm != "" {
+		var ok bool
+		if algorithm, ok = utils.ParseDNSSECAlgorithm(enable.Algorithm); !ok {
+			return nil, invalid("algorithm", "unsupported DNSSEC algorithm %q", enable.Algorithm)
+		}
+	}
+	settings := map[string]any{"NSEC3Enabled": false, "NSEC3Salt": "", "NSEC3Iterations": 0, "NSEC3OptOut": false}
+	if enable.NSEC3 != nil {
+		if _, err := hex.DecodeString(enable.NSEC3.Salt); err != nil || len(enable.NSEC3.Salt) > 510 {
+			return nil, invalid("nsec3.salt", "invalid NSEC3 salt %q, expected up to 255 hex encoded bytes", enable.NSEC3.Salt)
+		}
+		if enable.NSEC3.Iterations > maxNSEC3Iterations {
+			return nil, invalid("nsec3.iterations", "NSEC3 iterations must be at most %d", maxNSEC3Iterations)
+		}
+		settings = map[string]any{
+			"NSEC3Enabled":    true,
+			"NSEC3Salt":       strings.ToLower(enable.NSEC3.Salt),
+			"NSEC3Iterations": enable.NSEC3.Iterations,
+			"NSEC3OptOut":     enable.NSEC3.OptOut,
+		}
+	}
+	if enable.Rollover != nil {
+		if enable.Rollover.ZSKLifetimeDays < 0 || enable.Rollover.KSKLifetimeDays < 0 {
+			return nil, invalid("rollover", "key lifetimes must not be negative")
+		}
+		settings["ZSKLifetimeDays"] = enable.Rollover.ZSKLifetimeDays
+		settings["KSKLifetimeDays"] = enable.Rollover.KSKLifetimeDays
+	}
+	settings["Serial"] = gorm.Expr("serial + 1")
+
+	var zone data.Zone
+	if err := ds.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return nil, err
+	}
+	if zone.DNSSECEnabled {
+		if err := ds.db.Model(&data.Zone{}).Where("id = ?", zoneId).Updates(settings).Error; err != nil {
+			return nil, err
+		}
+		return ds.GetStatus(zoneId)
+	}
+
+	if enable.Rollover == nil {
+		settings["ZSKLifetimeDays"] = defaultZSKLifetimeDays
+		settings["KSKLifetimeDays"] = defaultKSKLifetimeDays
+	}
+	now := time.Now()
+	err := ds.db.Transaction(func(tx *gorm.DB) error {
+		for _, flags := range []uint16{utils.DNSKEYFlagZone | utils.DNSKEYFlagSEP, utils.DNSKEYFlagZone} {
+			key, err := utils.GenerateSigningKey(algorithm, flags)
+			if err != nil {
+				return err
+			}
+			dnssecKey, err := newDNSSECKey(zoneId, key)
+			if err != nil {
+				return err
+			}
+			dnssecKey.PublishedAt = &now
+			dnssecKey.ActivatedAt = &now
+			if err := tx.Create(dnssecKey).Error; err != nil {
+				return err
+			}
+		}
+		settings["DNSSECEnabled"] = true
+		return tx.Model(&data.Zone{}).Where("id = ?", zoneId).Updates(settings).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds.GetStatus(zoneId)
+}
+
+// DisableDNSSEC stops signing the zone and deletes its keys
+func (ds *DNSSECService) DisableDNSSEC(zoneId string) error {
+	return ds.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("zone_id = ?", zoneId).Delete(&data.DNSSECKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&data.Zone{}).Where("id = ?", zoneId).Updates(map[string]any{
+			"DNSSECEnabled": false,
+			"NSEC3Enabled":  false,
+			"Serial":        gorm.Expr("serial + 1"),
+		}).Error
+	})
+}
+
+// GetStatus returns the keys of the zone, the DS records for its parent and
+// the timeline of its key rollovers
+func (ds *DNSSECService) GetStatus(zoneId string) (*daos.DNSSECStatus, error) {
+	var zone data.Zone
+	if err := ds.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return nil, err
+	}
+	var keys []data.DNSSECKey
+	if err := ds.db.Where("zone_id = ?", zoneId).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	status := &daos.DNSSECStatus{ZoneID: zoneId, Enabled: zone.DNSSECEnabled, NSEC3: zone.ToDNSZone().NSEC3}
+	if zone.DNSSECEnabled {
+		status.Rollover = &daos.RolloverPolicy{ZSKLifetimeDays: zone.ZSKLifetimeDays, KSKLifetimeDays: zone.KSKLifetimeDays}
+	}
+	for _, key := range keys {
+		status.Keys = append(status.Keys, key.ToDNSSECKey())
+		if key.Flags&utils.DNSKEYFlagSEP == 0 || key.State != data.KeyStateActive {
+			continue
+		}
+		publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		dnskey := utils.DNSKEY{Flags: key.Flags, Protocol: 3, Algorithm: key.Algorithm, PublicKey: publicKey}
+		name := strings.TrimSuffix(zone.Name, ".")
+		status.DS = append(status.DS, name+". IN DS "+dnskey.DS(name).String())
+	}
+
+	maxTTL, err := maxZoneTTL(ds.db, zoneId)
+	if err != nil {
+		return nil, err
+	}
+	status.Timeline = rolloverTimeline(zone, keys, maxTTL)
+	return status, nil
+}
+
+// StartRollover introduces a new key of the given type right away instead
+// of waiting for the schedule: a published ZSK or a KSK double signing the
+// key set. The key manager completes the rollover.
+func (ds *DNSSECService) StartRollover(zoneId string, rollover daos.DNSSECRollover) (*daos.DNSSECStatus, error) {
+	var ksk bool
+	switch strings.ToUpper(rollover.KeyType) {
+	case "KSK":
+		ksk = true
+	case "ZSK":
+	default:
+		return nil, invalid("keyType", "unsupported key type %q, expected KSK or ZSK", rollover.KeyType)
+	}
+	var zone data.Zone
+	if err := ds.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return nil, err
+	}
+	if !zone.DNSSECEnabled {
+		return nil, conflict("DNSSEC is not enabled for zone %s", zone.Name)
+	}
+	var keys []data.DNSSECKey
+	if err := ds.db.Where("zone_id = ? AND state <> ?", zoneId, data.KeyStateRemoved).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	if rolloverInProgress(keys, ksk) {
+		return nil, conflict("a %s rollover is already in progress", strings.ToUpper(rollover.KeyType))
+	}
+	err := ds.db.Transaction(func(tx *gorm.DB) error {
+		if err := introduceKey(tx, zoneId, keys, ksk, time.Now()); err != nil {
+			return err
+		}
+		return tx.Model(&data.Zone{}).Where("id = ?", zoneId).Update("Serial", gorm.Expr("serial + 1")).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds.GetStatus(zoneId)
+}
+
+// ConfirmDS records that the parent zone publishes the DS of the active KSK
+// with the key tag, letting the key manager remove the KSK it replaces
+func (ds *DNSSECService) ConfirmDS(zoneId string, seen daos.DSSeen) (*daos.DNSSECStatus, error) {
+	var zone data.Zone
+	if err := ds.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return nil, err
+	}
+	if !zone.DNSSECEnabled {
+		return nil, conflict("DNSSEC is not enabled for zone %s", zone.Name)
+	}
+	res := ds.db.Model(&data.DNSSECKey{}).
+		Where("zone_id = ? AND key_tag = ? AND state = ? AND flags & ? <> 0", zoneId, seen.KeyTag, data.KeyStateActive, utils.DNSKEYFlagSEP).
+		Update("DSSeenAt", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, invalid("keyTag", "zone %s has no active KSK with key tag %d", zone.Name, seen.KeyTag)
+	}
+	return ds.GetStatus(zoneId)
+}
+
+// GetSigningKeys returns the keys published in the zone, including their
+// private part
+func (ds *DNSSECService) GetSigningKeys(zoneId string) ([]utils.SigningKey, error) {
+	var keys []data.DNSSECKey
+	if err := ds.db.Where("zone_id = ? AND state <> ?", zoneId, data.KeyStateRemoved).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	var toRet []utils.SigningKey
+	for _, key := range keys {
+		signingKey, err := utils.ParseSigningKey(key.Algorithm, key.Flags, key.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		switch key.State {
+		case data.KeyStatePublished:
+			signingKey.State = utils.KeyPublished
+		case data.KeyStateRetired:
+			signingKey.State = utils.KeyRetired
+		}
+		toRet = append(toRet, *signingKey)
+	}
+	return toRet, nil
+}
+
+func newDNSSECKey(zoneId string, key *utils.SigningKey) (*data.DNSSECKey, error) {
+	privateKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &data.DNSSECKey{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		ZoneID:     zoneId,
+		Flags:      key.Flags,
+		Algorithm:  key.Algorithm,
+		KeyTag:     key.KeyTag(),
+		PublicKey:  base64.StdEncoding.EncodeToString(key.PublicKey),
+		PrivateKey: privateKey,
+		State:      data.KeyStateActive,
+	}, nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// ValidationError is returned for requests with invalid fields
+type ValidationError struct {
+	Fields []daos.FieldError
+}
+
+func (err *ValidationError) Error() string {
+	messages := []string{}
+	for _, field := range err.Fields {
+		messages = append(messages, field.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// invalid returns a validation error for a field, empty for the whole request
+func invalid(field string, format string, args ...any) *ValidationError {
+	return &ValidationError{Fields: []daos.FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// in puts the fields of a validation error under the path of the object
+// they belong to, e.g. "changes[2]"
+func (err *ValidationError) in(path string) *ValidationError {
+	fields := make([]daos.FieldError, len(err.Fields))
+	for i, field := range err.Fields {
+		fields[i] = daos.FieldError{Field: path, Message: field.Message}
+		if field.Field != "" {
+			fields[i].Field = path + "." + field.Field
+		}
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// ConflictError is returned for changes the current state of a resource
+// does not allow
+type ConflictError struct {
+	Message string
+}
+
+func (err *ConflictError) Error() string {
+	return err.Message
+}
+
+func conflict(format string, args ...any) *ConflictError {
+	return &ConflictError{Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorMessage is the message clients see for an error returned by a
+// service. Unexpected errors, which may tell about the internals of the
+// server, get a generic message.
+func ErrorMessage(err error) string {
+	var validation *ValidationError
+	var versionConflict *VersionConflictError
+	var conflictError *ConflictError
+	switch {
+	case errors.As(err, &validation):
+		return validation.Error()
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return "Not found"
+	case errors.As(err, &versionConflict):
+		return versionConflict.Error()
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return "An item with the same name already exists"
+	case errors.As(err, &conflictError), errors.Is(err, ErrViewInUse),
+		errors.Is(err, ErrInvalidChangeSet), errors.Is(err, ErrInvalidZoneFile):
+		return err.Error()
+	}
+	return "Internal Server Error"
+}
+
+// deleted returns the error of a deletion, not found when nothing was deleted
+func deleted(res *gorm.DB) error {
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return res.Error
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sort"
+)
+
+// HistoryService shows past versions of zones and rolls zones back to them
+type HistoryService struct {
+	db *gorm.DB
+}
+
+func NewHistoryService(db *gorm.DB) *HistoryService {
+	return &HistoryService{db: db}
+}
+
+// GetVersions returns the versions of the records of a zone, or of a single
+// record when recordId is set, most recent first
+func (hs *HistoryService) GetVersions(zoneId string, recordId string) ([]daos.RecordVersion, error) {
+	query := hs.db.Where("zone_id = ?", zoneId)
+	if recordId != "" {
+		query = query.Where("record_id = ?", recordId)
+	}
+	var versions []data.RecordVersion
+	if err := query.Order("serial DESC, created_at DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	toRet := []daos.RecordVersion{}
+	for _, version := range versions {
+		toRet = append(toRet, version.ToRecordVersion())
+	}
+	return toRet, nil
+}
+
+// Snapshot returns the records of a zone as they were at a version
+func (hs *HistoryService) Snapshot(zoneId string, version daos.ZoneVersion) (daos.ZoneSnapshot, error) {
+	records, serial, err := zoneState(hs.db, zoneId, version)
+	if err != nil {
+		return daos.ZoneSnapshot{}, err
+	}
+	return daos.ZoneSnapshot{ZoneID: zoneId, Serial: serial, Records: sortedRecords(records)}, nil
+}
+
+// Diff returns the changes that turned a version of a zone into another
+func (hs *HistoryService) Diff(zoneId string, from daos.ZoneVersion, to daos.ZoneVersion) (daos.ZoneDiff, error) {
+	before, fromSerial, err := zoneState(hs.db, zoneId, from)
+	if err != nil {
+		return daos.ZoneDiff{}, err
+	}
+	after, toSerial, err := zoneState(hs.db, zoneId, to)
+	if err != nil {
+		return daos.ZoneDiff{}, err
+	}
+	diff := diffRecords(before, after)
+	diff.FromSerial, diff.ToSerial = fromSerial, toSerial
+	return diff, nil
+}
+
+// Rollback brings the records of a zone back to a previous version in a
+// single transaction, as a new version with its own serial
+func (hs *HistoryService) Rollback(actor daos.Actor, zoneId string, version daos.ZoneVersion) (daos.ZoneDiff, error) {
+	var diff daos.ZoneDiff
+	err := hs.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the zone first keeps concurrent changes from slipping
+		// between the states read and the rollback
+		var zone data.Zone
+		if err := forUpdate(tx).Where("id = ?", zoneId).First(&zone).Error; err != nil {
+			return err
+		}
+		current, currentSerial, err := zoneState(tx, zoneId, daos.ZoneVersion{})
+		if err != nil {
+			return err
+		}
+		target, _, err := zoneState(tx, zoneId, version)
+		if err != nil {
+			return err
+		}
+		diff = diffRecords(current, target)
+		serial, err := bumpSerial(tx, zoneId)
+		if err != nil {
+			return err
+		}
+		diff.FromSerial, diff.ToSerial = currentSerial, serial
+
+		for _, removed := range diff.Removed {
+			record := current[removed.ID]
+			if err := tx.Delete(&record).Error; err != nil {
+				return err
+			}
+			if err := recordChange(tx, actor, serial, &record, nil); err != nil {
+				return err
+			}
+		}
+		for _, added := range diff.Added {
+			// Restore the soft deleted record
+			record, err := restoreRecord(tx, target[added.ID])
+			if err != nil {
+				return err
+			}
+			if err := recordChange(tx, actor, serial, nil, &record); err != nil {
+				return err
+			}
+		}
+		for _, changed := range diff.Changed {
+			before := current[changed.Before.ID]
+			record, err := restoreRecord(tx, target[changed.After.ID])
+			if err != nil {
+				return err
+			}
+			if err := recordChange(tx, actor, serial, &before, &record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return diff, err
+}
+
+// restoreRecord saves a past state of a record over its row, undeleting it
+func restoreRecord(tx *gorm.DB, past data.Record) (data.Record, error) {
+	var record data.Record
+	err := tx.Unscoped().Where("id = ?", past.ID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return past, tx.Create(&past).Error
+	}
+	if err != nil {
+		return record, err
+	}
+	record.DeletedAt = gorm.DeletedAt{}
+	record.Name, record.Type, record.Value, record.TTL = past.Name, past.Type, past.Value, past.TTL
+	record.Steering, record.HealthCheck = past.Steering, past.HealthCheck
+	record.Version++
+	return record, tx.Unscoped().Save(&record).Error
+}
+
+// zoneState returns the records of a zone at a version, by ID, and the
+// serial of the zone then. Records changed before history was kept have no
+// versions: they count as they are now, from their creation to their
+// deletion.
+func zoneState(db *gorm.DB, zoneId string, version daos.ZoneVersion) (map[string]data.Record, uint32, error) {
+	var zone data.Zone
+	if err := db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return nil, 0, err
+	}
+	var records []data.Record
+	if err := db.Unscoped().Where("zone_id = ?", zoneId).Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+	state := map[string]data.Record{}
+	if version.Serial == nil && version.At == nil {
+		for _, record := range records {
+			if !record.DeletedAt.Valid {
+				state[record.ID] = record
+			}
+		}
+		return state, zone.Serial, nil
+	}
+
+	var versioned []string
+	if err := db.Model(&data.RecordVersion{}).Where("zone_id = ?", zoneId).Distinct().Pluck("record_id", &versioned).Error; err != nil {
+		return nil, 0, err
+	}
+	hasVersions := map[string]bool{}
+	for _, recordId := range versioned {
+		hasVersions[recordId] = true
+	}
+	for _, record := range records {
+		if hasVersions[record.ID] {
+			continue
+		}
+		if version.Serial != nil && !record.DeletedAt.Valid {
+			state[record.ID] = record
+		}
+		if version.At != nil && !record.CreatedAt.After(*version.At) &&
+			(!record.DeletedAt.Valid || record.DeletedAt.Time.After(*version.At)) {
+			state[record.ID] = record
+		}
+	}
+
+	query := db.Where("zone_id = ?", zoneId)
+	serial := uint32(1)
+	if version.Serial != nil {
+		query = query.Where("serial <= ?", *version.Serial)
+		serial = *version.Serial
+	} else {
+		query = query.Where("created_at <= ?", *version.At)
+	}
+	var versions []data.RecordVersion
+	if err := query.Order("serial, created_at").Find(&versions).Error; err != nil {
+		return nil, 0, err
+	}
+	for _, recordVersion := range versions {
+		if recordVersion.Deleted {
+			delete(state, recordVersion.RecordID)
+		} else {
+			state[recordVersion.RecordID] = recordVersion.ToRecord()
+		}
+		if version.Serial == nil {
+			serial = recordVersion.Serial
+		}
+	}
+	return state, serial, nil
+}
+
+// diffRecords returns the records added, removed and changed from before to after
+func diffRecords(before map[string]data.Record, after map[string]data.Record) daos.ZoneDiff {
+	diff := daos.ZoneDiff{Added: []daos.DNSRecord{}, Removed: []daos.DNSRecord{}, Changed: []daos.RecordChange{}}
+	for _, record := range sortedRecords(before) {
+		updated, kept := after[record.ID]
+		if !kept {
+			diff.Removed = append(diff.Removed, record)
+			continue
+		}
+		if !sameRecord(record, updated.ToDNSRecord()) {
+			diff.Changed = append(diff.Changed, daos.RecordChange{Before: record, After: updated.ToDNSRecord()})
+		}
+	}
+	for _, record := range sortedRecords(after) {
+		if _, existed := before[record.ID]; !existed {
+			diff.Added = append(diff.Added, record)
+		}
+	}
+	return diff
+}
+
+// sameRecord compares records whatever their versions, which past states
+// of records do not have
+func sameRecord(a daos.DNSRecord, b daos.DNSRecord) bool {
+	a.Version, b.Version = 0, 0
+	rawA, _ := json.Marshal(a)
+	rawB, _ := json.Marshal(b)
+	return string(rawA) == string(rawB)
+}
+
+// sortedRecords returns records in a stable order, by name, type and value
+func sortedRecords(records map[string]data.Record) []daos.DNSRecord {
+	toRet := []daos.DNSRecord{}
+	for _, record := range records {
+		toRet = append(toRet, record.ToDNSRecord())
+	}
+	sort.Slice(toRet, func(i, j int) bool {
+		a, b := toRet[i], toRet[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.ID < b.ID
+	})
+	return toRet
+}
+
+// recordChange keeps track of a change to a record made in the zone of the
+// serial: it stores the new version of the record and audits the change.
+// before is nil for created records and after for deleted ones.
+func recordChange(tx *gorm.DB, actor daos.Actor, serial uint32, before *data.Record, after *data.Record) error {
+	current, action := after, daos.AuditUpdate
+	var beforeRecord, afterRecord any
+	if before == nil {
+		action = daos.AuditCreate
+	} else {
+		beforeRecord = before.ToDNSRecord()
+	}
+	if after == nil {
+		current, action = before, daos.AuditDelete
+	} else {
+		afterRecord = after.ToDNSRecord()
+	}
+	version := data.RecordVersion{
+		Base:        data.Base{ID: uuid.NewString()},
+		RecordID:    current.ID,
+		ZoneID:      current.ZoneID,
+		Serial:      serial,
+		Deleted:     after == nil,
+		Name:        current.Name,
+		Type:        current.Type,
+		Value:       current.Value,
+		TTL:         current.TTL,
+		Steering:    current.Steering,
+		HealthCheck: current.HealthCheck,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return err
+	}
+	return audit(tx, actor, action, daos.AuditRecord, current.ZoneID, current.ID, beforeRecord, afterRecord)
+}
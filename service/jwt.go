@@ -0,0 +1,276 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// jwtLeeway tolerates clock differences with the identity provider
+const jwtLeeway = time.Minute
+
+// ErrInvalidToken is returned for JWTs that are malformed, badly signed,
+// expired or issued for someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwk is a key of a JSON Web Key Set (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of a key set by key ID
+func parseJWKS(raw []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		public, err := key.publicKey()
+		if err != nil {
+			fmt.Println("Error: skipping JWK", key.Kid+":", err)
+			continue
+		}
+		keys[key.Kid] = public
+	}
+	return keys, nil
+}
+
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch key.Kty {
+	case "RSA":
+		n, err := decode(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(key.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decode(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return public, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decode(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+// jwtHeader is the JOSE header of a signed JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parseJWT splits a compact JWT into its header, claims and signature
+func parseJWT(token string) (jwtHeader, map[string]any, []byte, error) {
+	var header jwtHeader
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, ErrInvalidToken
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(rawHeader, &header) != nil {
+		return header, nil, nil, ErrInvalidToken
+	}
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, nil, ErrInvalidToken
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(rawClaims)))
+	decoder.UseNumber()
+	var claims map[string]any
+	if decoder.Decode(&claims) != nil {
+		return header, nil, nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, ErrInvalidToken
+	}
+	return header, claims, signature, nil
+}
+
+// verifyJWTSignature checks the signature of the first two parts of a
+// token with a key of the algorithm the header names
+func verifyJWTSignature(token string, alg string, signature []byte, key crypto.PublicKey) error {
+	signed := []byte(token[:strings.LastIndex(token, ".")])
+	digest := func(h hash.Hash) []byte {
+		h.Write(signed)
+		return h.Sum(nil)
+	}
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+		h := hashes[alg[2:]]
+		sum := digest(h.New())
+		if alg[0] == 'P' {
+			if rsa.VerifyPSS(public, h, sum, signature, nil) != nil {
+				return ErrInvalidToken
+			}
+			return nil
+		}
+		if rsa.VerifyPKCS1v15(public, h, sum, signature) != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	case "ES256", "ES384":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidToken
+		}
+		var sum []byte
+		if alg == "ES256" {
+			sum = digest(sha256.New())
+		} else {
+			sum = digest(sha512.New384())
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, sum, r, s) {
+			return ErrInvalidToken
+		}
+		return nil
+	case "EdDSA":
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			break
+		}
+		if !ed25519.Verify(public, signed, signature) {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: algorithm %q does not match the key", ErrInvalidToken, alg)
+}
+
+// checkJWTClaims checks the issuer, audience and validity period of a token.
+// Tokens without the audience are refused, as they may be meant for another
+// service trusting the same issuer.
+func checkJWTClaims(claims map[string]any, issuer string, audience string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidToken, iss)
+	}
+	if audience == "" || !hasAudience(claims["aud"], audience) {
+		return fmt.Errorf("%w: not issued for %q", ErrInvalidToken, audience)
+	}
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if now.After(exp.Add(jwtLeeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(jwtLeeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+func hasAudience(claim any, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericDate(claim any) (time.Time, bool) {
+	number, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// stringsClaim returns a claim holding a list of strings, or a single one
+func stringsClaim(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_CheckJWTClaims(t *testing.T) {
+	now := time.Now()
+	claims := func(aud any) map[string]any {
+		claims := map[string]any{"iss": "https://id.example.test", "exp": json.Number("9999999999")}
+		if aud != nil {
+			claims["aud"] = aud
+		}
+		return claims
+	}
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		audience string
+		valid    bool
+	}{
+		{"Audience", claims("dns-api"), "dns-api", true},
+		{"AudienceList", claims([]any{"other", "dns-api"}), "dns-api", true},
+		{"OtherAudience", claims("other"), "dns-api", false},
+		{"NoAudienceClaim", claims(nil), "dns-api", false},
+		{"NoAudienceExpected", claims("dns-api"), "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkJWTClaims(test.claims, "https://id.example.test", test.audience, now)
+			if test.valid && err != nil {
+				t.Errorf("Expected the claims to be valid, got %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected an invalid token, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_NewOIDCServiceNeedsAudience(t *testing.T) {
+	config := daos.OIDCConfig{Issuer: "https://id.example.test", JWKSFile: "jwks.json"}
+	if _, err := NewOIDCService(nil, config); err == nil {
+		t.Errorf("Expected a configuration without an audience to be refused")
+	}
+	config.Audience = "dns-api"
+	if _, err := NewOIDCService(nil, config); err != nil {
+		t.Errorf("Expected the configuration to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/utils"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+)
+
+// Timing of key rollovers. A new key must be in the caches of resolvers
+// before it is relied on, and an old one stay published until nothing cached
+// depends on it anymore.
+const (
+	keyManagerInterval = time.Minute
+	dnskeyTTL          = time.Hour      // TTL of the DNSKEY set served by the DNS server
+	propagationDelay   = time.Hour      // Time for secondaries to pick up a change
+	parentDSTTL        = 24 * time.Hour // Assumed TTL of the DS records at the parent
+)
+
+// KeyManager rolls the DNSSEC keys of signed zones over on their schedule:
+// ZSKs with a pre-publish rollover, KSKs with a double signature rollover
+type KeyManager struct {
+	db         *gorm.DB
+	stopSignal chan struct{}
+}
+
+func NewKeyManager(db *gorm.DB) *KeyManager {
+	return &KeyManager{db: db, stopSignal: make(chan struct{})}
+}
+
+func (km *KeyManager) Start() {
+	go func() {
+		ticker := time.NewTicker(keyManagerInterval)
+		defer ticker.Stop()
+		for {
+			if err := km.Rollover(time.Now()); err != nil {
+				fmt.Println("Error:", err)
+			}
+			select {
+			case <-km.stopSignal:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (km *KeyManager) Stop() {
+	km.stopSignal <- struct{}{}
+}
+
+// Rollover applies the key transitions due at now in every signed zone
+func (km *KeyManager) Rollover(now time.Time) error {
+	var zones []data.Zone
+	if err := km.db.Where(&data.Zone{DNSSECEnabled: true}).Find(&zones).Error; err != nil {
+		return err
+	}
+	for _, zone := range zones {
+		if err := km.rolloverZone(zone, now); err != nil {
+			return fmt.Errorf("rolling keys of zone %s over: %w", zone.Name, err)
+		}
+	}
+	return nil
+}
+
+func (km *KeyManager) rolloverZone(zone data.Zone, now time.Time) error {
+	return km.db.Transaction(func(tx *gorm.DB) error {
+		var keys []data.DNSSECKey
+		if err := tx.Where("zone_id = ? AND state <> ?", zone.ID, data.KeyStateRemoved).Order("created_at").Find(&keys).Error; err != nil {
+			return err
+		}
+		maxTTL, err := maxZoneTTL(tx, zone.ID)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, transition := range planRollover(zone, keys, maxTTL) {
+			if transition.at.After(now) {
+				continue
+			}
+			changed = true
+			if transition.key == nil {
+				err = introduceKey(tx, zone.ID, keys, transition.ksk, now)
+			} else {
+				err = setKeyState(tx, transition.key, transition.state, now)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if !changed {
+			return nil
+		}
+		// Secondaries pick the new key set up with the zone
+		return tx.Model(&data.Zone{}).Where("id = ?", zone.ID).Update("Serial", gorm.Expr("serial + 1")).Error
+	})
+}
+
+// keyTransition is the next state change of a key
+type keyTransition struct {
+	key   *data.DNSSECKey // nil when a new key is introduced
+	ksk   bool
+	state string
+	at    time.Time
+}
+
+// planRollover returns the next transition of every key of the zone that has
+// one, and when a new key is due
+func planRollover(zone data.Zone, keys []data.DNSSECKey, maxTTL time.Duration) []keyTransition {
+	var plan []keyTransition
+
+	// ZSKs are published ahead of their activation, so the DNSKEY set holding
+	// them is cached everywhere before signatures made with them show up.
+	// Retired ZSKs stay published until the signatures they made expire from caches.
+	for _, key := range keysIn(keys, false, data.KeyStateRetired) {
+		plan = append(plan, keyTransition{key: key, state: data.KeyStateRemoved, at: since(key).Add(maxTTL + propagationDelay)})
+	}
+	active := keysIn(keys, false, data.KeyStateActive)
+	if published := keysIn(keys, false, data.KeyStatePublished); len(published) > 0 {
+		activation := since(published[0]).Add(dnskeyTTL + propagationDelay)
+		plan = append(plan, keyTransition{key: published[0], state: data.KeyStateActive, at: activation})
+		for _, key := range active {
+			plan = append(plan, keyTransition{key: key, state: data.KeyStateRetired, at: activation})
+		}
+	} else if zone.ZSKLifetimeDays > 0 && len(active) > 0 {
+		lifetime := time.Duration(zone.ZSKLifetimeDays) * 24 * time.Hour
+		plan = append(plan, keyTransition{state: data.KeyStatePublished,
+			at: since(active[len(active)-1]).Add(lifetime - dnskeyTTL - propagationDelay)})
+	}
+
+	// A new KSK signs the DNSKEY set along with the old one. The old KSK is
+	// retired, dropping its CDS, once the new key set reached the caches. It
+	// keeps signing until the parent is confirmed to publish the DS of the
+	// new KSK and the old DS expired from caches, however long that takes.
+	active = keysIn(keys, true, data.KeyStateActive)
+	retired := keysIn(keys, true, data.KeyStateRetired)
+	if len(active) > 0 && active[len(active)-1].DSSeenAt != nil {
+		seen := *active[len(active)-1].DSSeenAt
+		for _, key := range retired {
+			removal := seen
+			if since(key).After(removal) {
+				removal = since(key)
+			}
+			plan = append(plan, keyTransition{key: key, ksk: true, state: data.KeyStateRemoved, at: removal.Add(parentDSTTL + propagationDelay)})
+		}
+	}
+	if len(active) > 1 {
+		retirement := since(active[len(active)-1]).Add(dnskeyTTL + propagationDelay)
+		for _, key := range active[:len(active)-1] {
+			plan = append(plan, keyTransition{key: key, ksk: true, state: data.KeyStateRetired, at: retirement})
+		}
+	} else if zone.KSKLifetimeDays > 0 && len(active) == 1 && len(retired) == 0 {
+		lifetime := time.Duration(zone.KSKLifetimeDays) * 24 * time.Hour
+		plan = append(plan, keyTransition{ksk: true, state: data.KeyStateActive, at: since(active[0]).Add(lifetime)})
+	}
+	return plan
+}
+
+// rolloverInProgress reports whether a new key of the type is being introduced
+func rolloverInProgress(keys []data.DNSSECKey, ksk bool) bool {
+	if ksk {
+		return len(keysIn(keys, true, data.KeyStateActive)) > 1 || len(keysIn(keys, true, data.KeyStateRetired)) > 0
+	}
+	return len(keysIn(keys, false, data.KeyStatePublished)) > 0
+}
+
+// introduceKey generates the next key of the type with the algorithm of the
+// current one: published for a ZSK, active right away for a KSK
+func introduceKey(tx *gorm.DB, zoneId string, keys []data.DNSSECKey, ksk bool, now time.Time) error {
+	algorithm := utils.AlgorithmECDSAP256SHA256
+	for _, key := range keys {
+		if isKSK(key) == ksk {
+			algorithm = key.Algorithm
+		}
+	}
+	flags := utils.DNSKEYFlagZone
+	if ksk {
+		flags |= utils.DNSKEYFlagSEP
+	}
+	key, err := utils.GenerateSigningKey(algorithm, flags)
+	if err != nil {
+		return err
+	}
+	dnssecKey, err := newDNSSECKey(zoneId, key)
+	if err != nil {
+		return err
+	}
+	dnssecKey.PublishedAt = &now
+	if ksk {
+		dnssecKey.ActivatedAt = &now
+	} else {
+		dnssecKey.State = data.KeyStatePublished
+	}
+	return tx.Create(dnssecKey).Error
+}
+
+func setKeyState(tx *gorm.DB, key *data.DNSSECKey, state string, now time.Time) error {
+	updates := map[string]any{"State": state}
+	switch state {
+	case data.KeyStateActive:
+		updates["ActivatedAt"] = now
+	case data.KeyStateRetired:
+		updates["RetiredAt"] = now
+	case data.KeyStateRemoved:
+		updates["RemovedAt"] = now
+		updates["PrivateKey"] = "" // Never used again
+	}
+	return tx.Model(&data.DNSSECKey{}).Where("id = ?", key.ID).Updates(updates).Error
+}
+
+// rolloverTimeline lists the state changes the keys went through and the
+// ones planned next
+func rolloverTimeline(zone data.Zone, keys []data.DNSSECKey, maxTTL time.Duration) []daos.DNSSECEvent {
+	var timeline []daos.DNSSECEvent
+	for _, key := range keys {
+		for _, event := range []struct {
+			state string
+			at    *time.Time
+		}{
+			{data.KeyStatePublished, key.PublishedAt},
+			{data.KeyStateActive, key.ActivatedAt},
+			{data.KeyStateRetired, key.RetiredAt},
+			{data.KeyStateRemoved, key.RemovedAt},
+		} {
+			if event.at != nil {
+				timeline = append(timeline, daos.DNSSECEvent{KeyTag: key.KeyTag, KeyType: keyType(isKSK(key)),
+					State: event.state, At: *event.at})
+			}
+		}
+	}
+	if zone.DNSSECEnabled {
+		for _, transition := range planRollover(zone, keys, maxTTL) {
+			event := daos.DNSSECEvent{KeyType: keyType(transition.ksk), State: transition.state, At: transition.at, Planned: true}
+			if transition.key != nil {
+				event.KeyTag = transition.key.KeyTag
+			}
+			timeline = append(timeline, event)
+		}
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].At.Before(timeline[j].At) })
+	return timeline
+}
+
+// maxZoneTTL returns the longest time records of the zone may be cached
+func maxZoneTTL(db *gorm.DB, zoneId string) (time.Duration, error) {
+	var maxTTL int
+	err := db.Model(&data.Record{}).Where("zone_id = ?", zoneId).Select("COALESCE(MAX(ttl), 0)").Scan(&maxTTL).Error
+	if err != nil {
+		return 0, err
+	}
+	// The SOA and DNSKEY records are served with a one hour TTL
+	return max(time.Duration(maxTTL)*time.Second, dnskeyTTL), nil
+}
+
+// keysIn returns the keys of the type in the state, oldest first
+func keysIn(keys []data.DNSSECKey, ksk bool, state string) []*data.DNSSECKey {
+	var found []*data.DNSSECKey
+	for i := range keys {
+		if isKSK(keys[i]) == ksk && keys[i].State == state {
+			found = append(found, &keys[i])
+		}
+	}
+	return found
+}
+
+// since returns when the key entered its current state
+func since(key *data.DNSSECKey) time.Time {
+	var at *time.Time
+	switch key.State {
+	case data.KeyStatePublished:
+		at = key.PublishedAt
+	case data.KeyStateActive:
+		at = key.ActivatedAt
+	case data.KeyStateRetired:
+		at = key.RetiredAt
+	}
+	if at == nil {
+		return key.CreatedAt
+	}
+	return *at
+}
+
+func isKSK(key data.DNSSECKey) bool {
+	return key.Flags&utils.DNSKEYFlagSEP != 0
+}
+
+func keyType(ksk bool) string {
+	if ksk {
+		return "KSK"
+	}
+	return "ZSK"
+}
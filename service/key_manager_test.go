@@ -0,0 +1,56 @@
+package service
+
+import (
+	"dnsServer/data"
+	"dnsServer/utils"
+	"testing"
+	"time"
+)
+
+func Test_KSKRemovalWaitsForDS(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+	zone := data.Zone{Name: "example.org", DNSSECEnabled: true, KSKLifetimeDays: 365}
+	keys := []data.DNSSECKey{
+		{Flags: utils.DNSKEYFlagZone | utils.DNSKEYFlagSEP, KeyTag: 1, State: data.KeyStateRetired, ActivatedAt: at(0), RetiredAt: at(2 * time.Hour)},
+		{Flags: utils.DNSKEYFlagZone | utils.DNSKEYFlagSEP, KeyTag: 2, State: data.KeyStateActive, ActivatedAt: at(0)},
+	}
+	removal := func(plan []keyTransition) *keyTransition {
+		for i := range plan {
+			if plan[i].ksk && plan[i].state == data.KeyStateRemoved {
+				return &plan[i]
+			}
+		}
+		return nil
+	}
+
+	t.Run("Waiting", func(t *testing.T) {
+		plan := planRollover(zone, keys, time.Hour)
+		if transition := removal(plan); transition != nil {
+			t.Errorf("Expected the retired KSK to stay until the DS is seen, got removal at %v", transition.at)
+		}
+		for _, transition := range plan {
+			if transition.ksk && transition.key == nil {
+				t.Errorf("Expected no new KSK while the old one is kept, got one at %v", transition.at)
+			}
+		}
+		if !rolloverInProgress(keys, true) {
+			t.Errorf("Expected the KSK rollover to be in progress")
+		}
+	})
+
+	t.Run("Confirmed", func(t *testing.T) {
+		confirmed := append([]data.DNSSECKey(nil), keys...)
+		confirmed[1].DSSeenAt = at(5 * time.Hour)
+		transition := removal(planRollover(zone, confirmed, time.Hour))
+		if transition == nil || transition.key.KeyTag != 1 {
+			t.Fatalf("Expected the retired KSK to be removed, got %v", transition)
+		}
+		if expected := start.Add(5*time.Hour + parentDSTTL + propagationDelay); !transition.at.Equal(expected) {
+			t.Errorf("Expected removal at %v, got %v", expected, transition.at)
+		}
+	})
+}
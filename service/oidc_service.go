@@ -0,0 +1,219 @@
+package service
+
+import (
+	"crypto"
+	"dnsServer/daos"
+	"dnsServer/data"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Caching of the identity provider keys
+const (
+	jwksCacheTTL      = time.Hour        // Keys are fetched again after this long
+	jwksRefetchPeriod = 30 * time.Second // Least time between fetches for unknown key IDs
+	jwksFetchTimeout  = 10 * time.Second
+)
+
+// OIDCService authenticates callers of the API with JWTs of an identity
+// provider, whose signing keys are cached and fetched again when they
+// expire or a token names an unknown key, so that rotations are picked up.
+// Key files are read again as soon as they change.
+type OIDCService struct {
+	db     *gorm.DB
+	config daos.OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	modified time.Time // Modification time of the key file when it was read
+	now      func() time.Time
+}
+
+func NewOIDCService(db *gorm.DB, config daos.OIDCConfig) (*OIDCService, error) {
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("an issuer is needed")
+	}
+	if config.Audience == "" {
+		return nil, fmt.Errorf("an audience is needed")
+	}
+	if (config.JWKSURL == "") == (config.JWKSFile == "") {
+		return nil, fmt.Errorf("either a JWKS URL or a JWKS file is needed")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "sub"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.DefaultScope == "" {
+		config.DefaultScope = daos.APIScopeReadOnly
+	}
+	for group, scope := range config.GroupScopes {
+		if !ValidAPIScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q for group %s", scope, group)
+		}
+	}
+	if !ValidAPIScope(config.DefaultScope) {
+		return nil, fmt.Errorf("unknown default scope %q", config.DefaultScope)
+	}
+	return &OIDCService{
+		db:     db,
+		config: config,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		now:    time.Now,
+	}, nil
+}
+
+// Authenticate verifies a JWT and returns its caller, as a key bound to a
+// user named after the username claim, with the scope of its groups
+func (oidc *OIDCService) Authenticate(token string) (*daos.APIKey, error) {
+	header, claims, signature, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	key, err := oidc.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(token, header.Alg, signature, key); err != nil {
+		return nil, err
+	}
+	now := oidc.now()
+	if err := checkJWTClaims(claims, oidc.config.Issuer, oidc.config.Audience, now); err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: no sub claim", ErrInvalidToken)
+	}
+	username, _ := claims[oidc.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("%w: no %s claim", ErrInvalidToken, oidc.config.UsernameClaim)
+	}
+	groups := stringsClaim(claims[oidc.config.GroupsClaim])
+
+	user, err := oidc.provisionUser(subject, username)
+	if err != nil {
+		return nil, err
+	}
+	scope := oidc.config.DefaultScope
+	for _, group := range groups {
+		if groupScope, ok := oidc.config.GroupScopes[group]; ok && APIScopeAllows(groupScope, scope) {
+			scope = groupScope
+		}
+	}
+	caller := &daos.APIKey{
+		Name:   username,
+		Scope:  scope,
+		UserID: user.ID,
+		Groups: groups,
+	}
+	if exp, ok := numericDate(claims["exp"]); ok {
+		caller.ExpiresAt = &exp
+	}
+	return caller, nil
+}
+
+// provisionUser returns the user of a caller, found by the issuer and
+// subject of its token, creating it with the name on its first login.
+// Names are only displayed: a token naming another user is refused rather
+// than given its roles.
+func (oidc *OIDCService) provisionUser(subject string, name string) (data.User, error) {
+	identity := oidc.config.Issuer + " " + subject
+	var user data.User
+	err := oidc.db.Where("identity = ?", identity).First(&user).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+	user = data.User{Base: data.Base{ID: uuid.NewString()}, Name: name, Identity: &identity}
+	err = oidc.db.Create(&user).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Created by a concurrent login, or the name belongs to another user
+		if oidc.db.Where("identity = ?", identity).First(&user).Error == nil {
+			return user, nil
+		}
+		return user, fmt.Errorf("%w: the name %q belongs to another user", ErrInvalidToken, name)
+	}
+	return user, err
+}
+
+// signingKey returns the key with the ID, fetching the key set when the
+// cached one is stale or does not have it
+func (oidc *OIDCService) signingKey(kid string) (crypto.PublicKey, error) {
+	oidc.mu.Lock()
+	defer oidc.mu.Unlock()
+	now := oidc.now()
+	stale := now.Sub(oidc.fetched) >= jwksCacheTTL
+	if oidc.config.JWKSFile != "" {
+		if info, err := os.Stat(oidc.config.JWKSFile); err == nil && !info.ModTime().Equal(oidc.modified) {
+			stale = true
+		}
+	}
+	key, known := oidc.lookup(kid)
+	if stale || (!known && now.Sub(oidc.fetched) >= jwksRefetchPeriod) {
+		keys, err := oidc.fetchKeys()
+		if err != nil {
+			// Keep using the cached keys until the provider is back
+			fmt.Println("Error: fetching JWKS:", err)
+		} else {
+			oidc.keys, oidc.fetched = keys, now
+			key, known = oidc.lookup(kid)
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// lookup finds a cached key. Tokens without a key ID can only be checked
+// against a set of a single key. The service must be locked.
+func (oidc *OIDCService) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(oidc.keys) == 1 {
+		for _, key := range oidc.keys {
+			return key, true
+		}
+	}
+	key, known := oidc.keys[kid]
+	return key, known
+}
+
+func (oidc *OIDCService) fetchKeys() (map[string]crypto.PublicKey, error) {
+	if oidc.config.JWKSFile != "" {
+		info, err := os.Stat(oidc.config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := os.ReadFile(oidc.config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseJWKS(raw)
+		if err == nil {
+			oidc.modified = info.ModTime()
+		}
+		return keys, err
+	}
+	response, err := oidc.client.Get(oidc.config.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d from %s", response.StatusCode, oidc.config.JWKSURL)
+	}
+	raw, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(raw)
+}
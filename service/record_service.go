@@ -3,11 +3,47 @@ package service
 import (
 	"dnsServer/daos"
 	"dnsServer/data"
+	"dnsServer/utils"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
 )
 
+// ErrInvalidChangeSet is returned for change sets that cannot be applied,
+// none of their changes being made
+var ErrInvalidChangeSet = errors.New("invalid change set")
+
+// VersionConflictError is returned for changes based on a version of a zone
+// or a record that is no longer its current one
+type VersionConflictError struct {
+	Resource string // zone or record
+	ID       string
+	Expected uint32
+	Current  uint32
+}
+
+func (err *VersionConflictError) Error() string {
+	return fmt.Sprintf("%s %s is at version %d, not %d", err.Resource, err.ID, err.Current, err.Expected)
+}
+
+// checkVersion returns a conflict when a change based on the expected
+// version, 0 for any, is made to a resource at the current version
+func checkVersion(resource string, id string, expected uint32, current uint32) error {
+	if expected != 0 && expected != current {
+		return &VersionConflictError{Resource: resource, ID: id, Expected: expected, Current: current}
+	}
+	return nil
+}
+
+// forUpdate locks the rows read until the end of the transaction, so they
+// cannot change between their version check and their update
+func forUpdate(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
 type RecordService struct {
 	db *gorm.DB
 }
@@ -16,41 +52,344 @@ func NewRecordService(db *gorm.DB) *RecordService {
 	return &RecordService{db: db}
 }
 
-func (zs *RecordService) CreateRecord(zoneId string, create daos.DNSRecordCreate) daos.DNSRecord {
+// CreateRecord adds a record to a zone, recording who did it in the audit
+// log. A *ValidationError is returned for invalid records.
+func (zs *RecordService) CreateRecord(actor daos.Actor, zoneId string, create daos.DNSRecordCreate) (daos.DNSRecord, error) {
 
 	record := data.Record{
 		Base: data.Base{
 			ID: uuid.NewString(),
 		},
-		Name:   create.Name,
-		Type:   create.Type,
-		Value:  create.Value,
-		TTL:    create.TTL,
-		ZoneID: zoneId,
+		Name:        create.Name,
+		Type:        strings.ToUpper(create.Type),
+		Value:       create.Value,
+		TTL:         create.TTL,
+		ZoneID:      zoneId,
+		Steering:    create.Steering,
+		HealthCheck: create.HealthCheck,
 	}
-	zs.db.Create(&record).Commit()
-	return record.ToDNSRecord()
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the zone keeps concurrent changes from slipping past the
+		// checks of the records at the name
+		var zone data.Zone
+		if err := forUpdate(tx).Where("id = ?", zoneId).First(&zone).Error; err != nil {
+			return err
+		}
+		if invalid := validateRecord(zone.Name, create); invalid != nil {
+			return invalid
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		if err := validateNames(tx, zone, map[string]bool{qualifyRecordName(record.Name, zone.Name): true}); err != nil {
+			return err
+		}
+		serial, err := bumpSerial(tx, zoneId)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, actor, serial, nil, &record)
+	})
+	return record.ToDNSRecord(), err
 }
 
-func (zs *RecordService) UpdateRecord(update daos.DNSRecordUpdate) (*daos.DNSRecord, error) {
+// UpdateRecord changes the fields set in the update. It returns a
+// *VersionConflictError when the update is based on another version.
+func (zs *RecordService) UpdateRecord(actor daos.Actor, update daos.DNSRecordUpdate) (*daos.DNSRecord, error) {
 
 	record := data.Record{
 		Base: data.Base{
 			ID: update.ID,
 		},
-		Name:  update.Name,
-		Type:  update.Type,
-		Value: update.Value,
-		TTL:   update.TTL,
+		Name:        update.Name,
+		Type:        strings.ToUpper(update.Type),
+		Value:       update.Value,
+		TTL:         update.TTL,
+		Steering:    update.Steering,
+		HealthCheck: update.HealthCheck,
+	}
+	var updated *daos.DNSRecord
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		var before data.Record
+		if err := forUpdate(tx).Where("id = ?", update.ID).First(&before).Error; err != nil {
+			return err
+		}
+		if err := checkVersion(daos.AuditRecord, before.ID, update.Version, before.Version); err != nil {
+			return err
+		}
+		var zone data.Zone
+		if err := forUpdate(tx).Where("id = ?", before.ZoneID).First(&zone).Error; err != nil {
+			return err
+		}
+		if invalid := validateUpdate(zone.Name, before, update.DNSRecordCreate); invalid != nil {
+			return invalid
+		}
+		record.Version = before.Version + 1
+		if err := tx.Updates(&record).Error; err != nil {
+			return err
+		}
+		var after data.Record
+		if err := tx.Where("id = ?", update.ID).First(&after).Error; err != nil {
+			return err
+		}
+		names := map[string]bool{qualifyRecordName(before.Name, zone.Name): true, qualifyRecordName(after.Name, zone.Name): true}
+		if err := validateNames(tx, zone, names); err != nil {
+			return err
+		}
+		updatedRecord := after.ToDNSRecord()
+		updated = &updatedRecord
+		serial, err := bumpSerial(tx, after.ZoneID)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, actor, serial, &before, &after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+
+}
+
+// DeleteRecord deletes a record unless it is no longer at the version, 0
+// for any, in which case a *VersionConflictError is returned
+func (zs *RecordService) DeleteRecord(actor daos.Actor, recordId string, version uint32) error {
+	return zs.db.Transaction(func(tx *gorm.DB) error {
+		var record data.Record
+		if err := forUpdate(tx).Where("id = ?", recordId).First(&record).Error; err != nil {
+			return err
+		}
+		if err := checkVersion(daos.AuditRecord, record.ID, version, record.Version); err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", recordId).Delete(&data.Record{}).Error; err != nil {
+			return err
+		}
+		serial, err := bumpSerial(tx, record.ZoneID)
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, actor, serial, &record, nil)
+	})
+}
+
+// ApplyChanges makes the operations of a change set in a single transaction
+// and serial bump, once the records left at the names they touch are
+// checked. It returns the new serial and the record of each operation.
+func (zs *RecordService) ApplyChanges(actor daos.Actor, zoneId string, operations []daos.RecordOperation) (uint32, []daos.DNSRecord, error) {
+	return zs.applyChanges(actor, zoneId, func(*gorm.DB, data.Zone) ([]daos.RecordOperation, error) {
+		return operations, nil
+	})
+}
+
+// ApplyPlannedChanges is ApplyChanges with the operations that plan returns
+// for the records of the zone, read once the zone is locked so that they
+// cannot change before the operations are made. Nothing is changed, the
+// serial included, when plan fails or returns no operation.
+func (zs *RecordService) ApplyPlannedChanges(actor daos.Actor, zoneId string, plan func(records []daos.DNSRecord) ([]daos.RecordOperation, error)) (uint32, []daos.DNSRecord, error) {
+	return zs.applyChanges(actor, zoneId, func(tx *gorm.DB, zone data.Zone) ([]daos.RecordOperation, error) {
+		var current []data.Record
+		if err := tx.Where("zone_id = ?", zone.ID).Order("created_at").Find(&current).Error; err != nil {
+			return nil, err
+		}
+		records := make([]daos.DNSRecord, len(current))
+		for i := range current {
+			records[i] = current[i].ToDNSRecord()
+		}
+		return plan(records)
+	})
+}
+
+// errNoChanges rolls back the serial bump of a plan without operations
+var errNoChanges = errors.New("no changes")
+
+// applyChanges makes the operations planned once the zone is locked
+func (zs *RecordService) applyChanges(actor daos.Actor, zoneId string, plan func(tx *gorm.DB, zone data.Zone) ([]daos.RecordOperation, error)) (uint32, []daos.DNSRecord, error) {
+	type change struct {
+		before *data.Record
+		after  *data.Record
 	}
-	zs.db.Updates(&record).Commit()
-	return zs.GetRecord(update.ID)
+	var serial uint32
+	var records []daos.DNSRecord
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		var zone data.Zone
+		if err := tx.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+			return err
+		}
+		// Bumping the serial first locks the zone against concurrent changes
+		var err error
+		if serial, err = bumpSerial(tx, zoneId); err != nil {
+			return err
+		}
+		operations, err := plan(tx, zone)
+		if err != nil {
+			return err
+		}
+		if len(operations) == 0 {
+			return errNoChanges
+		}
 
+		var changes []change
+		touched := map[string]bool{}
+		for i, operation := range operations {
+			var before, after *data.Record
+			switch operation.Action {
+			case daos.ChangeCreate:
+				if operation.Record == nil {
+					return fmt.Errorf("%w: change %d has no record", ErrInvalidChangeSet, i)
+				}
+				if invalid := validateRecord(zone.Name, *operation.Record); invalid != nil {
+					return invalid.in(fmt.Sprintf("changes[%d].record", i))
+				}
+				record := data.Record{
+					Base:        data.Base{ID: uuid.NewString()},
+					Name:        operation.Record.Name,
+					Type:        strings.ToUpper(operation.Record.Type),
+					Value:       operation.Record.Value,
+					TTL:         operation.Record.TTL,
+					ZoneID:      zoneId,
+					Steering:    operation.Record.Steering,
+					HealthCheck: operation.Record.HealthCheck,
+				}
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+				after = &record
+			case daos.ChangeUpdate, daos.ChangeDelete:
+				var record data.Record
+				err := forUpdate(tx).Where("id = ? AND zone_id = ?", operation.ID, zoneId).First(&record).Error
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("%w: change %d: record %q is not in the zone", ErrInvalidChangeSet, i, operation.ID)
+				}
+				if err != nil {
+					return err
+				}
+				if err := checkVersion(daos.AuditRecord, record.ID, operation.Version, record.Version); err != nil {
+					return fmt.Errorf("%w: change %d: %w", ErrInvalidChangeSet, i, err)
+				}
+				before = &record
+				if operation.Action == daos.ChangeDelete {
+					if err := tx.Delete(&record).Error; err != nil {
+						return err
+					}
+				} else {
+					if operation.Record == nil {
+						return fmt.Errorf("%w: change %d has no record", ErrInvalidChangeSet, i)
+					}
+					if invalid := validateUpdate(zone.Name, record, *operation.Record); invalid != nil {
+						return invalid.in(fmt.Sprintf("changes[%d].record", i))
+					}
+					update := data.Record{
+						Base:        data.Base{ID: record.ID},
+						Name:        operation.Record.Name,
+						Type:        strings.ToUpper(operation.Record.Type),
+						Value:       operation.Record.Value,
+						TTL:         operation.Record.TTL,
+						Steering:    operation.Record.Steering,
+						HealthCheck: operation.Record.HealthCheck,
+					}
+					update.Version = record.Version + 1
+					if err := tx.Updates(&update).Error; err != nil {
+						return err
+					}
+					var updated data.Record
+					if err := tx.Where("id = ?", record.ID).First(&updated).Error; err != nil {
+						return err
+					}
+					after = &updated
+				}
+			default:
+				return fmt.Errorf("%w: change %d has unknown action %q", ErrInvalidChangeSet, i, operation.Action)
+			}
+
+			changes = append(changes, change{before, after})
+			result := after
+			if result == nil {
+				result = before
+			}
+			records = append(records, result.ToDNSRecord())
+			for _, record := range []*data.Record{before, after} {
+				if record != nil {
+					touched[qualifyRecordName(record.Name, zone.Name)] = true
+				}
+			}
+		}
+
+		if err := validateNames(tx, zone, touched); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := recordChange(tx, actor, serial, change.before, change.after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errNoChanges) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return serial, records, nil
 }
 
-func (zs *RecordService) DeleteRecord(recordId string) {
+// validateUpdate checks the record an update of the fields set in change
+// leads to
+func validateUpdate(zoneName string, before data.Record, change daos.DNSRecordCreate) *ValidationError {
+	after := daos.DNSRecordCreate{Name: before.Name, Type: before.Type, Value: before.Value, TTL: before.TTL,
+		Steering: before.Steering, HealthCheck: before.HealthCheck}
+	if change.Name != "" {
+		after.Name = change.Name
+	}
+	if change.Type != "" {
+		after.Type = change.Type
+	}
+	if change.Value != "" {
+		after.Value = change.Value
+	}
+	if change.TTL != 0 {
+		after.TTL = change.TTL
+	}
+	if change.Steering != nil {
+		after.Steering = change.Steering
+	}
+	if change.HealthCheck != nil {
+		after.HealthCheck = change.HealthCheck
+	}
+	return validateRecord(zoneName, after)
+}
 
-	zs.db.Where("id = ?", recordId).Delete(&data.Record{})
+// validateNames checks the records of a zone at the names are valid and
+// that names with a CNAME record have no other record
+func validateNames(tx *gorm.DB, zone data.Zone, names map[string]bool) error {
+	var records []data.Record
+	if err := tx.Where("zone_id = ?", zone.ID).Find(&records).Error; err != nil {
+		return err
+	}
+	types := map[string][]string{}
+	for _, record := range records {
+		name := qualifyRecordName(record.Name, zone.Name)
+		if !names[name] {
+			continue
+		}
+		rtype, ok := utils.ParseDNSRecordType(record.Type)
+		if !ok {
+			return fmt.Errorf("%w: unsupported record type %q at %s", ErrInvalidChangeSet, record.Type, name)
+		}
+		if _, err := utils.NewDNSAnswer(name, rtype, uint32(record.TTL), record.Value); err != nil {
+			return fmt.Errorf("%w: %s record at %s: %v", ErrInvalidChangeSet, rtype, name, err)
+		}
+		types[name] = append(types[name], rtype.String())
+	}
+	for name, nameTypes := range types {
+		for _, rtype := range nameTypes {
+			if rtype == "CNAME" && len(nameTypes) > 1 {
+				return fmt.Errorf("%w: %s has a CNAME record and other records", ErrInvalidChangeSet, name)
+			}
+		}
+	}
+	return nil
 }
 
 func (zs *RecordService) GetRecord(recordId string) (*daos.DNSRecord, error) {
@@ -68,13 +407,26 @@ func (zs *RecordService) GetRecord(recordId string) (*daos.DNSRecord, error) {
 	return &dnsRecord, nil
 }
 
-func (zs *RecordService) GetRecords(zoneId string, nameLike string) []daos.DNSRecord {
+func (zs *RecordService) GetRecords(zoneId string, nameLike string) ([]daos.DNSRecord, error) {
 	var records []data.Record
 
-	zs.db.Where("name ILIKE ? and zone_id = ?", "%"+nameLike+"%", zoneId).Find(&records)
+	if err := zs.db.Where("name ILIKE ? and zone_id = ?", "%"+nameLike+"%", zoneId).Find(&records).Error; err != nil {
+		return nil, err
+	}
 	var toRet []daos.DNSRecord
 	for _, record := range records {
 		toRet = append(toRet, record.ToDNSRecord())
 	}
-	return toRet
+	return toRet, nil
+}
+
+// bumpSerial increments the SOA serial of the zone so secondaries pick up
+// the change, and returns the new serial
+func bumpSerial(db *gorm.DB, zoneId string) (uint32, error) {
+	if err := db.Model(&data.Zone{}).Where("id = ?", zoneId).UpdateColumn("serial", gorm.Expr("serial + 1")).Error; err != nil {
+		return 0, err
+	}
+	var zone data.Zone
+	err := db.Select("serial").Where("id = ?", zoneId).First(&zone).Error
+	return zone.Serial, err
 }
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/utils"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MaxTTL is the largest TTL a record may have, as of RFC 2181
+const MaxTTL = 1<<31 - 1
+
+// ttlBounds are the bounds of the TTLs of records, changed with SetTTLBounds
+// while records are being validated
+var ttlBounds = struct {
+	sync.RWMutex
+	min, max int
+}{max: MaxTTL}
+
+// SetTTLBounds limits the TTLs of the records created and changed from now on
+func SetTTLBounds(min int, max int) error {
+	if min < 0 || max > MaxTTL || min > max {
+		return fmt.Errorf("invalid TTL bounds %d to %d, expected 0 <= min <= max <= %d", min, max, MaxTTL)
+	}
+	ttlBounds.Lock()
+	defer ttlBounds.Unlock()
+	ttlBounds.min, ttlBounds.max = min, max
+	return nil
+}
+
+// recordTypes are the types of records stored in zones
+var recordTypes = map[utils.DNSRecordType]bool{
+	utils.TypeA: true, utils.TypeAAAA: true, utils.TypeCNAME: true, utils.TypeMX: true, utils.TypeTXT: true,
+	utils.TypeNS: true, utils.TypePTR: true, utils.TypeSRV: true, utils.TypeDS: true, utils.TypeCAA: true,
+}
+
+// validateRecord checks a record of a zone: its type is supported, its value
+// parses for the type, its name is a valid name within the zone, its TTL is
+// within bounds and its steering and health check are valid. The error lists
+// every invalid field.
+func validateRecord(zoneName string, record daos.DNSRecordCreate) *ValidationError {
+	var fields []daos.FieldError
+	fail := func(field string, err error) {
+		fields = append(fields, daos.FieldError{Field: field, Message: err.Error()})
+	}
+	if err := checkOwnerName(record.Name, zoneName); err != nil {
+		fail("name", err)
+	}
+	rtype, ok := utils.ParseDNSRecordType(record.Type)
+	switch {
+	case rtype == utils.TypeSOA:
+		fail("type", fmt.Errorf("the SOA record of a zone is managed by the server"))
+	case !ok || !recordTypes[rtype]:
+		fail("type", fmt.Errorf("unsupported record type %q", record.Type))
+	default:
+		if err := checkRecordValue(rtype, record.Value); err != nil {
+			fail("value", err)
+		}
+	}
+	ttlBounds.RLock()
+	minTTL, maxTTL := ttlBounds.min, ttlBounds.max
+	ttlBounds.RUnlock()
+	if record.TTL < minTTL || record.TTL > maxTTL {
+		fail("ttl", fmt.Errorf("TTL must be between %d and %d seconds", minTTL, maxTTL))
+	}
+	if invalid := checkSteering(record.Steering); invalid != nil {
+		fields = append(fields, invalid.Fields...)
+	}
+	if invalid := checkHealthCheck(record.HealthCheck, record.Type); invalid != nil {
+		fields = append(fields, invalid.Fields...)
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// checkRecordValue checks a value parses for the type and that the names it
+// holds are valid hostnames
+func checkRecordValue(rtype utils.DNSRecordType, value string) error {
+	if _, err := utils.NewDNSAnswer(".", rtype, 0, value); err != nil {
+		return err
+	}
+	fields := strings.Fields(value)
+	switch rtype {
+	case utils.TypeCNAME, utils.TypePTR:
+		return checkName(value, false)
+	case utils.TypeNS:
+		return checkName(value, true)
+	case utils.TypeMX:
+		return checkName(fields[1], true)
+	case utils.TypeSRV:
+		return checkName(fields[3], true)
+	}
+	return nil
+}
+
+// checkOwnerName checks the name of a record, relative to the zone or
+// absolute with a trailing dot, is a valid name within the zone. Its first
+// label may be the "*" of a wildcard.
+func checkOwnerName(name string, zoneName string) error {
+	if name == "" || name == "@" {
+		return nil
+	}
+	if strings.HasSuffix(name, ".") && !isBelowZone(strings.ToLower(strings.TrimSuffix(name, ".")), zoneName) {
+		return fmt.Errorf("%s is not in zone %s", name, zoneName)
+	}
+	qualified := qualifyRecordName(name, zoneName)
+	if qualified == "*" || strings.HasPrefix(qualified, "*.") {
+		qualified = strings.TrimPrefix(strings.TrimPrefix(qualified, "*"), ".")
+		if qualified == "" {
+			return nil
+		}
+	}
+	return checkName(qualified, false)
+}
+
+// checkName checks a name has labels of 1 to 63 letters, digits and inner
+// hyphens and is at most 253 characters long. Names that are not hostnames
+// may also have underscores, as in "_sip._tcp".
+func checkName(name string, hostname bool) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return fmt.Errorf("a name is needed")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("name %q is longer than 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("name %q has a label that is empty or longer than 63 characters", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("name %q has a label starting or ending with a hyphen", name)
+		}
+		for _, c := range label {
+			valid := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || (c == '_' && !hostname)
+			if !valid {
+				return fmt.Errorf("name %q has invalid character %q", name, c)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSteering checks the traffic steering settings of a record
+func checkSteering(steering *daos.RecordSteering) *ValidationError {
+	if steering == nil {
+		return nil
+	}
+	switch steering.Policy {
+	case daos.SteeringWeighted, daos.SteeringGeo, daos.SteeringFailover:
+	default:
+		return invalid("steering.policy", "unknown steering policy %q", steering.Policy)
+	}
+	if steering.Weight < 0 {
+		return invalid("steering.weight", "weight must not be negative")
+	}
+	for _, country := range steering.Countries {
+		if len(country) != 2 {
+			return invalid("steering.countries", "invalid country code %q", country)
+		}
+	}
+	for _, continent := range steering.Continents {
+		if len(continent) != 2 {
+			return invalid("steering.continents", "invalid continent code %q", continent)
+		}
+	}
+	return nil
+}
+
+// checkHealthCheck checks the health check of a record, which only
+// addresses can have
+func checkHealthCheck(check *daos.HealthCheck, recordType string) *ValidationError {
+	if check == nil {
+		return nil
+	}
+	if !strings.EqualFold(recordType, "A") && !strings.EqualFold(recordType, "AAAA") {
+		return invalid("healthCheck", "only A and AAAA records can be health checked")
+	}
+	switch check.Type {
+	case daos.HealthCheckTCP:
+		if check.Port == 0 {
+			return invalid("healthCheck.port", "tcp health checks need a port")
+		}
+	case daos.HealthCheckPing, daos.HealthCheckHTTP, daos.HealthCheckHTTPS:
+	default:
+		return invalid("healthCheck.type", "unknown health check type %q", check.Type)
+	}
+	if check.Port < 0 || check.Port > 65535 {
+		return invalid("healthCheck.port", "invalid port %d", check.Port)
+	}
+	if check.IntervalSeconds < 0 || check.TimeoutSeconds < 0 || check.FailureThreshold < 0 || check.RecoveryThreshold < 0 {
+		return invalid("healthCheck", "health check intervals and thresholds must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"testing"
+)
+
+func Test_ValidateRecord(t *testing.T) {
+	check := &daos.HealthCheck{Type: daos.HealthCheckHTTP}
+	tests := []struct {
+		name   string
+		record daos.DNSRecordCreate
+		field  string // first invalid field, empty when valid
+	}{
+		{"Valid", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60}, ""},
+		{"Steered", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60,
+			Steering: &daos.RecordSteering{Policy: daos.SteeringWeighted, Weight: 2}, HealthCheck: check}, ""},
+		{"UnknownPolicy", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60,
+			Steering: &daos.RecordSteering{Policy: "round-robin"}}, "steering.policy"},
+		{"CheckedName", daos.DNSRecordCreate{Name: "www", Type: "CNAME", Value: "example.org", TTL: 60, HealthCheck: check}, "healthCheck"},
+		{"TCPWithoutPort", daos.DNSRecordCreate{Name: "www", Type: "AAAA", Value: "2001:db8::1", TTL: 60,
+			HealthCheck: &daos.HealthCheck{Type: daos.HealthCheckTCP}}, "healthCheck.port"},
+		{"OutOfZone", daos.DNSRecordCreate{Name: "www.example.org.", Type: "A", Value: "192.0.2.1", TTL: 60}, "name"},
+		{"NegativeTTL", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: -1}, "ttl"},
+		{"NotAnAddress", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "hello", TTL: 60}, "value"},
+		{"UnknownType", daos.DNSRecordCreate{Name: "www", Type: "BOGUS", Value: "hello", TTL: 60}, "type"},
+		{"MXWithoutPreference", daos.DNSRecordCreate{Name: "@", Type: "MX", Value: "mail.example.com", TTL: 60}, "value"},
+		{"SRVWithoutPort", daos.DNSRecordCreate{Name: "_sip._tcp", Type: "SRV", Value: "10 20 sip.example.com", TTL: 60}, "value"},
+		{"CAAWithoutTag", daos.DNSRecordCreate{Name: "@", Type: "CAA", Value: "0 letsencrypt.org", TTL: 60}, "value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invalid := validateRecord("example.com", test.record)
+			switch {
+			case test.field == "" && invalid != nil:
+				t.Errorf("Expected the record to be valid, got %v", invalid)
+			case test.field != "" && (invalid == nil || invalid.Fields[0].Field != test.field):
+				t.Errorf("Expected %s to be invalid, got %+v", test.field, invalid)
+			}
+		})
+	}
+}
+
+func Test_ValidateUpdate(t *testing.T) {
+	before := data.Record{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60, HealthCheck: &daos.HealthCheck{Type: daos.HealthCheckPing}}
+	// The health check kept from the record does not fit a CNAME
+	if invalid := validateUpdate("example.com", before, daos.DNSRecordCreate{Type: "CNAME", Value: "example.org"}); invalid == nil || invalid.Fields[0].Field != "healthCheck" {
+		t.Errorf("Expected the kept health check to be invalid, got %+v", invalid)
+	}
+	if invalid := validateUpdate("example.com", before, daos.DNSRecordCreate{Steering: &daos.RecordSteering{Policy: "nearest"}}); invalid == nil || invalid.Fields[0].Field != "steering.policy" {
+		t.Errorf("Expected the steering policy to be invalid, got %+v", invalid)
+	}
+	if invalid := validateUpdate("example.com", before, daos.DNSRecordCreate{Value: "192.0.2.2"}); invalid != nil {
+		t.Errorf("Expected the update to be valid, got %v", invalid)
+	}
+}
+
+func Test_SetTTLBounds(t *testing.T) {
+	defer SetTTLBounds(0, MaxTTL)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			validateRecord("example.com", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 60})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := SetTTLBounds(30, 3600); err != nil {
+			t.Fatalf("Expected the bounds to be set, got %v", err)
+		}
+	}
+	<-done
+	if invalid := validateRecord("example.com", daos.DNSRecordCreate{Name: "www", Type: "A", Value: "192.0.2.1", TTL: 10}); invalid == nil || invalid.Fields[0].Field != "ttl" {
+		t.Errorf("Expected the TTL to be out of bounds, got %+v", invalid)
+	}
+	if err := SetTTLBounds(100, 10); err == nil {
+		t.Errorf("Expected inverted bounds to be refused")
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"crypto/rand"
+	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/utils"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+type TSIGService struct {
+	db *gorm.DB
+}
+
+func NewTSIGService(db *gorm.DB) *TSIGService {
+	return &TSIGService{db: db}
+}
+
+// CreateKey stores a new TSIG key, generating a random secret when none is
+// given. The secret is only returned by this call.
+func (ts *TSIGService) CreateKey(create daos.TSIGKeyCreate) (daos.TSIGKey, error) {
+	algorithm := strings.ToLower(create.Algorithm)
+	if algorithm == "" {
+		algorithm = utils.TSIGHmacSHA256
+	}
+	if !utils.IsSupportedTSIGAlgorithm(algorithm) {
+		return daos.TSIGKey{}, invalid("algorithm", "unsupported TSIG algorithm %q", create.Algorithm)
+	}
+	secret := create.Secret
+	if secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return daos.TSIGKey{}, err
+		}
+		secret = base64.StdEncoding.EncodeToString(raw)
+	} else if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return daos.TSIGKey{}, invalid("secret", "secret must be base64 encoded: %v", err)
+	}
+
+	key := data.TSIGKey{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		Name:      strings.ToLower(strings.TrimSuffix(create.Name, ".")),
+		Algorithm: algorithm,
+		Secret:    secret,
+	}
+	if err := ts.db.Create(&key).Error; err != nil {
+		return daos.TSIGKey{}, err
+	}
+	created := key.ToTSIGKey()
+	created.Secret = secret
+	return created, nil
+}
+
+func (ts *TSIGService) DeleteKey(keyId string) error {
+	return deleted(ts.db.Where("id = ?", keyId).Delete(&data.TSIGKey{}))
+}
+
+func (ts *TSIGService) GetKey(keyId string) (*daos.TSIGKey, error) {
+	var key data.TSIGKey
+	if err := ts.db.Where("id = ?", keyId).First(&key).Error; err != nil {
+		return nil, err
+	}
+	dnsKey := key.ToTSIGKey()
+	return &dnsKey, nil
+}
+
+func (ts *TSIGService) GetKeys() ([]daos.TSIGKey, error) {
+	var keys []data.TSIGKey
+
+	if err := ts.db.Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.TSIGKey
+	for _, key := range keys {
+		toRet = append(toRet, key.ToTSIGKey())
+	}
+	return toRet, nil
+}
+
+// GetSigningKey returns the key with the given name, including its secret,
+// for signing and verifying messages
+func (ts *TSIGService) GetSigningKey(name string) (*utils.TSIGKey, error) {
+	var key data.TSIGKey
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if err := ts.db.Where("name = ?", name).First(&key).Error; err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.TSIGKey{Name: key.Name, Algorithm: key.Algorithm, Secret: secret}, nil
+}
+
+func (ts *TSIGService) CreatePolicy(zoneId string, create daos.TSIGPolicyCreate) (daos.TSIGPolicy, error) {
+	policy := data.TSIGPolicy{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		ZoneID:        zoneId,
+		KeyName:       strings.ToLower(strings.TrimSuffix(create.KeyName, ".")),
+		AllowTransfer: create.AllowTransfer,
+		AllowUpdate:   create.AllowUpdate,
+		NamePattern:   create.NamePattern,
+	}
+	if err := ts.db.Create(&policy).Error; err != nil {
+		return daos.TSIGPolicy{}, err
+	}
+	return policy.ToTSIGPolicy(), nil
+}
+
+func (ts *TSIGService) GetPolicy(policyId string) (*daos.TSIGPolicy, error) {
+	var policy data.TSIGPolicy
+	if err := ts.db.Where("id = ?", policyId).First(&policy).Error; err != nil {
+		return nil, err
+	}
+	toRet := policy.ToTSIGPolicy()
+	return &toRet, nil
+}
+
+func (ts *TSIGService) DeletePolicy(policyId string) error {
+	return deleted(ts.db.Where("id = ?", policyId).Delete(&data.TSIGPolicy{}))
+}
+
+func (ts *TSIGService) GetPolicies(zoneId string) ([]daos.TSIGPolicy, error) {
+	var policies []data.TSIGPolicy
+
+	if err := ts.db.Where("zone_id = ?", zoneId).Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.TSIGPolicy
+	for _, policy := range policies {
+		toRet = append(toRet, policy.ToTSIGPolicy())
+	}
+	return toRet, nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// ErrViewInUse is returned when deleting a view that still has zones
+var ErrViewInUse = errors.New("view still has zones")
+
+type ViewService struct {
+	db *gorm.DB
+}
+
+func NewViewService(db *gorm.DB) *ViewService {
+	return &ViewService{db: db}
+}
+
+func (vs *ViewService) CreateView(create daos.ViewCreate) (daos.View, error) {
+	if create.Name == "" {
+		return daos.View{}, invalid("name", "a view needs a name")
+	}
+	if len(create.Networks) == 0 && len(create.TSIGKeys) == 0 {
+		return daos.View{}, invalid("", "a view needs networks or TSIG keys to match clients")
+	}
+	view := data.View{
+		Base: data.Base{
+			ID: uuid.NewString(),
+		},
+		Name:     create.Name,
+		Priority: create.Priority,
+		Networks: []string{},
+		TSIGKeys: []string{},
+	}
+	for _, network := range create.Networks {
+		cidr, err := parseCIDR(network)
+		if err != nil {
+			return daos.View{}, invalid("networks", "%v", err)
+		}
+		view.Networks = append(view.Networks, cidr)
+	}
+	for _, keyName := range create.TSIGKeys {
+		view.TSIGKeys = append(view.TSIGKeys, strings.ToLower(strings.TrimSuffix(keyName, ".")))
+	}
+	if err := vs.db.Create(&view).Error; err != nil {
+		return daos.View{}, err
+	}
+	return view.ToView(), nil
+}
+
+// DeleteView deletes a view, which must not have zones anymore
+func (vs *ViewService) DeleteView(viewId string) error {
+	var zones int64
+	if err := vs.db.Model(&data.Zone{}).Where("view_id = ?", viewId).Count(&zones).Error; err != nil {
+		return err
+	}
+	if zones > 0 {
+		return ErrViewInUse
+	}
+	return deleted(vs.db.Where("id = ?", viewId).Delete(&data.View{}))
+}
+
+func (vs *ViewService) GetView(viewId string) (*daos.View, error) {
+	var view data.View
+	if err := vs.db.Where("id = ?", viewId).First(&view).Error; err != nil {
+		return nil, err
+	}
+	dnsView := view.ToView()
+	return &dnsView, nil
+}
+
+// GetViews returns the views in the order they are matched
+func (vs *ViewService) GetViews() ([]daos.View, error) {
+	var views []data.View
+
+	if err := vs.db.Order("priority, name").Find(&views).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.View
+	for _, view := range views {
+		toRet = append(toRet, view.ToView())
+	}
+	return toRet, nil
+}
+
+func (vs *ViewService) GetZones(viewId string) ([]daos.DNSZone, error) {
+	var zones []data.Zone
+
+	if err := vs.db.Where("view_id = ?", viewId).Order("name").Find(&zones).Error; err != nil {
+		return nil, err
+	}
+	var toRet []daos.DNSZone
+	for _, zone := range zones {
+		toRet = append(toRet, zone.ToDNSZone())
+	}
+	return toRet, nil
+}
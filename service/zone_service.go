@@ -15,35 +15,71 @@ func NewZoneService(db *gorm.DB) *ZoneService {
 	return &ZoneService{db: db}
 }
 
-func (zs *ZoneService) CreateZone(create daos.DNSZoneCreate) daos.DNSZone {
+// CreateZone adds a zone, whose name must be unique in its view
+func (zs *ZoneService) CreateZone(actor daos.Actor, create daos.DNSZoneCreate) (daos.DNSZone, error) {
 	zone := data.Zone{
 		Base: data.Base{
 			ID: uuid.NewString(),
 		},
-		Name: create.Name,
+		Name:   create.Name,
+		ViewID: create.ViewID,
+		Serial: 1,
 	}
-	zs.db.Create(&zone).Commit()
-	return zone.ToDNSZone()
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&zone).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, daos.AuditCreate, daos.AuditZone, zone.ID, zone.ID, nil, zone.ToDNSZone())
+	})
+	return zone.ToDNSZone(), err
 }
 
-func (zs *ZoneService) UpdateZone(update daos.DNSZoneUpdate) daos.DNSZone {
+// UpdateZone changes the fields set in the update. It returns a
+// *VersionConflictError when the update is based on another version.
+func (zs *ZoneService) UpdateZone(actor daos.Actor, update daos.DNSZoneUpdate) (daos.DNSZone, error) {
 	zone := data.Zone{
 		Base: data.Base{
 			ID: update.ID,
 		},
-		Name: update.Name,
+		Name:   update.Name,
+		ViewID: update.ViewID,
 	}
-	zs.db.Updates(&zone).Commit()
-	return zone.ToDNSZone()
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		var before data.Zone
+		if err := forUpdate(tx).Where("id = ?", update.ID).First(&before).Error; err != nil {
+			return err
+		}
+		if err := checkVersion(daos.AuditZone, before.ID, update.Version, before.Version); err != nil {
+			return err
+		}
+		zone.Version = before.Version + 1
+		if err := tx.Updates(&zone).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", update.ID).First(&zone).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, daos.AuditUpdate, daos.AuditZone, zone.ID, zone.ID, before.ToDNSZone(), zone.ToDNSZone())
+	})
+	return zone.ToDNSZone(), err
 }
 
-func (zs *ZoneService) DeleteZone(zoneId string) {
-	zone := data.Zone{
-		Base: data.Base{
-			ID: zoneId,
-		},
-	}
-	zs.db.Delete(&zone).Commit()
+// DeleteZone deletes a zone unless it is no longer at the version, 0 for
+// any, in which case a *VersionConflictError is returned
+func (zs *ZoneService) DeleteZone(actor daos.Actor, zoneId string, version uint32) error {
+	return zs.db.Transaction(func(tx *gorm.DB) error {
+		var zone data.Zone
+		if err := forUpdate(tx).Where("id = ?", zoneId).First(&zone).Error; err != nil {
+			return err
+		}
+		if err := checkVersion(daos.AuditZone, zone.ID, version, zone.Version); err != nil {
+			return err
+		}
+		if err := tx.Delete(&zone).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, daos.AuditDelete, daos.AuditZone, zone.ID, zone.ID, zone.ToDNSZone(), nil)
+	})
 }
 
 func (zs *ZoneService) GetZone(zoneId string) (*daos.DNSZone, error) {
@@ -60,13 +96,15 @@ func (zs *ZoneService) GetZone(zoneId string) (*daos.DNSZone, error) {
 	return &dnsZone, nil
 }
 
-func (zs *ZoneService) GetZones(nameLike string) []daos.DNSZone {
+func (zs *ZoneService) GetZones(nameLike string) ([]daos.DNSZone, error) {
 	var records []data.Zone
 
-	zs.db.Where("name ILIKE ?", "%"+nameLike+"%").Find(&records)
+	if err := zs.db.Where("name ILIKE ?", "%"+nameLike+"%").Find(&records).Error; err != nil {
+		return nil, err
+	}
 	var toRet []daos.DNSZone
 	for _, record := range records {
 		toRet = append(toRet, record.ToDNSZone())
 	}
-	return toRet
+	return toRet, nil
 }
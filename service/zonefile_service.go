@@ -0,0 +1,249 @@
+package service
+
+import (
+	"dnsServer/daos"
+	"dnsServer/data"
+	"dnsServer/utils"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidZoneFile is returned for zone files that cannot be imported
+var ErrInvalidZoneFile = errors.New("invalid zone file")
+
+// ZoneFileService imports and exports zones as master files
+type ZoneFileService struct {
+	db *gorm.DB
+}
+
+func NewZoneFileService(db *gorm.DB) *ZoneFileService {
+	return &ZoneFileService{db: db}
+}
+
+// errDryRun rolls back an import once it has been checked
+var errDryRun = errors.New("dry run")
+
+// Import applies the records of a zone file to a zone in a single
+// transaction and serial bump, and returns the changes. Records of the file
+// that the zone already has are kept, only their TTL being updated. The
+// changes are passed to authorize before any is made, and nothing changes
+// when it fails. The zone is left as it is when dryRun is set.
+func (zs *ZoneFileService) Import(actor daos.Actor, zoneId string, records []utils.ZoneFileRecord, mode string, dryRun bool, authorize func(diff daos.ZoneDiff) error) (daos.ZoneDiff, error) {
+	var diff daos.ZoneDiff
+	err := zs.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the zone keeps concurrent changes from slipping between
+		// the diff and its application
+		var zone data.Zone
+		if err := forUpdate(tx).Where("id = ?", zoneId).First(&zone).Error; err != nil {
+			return err
+		}
+		var current []data.Record
+		if err := tx.Where("zone_id = ?", zoneId).Order("created_at").Find(&current).Error; err != nil {
+			return err
+		}
+		existing := map[string][]data.Record{}
+		for _, record := range current {
+			key := zoneFileKey(qualifyRecordName(record.Name, zone.Name), record.Type, record.Value)
+			existing[key] = append(existing[key], record)
+		}
+
+		var created, removed []data.Record
+		var changed [][2]data.Record
+		kept, imported := map[string]bool{}, map[string]bool{}
+		for _, record := range records {
+			// The zone has its own SOA
+			if record.Type == utils.TypeSOA {
+				continue
+			}
+			if !isBelowZone(record.Name, zone.Name) {
+				return fmt.Errorf("%w: %s is not in zone %s", ErrInvalidZoneFile, record.Name, zone.Name)
+			}
+			create := daos.DNSRecordCreate{Name: relativeRecordName(record.Name, zone.Name), Type: record.Type.String(), Value: record.Value, TTL: int(record.TTL)}
+			if invalid := validateRecord(zone.Name, create); invalid != nil {
+				return fmt.Errorf("%w: %s %s record: %v", ErrInvalidZoneFile, record.Name, record.Type, invalid)
+			}
+			key := zoneFileKey(record.Name, record.Type.String(), record.Value)
+			if imported[key] {
+				continue
+			}
+			imported[key] = true
+			if matches := existing[key]; len(matches) > 0 {
+				before := matches[0]
+				kept[before.ID] = true
+				if before.TTL != int(record.TTL) {
+					after := before
+					after.TTL = int(record.TTL)
+					changed = append(changed, [2]data.Record{before, after})
+				}
+				continue
+			}
+			created = append(created, data.Record{
+				Base:   data.Base{ID: uuid.NewString()},
+				Name:   relativeRecordName(record.Name, zone.Name),
+				Type:   record.Type.String(),
+				Value:  record.Value,
+				TTL:    int(record.TTL),
+				ZoneID: zoneId,
+			})
+		}
+		if mode == daos.ImportReplace {
+			for _, record := range current {
+				if !kept[record.ID] {
+					removed = append(removed, record)
+				}
+			}
+		}
+
+		diff = daos.ZoneDiff{FromSerial: zone.Serial, ToSerial: zone.Serial,
+			Added: []daos.DNSRecord{}, Removed: []daos.DNSRecord{}, Changed: []daos.RecordChange{}}
+		for _, record := range created {
+			diff.Added = append(diff.Added, record.ToDNSRecord())
+		}
+		for _, record := range removed {
+			diff.Removed = append(diff.Removed, record.ToDNSRecord())
+		}
+		for _, change := range changed {
+			diff.Changed = append(diff.Changed, daos.RecordChange{Before: change[0].ToDNSRecord(), After: change[1].ToDNSRecord()})
+		}
+		if err := authorize(diff); err != nil {
+			return err
+		}
+		if len(created)+len(removed)+len(changed) == 0 {
+			return nil
+		}
+
+		serial, err := bumpSerial(tx, zoneId)
+		if err != nil {
+			return err
+		}
+		names := map[string]bool{}
+		for i := range created {
+			if err := tx.Create(&created[i]).Error; err != nil {
+				return err
+			}
+			if err := recordChange(tx, actor, serial, nil, &created[i]); err != nil {
+				return err
+			}
+			names[qualifyRecordName(created[i].Name, zone.Name)] = true
+		}
+		for _, change := range changed {
+			before, after := change[0], change[1]
+			if err := tx.Model(&after).Updates(map[string]any{"ttl": after.TTL, "version": gorm.Expr("version + 1")}).Error; err != nil {
+				return err
+			}
+			after.Version++
+			if err := recordChange(tx, actor, serial, &before, &after); err != nil {
+				return err
+			}
+			names[qualifyRecordName(after.Name, zone.Name)] = true
+		}
+		for i := range removed {
+			if err := tx.Delete(&removed[i]).Error; err != nil {
+				return err
+			}
+			if err := recordChange(tx, actor, serial, &removed[i], nil); err != nil {
+				return err
+			}
+			names[qualifyRecordName(removed[i].Name, zone.Name)] = true
+		}
+		if err := validateNames(tx, zone, names); err != nil {
+			return err
+		}
+		if dryRun {
+			// The changes were only made to check them
+			return errDryRun
+		}
+		diff.ToSerial = serial
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return diff, err
+}
+
+// Export writes a zone as a master file, starting with its SOA
+func (zs *ZoneFileService) Export(w io.Writer, zoneId string) error {
+	var zone data.Zone
+	if err := zs.db.Where("id = ?", zoneId).First(&zone).Error; err != nil {
+		return err
+	}
+	var records []data.Record
+	if err := zs.db.Where("zone_id = ?", zoneId).Find(&records).Error; err != nil {
+		return err
+	}
+	zoneName := strings.ToLower(strings.TrimSuffix(zone.Name, "."))
+
+	mname := "ns1." + zoneName
+	var entries []utils.ZoneFileRecord
+	for _, record := range records {
+		rtype, ok := utils.ParseDNSRecordType(record.Type)
+		if !ok || rtype == utils.TypeSOA {
+			continue
+		}
+		name := qualifyRecordName(record.Name, zone.Name)
+		entry := utils.ZoneFileRecord{Name: name, TTL: uint32(record.TTL), Type: rtype, Value: record.Value}
+		if answer, err := utils.NewDNSAnswer(name, rtype, entry.TTL, record.Value); err == nil {
+			entry.Value = answer.Value()
+		}
+		if rtype == utils.TypeNS && name == zoneName && mname == "ns1."+zoneName {
+			mname = strings.ToLower(strings.TrimSuffix(entry.Value, "."))
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Name != b.Name {
+			// The apex comes first, then names in order
+			return a.Name == zoneName || (b.Name != zoneName && a.Name < b.Name)
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+
+	soa := utils.NewSOAAnswer(zoneName, utils.SOATTL, mname, "hostmaster."+zoneName, zone.Serial,
+		utils.SOARefresh, utils.SOARetry, utils.SOAExpire, utils.SOAMinimum)
+	entries = append([]utils.ZoneFileRecord{{Name: zoneName, TTL: soa.TTL, Type: utils.TypeSOA, Value: soa.Value()}}, entries...)
+	return utils.WriteZoneFile(w, zoneName, utils.SOATTL, entries)
+}
+
+// zoneFileKey identifies a record by its owner, type and data, whatever the
+// way its value is written
+func zoneFileKey(name string, recordType string, value string) string {
+	recordType = strings.ToUpper(recordType)
+	if rtype, ok := utils.ParseDNSRecordType(recordType); ok {
+		if answer, err := utils.NewDNSAnswer(name, rtype, 0, value); err == nil {
+			value = answer.Value()
+		}
+		if rtype != utils.TypeTXT {
+			value = strings.ToLower(value)
+		}
+	}
+	return name + " " + recordType + " " + value
+}
+
+// qualifyRecordName returns the absolute name of a record, whose name may be
+// relative to the zone ("www"), the apex ("@" or empty) or absolute
+func qualifyRecordName(name string, zoneName string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zoneName = strings.ToLower(strings.TrimSuffix(zoneName, "."))
+	if name == "" || name == "@" {
+		return zoneName
+	}
+	if isBelowZone(name, zoneName) || zoneName == "" {
+		return name
+	}
+	return name + "." + zoneName
+}
+
+func isBelowZone(name string, zoneName string) bool {
+	zoneName = strings.ToLower(strings.TrimSuffix(zoneName, "."))
+	return zoneName == "" || name == zoneName || strings.HasSuffix(name, "."+zoneName)
+}
@@ -3,6 +3,8 @@ package utils
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"strconv"
 	"strings"
 )
 
@@ -13,13 +15,116 @@ type DNSRecordType uint16
 
 // Constants for different DNS record types
 const (
-	TypeA     DNSRecordType = 1  // A record (IPv4 address)
-	TypeAAAA  DNSRecordType = 28 // AAAA record (IPv6 address)
-	TypeCNAME DNSRecordType = 5  // CNAME record
-	TypeMX    DNSRecordType = 15 // MX record
-	TypeTXT   DNSRecordType = 16 // TXT record
+	TypeA          DNSRecordType = 1   // A record (IPv4 address)
+	TypeNS         DNSRecordType = 2   // NS record
+	TypeCNAME      DNSRecordType = 5   // CNAME record
+	TypeSOA        DNSRecordType = 6   // SOA record
+	TypePTR        DNSRecordType = 12  // PTR record
+	TypeMX         DNSRecordType = 15  // MX record
+	TypeTXT        DNSRecordType = 16  // TXT record
+	TypeAAAA       DNSRecordType = 28  // AAAA record (IPv6 address)
+	TypeSRV        DNSRecordType = 33  // SRV record
+	TypeOPT        DNSRecordType = 41  // EDNS pseudo record
+	TypeDS         DNSRecordType = 43  // Delegation signer
+	TypeRRSIG      DNSRecordType = 46  // DNSSEC signature
+	TypeNSEC       DNSRecordType = 47  // Authenticated denial of existence
+	TypeDNSKEY     DNSRecordType = 48  // DNSSEC public key
+	TypeNSEC3      DNSRecordType = 50  // Hashed authenticated denial of existence
+	TypeNSEC3PARAM DNSRecordType = 51  // NSEC3 parameters
+	TypeCDS        DNSRecordType = 59  // Child DS
+	TypeCDNSKEY    DNSRecordType = 60  // Child DNSKEY
+	TypeTSIG       DNSRecordType = 250 // TSIG meta record
+	TypeIXFR       DNSRecordType = 251 // Incremental zone transfer
+	TypeAXFR       DNSRecordType = 252 // Full zone transfer
+	TypeANY        DNSRecordType = 255 // Any type
+	TypeCAA        DNSRecordType = 257 // Certification authority authorization
 )
 
+// DNS classes
+const (
+	ClassIN   uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
+
+// Opcodes, as found in bits 11-14 of the header flags
+const (
+	OpcodeQuery  uint16 = 0
+	OpcodeNotify uint16 = 4
+	OpcodeUpdate uint16 = 5
+)
+
+// Response codes, as found in the low 4 bits of the header flags
+const (
+	RcodeSuccess        uint16 = 0
+	RcodeFormatError    uint16 = 1
+	RcodeServerFailure  uint16 = 2
+	RcodeNameError      uint16 = 3
+	RcodeNotImplemented uint16 = 4
+	RcodeRefused        uint16 = 5
+	RcodeYXDomain       uint16 = 6
+	RcodeYXRRSet        uint16 = 7
+	RcodeNXRRSet        uint16 = 8
+	RcodeNotAuth        uint16 = 9
+	RcodeNotZone        uint16 = 10
+)
+
+// Header flag bits
+const (
+	FlagQR uint16 = 1 << 15 // Response
+	FlagAA uint16 = 1 << 10 // Authoritative answer
+	FlagTC uint16 = 1 << 9  // Truncated
+	FlagRD uint16 = 1 << 8  // Recursion desired
+	FlagRA uint16 = 1 << 7  // Recursion available
+	FlagAD uint16 = 1 << 5  // Authentic data, the answer was validated with DNSSEC
+	FlagCD uint16 = 1 << 4  // Checking disabled, return answers that fail validation
+)
+
+var recordTypeNames = map[DNSRecordType]string{
+	TypeA:          "A",
+	TypeNS:         "NS",
+	TypeCNAME:      "CNAME",
+	TypeSOA:        "SOA",
+	TypePTR:        "PTR",
+	TypeMX:         "MX",
+	TypeTXT:        "TXT",
+	TypeAAAA:       "AAAA",
+	TypeSRV:        "SRV",
+	TypeOPT:        "OPT",
+	TypeDS:         "DS",
+	TypeRRSIG:      "RRSIG",
+	TypeNSEC:       "NSEC",
+	TypeDNSKEY:     "DNSKEY",
+	TypeNSEC3:      "NSEC3",
+	TypeNSEC3PARAM: "NSEC3PARAM",
+	TypeCDS:        "CDS",
+	TypeCDNSKEY:    "CDNSKEY",
+	TypeTSIG:       "TSIG",
+	TypeIXFR:       "IXFR",
+	TypeAXFR:       "AXFR",
+	TypeANY:        "ANY",
+	TypeCAA:        "CAA",
+}
+
+// String returns the mnemonic of the record type, e.g. "A" or "MX"
+func (t DNSRecordType) String() string {
+	if name, ok := recordTypeNames[t]; ok {
+		return name
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseDNSRecordType returns the record type for a mnemonic such as "AAAA"
+func ParseDNSRecordType(name string) (DNSRecordType, bool) {
+	name = strings.ToUpper(name)
+	for t, n := range recordTypeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
 type DNSHeader struct {
 	ID      uint16
 	Flags   uint16
@@ -29,6 +134,16 @@ type DNSHeader struct {
 	Arcount uint16
 }
 
+// Opcode returns the opcode carried in the header flags
+func (header DNSHeader) Opcode() uint16 {
+	return (header.Flags >> 11) & 0xF
+}
+
+// Rcode returns the response code carried in the header flags
+func (header DNSHeader) Rcode() uint16 {
+	return header.Flags & 0xF
+}
+
 type DNSQuestion struct {
 	Name  string
 	Type  DNSRecordType
@@ -37,10 +152,15 @@ type DNSQuestion struct {
 
 // DNSPacket represents a full DNS packet
 type DNSPacket struct {
-	Header    DNSHeader
-	Questions []DNSQuestion
+	Header     DNSHeader
+	Questions  []DNSQuestion
+	Answers    []DNSAnswer // Prerequisite section for UPDATE messages
+	Authority  []DNSAnswer // Update section for UPDATE messages
+	Additional []DNSAnswer
 }
 
+var errTruncatedMessage = errors.New("truncated DNS message")
+
 // Serialize converts the DNSPacket into a byte slice
 func (packet *DNSPacket) Serialize() []byte {
 	buffer := new(bytes.Buffer)
@@ -48,10 +168,10 @@ func (packet *DNSPacket) Serialize() []byte {
 	// Write header
 	binary.Write(buffer, binary.BigEndian, packet.Header.ID)
 	binary.Write(buffer, binary.BigEndian, packet.Header.Flags)
-	binary.Write(buffer, binary.BigEndian, packet.Header.Qdcount)
-	binary.Write(buffer, binary.BigEndian, packet.Header.Ancount)
-	binary.Write(buffer, binary.BigEndian, packet.Header.Nscount)
-	binary.Write(buffer, binary.BigEndian, packet.Header.Arcount)
+	binary.Write(buffer, binary.BigEndian, uint16(len(packet.Questions)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(packet.Answers)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(packet.Authority)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(packet.Additional)))
 
 	// Write questions
 	for _, question := range packet.Questions {
@@ -60,34 +180,69 @@ func (packet *DNSPacket) Serialize() []byte {
 		binary.Write(buffer, binary.BigEndian, question.Class)
 	}
 
+	writeDNSAnswers(buffer, packet.Answers)
+	writeDNSAnswers(buffer, packet.Authority)
+	writeDNSAnswers(buffer, packet.Additional)
+
 	return buffer.Bytes()
 }
 
 // writeDNSName writes a domain name in DNS packet format
 func writeDNSName(buffer *bytes.Buffer, name string) {
-	for _, part := range strings.Split(name, ".") {
-		buffer.WriteByte(byte(len(part)))
-		buffer.WriteString(part)
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, part := range strings.Split(name, ".") {
+			buffer.WriteByte(byte(len(part)))
+			buffer.WriteString(part)
+		}
 	}
 	buffer.WriteByte(0) // Null byte to end the name
 }
-func ParseDNSPacket(data []byte) DNSPacket {
+
+func ParseDNSPacket(data []byte) (DNSPacket, error) {
 	packet := DNSPacket{}
-	header := parseDNSHeader(data)
+	header, err := parseDNSHeader(data)
+	if err != nil {
+		return packet, err
+	}
 	packet.Header = header
 	numberOfQuestions := int(header.Qdcount)
 	offset := HEADER_SIZE
 	for i := 0; i < numberOfQuestions; i++ {
-		question, nextOffset := parseDNSQuestion(data[offset:])
+		question, nextOffset, err := parseDNSQuestion(data, offset)
+		if err != nil {
+			return packet, err
+		}
 		packet.Questions = append(packet.Questions, question)
-		offset += nextOffset
+		offset = nextOffset
 
 	}
+	sections := []struct {
+		count  uint16
+		target *[]DNSAnswer
+	}{
+		{header.Ancount, &packet.Answers},
+		{header.Nscount, &packet.Authority},
+		{header.Arcount, &packet.Additional},
+	}
+	for _, section := range sections {
+		for i := 0; i < int(section.count); i++ {
+			answer, nextOffset, err := parseDNSAnswer(data, offset)
+			if err != nil {
+				return packet, err
+			}
+			*section.target = append(*section.target, answer)
+			offset = nextOffset
+		}
+	}
 
-	return packet
+	return packet, nil
 }
 
-func parseDNSHeader(data []byte) DNSHeader {
+func parseDNSHeader(data []byte) (DNSHeader, error) {
+	if len(data) < HEADER_SIZE {
+		return DNSHeader{}, errTruncatedMessage
+	}
 	return DNSHeader{
 		ID:      binary.BigEndian.Uint16(data[:2]),
 		Flags:   binary.BigEndian.Uint16(data[2:4]),
@@ -95,34 +250,67 @@ func parseDNSHeader(data []byte) DNSHeader {
 		Ancount: binary.BigEndian.Uint16(data[6:8]),
 		Nscount: binary.BigEndian.Uint16(data[8:10]),
 		Arcount: binary.BigEndian.Uint16(data[10:12]),
-	}
+	}, nil
 }
 
-func parseDNSQuestion(data []byte) (DNSQuestion, int) {
-	name, offset := parseDNSName(data)
+// parseDNSQuestion parses the question starting at offset in the full message
+// and returns it along with the offset of the next section entry
+func parseDNSQuestion(data []byte, offset int) (DNSQuestion, int, error) {
+	name, offset, err := parseDNSName(data, offset)
+	if err != nil {
+		return DNSQuestion{}, 0, err
+	}
+	if offset+4 > len(data) {
+		return DNSQuestion{}, 0, errTruncatedMessage
+	}
 	qtype := binary.BigEndian.Uint16(data[offset : offset+2])
 	qclass := binary.BigEndian.Uint16(data[offset+2 : offset+4])
-	return DNSQuestion{Name: name, Type: DNSRecordType(qtype), Class: qclass}, offset + 4
+	return DNSQuestion{Name: name, Type: DNSRecordType(qtype), Class: qclass}, offset + 4, nil
 }
 
-func parseDNSName(data []byte) (string, int) {
-	var name string
-	offset := 0
+// parseDNSName parses the name starting at offset in the full message,
+// following compression pointers, and returns the offset right after it
+func parseDNSName(data []byte, offset int) (string, int, error) {
+	var name strings.Builder
+	end := -1
+	jumps := 0
 
 	for {
+		if offset >= len(data) {
+			return "", 0, errTruncatedMessage
+		}
 		length := int(data[offset])
 		if length == 0 {
 			offset++ // Move past the null byte
 			break
 		}
 
-		if len(name) > 0 {
-			name += "." // Add dot only between labels, not at the beginning
+		if length&0xC0 == 0xC0 {
+			// Compression pointer to an earlier name in the message
+			if offset+2 > len(data) || jumps > 32 {
+				return "", 0, errTruncatedMessage
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:offset+2]) & 0x3FFF)
+			jumps++
+			continue
 		}
 
-		name += string(data[offset+1 : offset+1+length])
+		if offset+1+length > len(data) {
+			return "", 0, errTruncatedMessage
+		}
+		if name.Len() > 0 {
+			name.WriteByte('.') // Add dot only between labels, not at the beginning
+		}
+
+		name.Write(data[offset+1 : offset+1+length])
 		offset += 1 + length
 	}
 
-	return name, offset
+	if end < 0 {
+		end = offset
+	}
+	return name.String(), end, nil
 }
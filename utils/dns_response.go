@@ -18,83 +18,180 @@ type DNSAnswer struct {
 	MXPref  uint16   // For MX records, preference value
 	MXHost  string   // For MX records, host name
 	TXTData []string // For TXT records, can be multiple strings
+	RData   []byte   // For all other types, the uncompressed wire format RDATA
 }
 
 type DNSResponse struct {
-	Header    DNSHeader
-	Questions []DNSQuestion
-	Answers   []DNSAnswer
+	Header     DNSHeader
+	Questions  []DNSQuestion
+	Answers    []DNSAnswer
+	Authority  []DNSAnswer
+	Additional []DNSAnswer
 }
 
-func ParseDNSResponse(data []byte) DNSResponse {
-	packet := DNSResponse{}
-	header := parseDNSHeader(data)
-	packet.Header = header
-	numberOfQuestions := int(header.Qdcount)
-	numberOfAnswers := int(header.Ancount)
-
-	offset := HEADER_SIZE
-	for i := 0; i < numberOfQuestions; i++ {
-		question, nextOffset := parseDNSQuestion(data[offset:])
-		packet.Questions = append(packet.Questions, question)
-		offset += nextOffset
-
-	}
-	for i := 0; i < numberOfAnswers; i++ {
-		answer, nextOffset := parseDNSAnswer(data[offset:])
-		packet.Answers = append(packet.Answers, answer)
-		offset += nextOffset
-
-	}
-
-	return packet
+func ParseDNSResponse(data []byte) (DNSResponse, error) {
+	packet, err := ParseDNSPacket(data)
+	return DNSResponse{
+		Header:     packet.Header,
+		Questions:  packet.Questions,
+		Answers:    packet.Answers,
+		Authority:  packet.Authority,
+		Additional: packet.Additional,
+	}, err
 }
 
-func parseDNSAnswer(data []byte) (DNSAnswer, int) {
+// parseDNSAnswer parses the resource record starting at offset in the full
+// message and returns it along with the offset of the next record
+func parseDNSAnswer(data []byte, offset int) (DNSAnswer, int, error) {
 	var answer DNSAnswer
-	var offset int
 
-	name, n := parseDNSName(data)
+	name, offset, err := parseDNSName(data, offset)
+	if err != nil {
+		return answer, 0, err
+	}
 	answer.Name = name
-	offset = n
 
+	if offset+10 > len(data) {
+		return answer, 0, errTruncatedMessage
+	}
 	answer.Type = DNSRecordType(binary.BigEndian.Uint16(data[offset : offset+2]))
 	answer.Class = binary.BigEndian.Uint16(data[offset+2 : offset+4])
 	answer.TTL = binary.BigEndian.Uint32(data[offset+4 : offset+8])
-	dataLength := binary.BigEndian.Uint16(data[offset+8 : offset+10])
+	dataLength := int(binary.BigEndian.Uint16(data[offset+8 : offset+10]))
 	offset += 10
+	end := offset + dataLength
+	if end > len(data) {
+		return answer, 0, errTruncatedMessage
+	}
+	if dataLength == 0 {
+		// Empty RDATA is used by UPDATE messages to delete whole RRsets
+		return answer, end, nil
+	}
 
 	switch answer.Type {
 	case TypeA: // A record
-		answer.Addr = net.IP(data[offset : offset+int(dataLength)]).To4()
+		answer.Addr = net.IP(data[offset:end]).To4()
 
 	case TypeAAAA: // AAAA record
-		answer.Addr = net.IP(data[offset : offset+int(dataLength)]).To16()
+		answer.Addr = net.IP(data[offset:end]).To16()
 
 	case TypeCNAME: // CNAME record
-		cname, _ := parseDNSName(data[offset:])
-		answer.Cname = cname
+		answer.Cname, _, err = parseDNSName(data, offset)
 
 	case TypeMX: // MX record
+		if dataLength < 3 {
+			return answer, 0, errTruncatedMessage
+		}
 		answer.MXPref = binary.BigEndian.Uint16(data[offset : offset+2])
-		exchange, n := parseDNSName(data[offset+2:])
-		answer.MXHost = exchange
-		offset += n
+		answer.MXHost, _, err = parseDNSName(data, offset+2)
 
 	case TypeTXT: // TXT record
-		end := offset + int(dataLength)
 		var txtParts []string
 		for offset < end {
 			txtLength := int(data[offset])
 			offset++
+			if offset+txtLength > end {
+				return answer, 0, errTruncatedMessage
+			}
 			txtParts = append(txtParts, string(data[offset:offset+txtLength]))
 			offset += txtLength
 		}
 		answer.TXTData = txtParts
+
+	case TypeNS, TypePTR, TypeSOA, TypeSRV:
+		// These embed names that may be compressed, store them expanded
+		answer.RData, err = expandRData(data, offset, end, answer.Type)
+
+	default:
+		answer.RData = append([]byte(nil), data[offset:end]...)
+	}
+	if err != nil {
+		return answer, 0, err
 	}
 
-	offset += int(dataLength)
-	return answer, offset
+	return answer, end, nil
+}
+
+// expandRData copies the RDATA of types that carry domain names, replacing
+// any compression pointers with the full names
+func expandRData(data []byte, offset int, end int, rtype DNSRecordType) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if rtype == TypeSRV {
+		if offset+6 > end {
+			return nil, errTruncatedMessage
+		}
+		buffer.Write(data[offset : offset+6])
+		offset += 6
+	}
+	name, next, err := parseDNSName(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	writeDNSName(buffer, name)
+	if rtype == TypeSOA {
+		rname, next, err := parseDNSName(data, next)
+		if err != nil {
+			return nil, err
+		}
+		if next+20 > end {
+			return nil, errTruncatedMessage
+		}
+		writeDNSName(buffer, rname)
+		buffer.Write(data[next : next+20])
+	}
+	return buffer.Bytes(), nil
+}
+
+// RDataBytes returns the uncompressed wire format RDATA of the answer
+func (answer DNSAnswer) RDataBytes() []byte {
+	buffer := new(bytes.Buffer)
+	switch answer.Type {
+	case TypeA:
+		if answer.Addr == nil {
+			return answer.RData
+		}
+		buffer.Write(answer.Addr.To4())
+	case TypeAAAA:
+		if answer.Addr == nil {
+			return answer.RData
+		}
+		buffer.Write(answer.Addr.To16())
+	case TypeCNAME: // CNAME record
+		if answer.Cname == "" {
+			return answer.RData
+		}
+		writeDNSName(buffer, answer.Cname)
+	case TypeMX: // MX record
+		if answer.MXHost == "" {
+			return answer.RData
+		}
+		binary.Write(buffer, binary.BigEndian, answer.MXPref) // MX priority
+		writeDNSName(buffer, answer.MXHost)
+	case TypeTXT: // TXT record
+		if answer.TXTData == nil {
+			return answer.RData
+		}
+		for _, txt := range answer.TXTData {
+			buffer.WriteByte(byte(len(txt)))
+			buffer.WriteString(txt)
+		}
+	default:
+		return answer.RData
+	}
+	return buffer.Bytes()
+}
+
+// writeDNSAnswers writes resource records in DNS packet format
+func writeDNSAnswers(buffer *bytes.Buffer, answers []DNSAnswer) {
+	for _, answer := range answers {
+		writeDNSName(buffer, answer.Name)
+		binary.Write(buffer, binary.BigEndian, answer.Type)
+		binary.Write(buffer, binary.BigEndian, answer.Class)
+		binary.Write(buffer, binary.BigEndian, answer.TTL)
+		rdata := answer.RDataBytes()
+		binary.Write(buffer, binary.BigEndian, uint16(len(rdata)))
+		buffer.Write(rdata)
+	}
 }
 
 func (response DNSResponse) Serialize() []byte {
@@ -103,10 +200,10 @@ func (response DNSResponse) Serialize() []byte {
 	// Write header
 	binary.Write(buffer, binary.BigEndian, response.Header.ID)
 	binary.Write(buffer, binary.BigEndian, response.Header.Flags)
-	binary.Write(buffer, binary.BigEndian, response.Header.Qdcount)
-	binary.Write(buffer, binary.BigEndian, response.Header.Ancount)
-	binary.Write(buffer, binary.BigEndian, response.Header.Nscount)
-	binary.Write(buffer, binary.BigEndian, response.Header.Arcount)
+	binary.Write(buffer, binary.BigEndian, uint16(len(response.Questions)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(response.Answers)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(response.Authority)))
+	binary.Write(buffer, binary.BigEndian, uint16(len(response.Additional)))
 
 	// Write questions
 	for _, question := range response.Questions {
@@ -116,38 +213,9 @@ func (response DNSResponse) Serialize() []byte {
 	}
 
 	// Write answers
-	for _, answer := range response.Answers {
-		writeDNSName(buffer, answer.Name)
-		binary.Write(buffer, binary.BigEndian, answer.Type)
-		binary.Write(buffer, binary.BigEndian, answer.Class)
-		binary.Write(buffer, binary.BigEndian, answer.TTL)
-		switch answer.Type {
-		case TypeA:
-			binary.Write(buffer, binary.BigEndian, uint16(4)) // Length of an IPv4 address is 4 bytes
-			buffer.Write(answer.Addr.To4())
-			break
-		case TypeAAAA:
-			binary.Write(buffer, binary.BigEndian, uint16(16)) // Length of an IPv6 address
-			buffer.Write(answer.Addr.To16())
-			break
-		case TypeCNAME: // CNAME record
-			cname := serializeDNSName(answer.Cname) // Assume answer.CNAME is a string with the canonical name
-			binary.Write(buffer, binary.BigEndian, uint16(len(cname)))
-			buffer.Write(cname)
-
-		case TypeMX: // MX record
-			binary.Write(buffer, binary.BigEndian, uint16(2+len(serializeDNSName(answer.MXHost)))) // 2 bytes for priority + length of host
-			binary.Write(buffer, binary.BigEndian, answer.MXPref)                                  // MX priority
-			buffer.Write(serializeDNSName(answer.MXHost))
-
-		case TypeTXT: // TXT record
-			/*txt := []byte(answer.TXTData)
-			binary.Write(buffer, binary.BigEndian, uint16(len(txt)))
-			buffer.Write(txt)*/
-
-		}
-
-	}
+	writeDNSAnswers(buffer, response.Answers)
+	writeDNSAnswers(buffer, response.Authority)
+	writeDNSAnswers(buffer, response.Additional)
 
 	return buffer.Bytes()
 }
@@ -189,7 +257,8 @@ func (response DNSResponse) ToString() string {
 			sb.WriteString(fmt.Sprintf("MX Preference: %d, MX Host: %s\n", answer.MXPref, answer.MXHost))
 		case TypeTXT:
 			sb.WriteString(fmt.Sprintf("TXT: %s\n", strings.Join(answer.TXTData, ", ")))
-			// Add more cases as needed for other types
+		default:
+			sb.WriteString(fmt.Sprintf("%s\n", answer.Value()))
 		}
 	}
 
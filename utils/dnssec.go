@@ -0,0 +1,466 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSSEC signing algorithms (RFC 8624)
+const (
+	AlgorithmECDSAP256SHA256 uint8 = 13
+	AlgorithmED25519         uint8 = 15
+)
+
+// DNSKEY flags
+const (
+	DNSKEYFlagZone uint16 = 256 // Zone key, set on every key we publish
+	DNSKEYFlagSEP  uint16 = 1   // Secure entry point, set on key signing keys
+)
+
+// DigestSHA256 is the DS digest type we publish
+const DigestSHA256 uint8 = 2
+
+// ParseDNSSECAlgorithm returns the algorithm number for a mnemonic such as "ED25519"
+func ParseDNSSECAlgorithm(name string) (uint8, bool) {
+	switch strings.ToUpper(name) {
+	case "ECDSAP256SHA256", "13":
+		return AlgorithmECDSAP256SHA256, true
+	case "ED25519", "15":
+		return AlgorithmED25519, true
+	}
+	return 0, false
+}
+
+// DNSKEY is the public part of a zone key
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+// RData returns the wire format RDATA of the key
+func (key DNSKEY) RData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, key.Flags)
+	buffer.WriteByte(key.Protocol)
+	buffer.WriteByte(key.Algorithm)
+	buffer.Write(key.PublicKey)
+	return buffer.Bytes()
+}
+
+// KeyTag computes the key tag as described in RFC 4034 appendix B
+func (key DNSKEY) KeyTag() uint16 {
+	var ac uint32
+	for i, b := range key.RData() {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac & 0xFFFF)
+}
+
+// IsKSK reports whether the key is a key signing key
+func (key DNSKEY) IsKSK() bool {
+	return key.Flags&DNSKEYFlagSEP != 0
+}
+
+// ToAnswer returns the DNSKEY record of the key, or CDNSKEY when rtype says so
+func (key DNSKEY) ToAnswer(owner string, rtype DNSRecordType, ttl uint32) DNSAnswer {
+	return DNSAnswer{Name: owner, Type: rtype, Class: ClassIN, TTL: ttl, RData: key.RData()}
+}
+
+// ParseDNSKEY parses the RDATA of a DNSKEY or CDNSKEY record
+func ParseDNSKEY(rdata []byte) (DNSKEY, error) {
+	if len(rdata) < 4 {
+		return DNSKEY{}, errTruncatedMessage
+	}
+	return DNSKEY{
+		Flags:     binary.BigEndian.Uint16(rdata[0:2]),
+		Protocol:  rdata[2],
+		Algorithm: rdata[3],
+		PublicKey: append([]byte(nil), rdata[4:]...),
+	}, nil
+}
+
+// DS is a delegation signer record, published at the parent to vouch for a key
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+// DS computes the delegation signer record of the key owned by owner
+func (key DNSKEY) DS(owner string) DS {
+	digest := sha256.New()
+	digest.Write(serializeDNSName(strings.ToLower(owner)))
+	digest.Write(key.RData())
+	return DS{KeyTag: key.KeyTag(), Algorithm: key.Algorithm, DigestType: DigestSHA256, Digest: digest.Sum(nil)}
+}
+
+// RData returns the wire format RDATA of the DS record
+func (ds DS) RData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, ds.KeyTag)
+	buffer.WriteByte(ds.Algorithm)
+	buffer.WriteByte(ds.DigestType)
+	buffer.Write(ds.Digest)
+	return buffer.Bytes()
+}
+
+// String returns the DS record in presentation format, as entered at registrars
+func (ds DS) String() string {
+	return fmt.Sprintf("%d %d %d %X", ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)
+}
+
+// ToAnswer returns the DS record, or CDS when rtype says so
+func (ds DS) ToAnswer(owner string, rtype DNSRecordType, ttl uint32) DNSAnswer {
+	return DNSAnswer{Name: owner, Type: rtype, Class: ClassIN, TTL: ttl, RData: ds.RData()}
+}
+
+// ParseDS parses the RDATA of a DS or CDS record
+func ParseDS(rdata []byte) (DS, error) {
+	if len(rdata) < 4 {
+		return DS{}, errTruncatedMessage
+	}
+	return DS{
+		KeyTag:     binary.BigEndian.Uint16(rdata[0:2]),
+		Algorithm:  rdata[2],
+		DigestType: rdata[3],
+		Digest:     append([]byte(nil), rdata[4:]...),
+	}, nil
+}
+
+// SigningKey is a zone key along with its private part
+type SigningKey struct {
+	DNSKEY
+	PrivateKey crypto.Signer
+	State      KeyState
+}
+
+// KeyState tells how a key takes part in signing while it is rolled over
+type KeyState uint8
+
+const (
+	KeyActive    KeyState = iota // Published and signing
+	KeyPublished                 // Published ahead of its activation, not signing yet
+	KeyRetired                   // Published after its replacement took over
+)
+
+// GenerateSigningKey creates a new key pair for the algorithm
+func GenerateSigningKey(algorithm uint8, flags uint16) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	switch algorithm {
+	case AlgorithmECDSAP256SHA256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+	case AlgorithmED25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = key
+	default:
+		return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", algorithm)
+	}
+	return newSigningKey(algorithm, flags, privateKey)
+}
+
+func newSigningKey(algorithm uint8, flags uint16, privateKey crypto.Signer) (*SigningKey, error) {
+	key := &SigningKey{
+		DNSKEY:     DNSKEY{Flags: flags, Protocol: 3, Algorithm: algorithm},
+		PrivateKey: privateKey,
+	}
+	switch public := privateKey.Public().(type) {
+	case *ecdsa.PublicKey:
+		if algorithm != AlgorithmECDSAP256SHA256 {
+			return nil, errors.New("ECDSA key used with a non ECDSA algorithm")
+		}
+		key.PublicKey = append(padBytes(public.X.Bytes(), 32), padBytes(public.Y.Bytes(), 32)...)
+	case ed25519.PublicKey:
+		if algorithm != AlgorithmED25519 {
+			return nil, errors.New("Ed25519 key used with a non Ed25519 algorithm")
+		}
+		key.PublicKey = append([]byte(nil), public...)
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	return key, nil
+}
+
+// MarshalPrivateKey encodes the private key as base64 PKCS #8, for storage
+func (key *SigningKey) MarshalPrivateKey() (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// ParseSigningKey restores a key stored with MarshalPrivateKey
+func ParseSigningKey(algorithm uint8, flags uint16, privateKey string) (*SigningKey, error) {
+	der, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return newSigningKey(algorithm, flags, signer)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// RRSIG is the RDATA of a signature record
+type RRSIG struct {
+	TypeCovered DNSRecordType
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+// signedData returns the RDATA of the signature without the signature itself
+func (sig RRSIG) signedData() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, sig.TypeCovered)
+	buffer.WriteByte(sig.Algorithm)
+	buffer.WriteByte(sig.Labels)
+	binary.Write(buffer, binary.BigEndian, sig.OriginalTTL)
+	binary.Write(buffer, binary.BigEndian, sig.Expiration)
+	binary.Write(buffer, binary.BigEndian, sig.Inception)
+	binary.Write(buffer, binary.BigEndian, sig.KeyTag)
+	writeDNSName(buffer, strings.ToLower(sig.SignerName))
+	return buffer.Bytes()
+}
+
+// RData returns the wire format RDATA of the signature
+func (sig RRSIG) RData() []byte {
+	return append(sig.signedData(), sig.Signature...)
+}
+
+// ParseRRSIG parses the RDATA of an RRSIG record
+func ParseRRSIG(rdata []byte) (RRSIG, error) {
+	if len(rdata) < 18 {
+		return RRSIG{}, errTruncatedMessage
+	}
+	sig := RRSIG{
+		TypeCovered: DNSRecordType(binary.BigEndian.Uint16(rdata[0:2])),
+		Algorithm:   rdata[2],
+		Labels:      rdata[3],
+		OriginalTTL: binary.BigEndian.Uint32(rdata[4:8]),
+		Expiration:  binary.BigEndian.Uint32(rdata[8:12]),
+		Inception:   binary.BigEndian.Uint32(rdata[12:16]),
+		KeyTag:      binary.BigEndian.Uint16(rdata[16:18]),
+	}
+	signer, offset, err := parseDNSName(rdata, 18)
+	if err != nil {
+		return RRSIG{}, err
+	}
+	sig.SignerName = signer
+	sig.Signature = append([]byte(nil), rdata[offset:]...)
+	return sig, nil
+}
+
+// labelCount returns the number of labels of the name, not counting a
+// leading wildcard label
+func labelCount(name string) uint8 {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 0
+	}
+	labels := strings.Split(name, ".")
+	if labels[0] == "*" {
+		return uint8(len(labels) - 1)
+	}
+	return uint8(len(labels))
+}
+
+// canonicalRRSetData returns the data covered by a signature over the
+// RRset: the records in canonical form and order (RFC 4034 section 6)
+func canonicalRRSetData(rrset []DNSAnswer, originalTTL uint32) []byte {
+	var rdatas [][]byte
+	for _, rr := range rrset {
+		rdatas = append(rdatas, canonicalRData(rr))
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	owner := serializeDNSName(strings.ToLower(rrset[0].Name))
+	buffer := new(bytes.Buffer)
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue // Duplicate records are not part of the RRset
+		}
+		buffer.Write(owner)
+		binary.Write(buffer, binary.BigEndian, rrset[0].Type)
+		binary.Write(buffer, binary.BigEndian, rrset[0].Class)
+		binary.Write(buffer, binary.BigEndian, originalTTL)
+		binary.Write(buffer, binary.BigEndian, uint16(len(rdata)))
+		buffer.Write(rdata)
+	}
+	return buffer.Bytes()
+}
+
+// canonicalRData lower-cases the names embedded in the RDATA of the types
+// listed in RFC 4034 section 6.2
+func canonicalRData(rr DNSAnswer) []byte {
+	switch rr.Type {
+	case TypeCNAME:
+		rr.Cname = strings.ToLower(rr.Cname)
+	case TypeMX:
+		rr.MXHost = strings.ToLower(rr.MXHost)
+	}
+	return rr.RDataBytes()
+}
+
+// SignRRSet signs the RRset, whose records must share owner, type and class,
+// and returns the RRSIG record covering it
+func SignRRSet(rrset []DNSAnswer, key *SigningKey, signerName string, inception time.Time, expiration time.Time) (DNSAnswer, error) {
+	if len(rrset) == 0 {
+		return DNSAnswer{}, errors.New("cannot sign an empty RRset")
+	}
+	ttl := rrset[0].TTL
+	sig := RRSIG{
+		TypeCovered: rrset[0].Type,
+		Algorithm:   key.Algorithm,
+		Labels:      labelCount(rrset[0].Name),
+		OriginalTTL: ttl,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      key.KeyTag(),
+		SignerName:  canonicalDNSName(signerName),
+	}
+	data := append(sig.signedData(), canonicalRRSetData(rrset, ttl)...)
+
+	var err error
+	switch key.Algorithm {
+	case AlgorithmECDSAP256SHA256:
+		digest := sha256.Sum256(data)
+		privateKey, ok := key.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return DNSAnswer{}, errors.New("key is not an ECDSA key")
+		}
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, privateKey, digest[:])
+		if err == nil {
+			sig.Signature = append(padBytes(r.Bytes(), 32), padBytes(s.Bytes(), 32)...)
+		}
+	case AlgorithmED25519:
+		sig.Signature, err = key.PrivateKey.Sign(rand.Reader, data, crypto.Hash(0))
+	default:
+		err = fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+	}
+	if err != nil {
+		return DNSAnswer{}, err
+	}
+	return DNSAnswer{Name: rrset[0].Name, Type: TypeRRSIG, Class: rrset[0].Class, TTL: ttl, RData: sig.RData()}, nil
+}
+
+// canonicalDNSName lower-cases a name and strips its trailing dot
+func canonicalDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// VerifyRRSet checks that sig is a valid signature of the RRset made with
+// key, at the given time (RFC 4035 section 5.3)
+func VerifyRRSet(rrset []DNSAnswer, sig RRSIG, key DNSKEY, now time.Time) error {
+	if len(rrset) == 0 {
+		return errors.New("cannot verify an empty RRset")
+	}
+	if sig.TypeCovered != rrset[0].Type || sig.Algorithm != key.Algorithm || sig.KeyTag != key.KeyTag() {
+		return errors.New("signature does not cover the RRset with this key")
+	}
+	if key.Flags&DNSKEYFlagZone == 0 {
+		return errors.New("key is not a zone key")
+	}
+	// Validity times use serial number arithmetic (RFC 1982)
+	t := uint32(now.Unix())
+	if int32(t-sig.Inception) < 0 || int32(sig.Expiration-t) < 0 {
+		return errors.New("signature is not valid at this time")
+	}
+
+	owner := canonicalDNSName(rrset[0].Name)
+	labels := labelCount(owner)
+	if sig.Labels > labels {
+		return errors.New("signature has more labels than its owner")
+	}
+	if sig.Labels < labels {
+		// The RRset was synthesized from a wildcard, signed under its original owner
+		parts := strings.Split(owner, ".")
+		owner = strings.Join(append([]string{"*"}, parts[len(parts)-int(sig.Labels):]...), ".")
+		if sig.Labels == 0 {
+			owner = "*"
+		}
+	}
+	expanded := make([]DNSAnswer, len(rrset))
+	for i, rr := range rrset {
+		rr.Name = owner
+		expanded[i] = rr
+	}
+	data := append(sig.signedData(), canonicalRRSetData(expanded, sig.OriginalTTL)...)
+
+	switch key.Algorithm {
+	case AlgorithmECDSAP256SHA256:
+		if len(key.PublicKey) != 64 || len(sig.Signature) != 64 {
+			return errors.New("malformed ECDSA key or signature")
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(key.PublicKey[:32]),
+			Y:     new(big.Int).SetBytes(key.PublicKey[32:]),
+		}
+		digest := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(sig.Signature[:32])
+		s := new(big.Int).SetBytes(sig.Signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+	case AlgorithmED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(key.PublicKey, data, sig.Signature) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+	}
+	return nil
+}
+
+// SupportedAlgorithm reports whether keys of the algorithm can be verified
+func SupportedAlgorithm(algorithm uint8) bool {
+	return algorithm == AlgorithmECDSAP256SHA256 || algorithm == AlgorithmED25519
+}
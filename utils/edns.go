@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// DefaultEDNSUDPSize is the UDP payload size advertised in our OPT records
+const DefaultEDNSUDPSize = 1232
+
+// ExtendedRcodeBadVers is the upper 8 bits of the BADVERS rcode (16), sent
+// to clients using an EDNS version we do not support
+const ExtendedRcodeBadVers uint8 = 1
+
+// ednsFlagDO is the DNSSEC OK bit in the TTL field of an OPT record
+const ednsFlagDO = 1 << 15
+
+// EDNSOption is a single option carried in an OPT record
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// EDNS holds the information carried in an OPT record
+type EDNS struct {
+	UDPSize       uint16
+	ExtendedRcode uint8
+	Version       uint8
+	DO            bool // DNSSEC OK
+	Options       []EDNSOption
+}
+
+// EDNS returns the EDNS information of the packet, or nil if it has no OPT record
+func (packet DNSPacket) EDNS() *EDNS {
+	return findEDNS(packet.Additional)
+}
+
+// EDNS returns the EDNS information of the response, or nil if it has no OPT record
+func (response DNSResponse) EDNS() *EDNS {
+	return findEDNS(response.Additional)
+}
+
+func findEDNS(additional []DNSAnswer) *EDNS {
+	for _, answer := range additional {
+		if answer.Type != TypeOPT {
+			continue
+		}
+		edns := &EDNS{
+			UDPSize:       answer.Class,
+			ExtendedRcode: uint8(answer.TTL >> 24),
+			Version:       uint8(answer.TTL >> 16),
+			DO:            answer.TTL&ednsFlagDO != 0,
+		}
+		rdata := answer.RData
+		for len(rdata) >= 4 {
+			code := binary.BigEndian.Uint16(rdata[0:2])
+			length := int(binary.BigEndian.Uint16(rdata[2:4]))
+			if 4+length > len(rdata) {
+				break
+			}
+			edns.Options = append(edns.Options, EDNSOption{Code: code, Data: append([]byte(nil), rdata[4:4+length]...)})
+			rdata = rdata[4+length:]
+		}
+		return edns
+	}
+	return nil
+}
+
+// Option returns the data of the first option with the given code
+func (edns *EDNS) Option(code uint16) ([]byte, bool) {
+	for _, option := range edns.Options {
+		if option.Code == code {
+			return option.Data, true
+		}
+	}
+	return nil, false
+}
+
+// ToAnswer builds the OPT pseudo record carrying the EDNS information
+func (edns EDNS) ToAnswer() DNSAnswer {
+	ttl := uint32(edns.ExtendedRcode)<<24 | uint32(edns.Version)<<16
+	if edns.DO {
+		ttl |= ednsFlagDO
+	}
+	rdata := new(bytes.Buffer)
+	for _, option := range edns.Options {
+		binary.Write(rdata, binary.BigEndian, option.Code)
+		binary.Write(rdata, binary.BigEndian, uint16(len(option.Data)))
+		rdata.Write(option.Data)
+	}
+	return DNSAnswer{Name: "", Type: TypeOPT, Class: edns.UDPSize, TTL: ttl, RData: rdata.Bytes()}
+}
+
+// EDNSOptionClientSubnet is the code of the EDNS Client Subnet option (RFC 7871)
+const EDNSOptionClientSubnet uint16 = 8
+
+// ClientSubnet is the network of the client a resolver queries on behalf of
+type ClientSubnet struct {
+	Prefix      netip.Prefix // Address of the client truncated to the source prefix length
+	ScopeLength uint8        // Prefix length the answer is valid for, set in responses
+}
+
+// ClientSubnet returns the EDNS Client Subnet option of the message, or nil
+// if it has none. Malformed options are reported as errors.
+func (edns *EDNS) ClientSubnet() (*ClientSubnet, error) {
+	data, ok := edns.Option(EDNSOptionClientSubnet)
+	if !ok {
+		return nil, nil
+	}
+	return ParseClientSubnet(data)
+}
+
+// ParseClientSubnet decodes the data of an EDNS Client Subnet option
+func ParseClientSubnet(data []byte) (*ClientSubnet, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("client subnet option too short")
+	}
+	family := binary.BigEndian.Uint16(data[0:2])
+	sourceLength, scopeLength := int(data[2]), data[3]
+	address := data[4:]
+	var size int
+	switch family {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unsupported client subnet family %d", family)
+	}
+	if sourceLength > size*8 || len(address) != (sourceLength+7)/8 {
+		return nil, fmt.Errorf("invalid client subnet address length")
+	}
+	raw := make([]byte, size)
+	copy(raw, address)
+	ip, _ := netip.AddrFromSlice(raw)
+	prefix := netip.PrefixFrom(ip, sourceLength)
+	if prefix.Masked() != prefix {
+		return nil, fmt.Errorf("client subnet address has bits set beyond its prefix")
+	}
+	return &ClientSubnet{Prefix: prefix, ScopeLength: scopeLength}, nil
+}
+
+// ToOption encodes the client subnet as an EDNS option, truncating the
+// address to the source prefix length
+func (subnet ClientSubnet) ToOption() EDNSOption {
+	family := uint16(2)
+	if subnet.Prefix.Addr().Is4() {
+		family = 1
+	}
+	prefix := subnet.Prefix.Masked()
+	address := prefix.Addr().AsSlice()[:(prefix.Bits()+7)/8]
+	data := make([]byte, 4, 4+len(address))
+	binary.BigEndian.PutUint16(data, family)
+	data[2] = uint8(prefix.Bits())
+	data[3] = subnet.ScopeLength
+	return EDNSOption{Code: EDNSOptionClientSubnet, Data: append(data, address...)}
+}
+
+// EDNSOptionPadding is the code of the Padding option (RFC 7830)
+const EDNSOptionPadding uint16 = 12
+
+// EDNSOptionCookie is the code of the DNS Cookie option (RFC 7873)
+const EDNSOptionCookie uint16 = 10
+
+// RcodeBadCookie is the extended rcode of responses to queries with an
+// invalid server cookie. Its upper 8 bits go in the OPT record.
+const RcodeBadCookie uint16 = 23
+
+// Cookie is a client cookie, along with the server cookie the server
+// returned for it if any
+type Cookie struct {
+	Client []byte // 8 bytes
+	Server []byte // 8 to 32 bytes, empty until the server returned one
+}
+
+// Cookie returns the DNS Cookie option of the message, or nil if it has
+// none. Malformed options are reported as errors.
+func (edns *EDNS) Cookie() (*Cookie, error) {
+	data, ok := edns.Option(EDNSOptionCookie)
+	if !ok {
+		return nil, nil
+	}
+	return ParseCookie(data)
+}
+
+// ParseCookie decodes the data of a DNS Cookie option
+func ParseCookie(data []byte) (*Cookie, error) {
+	if len(data) != 8 && (len(data) < 16 || len(data) > 40) {
+		return nil, fmt.Errorf("invalid cookie length %d", len(data))
+	}
+	return &Cookie{Client: append([]byte(nil), data[:8]...), Server: append([]byte(nil), data[8:]...)}, nil
+}
+
+// ToOption encodes the cookies as an EDNS option
+func (cookie Cookie) ToOption() EDNSOption {
+	return EDNSOption{Code: EDNSOptionCookie, Data: append(append([]byte(nil), cookie.Client...), cookie.Server...)}
+}
+
+// ServerCookie computes the server cookie of a client cookie and address
+// at a time, in the interoperable format of RFC 9018: version 1, three
+// reserved bytes, the timestamp and the SipHash-2-4 of all that keyed with
+// the server secret
+func ServerCookie(secret [16]byte, clientCookie []byte, clientIP netip.Addr, timestamp uint32) []byte {
+	cookie := make([]byte, 8, 16)
+	cookie[0] = 1
+	binary.BigEndian.PutUint32(cookie[4:8], timestamp)
+	input := append(append(append([]byte(nil), clientCookie...), cookie...), clientIP.Unmap().AsSlice()...)
+	return binary.LittleEndian.AppendUint64(cookie, SipHash24(secret, input))
+}
+
+// ExtendedRcode returns the rcode of the response, including the upper bits
+// carried in its OPT record
+func (response DNSResponse) ExtendedRcode() uint16 {
+	rcode := response.Header.Rcode()
+	if edns := response.EDNS(); edns != nil {
+		rcode |= uint16(edns.ExtendedRcode) << 4
+	}
+	return rcode
+}
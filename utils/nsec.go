@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"sort"
+	"strings"
+)
+
+// NSEC3HashSHA1 is the only NSEC3 hash algorithm defined (RFC 5155)
+const NSEC3HashSHA1 uint8 = 1
+
+// NSEC3FlagOptOut marks NSEC3 records whose span may contain insecure delegations
+const NSEC3FlagOptOut uint8 = 1
+
+// NSEC proves which types exist at its owner name and that no names exist
+// between the owner and NextName
+type NSEC struct {
+	NextName string
+	Types    []DNSRecordType
+}
+
+// RData returns the wire format RDATA of the record
+func (nsec NSEC) RData() []byte {
+	buffer := new(bytes.Buffer)
+	writeDNSName(buffer, strings.ToLower(nsec.NextName))
+	buffer.Write(encodeTypeBitmap(nsec.Types))
+	return buffer.Bytes()
+}
+
+// ParseNSEC parses the RDATA of an NSEC record
+func ParseNSEC(rdata []byte) (NSEC, error) {
+	next, offset, err := parseDNSName(rdata, 0)
+	if err != nil {
+		return NSEC{}, err
+	}
+	types, err := decodeTypeBitmap(rdata[offset:])
+	return NSEC{NextName: next, Types: types}, err
+}
+
+// NSEC3 is the hashed variant of NSEC, proving no names exist between the
+// hash of its owner name and NextHashed
+type NSEC3 struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []DNSRecordType
+}
+
+// RData returns the wire format RDATA of the record
+func (nsec3 NSEC3) RData() []byte {
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte(nsec3.HashAlgorithm)
+	buffer.WriteByte(nsec3.Flags)
+	binary.Write(buffer, binary.BigEndian, nsec3.Iterations)
+	buffer.WriteByte(byte(len(nsec3.Salt)))
+	buffer.Write(nsec3.Salt)
+	buffer.WriteByte(byte(len(nsec3.NextHashed)))
+	buffer.Write(nsec3.NextHashed)
+	buffer.Write(encodeTypeBitmap(nsec3.Types))
+	return buffer.Bytes()
+}
+
+// ParseNSEC3 parses the RDATA of an NSEC3 record
+func ParseNSEC3(rdata []byte) (NSEC3, error) {
+	if len(rdata) < 5 {
+		return NSEC3{}, errTruncatedMessage
+	}
+	nsec3 := NSEC3{
+		HashAlgorithm: rdata[0],
+		Flags:         rdata[1],
+		Iterations:    binary.BigEndian.Uint16(rdata[2:4]),
+	}
+	offset := 4
+	saltLength := int(rdata[offset])
+	offset++
+	if offset+saltLength+1 > len(rdata) {
+		return NSEC3{}, errTruncatedMessage
+	}
+	nsec3.Salt = append([]byte(nil), rdata[offset:offset+saltLength]...)
+	offset += saltLength
+	hashLength := int(rdata[offset])
+	offset++
+	if offset+hashLength > len(rdata) {
+		return NSEC3{}, errTruncatedMessage
+	}
+	nsec3.NextHashed = append([]byte(nil), rdata[offset:offset+hashLength]...)
+	offset += hashLength
+	types, err := decodeTypeBitmap(rdata[offset:])
+	nsec3.Types = types
+	return nsec3, err
+}
+
+// NSEC3PARAM publishes the parameters used to build the NSEC3 chain of a zone
+type NSEC3PARAM struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+// RData returns the wire format RDATA of the record
+func (param NSEC3PARAM) RData() []byte {
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte(param.HashAlgorithm)
+	buffer.WriteByte(param.Flags)
+	binary.Write(buffer, binary.BigEndian, param.Iterations)
+	buffer.WriteByte(byte(len(param.Salt)))
+	buffer.Write(param.Salt)
+	return buffer.Bytes()
+}
+
+// HashNSEC3Name computes the iterated SHA-1 hash of a name (RFC 5155 section 5)
+func HashNSEC3Name(name string, salt []byte, iterations uint16) []byte {
+	digest := sha1.Sum(append(serializeDNSName(strings.ToLower(name)), salt...))
+	hash := digest[:]
+	for i := 0; i < int(iterations); i++ {
+		digest = sha1.Sum(append(hash, salt...))
+		hash = digest[:]
+	}
+	return hash
+}
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// EncodeNSEC3Hash returns the hash as the label used for NSEC3 owner names
+func EncodeNSEC3Hash(hash []byte) string {
+	return strings.ToLower(base32Hex.EncodeToString(hash))
+}
+
+// DecodeNSEC3Hash parses the first label of an NSEC3 owner name
+func DecodeNSEC3Hash(label string) ([]byte, error) {
+	return base32Hex.DecodeString(strings.ToUpper(label))
+}
+
+// CompareCanonical orders names as described in RFC 4034 section 6.1: label
+// by label starting from the root, comparing lower-cased labels as bytes
+func CompareCanonical(a string, b string) int {
+	la := splitLabels(a)
+	lb := splitLabels(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+func splitLabels(name string) []string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// encodeTypeBitmap encodes the types present at a name as window blocks
+// (RFC 4034 section 4.1.2)
+func encodeTypeBitmap(types []DNSRecordType) []byte {
+	sorted := append([]DNSRecordType(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	buffer := new(bytes.Buffer)
+	for i := 0; i < len(sorted); {
+		window := byte(sorted[i] >> 8)
+		var bitmap [32]byte
+		length := 0
+		for ; i < len(sorted) && byte(sorted[i]>>8) == window; i++ {
+			low := byte(sorted[i])
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			if int(low/8)+1 > length {
+				length = int(low/8) + 1
+			}
+		}
+		buffer.WriteByte(window)
+		buffer.WriteByte(byte(length))
+		buffer.Write(bitmap[:length])
+	}
+	return buffer.Bytes()
+}
+
+func decodeTypeBitmap(data []byte) ([]DNSRecordType, error) {
+	var types []DNSRecordType
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errTruncatedMessage
+		}
+		window := int(data[0])
+		length := int(data[1])
+		if length > 32 || 2+length > len(data) {
+			return nil, errTruncatedMessage
+		}
+		for i, b := range data[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, DNSRecordType(window<<8|i*8+bit))
+				}
+			}
+		}
+		data = data[2+length:]
+	}
+	return types, nil
+}
+
+// HasType reports whether the type is listed in a type bitmap
+func HasType(types []DNSRecordType, rtype DNSRecordType) bool {
+	for _, t := range types {
+		if t == rtype {
+			return true
+		}
+	}
+	return false
+}
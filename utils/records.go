@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// NewDNSAnswer builds a resource record from the textual value stored for a
+// record, e.g. "10 mail.example.com" for an MX record
+func NewDNSAnswer(name string, rtype DNSRecordType, ttl uint32, value string) (DNSAnswer, error) {
+	answer := DNSAnswer{Name: name, Type: rtype, Class: ClassIN, TTL: ttl}
+	fields := strings.Fields(value)
+
+	switch rtype {
+	case TypeA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() == nil {
+			return answer, fmt.Errorf("invalid IPv4 address %q", value)
+		}
+		answer.Addr = ip.To4()
+
+	case TypeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return answer, fmt.Errorf("invalid IPv6 address %q", value)
+		}
+		answer.Addr = ip.To16()
+
+	case TypeCNAME:
+		answer.Cname = strings.TrimSuffix(value, ".")
+
+	case TypeMX:
+		if len(fields) != 2 {
+			return answer, fmt.Errorf("invalid MX value %q, expected \"preference host\"", value)
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return answer, fmt.Errorf("invalid MX preference %q", fields[0])
+		}
+		answer.MXPref = uint16(pref)
+		answer.MXHost = strings.TrimSuffix(fields[1], ".")
+
+	case TypeTXT:
+		// Character strings are limited to 255 bytes, split longer values
+		for len(value) > 255 {
+			answer.TXTData = append(answer.TXTData, value[:255])
+			value = value[255:]
+		}
+		answer.TXTData = append(answer.TXTData, value)
+
+	case TypeNS, TypePTR:
+		answer.RData = serializeDNSName(strings.ToLower(value))
+
+	case TypeSRV:
+		if len(fields) != 4 {
+			return answer, fmt.Errorf("invalid SRV value %q, expected \"priority weight port target\"", value)
+		}
+		buffer := new(bytes.Buffer)
+		for _, field := range fields[:3] {
+			n, err := strconv.ParseUint(field, 10, 16)
+			if err != nil {
+				return answer, fmt.Errorf("invalid SRV field %q", field)
+			}
+			binary.Write(buffer, binary.BigEndian, uint16(n))
+		}
+		writeDNSName(buffer, strings.ToLower(fields[3]))
+		answer.RData = buffer.Bytes()
+
+	case TypeSOA:
+		if len(fields) != 7 {
+			return answer, fmt.Errorf("invalid SOA value %q", value)
+		}
+		buffer := new(bytes.Buffer)
+		writeDNSName(buffer, strings.ToLower(fields[0]))
+		writeDNSName(buffer, strings.ToLower(fields[1]))
+		for _, field := range fields[2:] {
+			n, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return answer, fmt.Errorf("invalid SOA field %q", field)
+			}
+			binary.Write(buffer, binary.BigEndian, uint32(n))
+		}
+		answer.RData = buffer.Bytes()
+
+	case TypeDS:
+		// Delegation signer of a child zone: "key-tag algorithm digest-type digest"
+		if len(fields) < 4 {
+			return answer, fmt.Errorf("invalid DS value %q, expected \"key-tag algorithm digest-type digest\"", value)
+		}
+		var numbers [3]uint64
+		for i, bits := range []int{16, 8, 8} {
+			n, err := strconv.ParseUint(fields[i], 10, bits)
+			if err != nil {
+				return answer, fmt.Errorf("invalid DS field %q", fields[i])
+			}
+			numbers[i] = n
+		}
+		digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+		if err != nil {
+			return answer, fmt.Errorf("invalid DS digest %q", strings.Join(fields[3:], ""))
+		}
+		answer.RData = DS{KeyTag: uint16(numbers[0]), Algorithm: uint8(numbers[1]), DigestType: uint8(numbers[2]), Digest: digest}.RData()
+
+	case TypeCAA:
+		// Certificate authorities allowed to issue for the name: "flags tag value"
+		if len(fields) < 3 {
+			return answer, fmt.Errorf("invalid CAA value %q, expected \"flags tag value\"", value)
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return answer, fmt.Errorf("invalid CAA flags %q", fields[0])
+		}
+		tag := fields[1]
+		if !isCAATag(tag) {
+			return answer, fmt.Errorf("invalid CAA tag %q", tag)
+		}
+		text := strings.TrimSpace(strings.TrimSpace(value)[len(fields[0]):])
+		text = strings.TrimSpace(text[len(tag):])
+		if len(text) >= 2 && strings.HasPrefix(text, `"`) && strings.HasSuffix(text, `"`) {
+			text = text[1 : len(text)-1]
+		}
+		answer.RData = append([]byte{byte(flags), byte(len(tag))}, tag+text...)
+
+	default:
+		return answer, fmt.Errorf("unsupported record type %s", rtype)
+	}
+	return answer, nil
+}
+
+// isCAATag reports whether a CAA property tag is 1 to 15 letters and digits
+func isCAATag(tag string) bool {
+	if len(tag) == 0 || len(tag) > 15 {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Default SOA timers of hosted zones
+const (
+	SOATTL     = 3600
+	SOARefresh = 3600
+	SOARetry   = 600
+	SOAExpire  = 604800
+	SOAMinimum = 300
+)
+
+// NewSOAAnswer builds the SOA record of a zone
+func NewSOAAnswer(zone string, ttl uint32, mname string, rname string, serial uint32, refresh uint32, retry uint32, expire uint32, minimum uint32) DNSAnswer {
+	buffer := new(bytes.Buffer)
+	writeDNSName(buffer, strings.ToLower(mname))
+	writeDNSName(buffer, strings.ToLower(rname))
+	for _, n := range []uint32{serial, refresh, retry, expire, minimum} {
+		binary.Write(buffer, binary.BigEndian, n)
+	}
+	return DNSAnswer{Name: zone, Type: TypeSOA, Class: ClassIN, TTL: ttl, RData: buffer.Bytes()}
+}
+
+// Value returns the textual representation of the answer's data, in the
+// same format accepted by NewDNSAnswer
+func (answer DNSAnswer) Value() string {
+	switch answer.Type {
+	case TypeA, TypeAAAA:
+		return answer.Addr.String()
+	case TypeCNAME:
+		return answer.Cname
+	case TypeMX:
+		return fmt.Sprintf("%d %s", answer.MXPref, answer.MXHost)
+	case TypeTXT:
+		return strings.Join(answer.TXTData, "")
+	case TypeNS, TypePTR:
+		name, _, err := parseDNSName(answer.RData, 0)
+		if err == nil {
+			return name
+		}
+	case TypeSRV:
+		if len(answer.RData) > 6 {
+			target, _, err := parseDNSName(answer.RData, 6)
+			if err == nil {
+				return fmt.Sprintf("%d %d %d %s",
+					binary.BigEndian.Uint16(answer.RData[0:2]),
+					binary.BigEndian.Uint16(answer.RData[2:4]),
+					binary.BigEndian.Uint16(answer.RData[4:6]),
+					target)
+			}
+		}
+	case TypeSOA:
+		mname, offset, err := parseDNSName(answer.RData, 0)
+		if err != nil {
+			break
+		}
+		rname, offset, err := parseDNSName(answer.RData, offset)
+		if err != nil || offset+20 > len(answer.RData) {
+			break
+		}
+		values := []string{mname, rname}
+		for i := 0; i < 5; i++ {
+			values = append(values, strconv.FormatUint(uint64(binary.BigEndian.Uint32(answer.RData[offset+4*i:])), 10))
+		}
+		return strings.Join(values, " ")
+	case TypeDS:
+		if ds, err := ParseDS(answer.RData); err == nil {
+			return ds.String()
+		}
+	case TypeCAA:
+		if len(answer.RData) >= 2 && len(answer.RData) >= 2+int(answer.RData[1]) {
+			end := 2 + int(answer.RData[1])
+			return fmt.Sprintf("%d %s \"%s\"", answer.RData[0], answer.RData[2:end], answer.RData[end:])
+		}
+	}
+	return fmt.Sprintf("\\# %d %x", len(answer.RData), answer.RData)
+}
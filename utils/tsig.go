@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// Supported TSIG algorithms (RFC 8945)
+const (
+	TSIGHmacSHA256 = "hmac-sha256"
+	TSIGHmacSHA512 = "hmac-sha512"
+)
+
+// TSIG error codes, carried in the Error field of the TSIG record
+const (
+	TSIGErrBadSig  uint16 = 16
+	TSIGErrBadKey  uint16 = 17
+	TSIGErrBadTime uint16 = 18
+)
+
+// TSIGFudge is the allowed clock skew, in seconds, between signer and verifier
+const TSIGFudge = 300
+
+// TSIGKey is a shared secret used to sign and verify messages
+type TSIGKey struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// TSIGRecord is the RDATA of a TSIG record along with its owner name
+type TSIGRecord struct {
+	KeyName    string
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	OtherData  []byte
+}
+
+// TSIGError is returned when a message fails TSIG verification
+type TSIGError struct {
+	Code uint16
+}
+
+func (e *TSIGError) Error() string {
+	switch e.Code {
+	case TSIGErrBadSig:
+		return "TSIG verification failed: bad signature"
+	case TSIGErrBadKey:
+		return "TSIG verification failed: unknown key"
+	case TSIGErrBadTime:
+		return "TSIG verification failed: signature expired"
+	}
+	return fmt.Sprintf("TSIG verification failed: error %d", e.Code)
+}
+
+// IsSupportedTSIGAlgorithm reports whether messages can be signed with the algorithm
+func IsSupportedTSIGAlgorithm(algorithm string) bool {
+	return tsigHash(algorithm) != nil
+}
+
+func tsigHash(algorithm string) func() hash.Hash {
+	switch strings.ToLower(strings.TrimSuffix(algorithm, ".")) {
+	case TSIGHmacSHA256:
+		return sha256.New
+	case TSIGHmacSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// SignTSIG appends a TSIG record to the message and returns the signed
+// message and its MAC. requestMAC must be set when signing a response, so the
+// signature covers the request it answers.
+func SignTSIG(msg []byte, key TSIGKey, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	if len(msg) < HEADER_SIZE {
+		return nil, nil, errTruncatedMessage
+	}
+	newHash := tsigHash(key.Algorithm)
+	if newHash == nil {
+		return nil, nil, fmt.Errorf("unsupported TSIG algorithm %q", key.Algorithm)
+	}
+	record := TSIGRecord{
+		KeyName:    key.Name,
+		Algorithm:  key.Algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      TSIGFudge,
+		OriginalID: binary.BigEndian.Uint16(msg[0:2]),
+	}
+	record.MAC = computeTSIGMAC(newHash, key.Secret, msg, requestMAC, record, false)
+	return appendTSIG(msg, record), record.MAC, nil
+}
+
+// SignTSIGContinuation signs a message following the first one of a response
+// spanning several messages, such as a zone transfer. Its MAC covers the MAC
+// of the previous message and only the timers of the TSIG variables, as
+// described in RFC 8945 section 5.3.1.
+func SignTSIGContinuation(msg []byte, key TSIGKey, priorMAC []byte, now time.Time) ([]byte, []byte, error) {
+	if len(msg) < HEADER_SIZE {
+		return nil, nil, errTruncatedMessage
+	}
+	newHash := tsigHash(key.Algorithm)
+	if newHash == nil {
+		return nil, nil, fmt.Errorf("unsupported TSIG algorithm %q", key.Algorithm)
+	}
+	record := TSIGRecord{
+		KeyName:    key.Name,
+		Algorithm:  key.Algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      TSIGFudge,
+		OriginalID: binary.BigEndian.Uint16(msg[0:2]),
+	}
+	record.MAC = computeTSIGMAC(newHash, key.Secret, msg, priorMAC, record, true)
+	return appendTSIG(msg, record), record.MAC, nil
+}
+
+// AppendTSIGError appends an unsigned TSIG record carrying errorCode, used to
+// answer requests whose key or signature could not be verified
+func AppendTSIGError(msg []byte, request TSIGRecord, errorCode uint16, now time.Time) []byte {
+	record := TSIGRecord{
+		KeyName:    request.KeyName,
+		Algorithm:  request.Algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      TSIGFudge,
+		OriginalID: request.OriginalID,
+		Error:      errorCode,
+	}
+	return appendTSIG(msg, record)
+}
+
+// VerifyTSIG checks the TSIG record at the end of msg against key. It returns
+// the TSIG record on success, or a *TSIGError describing the failure.
+func VerifyTSIG(msg []byte, key TSIGKey, requestMAC []byte, now time.Time) (*TSIGRecord, error) {
+	return verifyTSIG(msg, key, requestMAC, now, false)
+}
+
+// VerifyTSIGContinuation checks the TSIG record of a message following the
+// first one of a response, signed with SignTSIGContinuation
+func VerifyTSIGContinuation(msg []byte, key TSIGKey, priorMAC []byte, now time.Time) (*TSIGRecord, error) {
+	return verifyTSIG(msg, key, priorMAC, now, true)
+}
+
+func verifyTSIG(msg []byte, key TSIGKey, requestMAC []byte, now time.Time, timersOnly bool) (*TSIGRecord, error) {
+	record, stripped, err := ReadTSIG(msg)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, errors.New("message is not signed")
+	}
+	newHash := tsigHash(record.Algorithm)
+	if newHash == nil || !strings.EqualFold(strings.TrimSuffix(record.KeyName, "."), strings.TrimSuffix(key.Name, ".")) ||
+		!strings.EqualFold(strings.TrimSuffix(record.Algorithm, "."), key.Algorithm) {
+		return record, &TSIGError{Code: TSIGErrBadKey}
+	}
+	expected := computeTSIGMAC(newHash, key.Secret, stripped, requestMAC, *record, timersOnly)
+	if !hmac.Equal(expected, record.MAC) {
+		return record, &TSIGError{Code: TSIGErrBadSig}
+	}
+	skew := int64(record.TimeSigned) - now.Unix()
+	if skew < -int64(record.Fudge) || skew > int64(record.Fudge) {
+		return record, &TSIGError{Code: TSIGErrBadTime}
+	}
+	return record, nil
+}
+
+// ReadTSIG returns the TSIG record of the message, or nil if it is not
+// signed, along with the message as it was before signing: without the TSIG
+// record, with ARCOUNT decremented and the original ID restored
+func ReadTSIG(msg []byte) (*TSIGRecord, []byte, error) {
+	header, err := parseDNSHeader(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Arcount == 0 {
+		return nil, msg, nil
+	}
+	offset := HEADER_SIZE
+	for i := 0; i < int(header.Qdcount); i++ {
+		if _, offset, err = parseDNSQuestion(msg, offset); err != nil {
+			return nil, nil, err
+		}
+	}
+	lastStart := 0
+	total := int(header.Ancount) + int(header.Nscount) + int(header.Arcount)
+	for i := 0; i < total; i++ {
+		lastStart = offset
+		if _, offset, err = parseDNSAnswer(msg, offset); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	last, _, err := parseDNSAnswer(msg, lastStart)
+	if err != nil || last.Type != TypeTSIG {
+		return nil, msg, err
+	}
+	record, err := parseTSIGRData(last.Name, last.RData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stripped := append([]byte(nil), msg[:lastStart]...)
+	binary.BigEndian.PutUint16(stripped[0:2], record.OriginalID)
+	binary.BigEndian.PutUint16(stripped[10:12], header.Arcount-1)
+	return record, stripped, nil
+}
+
+func parseTSIGRData(keyName string, rdata []byte) (*TSIGRecord, error) {
+	record := &TSIGRecord{KeyName: keyName}
+	algorithm, offset, err := parseDNSName(rdata, 0)
+	if err != nil {
+		return nil, err
+	}
+	record.Algorithm = algorithm
+	if offset+10 > len(rdata) {
+		return nil, errTruncatedMessage
+	}
+	record.TimeSigned = uint64(binary.BigEndian.Uint16(rdata[offset:]))<<32 | uint64(binary.BigEndian.Uint32(rdata[offset+2:]))
+	record.Fudge = binary.BigEndian.Uint16(rdata[offset+6:])
+	macSize := int(binary.BigEndian.Uint16(rdata[offset+8:]))
+	offset += 10
+	if offset+macSize+6 > len(rdata) {
+		return nil, errTruncatedMessage
+	}
+	record.MAC = append([]byte(nil), rdata[offset:offset+macSize]...)
+	offset += macSize
+	record.OriginalID = binary.BigEndian.Uint16(rdata[offset:])
+	record.Error = binary.BigEndian.Uint16(rdata[offset+2:])
+	otherLen := int(binary.BigEndian.Uint16(rdata[offset+4:]))
+	offset += 6
+	if offset+otherLen > len(rdata) {
+		return nil, errTruncatedMessage
+	}
+	record.OtherData = append([]byte(nil), rdata[offset:offset+otherLen]...)
+	return record, nil
+}
+
+// computeTSIGMAC computes the MAC over the unsigned message and the TSIG
+// variables, as described in RFC 8945 section 4.3, or only their timers for
+// the messages following the first one of a response
+func computeTSIGMAC(newHash func() hash.Hash, secret []byte, msg []byte, requestMAC []byte, record TSIGRecord, timersOnly bool) []byte {
+	mac := hmac.New(newHash, secret)
+	if requestMAC != nil {
+		binary.Write(mac, binary.BigEndian, uint16(len(requestMAC)))
+		mac.Write(requestMAC)
+	}
+	mac.Write(msg)
+
+	variables := new(bytes.Buffer)
+	if timersOnly {
+		writeTSIGTimers(variables, record)
+		mac.Write(variables.Bytes())
+		return mac.Sum(nil)
+	}
+	writeDNSName(variables, strings.ToLower(record.KeyName))
+	binary.Write(variables, binary.BigEndian, ClassANY)
+	binary.Write(variables, binary.BigEndian, uint32(0)) // TTL
+	writeDNSName(variables, strings.ToLower(record.Algorithm))
+	writeTSIGTimers(variables, record)
+	binary.Write(variables, binary.BigEndian, record.Error)
+	binary.Write(variables, binary.BigEndian, uint16(len(record.OtherData)))
+	variables.Write(record.OtherData)
+	mac.Write(variables.Bytes())
+
+	return mac.Sum(nil)
+}
+
+func writeTSIGTimers(buffer *bytes.Buffer, record TSIGRecord) {
+	binary.Write(buffer, binary.BigEndian, uint16(record.TimeSigned>>32))
+	binary.Write(buffer, binary.BigEndian, uint32(record.TimeSigned))
+	binary.Write(buffer, binary.BigEndian, record.Fudge)
+}
+
+func appendTSIG(msg []byte, record TSIGRecord) []byte {
+	rdata := new(bytes.Buffer)
+	writeDNSName(rdata, strings.ToLower(record.Algorithm))
+	writeTSIGTimers(rdata, record)
+	binary.Write(rdata, binary.BigEndian, uint16(len(record.MAC)))
+	rdata.Write(record.MAC)
+	binary.Write(rdata, binary.BigEndian, record.OriginalID)
+	binary.Write(rdata, binary.BigEndian, record.Error)
+	binary.Write(rdata, binary.BigEndian, uint16(len(record.OtherData)))
+	rdata.Write(record.OtherData)
+
+	buffer := bytes.NewBuffer(append([]byte(nil), msg...))
+	writeDNSAnswers(buffer, []DNSAnswer{{
+		Name:  strings.ToLower(record.KeyName),
+		Type:  TypeTSIG,
+		Class: ClassANY,
+		TTL:   0,
+		RData: rdata.Bytes(),
+	}})
+	signed := buffer.Bytes()
+	binary.BigEndian.PutUint16(signed[10:12], binary.BigEndian.Uint16(signed[10:12])+1)
+	return signed
+}
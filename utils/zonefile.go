@@ -0,0 +1,504 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxIncludeDepth bounds nested $INCLUDE directives, which could loop
+const maxIncludeDepth = 8
+
+// ZoneFileRecord is a record of a master file (RFC 1035 section 5). Names
+// are absolute, lower-cased and without their trailing dot, and values are
+// in the format of NewDNSAnswer.
+type ZoneFileRecord struct {
+	Name  string
+	TTL   uint32
+	Type  DNSRecordType
+	Value string
+}
+
+// IncludeFunc returns the content of a file named by an $INCLUDE directive
+type IncludeFunc func(name string) ([]byte, error)
+
+// zoneFileToken is a word or a quoted string of a master file
+type zoneFileToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneFileEntry is a directive or a record, which parentheses may spread
+// over several lines
+type zoneFileEntry struct {
+	line        int
+	tokens      []zoneFileToken
+	sameOwner   bool // The entry starts with a blank, its owner is that of the previous record
+	startOfLine bool
+}
+
+// zoneFileParser holds the state carried from an entry to the next
+type zoneFileParser struct {
+	include    IncludeFunc
+	origin     string
+	defaultTTL *uint32
+	lastTTL    *uint32
+	lastOwner  string
+	records    []ZoneFileRecord
+}
+
+// ParseZoneFile reads the records of a master file with the given origin,
+// supporting $ORIGIN, $TTL and $INCLUDE, relative names, parentheses and
+// comments. Includes are refused when include is nil.
+func ParseZoneFile(name string, content []byte, origin string, include IncludeFunc) ([]ZoneFileRecord, error) {
+	parser := &zoneFileParser{include: include, origin: canonicalZoneName(origin)}
+	if err := parser.parse(name, content, 0); err != nil {
+		return nil, err
+	}
+	return parser.records, nil
+}
+
+func (parser *zoneFileParser) parse(name string, content []byte, depth int) error {
+	entries, err := tokenizeZoneFile(content)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	for _, entry := range entries {
+		if err := parser.entry(entry, depth); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, entry.line, err)
+		}
+	}
+	return nil
+}
+
+func (parser *zoneFileParser) entry(entry zoneFileEntry, depth int) error {
+	tokens := entry.tokens
+	if !entry.sameOwner && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+		return parser.directive(strings.ToUpper(tokens[0].text), tokens[1:], depth)
+	}
+
+	owner := parser.lastOwner
+	if !entry.sameOwner {
+		owner = parser.qualify(tokens[0].text)
+		tokens = tokens[1:]
+	} else if owner == "" {
+		return fmt.Errorf("no owner for the record")
+	}
+	parser.lastOwner = owner
+
+	var ttl *uint32
+fields:
+	for i := 0; i < 2 && len(tokens) > 0; i++ {
+		// The TTL and the class are optional and come in any order
+		seconds, err := parseZoneFileTTL(tokens[0].text)
+		class := strings.ToUpper(tokens[0].text)
+		switch {
+		case err == nil && ttl == nil:
+			ttl = &seconds
+		case class == "IN":
+		case class == "CH" || class == "HS" || class == "CS":
+			return fmt.Errorf("unsupported class %s", class)
+		default:
+			break fields
+		}
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("missing record type")
+	}
+	rtype, ok := ParseDNSRecordType(tokens[0].text)
+	if !ok {
+		return fmt.Errorf("unsupported record type %s", tokens[0].text)
+	}
+	switch {
+	case ttl != nil:
+		parser.lastTTL = ttl
+	case parser.defaultTTL != nil:
+		ttl = parser.defaultTTL
+	case parser.lastTTL != nil:
+		ttl = parser.lastTTL
+	default:
+		return fmt.Errorf("no TTL for the record and no $TTL")
+	}
+
+	value, err := parser.rdata(rtype, tokens[1:])
+	if err != nil {
+		return err
+	}
+	if _, err := NewDNSAnswer(owner, rtype, *ttl, value); err != nil {
+		return err
+	}
+	parser.records = append(parser.records, ZoneFileRecord{Name: owner, TTL: *ttl, Type: rtype, Value: value})
+	return nil
+}
+
+func (parser *zoneFileParser) directive(directive string, args []zoneFileToken, depth int) error {
+	switch directive {
+	case "$ORIGIN":
+		if len(args) != 1 {
+			return fmt.Errorf("$ORIGIN takes a name")
+		}
+		parser.origin = parser.qualify(args[0].text)
+	case "$TTL":
+		if len(args) != 1 {
+			return fmt.Errorf("$TTL takes a TTL")
+		}
+		ttl, err := parseZoneFileTTL(args[0].text)
+		if err != nil {
+			return err
+		}
+		parser.defaultTTL = &ttl
+	case "$INCLUDE":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("$INCLUDE takes a file name and an optional origin")
+		}
+		if parser.include == nil {
+			return fmt.Errorf("$INCLUDE is not allowed here")
+		}
+		if depth >= maxIncludeDepth {
+			return fmt.Errorf("too many nested $INCLUDE")
+		}
+		content, err := parser.include(args[0].text)
+		if err != nil {
+			return err
+		}
+		// The included file starts with its own origin and owner, both of
+		// which are restored after it
+		origin, owner := parser.origin, parser.lastOwner
+		if len(args) == 2 {
+			parser.origin = parser.qualify(args[1].text)
+		}
+		err = parser.parse(args[0].text, content, depth+1)
+		parser.origin, parser.lastOwner = origin, owner
+		return err
+	default:
+		return fmt.Errorf("unsupported directive %s", directive)
+	}
+	return nil
+}
+
+// rdata turns the data of a record into its value
+func (parser *zoneFileParser) rdata(rtype DNSRecordType, tokens []zoneFileToken) (string, error) {
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = token.text
+	}
+	count := map[DNSRecordType]int{TypeA: 1, TypeAAAA: 1, TypeNS: 1, TypeCNAME: 1, TypePTR: 1, TypeMX: 2, TypeSRV: 4, TypeSOA: 7}
+	if n, fixed := count[rtype]; fixed && len(words) != n {
+		return "", fmt.Errorf("%s records have %d fields, got %d", rtype, n, len(words))
+	}
+	switch rtype {
+	case TypeA, TypeAAAA:
+		return words[0], nil
+	case TypeNS, TypeCNAME, TypePTR:
+		return parser.qualify(words[0]), nil
+	case TypeMX:
+		return words[0] + " " + parser.qualify(words[1]), nil
+	case TypeSRV:
+		return strings.Join(words[:3], " ") + " " + parser.qualify(words[3]), nil
+	case TypeSOA:
+		values := []string{parser.qualify(words[0]), parser.qualify(words[1]), words[2]}
+		for _, word := range words[3:] {
+			seconds, err := parseZoneFileTTL(word)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, strconv.FormatUint(uint64(seconds), 10))
+		}
+		return strings.Join(values, " "), nil
+	case TypeTXT:
+		if len(tokens) == 0 {
+			return "", fmt.Errorf("TXT records need a string")
+		}
+		var value strings.Builder
+		for _, token := range tokens {
+			text, err := unescapeZoneFileString(token.text)
+			if err != nil {
+				return "", err
+			}
+			value.WriteString(text)
+		}
+		return value.String(), nil
+	case TypeDS:
+		return strings.Join(words, " "), nil
+	case TypeCAA:
+		if len(tokens) < 3 {
+			return "", fmt.Errorf("CAA records have flags, a tag and a value")
+		}
+		var value strings.Builder
+		for _, token := range tokens[2:] {
+			text, err := unescapeZoneFileString(token.text)
+			if err != nil {
+				return "", err
+			}
+			value.WriteString(text)
+		}
+		return words[0] + " " + words[1] + ` "` + value.String() + `"`, nil
+	}
+	return "", fmt.Errorf("unsupported record type %s", rtype)
+}
+
+// qualify makes a name of the file absolute
+func (parser *zoneFileParser) qualify(name string) string {
+	if name == "@" {
+		return parser.origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return canonicalZoneName(name)
+	}
+	if parser.origin == "" {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + "." + parser.origin
+}
+
+func canonicalZoneName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// parseZoneFileTTL reads a TTL in seconds or in the BIND units, e.g. "1h30m"
+func parseZoneFileTTL(text string) (uint32, error) {
+	if text == "" || !unicode.IsDigit(rune(text[0])) {
+		return 0, fmt.Errorf("invalid TTL %q", text)
+	}
+	if seconds, err := strconv.ParseUint(text, 10, 32); err == nil {
+		return uint32(seconds), nil
+	}
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var total, number uint64
+	digits := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c >= '0' && c <= '9' {
+			number = number*10 + uint64(c-'0')
+			digits = true
+		} else if unit, ok := units[c|0x20]; ok && digits {
+			total += number * unit
+			number, digits = 0, false
+		} else {
+			return 0, fmt.Errorf("invalid TTL %q", text)
+		}
+		if total+number > 1<<31-1 {
+			return 0, fmt.Errorf("TTL %q is too large", text)
+		}
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid TTL %q", text)
+	}
+	return uint32(total), nil
+}
+
+// tokenizeZoneFile splits a master file into its entries
+func tokenizeZoneFile(content []byte) ([]zoneFileEntry, error) {
+	var entries []zoneFileEntry
+	line, depth := 1, 0
+	entry := zoneFileEntry{line: 1, startOfLine: true}
+	var word strings.Builder
+	inWord := false
+	endWord := func() {
+		if inWord {
+			entry.tokens = append(entry.tokens, zoneFileToken{text: word.String()})
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if entry.startOfLine && depth == 0 {
+			entry.startOfLine = false
+			entry.sameOwner = c == ' ' || c == '\t'
+		}
+		switch {
+		case c == ';':
+			endWord()
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '"':
+			endWord()
+			start := line
+			var text strings.Builder
+			for i++; i < len(content) && content[i] != '"'; i++ {
+				if content[i] == '\\' && i+1 < len(content) {
+					text.WriteByte(content[i])
+					i++
+				}
+				if content[i] == '\n' {
+					line++
+				}
+				text.WriteByte(content[i])
+			}
+			if i >= len(content) {
+				return nil, fmt.Errorf("%d: unterminated string", start)
+			}
+			entry.tokens = append(entry.tokens, zoneFileToken{text: text.String(), quoted: true})
+		case c == '(':
+			endWord()
+			depth++
+		case c == ')':
+			endWord()
+			if depth == 0 {
+				return nil, fmt.Errorf("%d: unbalanced parenthesis", line)
+			}
+			depth--
+		case c == '\n':
+			endWord()
+			line++
+			if depth == 0 {
+				if len(entry.tokens) > 0 {
+					entries = append(entries, entry)
+				}
+				entry = zoneFileEntry{line: line, startOfLine: true}
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		case c == '\\' && i+1 < len(content):
+			// Escapes are kept for the values that unescape them
+			word.WriteByte(c)
+			word.WriteByte(content[i+1])
+			inWord = true
+			i++
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	if depth > 0 {
+		return nil, fmt.Errorf("%d: unbalanced parenthesis", entry.line)
+	}
+	if len(entry.tokens) > 0 {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// unescapeZoneFileString resolves the \X and \DDD escapes of a string
+func unescapeZoneFileString(text string) (string, error) {
+	if !strings.Contains(text, "\\") {
+		return text, nil
+	}
+	var unescaped strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' {
+			unescaped.WriteByte(text[i])
+			continue
+		}
+		if i+3 < len(text) && isDigits(text[i+1:i+4]) {
+			n, _ := strconv.Atoi(text[i+1 : i+4])
+			if n > 255 {
+				return "", fmt.Errorf("invalid escape \\%s", text[i+1:i+4])
+			}
+			unescaped.WriteByte(byte(n))
+			i += 3
+		} else if i+1 < len(text) {
+			unescaped.WriteByte(text[i+1])
+			i++
+		}
+	}
+	return unescaped.String(), nil
+}
+
+func isDigits(text string) bool {
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteZoneFile writes records as a master file that BIND can load, with
+// owner names relative to the origin
+func WriteZoneFile(w io.Writer, origin string, ttl uint32, records []ZoneFileRecord) error {
+	origin = canonicalZoneName(origin)
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "$ORIGIN %s\n$TTL %d\n", absoluteName(origin), ttl)
+	for _, record := range records {
+		data, err := zoneFileData(record)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%-24s %-6d IN %-6s %s\n", relativeOwner(record.Name, origin), record.TTL, record.Type, data)
+	}
+	return out.Flush()
+}
+
+// zoneFileData returns the data of a record as written in master files
+func zoneFileData(record ZoneFileRecord) (string, error) {
+	fields := strings.Fields(record.Value)
+	switch record.Type {
+	case TypeNS, TypeCNAME, TypePTR:
+		return absoluteName(record.Value), nil
+	case TypeMX:
+		if len(fields) == 2 {
+			return fields[0] + " " + absoluteName(fields[1]), nil
+		}
+	case TypeSRV:
+		if len(fields) == 4 {
+			return strings.Join(fields[:3], " ") + " " + absoluteName(fields[3]), nil
+		}
+	case TypeSOA:
+		if len(fields) == 7 {
+			return absoluteName(fields[0]) + " " + absoluteName(fields[1]) + " ( " + strings.Join(fields[2:], " ") + " )", nil
+		}
+	case TypeTXT:
+		value := record.Value
+		var strs []string
+		for len(value) > 255 {
+			strs = append(strs, quoteZoneFileString(value[:255]))
+			value = value[255:]
+		}
+		strs = append(strs, quoteZoneFileString(value))
+		return strings.Join(strs, " "), nil
+	case TypeCAA:
+		answer, err := NewDNSAnswer("", TypeCAA, 0, record.Value)
+		if err == nil {
+			end := 2 + int(answer.RData[1])
+			return fmt.Sprintf("%d %s %s", answer.RData[0], answer.RData[2:end], quoteZoneFileString(string(answer.RData[end:]))), nil
+		}
+	default:
+		return record.Value, nil
+	}
+	return "", fmt.Errorf("invalid %s value %q", record.Type, record.Value)
+}
+
+func quoteZoneFileString(text string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"' || c == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&quoted, "\\%03d", c)
+		default:
+			quoted.WriteByte(c)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+func absoluteName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// relativeOwner writes an owner name relative to the origin when below it
+func relativeOwner(name string, origin string) string {
+	name = canonicalZoneName(name)
+	if name == origin {
+		return "@"
+	}
+	if origin != "" && strings.HasSuffix(name, "."+origin) {
+		return strings.TrimSuffix(name, "."+origin)
+	}
+	return absoluteName(name)
+}
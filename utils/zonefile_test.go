@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_ParseZoneFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		records []ZoneFileRecord
+		fails   bool
+	}{
+		{
+			name:    "RelativeNames",
+			content: "$TTL 300\nwww IN A 192.0.2.1\n@ NS ns1\nmail.example.com. MX 10 mx\n",
+			records: []ZoneFileRecord{
+				{Name: "www.example.com", TTL: 300, Type: TypeA, Value: "192.0.2.1"},
+				{Name: "example.com", TTL: 300, Type: TypeNS, Value: "ns1.example.com"},
+				{Name: "mail.example.com", TTL: 300, Type: TypeMX, Value: "10 mx.example.com"},
+			},
+		},
+		{
+			name:    "Origin",
+			content: "$TTL 60\n$ORIGIN sub\nhost A 192.0.2.2\n$ORIGIN other.org.\n@ CNAME target\n",
+			records: []ZoneFileRecord{
+				{Name: "host.sub.example.com", TTL: 60, Type: TypeA, Value: "192.0.2.2"},
+				{Name: "other.org", TTL: 60, Type: TypeCNAME, Value: "target.other.org"},
+			},
+		},
+		{
+			name:    "TTL",
+			content: "$TTL 1h\na A 192.0.2.1\nb 30 A 192.0.2.2\nc IN 1d2h A 192.0.2.3\n",
+			records: []ZoneFileRecord{
+				{Name: "a.example.com", TTL: 3600, Type: TypeA, Value: "192.0.2.1"},
+				{Name: "b.example.com", TTL: 30, Type: TypeA, Value: "192.0.2.2"},
+				{Name: "c.example.com", TTL: 93600, Type: TypeA, Value: "192.0.2.3"},
+			},
+		},
+		{
+			name:    "LastTTLWithoutDefault",
+			content: "a 120 A 192.0.2.1\nb A 192.0.2.2\n",
+			records: []ZoneFileRecord{
+				{Name: "a.example.com", TTL: 120, Type: TypeA, Value: "192.0.2.1"},
+				{Name: "b.example.com", TTL: 120, Type: TypeA, Value: "192.0.2.2"},
+			},
+		},
+		{
+			name:    "SameOwner",
+			content: "$TTL 300\nwww A 192.0.2.1\n    AAAA 2001:db8::1 ; the same owner\n",
+			records: []ZoneFileRecord{
+				{Name: "www.example.com", TTL: 300, Type: TypeA, Value: "192.0.2.1"},
+				{Name: "www.example.com", TTL: 300, Type: TypeAAAA, Value: "2001:db8::1"},
+			},
+		},
+		{
+			name: "Parentheses",
+			content: "$TTL 300\n@ SOA ns1 hostmaster (\n  2024010101 ; serial\n  1h 10m\n  1w 5m )\n" +
+				"_sip._tcp SRV ( 10 20\n 5060 sip )\n",
+			records: []ZoneFileRecord{
+				{Name: "example.com", TTL: 300, Type: TypeSOA, Value: "ns1.example.com hostmaster.example.com 2024010101 3600 600 604800 300"},
+				{Name: "_sip._tcp.example.com", TTL: 300, Type: TypeSRV, Value: "10 20 5060 sip.example.com"},
+			},
+		},
+		{
+			name:    "QuotedTXT",
+			content: "$TTL 300\ntxt TXT \"v=spf1 ; not a comment\" \" -all\"\nesc TXT \"say \\\"hi\\\" \\065\"\n",
+			records: []ZoneFileRecord{
+				{Name: "txt.example.com", TTL: 300, Type: TypeTXT, Value: "v=spf1 ; not a comment -all"},
+				{Name: "esc.example.com", TTL: 300, Type: TypeTXT, Value: "say \"hi\" A"},
+			},
+		},
+		{name: "NoTTL", content: "www A 192.0.2.1\n", fails: true},
+		{name: "UnbalancedParenthesis", content: "$TTL 300\n@ SOA ns1 hostmaster ( 1 2 3 4 5\n", fails: true},
+		{name: "UnterminatedString", content: "$TTL 300\ntxt TXT \"open\n", fails: true},
+		{name: "UnsupportedClass", content: "$TTL 300\nwww CH A 192.0.2.1\n", fails: true},
+		{name: "IncludeRefused", content: "$INCLUDE other.zone\n", fails: true},
+		{name: "WrongFieldCount", content: "$TTL 300\nmail MX mx\n", fails: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ParseZoneFile("test.zone", []byte(test.content), "example.com.", nil)
+			if test.fails {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if fmt.Sprint(records) != fmt.Sprint(test.records) {
+				t.Errorf("Expected %+v, got %+v", test.records, records)
+			}
+		})
+	}
+}
+
+func Test_ParseZoneFileInclude(t *testing.T) {
+	files := map[string]string{"hosts.zone": "db A 192.0.2.10\n"}
+	include := func(name string) ([]byte, error) {
+		content, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("no file %s", name)
+		}
+		return []byte(content), nil
+	}
+	content := "$TTL 300\nwww A 192.0.2.1\n$INCLUDE hosts.zone internal\n    A 192.0.2.2\n"
+	records, err := ParseZoneFile("test.zone", []byte(content), "example.com", include)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	expected := []ZoneFileRecord{
+		{Name: "www.example.com", TTL: 300, Type: TypeA, Value: "192.0.2.1"},
+		{Name: "db.internal.example.com", TTL: 300, Type: TypeA, Value: "192.0.2.10"},
+		{Name: "www.example.com", TTL: 300, Type: TypeA, Value: "192.0.2.2"},
+	}
+	if fmt.Sprint(records) != fmt.Sprint(expected) {
+		t.Errorf("Expected the origin and owner to be restored after the include, got %+v", records)
+	}
+}